@@ -1,20 +1,30 @@
 package cosmosadapter
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"context"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
 	"github.com/mmcloughlin/meow"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Data struct {
@@ -23,433 +33,1989 @@ type Data struct {
 }
 
 // CasbinRule represents a rule in Casbin.
+// Empty values are not stored, which keeps documents and their index entries
+// small. Documents written with empty values are read the same way.
 type CasbinRule struct {
 	ID    string `json:"id"`
 	PType string `json:"pType"`
-	V0    string `json:"v0"`
-	V1    string `json:"v1"`
-	V2    string `json:"v2"`
-	V3    string `json:"v3"`
-	V4    string `json:"v4"`
-	V5    string `json:"v5"`
+	V0    string `json:"v0,omitempty"`
+	V1    string `json:"v1,omitempty"`
+	V2    string `json:"v2,omitempty"`
+	V3    string `json:"v3,omitempty"`
+	V4    string `json:"v4,omitempty"`
+	V5    string `json:"v5,omitempty"`
+	// V6 to V11 hold the values of rules longer than six, for attribute-heavy models.
+	V6  string `json:"v6,omitempty"`
+	V7  string `json:"v7,omitempty"`
+	V8  string `json:"v8,omitempty"`
+	V9  string `json:"v9,omitempty"`
+	V10 string `json:"v10,omitempty"`
+	V11 string `json:"v11,omitempty"`
+	// Rule holds the values instead of V0 to V5 when Options.ArraySchema is
+	// enabled. Both forms are read regardless of the option.
+	Rule []string `json:"rule,omitempty"`
+
+	// PartitionKey is the partition key of the rule with PartitionByDomain.
+	PartitionKey string `json:"partitionKey,omitempty"`
+	// Namespace is the Options.Namespace of the adapter that wrote the rule.
+	Namespace string `json:"namespace,omitempty"`
+	// TTL is the Cosmos ttl, in seconds, of temporary rules added by
+	// AddPolicyWithExpiry, and ExpiresAt their expiry in seconds since the epoch.
+	TTL       int   `json:"ttl,omitempty"`
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+
+	// Deleted marks the document as a tombstone for a removed rule. It is only
+	// written when Options.Tombstones is enabled.
+	Deleted bool `json:"deleted,omitempty"`
+	// SchemaVersion is the version of the document schema the rule was written
+	// in. Documents written before it was stamped are version 0.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	// Generation is the generation of the rules the document belongs to with
+	// Options.GenerationalSave, also part of its ID.
+	Generation int64 `json:"generation,omitempty"`
+	// Signature is the signature of the rule with Options.Signer.
+	Signature string `json:"signature,omitempty"`
+	// UpdatedAt is the time of the last UpdatePolicy patching the rule in
+	// place, in seconds since the epoch.
+	UpdatedAt int64 `json:"updatedAt,omitempty"`
+	// Ts is the Cosmos _ts system property, the last modification time of the
+	// document in seconds since the epoch. It is set by the server.
+	Ts int64 `json:"_ts,omitempty"`
 }
 
-// adapter represents the CosmosDB adapter for policy storage.
-type adapter struct {
+// Adapter represents the CosmosDB adapter for policy storage.
+//
+// An Adapter is safe for concurrent use, so several enforcers, such as
+// SyncedEnforcers, can share one. The state of the last load, reported by
+// IsFiltered and NeedsReload and used by LoadPolicyDelta, is shared as well:
+// it is the state of the last load made by any of them. The functions of the
+// options, such as NewID or NewContainer, must be safe for concurrent use.
+type Adapter struct {
 	containerName   string
 	databaseName    string
-	containerClient *azcosmos.ContainerClient
+	containerClient Container
 	db              *azcosmos.DatabaseClient
 	client          *azcosmos.Client
-	filtered        bool
+	// filtered, loaded, watermark and version describe the last policy loaded
+	// through the adapter, by any of the enforcers sharing it.
+	filtered    atomic.Bool
+	loaded      atomic.Bool
+	tombstones  bool
+	domains     bool
+	arraySchema bool
+	idFunc      IDFunc
+	validator   Validator
+	normalizer  Normalizer
+	ruleExpiry  bool
+	namespace   string
+	mapper      DocumentMapper
+	compat      *CompatSchema
+
+	// containers holds the clients of the rule containers by name, including
+	// containerClient.
+	containers            map[string]Container
+	groupingContainerName string
+	ptypeContainers       map[string]string
+
+	partitionStrategy PartitionStrategy
+	tenantCache       *tenantCache
+	filterCache       *filterCache
+	quotas            *quotas
+	watermark         atomic.Int64
+	version           atomic.Int64
+	eventsClient      Container
+	auditClient       Container
+	snapshotClient    Container
+	snapshotOnSave    bool
+	actor             string
+	rest              *restClient
+	tracer            trace.Tracer
+	metrics           *metrics
+	stats             *statsRecorder
+	logger            *slog.Logger
+	onOperation       func(OperationInfo)
+
+	policyTypeCache policyTypeCache
+
+	// secondary holds the clients of the rules containers in
+	// secondaryRegion by name, see Options.SecondaryRegion.
+	secondary       map[string]Container
+	secondaryRegion string
+
+	// partitionDeleteUnsupported is set once Cosmos rejected a delete by
+	// partition key, see purgePolicyType.
+	partitionDeleteUnsupported atomic.Bool
+
+	auditCorrelationID func() string
+	auditChain         *auditChain
+
+	signer          Signer
+	signaturePolicy SignaturePolicy
+
+	upgradeSchemaOnLoad bool
+
+	// dataPlaneOnly keeps the adapter from sending control-plane requests,
+	// see Options.DataPlaneOnly.
+	dataPlaneOnly bool
+
+	// generation is the current generation of the rules with generational,
+	// and loadedGeneration the generation read by the last load.
+	generational     bool
+	generation       atomic.Int64
+	loadedGeneration atomic.Int64
+
+	writeConcurrency int
+
+	// filterQueries caches the queries of RemoveFilteredPolicy, see
+	// filterQueryFor.
+	filterQueries sync.Map
+
+	logQueries            bool
+	redactQueryParameters bool
+	skipMalformed         bool
+	onSkippedDocument     func(document []byte, err error)
+
+	dryRun   bool
+	onDryRun func(DryWrite)
+
+	hooks []Hook
+
+	timeouts operationTimeouts
+
+	newContainerFunc func(name string) Container
+
+	now   func() time.Time
+	newID func() string
+
+	conflictResolutionPolicy *azcosmos.ConflictResolutionPolicy
+	indexingPolicy           *azcosmos.IndexingPolicy
+	analyticalStoreTTL       *int32
+
+	lifecycle lifecycle
 }
 
-func NewAdapterFromConnectionSting(connectionString string, options Options) persist.Adapter {
-	client, err := azcosmos.NewClientFromConnectionString(connectionString, &options.ClientOptions)
+var (
+	_ persist.FilteredAdapter  = (*Adapter)(nil)
+	_ persist.BatchAdapter     = (*Adapter)(nil)
+	_ persist.UpdatableAdapter = (*Adapter)(nil)
+)
+
+// Option configures an adapter created by NewAdapterFromConnectionString or
+// NewAdapterFromCredential. Options is an Option setting every option, so it
+// comes first when combined with functions such as Database:
+//
+//	a := cosmosadapter.NewAdapterFromConnectionString(connectionString,
+//		cosmosadapter.Options{Tombstones: true},
+//		cosmosadapter.Database("mycasbindb"))
+type Option interface {
+	apply(options *Options)
+}
+
+// apply replaces the options set by the options given before.
+func (o Options) apply(options *Options) {
+	*options = o
+}
+
+type optionFunc func(options *Options)
+
+func (f optionFunc) apply(options *Options) {
+	f(options)
+}
+
+// Database sets the name of the database, "casbin" by default.
+func Database(name string) Option {
+	return optionFunc(func(options *Options) { options.DatabaseName = name })
+}
+
+// Collection sets the name of the rules container, "casbin_rule" by default.
+func Collection(name string) Option {
+	return optionFunc(func(options *Options) { options.ContainerName = name })
+}
+
+// newOptions returns the options set by opts, with the default database and
+// container names.
+func newOptions(opts []Option) Options {
+	var options Options
+	for _, opt := range opts {
+		opt.apply(&options)
+	}
+	if options.DatabaseName == "" {
+		options.DatabaseName = "casbin"
+	}
+	if options.ContainerName == "" {
+		options.ContainerName = "casbin_rule"
+	}
+	return options
+}
+
+// NewAdapterFromConnectionString returns an adapter storing the rules in the
+// account of the connection string. Unless Options.SkipAutoCreate,
+// Options.DataPlaneOnly or Options.LazyConnect is set, it creates the database and the containers if
+// they don't exist, and panics if it can't. The account key can be replaced
+// with SetAccountKey.
+func NewAdapterFromConnectionString(connectionString string, opts ...Option) *Adapter {
+	return newAdapterFromConnectionString(connectionString, newOptions(opts))
+}
+
+// NewAdapterFromCredential returns an adapter storing the rules in the account
+// of the endpoint, authenticated with the credential, such as an
+// *azidentity.DefaultAzureCredential. Like NewAdapterFromConnectionString, it
+// creates the database and the containers unless configured otherwise. The
+// credential can be replaced with SetCredential.
+func NewAdapterFromCredential(endpoint string, cred azcore.TokenCredential, opts ...Option) *Adapter {
+	return newAdapterFromCredential(endpoint, cred, newOptions(opts))
+}
+
+// NewAdapterFromConnectionSting returns an adapter storing the rules in the
+// account of the connection string.
+//
+// Deprecated: Use NewAdapterFromConnectionString.
+func NewAdapterFromConnectionSting(connectionString string, options Options) *Adapter {
+	return newAdapterFromConnectionString(connectionString, options)
+}
+
+// NewAdapter returns an adapter storing the rules in the account of the
+// endpoint, authenticated with the credential.
+//
+// Deprecated: Use NewAdapterFromCredential, which takes any credential.
+func NewAdapter(endpoint string, cred *azidentity.DefaultAzureCredential, options Options) *Adapter {
+	return newAdapterFromCredential(endpoint, cred, options)
+}
+
+func newAdapterFromConnectionString(connectionString string, options Options) *Adapter {
+	rest, err := newRESTClientFromConnectionString(connectionString, options.Transport, options.AccountKeySource)
+	if err != nil {
+		panic(fmt.Sprintf("Parsing connection string caused error: %s", err.Error()))
+	}
+	client, err := azcosmos.NewClientFromConnectionString(connectionString, withAuthorizationPolicy(newClientOptions(options), keyPolicy{rest.key}))
 	if err != nil {
 		panic(fmt.Sprintf("Creating new cosmos client caused error: %s", err.Error()))
 	}
-	return NewAdapterFromClient(client, options)
+	a := NewAdapterFromClient(client, options)
+	a.rest = rest
+	if options.SecondaryRegion != "" {
+		secondary, err := azcosmos.NewClientFromConnectionString(connectionString, withAuthorizationPolicy(newSecondaryClientOptions(options), keyPolicy{rest.key}))
+		if err != nil {
+			panic(fmt.Sprintf("Creating new cosmos client caused error: %s", err.Error()))
+		}
+		a.setSecondary(secondary, options.SecondaryRegion)
+	}
+	return a
 }
 
-// NewAdapter is the constructor for Adapter.
-// if no options are given the database name is "casbin" and the containerClient is named casbin_rule
-// if the database or containerClient is not found it is automatically created.
-// the database can be changed by using the Database(db string) option.
-// the containerClient can be changed by using the Collection(coll string) option.
-// see README for example
-func NewAdapter(endpoint string, cred *azidentity.DefaultAzureCredential, options Options) persist.Adapter {
-
-	client, err := azcosmos.NewClient(endpoint, cred, &options.ClientOptions)
+func newAdapterFromCredential(endpoint string, cred azcore.TokenCredential, options Options) *Adapter {
+	// the credential can be replaced with SetCredential
+	rotating := newRotatingCredential(cred)
+	client, err := azcosmos.NewClient(endpoint, rotating, withAuthorizationPolicy(newClientOptions(options), tokenPolicy{rotating}))
 	if err != nil {
 		panic(fmt.Sprintf("Creating new cosmos client caused error: %s", err.Error()))
 	}
-	return NewAdapterFromClient(client, options)
+	a := NewAdapterFromClient(client, options)
+	a.rest = &restClient{endpoint: endpoint, credential: rotating, transport: options.Transport}
+	if options.SecondaryRegion != "" {
+		secondary, err := azcosmos.NewClient(endpoint, rotating, withAuthorizationPolicy(newSecondaryClientOptions(options), tokenPolicy{rotating}))
+		if err != nil {
+			panic(fmt.Sprintf("Creating new cosmos client caused error: %s", err.Error()))
+		}
+		a.setSecondary(secondary, options.SecondaryRegion)
+	}
+	return a
 }
 
-func NewAdapterFromClient(client *azcosmos.Client, options Options) persist.Adapter {
+func NewAdapterFromClient(client *azcosmos.Client, options Options) *Adapter {
 	// create adapter and set default values
-	a := &adapter{
+	a := &Adapter{
 		containerName: options.ContainerName,
 		databaseName:  options.DatabaseName,
 		client:        client,
-	}
+		tombstones:    options.Tombstones,
+		domains:       options.Domains || options.PartitionStrategy == PartitionByDomain,
+		arraySchema:   options.ArraySchema,
+		idFunc:        options.IDFunc,
+		validator:     options.Validator,
+		normalizer:    options.Normalizer,
+		ruleExpiry:    options.RuleExpiry,
+		namespace:     options.Namespace,
+		mapper:        options.Mapper,
 
-	database, err := a.client.NewDatabase(options.DatabaseName)
-	if err != nil {
-		panic(fmt.Sprintf("Creating new database with id %s caused error: %s", options.DatabaseName, err.Error()))
+		partitionStrategy: options.PartitionStrategy,
+		tenantCache:       newTenantCache(options.TenantCacheTTL),
+		filterCache:       newFilterCache(options.FilterCacheSize, options.FilterCacheTTL),
+		quotas:            newQuotas(options),
+		actor:             options.Actor,
+		snapshotOnSave:    options.SnapshotOnSave,
+
+		conflictResolutionPolicy: options.ConflictResolutionPolicy,
+		indexingPolicy:           options.IndexingPolicy,
+		analyticalStoreTTL:       analyticalStoreTTL(options.AnalyticalStoreTTL),
+	}
+	if options.Compat != nil {
+		a.compat = options.Compat
+		a.mapper = *options.Compat
+	}
+	if a.mapper == nil {
+		a.mapper = jsonMapper{strict: options.StrictDecoding}
+	}
+	if options.TracerProvider != nil {
+		a.tracer = options.TracerProvider.Tracer(instrumentationName)
+	} else {
+		a.tracer = defaultTracer()
+	}
+	a.metrics = newMetrics(options.MeterProvider)
+	a.stats = newStatsRecorder()
+	a.logger = loggerFrom(options)
+	a.onOperation = options.OnOperation
+	a.auditCorrelationID = options.AuditCorrelationID
+	a.signer, a.signaturePolicy = options.Signer, options.SignaturePolicy
+	a.dataPlaneOnly = options.DataPlaneOnly
+	if options.AuditChain && options.AuditContainerName != "" {
+		a.auditChain = &auditChain{}
+	}
+	a.upgradeSchemaOnLoad = options.UpgradeSchemaOnLoad
+	a.logQueries = options.LogQueries
+	a.redactQueryParameters = options.RedactQueryParameters
+	a.skipMalformed = options.SkipMalformedDocuments
+	// SavePolicy never empties the container with tombstones
+	a.generational = options.GenerationalSave && !options.Tombstones
+	a.writeConcurrency = options.WriteConcurrency
+	if a.writeConcurrency <= 0 {
+		a.writeConcurrency = defaultWriteConcurrency
+	}
+	a.onSkippedDocument = options.OnSkippedDocument
+	a.dryRun = options.DryRun
+	a.onDryRun = options.OnDryRun
+	a.hooks = options.Hooks
+	a.timeouts = operationTimeouts{load: options.LoadTimeout, write: options.WriteTimeout, bulk: options.BulkTimeout}
+	a.now = options.Now
+	if a.now == nil {
+		a.now = time.Now
 	}
+	a.newID = options.NewID
+	if a.newID == nil {
+		a.newID = newEventID
+	}
+	a.lifecycle.transport = options.Transport
 
-	container, err := a.client.NewContainer(database.ID(), options.ContainerName)
-	if err != nil {
-		panic(fmt.Sprintf("Creating container with name %s caused error: %s", options.ContainerName, err.Error()))
+	if options.NewContainer != nil {
+		a.newContainerFunc = options.NewContainer
+		if options.Faults != nil {
+			a.newContainerFunc = func(name string) Container {
+				return faultContainer{Container: options.NewContainer(name), faults: options.Faults}
+			}
+		}
+		a.containerClient = a.withDryRun(options.ContainerName, a.newContainerFunc(options.ContainerName))
+	} else {
+		database, err := a.client.NewDatabase(options.DatabaseName)
+		if err != nil {
+			panic(fmt.Sprintf("Creating new database with id %s caused error: %s", options.DatabaseName, err.Error()))
+		}
+
+		container, err := a.client.NewContainer(database.ID(), options.ContainerName)
+		if err != nil {
+			panic(fmt.Sprintf("Creating container with name %s caused error: %s", options.ContainerName, err.Error()))
+		}
+		a.db = database
+		a.containerClient = a.withDryRun(options.ContainerName, container)
 	}
-	a.db = database
-	a.containerClient = container
 	a.databaseName = options.DatabaseName
+	a.newRuleContainers(options)
+	if options.EventSourcing {
+		a.eventsClient = a.newContainer(eventContainerName(options))
+	}
+	if options.AuditContainerName != "" {
+		a.auditClient = a.newContainer(options.AuditContainerName)
+	}
+	if options.SnapshotContainerName != "" {
+		a.snapshotClient = a.newContainer(options.SnapshotContainerName)
+	}
 
-	a.createDatabaseIfNotExist()
-	a.createCollectionIfNotExist()
-	a.filtered = false
+	if !options.SkipAutoCreate && !options.DataPlaneOnly && !options.DryRun && options.NewContainer == nil {
+		if options.LazyConnect {
+			a.lifecycle.pending = func(ctx context.Context) error {
+				return a.createInfrastructure(ctx, options)
+			}
+		} else if err := a.createInfrastructure(context.Background(), options); err != nil {
+			panic(err.Error())
+		}
+	}
+	a.filtered.Store(false)
+	a.logger.Info("connected to cosmos", "database", a.databaseName, "container", a.containerName)
 	return a
 }
 
-func (a *adapter) createDatabaseIfNotExist() {
-	ctx := context.Background()
-	_, err := a.db.Read(ctx, nil)
+// newContainer returns the client of a container of the database.
+func (a *Adapter) newContainer(name string) Container {
+	if a.newContainerFunc != nil {
+		return a.withDryRun(name, a.newContainerFunc(name))
+	}
+	container, err := a.db.NewContainer(name)
 	if err != nil {
-		resErr := err.(*azcore.ResponseError)
-		if resErr.StatusCode == http.StatusNotFound {
-			dbProps := azcosmos.DatabaseProperties{ID: a.databaseName}
-			_, createDbErr := a.client.CreateDatabase(ctx, dbProps, nil)
-			if createDbErr != nil {
-				panic(fmt.Sprintf("Creating cosmos database caused error: %s", createDbErr.Error()))
-			}
-		} else {
-			panic(fmt.Sprintf("Reading cosmos database caused error: %s", err.Error()))
+		panic(fmt.Sprintf("Creating container with name %s caused error: %s", name, err.Error()))
+	}
+	return a.withDryRun(name, container)
+}
+
+// createInfrastructure creates the database and the containers used by the
+// adapter if they don't exist.
+func (a *Adapter) createInfrastructure(ctx context.Context, options Options) error {
+	if err := a.createDatabaseIfNotExist(ctx); err != nil {
+		return err
+	}
+	if err := a.createCollectionIfNotExist(ctx); err != nil {
+		return err
+	}
+	if options.EventSourcing {
+		if err := a.createEventContainerIfNotExist(ctx, eventContainerName(options)); err != nil {
+			return err
+		}
+	}
+	if options.AuditContainerName != "" {
+		if err := a.createAuditContainerIfNotExist(ctx, options.AuditContainerName); err != nil {
+			return err
+		}
+	}
+	if options.SnapshotContainerName != "" {
+		if err := a.createSnapshotContainerIfNotExist(ctx, options.SnapshotContainerName); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
+func (a *Adapter) createDatabaseIfNotExist(ctx context.Context) error {
+	_, err := a.db.Read(ctx, nil)
+	if err == nil {
+		return nil
+	}
+	if !isStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("reading cosmos database: %w", err)
+	}
+	dbProps := azcosmos.DatabaseProperties{ID: a.databaseName}
+	if _, err := a.client.CreateDatabase(ctx, dbProps, nil); err != nil && !isStatus(err, http.StatusConflict) {
+		return fmt.Errorf("creating cosmos database: %w", err)
+	}
+	a.logger.Info("created cosmos database", "database", a.databaseName)
+	return nil
 }
 
-func (a *adapter) createCollectionIfNotExist() {
-	ctx := context.Background()
-	_, err := a.containerClient.Read(ctx, nil)
+func (a *Adapter) createCollectionIfNotExist(ctx context.Context) error {
+	for _, name := range a.ruleContainerNames() {
+		if err := a.createRuleContainerIfNotExist(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if err != nil {
-		resErr := err.(*azcore.ResponseError)
-		if resErr.StatusCode == http.StatusNotFound {
-			properties := azcosmos.ContainerProperties{
-				ID: a.containerName,
-				PartitionKeyDefinition: azcosmos.PartitionKeyDefinition{
-					Paths: []string{"/pType"},
-				},
-			}
-			_, err := a.db.CreateContainer(ctx, properties, nil)
-			if err != nil {
-				panic(fmt.Sprintf("Creating cosmos containerClient caused error: %s", err.Error()))
+func (a *Adapter) createRuleContainerIfNotExist(ctx context.Context, name string) error {
+	container := cosmosContainer(a.containers[name])
+	res, err := container.Read(ctx, nil)
+	if err == nil && a.ruleExpiry {
+		if err := a.ensureTTL(ctx, container, res.ContainerProperties); err != nil {
+			return fmt.Errorf("enabling ttl on cosmos container %s: %w", name, err)
+		}
+	}
+	if err == nil && a.indexingPolicy != nil {
+		if err := a.ensureIndexingPolicy(ctx, container, res.ContainerProperties); err != nil {
+			return fmt.Errorf("updating the indexing policy of cosmos container %s: %w", name, err)
+		}
+	}
+	if err == nil && a.analyticalStoreTTL != nil {
+		if err := a.ensureAnalyticalStoreTTL(ctx, container, res.ContainerProperties); err != nil {
+			return fmt.Errorf("updating the analytical store ttl of cosmos container %s: %w", name, err)
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	if !isStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("reading cosmos container %s: %w", name, err)
+	}
+	if _, err := a.db.CreateContainer(ctx, a.containerProperties(name), nil); err != nil && !isStatus(err, http.StatusConflict) {
+		return fmt.Errorf("creating cosmos container %s: %w", name, err)
+	}
+	a.logger.Info("created cosmos container", "database", a.databaseName, "container", name)
+	return nil
+}
+
+// clearContainer deletes every document of a container that is not an azcosmos
+// client, and so can't be dropped.
+func (a *Adapter) clearContainer(ctx context.Context, container Container) error {
+	field := strings.TrimPrefix(a.partitionKeyPath(), "/")
+	type document struct {
+		id           string
+		partitionKey string
+	}
+	var documents []document
+	pager := container.NewQueryItemsPager("SELECT * FROM c", azcosmos.PartitionKey{}, nil)
+	for pager.More() {
+		res, err := nextPage(ctx, pager)
+		if err != nil {
+			return err
+		}
+		for _, item := range res.Items {
+			var doc map[string]interface{}
+			if err := json.Unmarshal(item, &doc); err != nil {
+				return err
 			}
-		} else {
-			panic(fmt.Sprintf("Reading cosmos containerClient caused error: %s", err.Error()))
+			id, _ := doc["id"].(string)
+			partitionKey, _ := doc[field].(string)
+			documents = append(documents, document{id: id, partitionKey: partitionKey})
 		}
 	}
+	// deleted once read, as deletions would shift the pages
+	for _, doc := range documents {
+		_, err := container.DeleteItem(ctx, azcosmos.NewPartitionKeyString(doc.partitionKey), doc.id, nil)
+		if err != nil && !isStatus(err, http.StatusNotFound) {
+			return err
+		}
+	}
+	return nil
 }
 
 //// NewFilteredAdapter is the constructor for FilteredAdapter.
 //// Casbin will not automatically call LoadPolicy() for a filtered adapter.
 //func NewFilteredAdapter(url string, options ...Option) persist.FilteredAdapter {
-//	a := NewAdapter(url, options...).(*adapter)
+//	a := NewAdapter(url, options...).(*Adapter)
 //	a.filtered = true
 //	return a
 //}
 
-func (a *adapter) dropCollection() error {
-	_, err := a.containerClient.Delete(context.Background(), nil)
-	if err != nil {
-		return err
+func (a *Adapter) dropCollection() error {
+	for _, name := range a.ruleContainerNames() {
+		client := cosmosContainer(a.containers[name])
+		if client == nil || a.dataPlaneOnly {
+			if err := a.clearContainer(context.Background(), a.containers[name]); err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := client.Delete(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		_, err = a.db.CreateContainer(context.Background(), a.containerProperties(name), nil)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// containerProperties returns the properties a rules container is created with.
+func (a *Adapter) containerProperties(name string) azcosmos.ContainerProperties {
 	properties := azcosmos.ContainerProperties{
-		ID: a.containerName,
+		ID: name,
 		PartitionKeyDefinition: azcosmos.PartitionKeyDefinition{
-			Paths: []string{"/pType"},
+			Paths: []string{a.partitionKeyPath()},
 		},
+		ConflictResolutionPolicy:           a.conflictResolutionPolicy,
+		IndexingPolicy:                     a.indexingPolicy,
+		AnalyticalStoreTimeToLiveInSeconds: a.analyticalStoreTTL,
 	}
-	_, err = a.db.CreateContainer(context.Background(), properties, nil)
-	return err
+	if a.ruleExpiry {
+		noDefault := int32(-1)
+		properties.DefaultTimeToLive = &noDefault
+	}
+	return properties
 }
 
-func loadPolicyLine(line CasbinRule, model model.Model) {
-	key := line.PType
-	sec := key[:1]
+// loadPolicyLine adds the rule to the model with persist.LoadPolicyArray,
+// unless it is removed, expired, already in the model, or of a policy type the
+// model doesn't define. A rule that doesn't fit the definition of its policy
+// type is passed to malformed.
+func (a *Adapter) loadPolicyLine(ctx context.Context, line CasbinRule, model model.Model) error {
+	if line.Deleted || line.expired(a.now()) {
+		return nil
+	}
+	if line.PType == "" || line.PType == policyVersionID {
+		return nil
+	}
+	tokens := modelTokens(line, model)
+	if len(tokens) == 0 {
+		return nil
+	}
+	if err := persist.LoadPolicyArray(append([]string{line.PType}, tokens...), model); err != nil {
+		document, _ := a.mapper.ToDocument(line)
+		return a.malformed(ctx, document, err)
+	}
+	return nil
+}
 
-	tokens := []string{}
-	if line.V0 != "" {
-		tokens = append(tokens, line.V0)
-	} else {
-		goto LineEnd
+// modelTokens returns the values of the rule as held by the model, nil if the
+// model doesn't define its policy type. Empty values are not stored, so the
+// trailing ones of rules shorter than the definition are restored.
+func modelTokens(line CasbinRule, model model.Model) []string {
+	assertion, ok := model[line.PType[:1]][line.PType]
+	if !ok {
+		return nil
+	}
+	tokens := policyTokens(line)
+	if len(tokens) == 0 {
+		return nil
 	}
+	for len(tokens) < len(assertion.Tokens) {
+		tokens = append(tokens, "")
+	}
+	return tokens
+}
 
-	if line.V1 != "" {
-		tokens = append(tokens, line.V1)
-	} else {
-		goto LineEnd
+// decodeRule decodes a document read by the operation of the context. Loads
+// also check that it is a rule, with a pType and values. The documents that
+// are not are passed to malformed, and ok is false when they are skipped.
+func (a *Adapter) decodeRule(ctx context.Context, document []byte) (line CasbinRule, ok bool, err error) {
+	line, err = a.mapper.FromDocument(document)
+	if op := operationFrom(ctx); err == nil && op != nil && op.loading {
+		if err = notRuleReason(line); err == nil {
+			var skip bool
+			if skip, err = a.checkSignature(line); skip {
+				return line, false, nil
+			}
+		}
+	}
+	if err != nil {
+		return line, false, a.malformed(ctx, document, err)
 	}
+	return line, true, nil
+}
 
-	if line.V2 != "" {
-		tokens = append(tokens, line.V2)
-	} else {
-		goto LineEnd
+// notRuleReason returns why a decoded document is not a rule, nil if it is one or
+// the policy version.
+func notRuleReason(line CasbinRule) error {
+	switch {
+	case line.PType == "":
+		return errors.New("no pType")
+	case line.PType == policyVersionID, line.Deleted:
+		return nil
+	case len(line.Rule) == 0:
+		if _, n := storedValues(line); n == 0 {
+			return errors.New("no values")
+		}
 	}
+	return nil
+}
 
-	if line.V3 != "" {
-		tokens = append(tokens, line.V3)
-	} else {
-		goto LineEnd
+// malformed handles a malformed document read by the operation of the
+// context. With Options.OnSkippedDocument or SkipMalformedDocuments it is
+// skipped. Loads skip it too, and fail with every malformed document they read
+// once done. Other operations fail right away with the returned error.
+func (a *Adapter) malformed(ctx context.Context, document []byte, err error) error {
+	doc := MalformedDocument{ID: documentID(document), Err: err}
+	if a.onSkippedDocument != nil {
+		a.onSkippedDocument(document, err)
+		return nil
+	}
+	if a.skipMalformed {
+		a.logger.Warn("skipped malformed document", "id", doc.ID, "error", err)
+		return nil
 	}
+	if op := operationFrom(ctx); op != nil && op.loading {
+		op.mu.Lock()
+		op.malformed = append(op.malformed, doc)
+		op.mu.Unlock()
+		return nil
+	}
+	return &MalformedDocumentsError{Documents: []MalformedDocument{doc}}
+}
 
-	if line.V4 != "" {
-		tokens = append(tokens, line.V4)
-	} else {
-		goto LineEnd
+// documentID returns the id of a document that can't be decoded, if any.
+func documentID(document []byte) string {
+	var meta struct {
+		ID string `json:"id"`
 	}
+	_ = json.Unmarshal(document, &meta)
+	return meta.ID
+}
 
-	if line.V5 != "" {
-		tokens = append(tokens, line.V5)
-	} else {
-		goto LineEnd
+// maxRuleValues is the number of values the V fields can hold.
+const maxRuleValues = 12
+
+// values returns pointers to the V0 to V11 fields, in order.
+func (line *CasbinRule) values() []*string {
+	return []*string{
+		&line.V0, &line.V1, &line.V2, &line.V3, &line.V4, &line.V5,
+		&line.V6, &line.V7, &line.V8, &line.V9, &line.V10, &line.V11,
+	}
+}
+
+// storedValues returns the V0 to V11 fields, and the number of values of the
+// rule they hold. Empty values are not stored, so the rule ends with its last
+// non-empty value, and may have empty values before it.
+func storedValues(line CasbinRule) ([maxRuleValues]string, int) {
+	values := [maxRuleValues]string{
+		line.V0, line.V1, line.V2, line.V3, line.V4, line.V5,
+		line.V6, line.V7, line.V8, line.V9, line.V10, line.V11,
 	}
+	n := maxRuleValues
+	for n > 0 && values[n-1] == "" {
+		n--
+	}
+	return values, n
+}
 
-LineEnd:
-	model[sec][key].Policy = append(model[sec][key].Policy, tokens)
+func policyTokens(line CasbinRule) []string {
+	if len(line.Rule) > 0 {
+		return append([]string{}, line.Rule...)
+	}
+	// sized first, as loads call it for every rule
+	values, n := storedValues(line)
+	tokens := make([]string, n)
+	copy(tokens, values[:n])
+	return tokens
 }
 
 // LoadPolicy loads policy from database.
-func (a *adapter) LoadPolicy(model model.Model) error {
-	ctx := context.Background()
-	var lines []CasbinRule
-	a.filtered = false
-	loadPolicyQuery := "SELECT * FROM c"
+// The time the load started is remembered as the watermark for LoadPolicyDelta.
+func (a *Adapter) LoadPolicy(model model.Model) (err error) {
+	return a.loadPolicy(context.Background(), model)
+}
+
+func (a *Adapter) loadPolicy(ctx context.Context, model model.Model) (err error) {
+	ctx, op := a.startOperation(ctx, "LoadPolicy")
+	defer func() { err = a.endOperation(op, err) }()
+	op.loading = true
+	err = a.loadRules(ctx, model)
+	if a.secondary != nil && regionalFailure(ctx, err) {
+		// the rules are queried before any is added to the model
+		op.logger.Warn("loading the policy failed, loading it from the secondary region", "region", a.secondaryRegion, "error", err)
+		op.secondary = true
+		err = a.loadRules(ctx, model)
+	}
+	return err
+}
 
-	queryPager := a.containerClient.NewQueryItemsPager(loadPolicyQuery, azcosmos.NewPartitionKeyString("p"), nil)
+// loadRules loads the rules of the operation of LoadPolicy into the model.
+func (a *Adapter) loadRules(ctx context.Context, model model.Model) error {
+	a.filtered.Store(false)
+	loadPolicyQuery, parameters := a.inNamespace(a.selectRules(), nil)
+	container := a.readContainer(ctx, a.containerName)
 
-	for queryPager.More() {
-		res, err := queryPager.NextPage(ctx)
+	var version, generation, watermark int64
+	var lines []CasbinRule
+	for attempt := 1; ; attempt++ {
+		// Read the version first, so changes made during the load are reported
+		// by NeedsReload, and read by the next LoadPolicyDelta.
+		current, start, err := a.readLoadVersion(ctx, container)
 		if err != nil {
 			return err
 		}
-		for _, item := range res.Items {
-			var line CasbinRule
-			err := json.Unmarshal(item, &line)
-			if err != nil {
-				return err
-			}
-			lines = append(lines, line)
+		version, watermark = current.Version, start
+		a.storeGeneration(current.Generation)
+		generation = a.generation.Load()
+
+		lines, err = a.queryPolicyTypes(ctx, loadPolicyQuery, a.loadedPolicyTypes(model, version), parameters)
+		if err != nil {
+			return err
+		}
+		if !a.generational {
+			break
+		}
+		// A SavePolicy switching to a new generation during the query purges the
+		// generation read with its next SavePolicy, so the rules read may be
+		// partial: they are read again from the new generation.
+		after, _, err := readVersionDocument(ctx, container, a.versionID())
+		if err != nil {
+			return err
+		}
+		if after.Generation <= generation {
+			break
+		}
+		if attempt == generationLoadAttempts {
+			return errors.New("the policy was saved during every attempt to load it")
 		}
+		operationFrom(ctx).retry("policy saved during the load")
 	}
 
+	var stale []CasbinRule
 	for _, line := range lines {
-		loadPolicyLine(line, model)
+		if err := a.loadPolicyLine(ctx, line, model); err != nil {
+			return err
+		}
+		if a.upgradeSchemaOnLoad && a.staleSchema(line) {
+			stale = append(stale, line)
+		}
+	}
+	a.watermark.Store(watermark)
+	a.loaded.Store(true)
+	a.version.Store(version)
+	a.loadedGeneration.Store(generation)
+	if len(stale) > 0 && !operationFrom(ctx).secondary {
+		// the policy is loaded, so a failed upgrade is retried by the next load
+		if _, err := a.upgradeSchema(ctx, stale); err != nil {
+			a.logger.Warn("upgrading the document schema failed", "error", err)
+		}
 	}
 	return nil
 }
 
-// LoadFilteredPolicy loads matching policy lines from database. If not nil,
-// the filter must be a valid MongoDB selector.
-func (a *adapter) LoadFilteredPolicy(model model.Model, filter interface{}) error {
-	var lines []CasbinRule
-	querySpec := filter.(SqlQuerySpec)
-	a.filtered = true
+// LoadPolicyDelta applies the documents modified since the last LoadPolicy or
+// LoadPolicyDelta to the model, instead of re-reading the whole container.
+// Removals are only visible when Options.Tombstones is enabled; without it
+// removed rules stay in the model until the next LoadPolicy.
+// When used with an enforcer, call e.BuildRoleLinks() afterwards.
+func (a *Adapter) LoadPolicyDelta(model model.Model) (err error) {
+	return a.loadPolicyDelta(context.Background(), model)
+}
 
-	queryOptions := &azcosmos.QueryOptions{
-		QueryParameters: querySpec.Parameters,
+func (a *Adapter) loadPolicyDelta(ctx context.Context, model model.Model) (err error) {
+	ctx, op := a.startOperation(ctx, "LoadPolicyDelta")
+	defer func() { err = a.endOperation(op, err) }()
+	op.loading = true
+	if a.filtered.Load() {
+		return errors.New("cannot load a policy delta into a filtered policy")
+	}
+	if !a.loaded.Load() {
+		return errors.New("no watermark: LoadPolicy must be called before LoadPolicyDelta")
+	}
+	since := a.watermark.Load()
+	if a.generation.Load() != a.loadedGeneration.Load() {
+		return errGenerationChanged
+	}
+
+	// The watermark precedes the previous load by watermarkSkew, so documents
+	// written shortly before it are read again. Applying them twice is harmless.
+	deltaQuery, parameters := a.inNamespace(a.selectRules()+" WHERE c._ts >= @ts",
+		[]azcosmos.QueryParameter{{Name: "@ts", Value: since}})
+
+	current, watermark, err := a.readLoadVersion(ctx, a.containerClient)
+	if err != nil {
+		return err
 	}
-	queryPager := a.containerClient.NewQueryItemsPager(querySpec.Query, azcosmos.NewPartitionKeyString("p"), queryOptions)
 
-	for queryPager.More() {
-		res, err := queryPager.NextPage(context.Background())
+	for _, ptype := range policyTypes(model) {
+		lines, err := a.query(ctx, deltaQuery, ptype, parameters)
 		if err != nil {
 			return err
 		}
-		for _, item := range res.Items {
-			var line CasbinRule
-			err := json.Unmarshal(item, &line)
-			if err != nil {
+		for _, line := range lines {
+			if (line.Deleted || line.expired(a.now())) && line.PType != "" {
+				if tokens := modelTokens(line, model); tokens != nil {
+					model.RemovePolicy(line.PType[:1], line.PType, tokens)
+				}
+				continue
+			}
+			if err := a.loadPolicyLine(ctx, line, model); err != nil {
 				return err
 			}
-			lines = append(lines, line)
+		}
+	}
+	a.watermark.Store(watermark)
+	a.version.Store(current.Version)
+	return nil
+}
+
+// policyTypes returns the policy types defined by the model, which are the
+// partition key values the rules are stored under.
+func policyTypes(model model.Model) []string {
+	var ptypes []string
+	for _, sec := range []string{"p", "g"} {
+		for ptype := range model[sec] {
+			ptypes = append(ptypes, ptype)
+		}
+	}
+	return ptypes
+}
+
+// query runs a single partition query and decodes every page into rules. An
+// empty ptype queries every partition of every rule container.
+func (a *Adapter) query(ctx context.Context, query string, ptype string, parameters []azcosmos.QueryParameter) ([]CasbinRule, error) {
+	var lines []CasbinRule
+	query, parameters, pk := a.inPolicyType(query, parameters, ptype)
+	containers := []Container{a.readContainer(ctx, a.containerNameFor(ptype))}
+	if ptype == "" {
+		containers = containers[:0]
+		for _, name := range a.ruleContainerNames() {
+			containers = append(containers, a.readContainer(ctx, name))
+		}
+	}
+	for _, container := range containers {
+		queryPager := container.NewQueryItemsPager(query, pk, &azcosmos.QueryOptions{QueryParameters: parameters})
+		continuation := ""
+		for queryPager.More() {
+			res, err := nextPage(ctx, queryPager)
+			if err != nil {
+				return nil, err
+			}
+			operationFrom(ctx).query(query, ptype, parameters, continuation, res)
+			if res.ContinuationToken != nil {
+				continuation = *res.ContinuationToken
+			}
+			lines = slices.Grow(lines, len(res.Items))
+			for _, item := range res.Items {
+				line, ok, err := a.decodeRule(ctx, item)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+				if a.inOtherNamespace(line) || a.inOtherGeneration(line) {
+					// filters given to LoadFilteredPolicy are not restricted to the namespace
+					continue
+				}
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines, nil
+}
+
+// LoadFilteredPolicy loads matching policy lines from database. If not nil,
+// the filter must be a SqlQuerySpec or *SqlQuerySpec. With
+// Options.FilterCacheSize the rules are cached per filter.
+func (a *Adapter) LoadFilteredPolicy(model model.Model, filter interface{}) (err error) {
+	return a.loadFilteredPolicy(context.Background(), model, filter)
+}
+
+func (a *Adapter) loadFilteredPolicy(ctx context.Context, model model.Model, filter interface{}) (err error) {
+	ctx, op := a.startOperation(ctx, "LoadFilteredPolicy")
+	defer func() { err = a.endOperation(op, err) }()
+	op.loading = true
+	querySpec, err := toQuerySpec(filter)
+	if err != nil {
+		return err
+	}
+	a.filtered.Store(true)
+
+	version, _, err := readVersion(ctx, a.containerClient, a.versionID())
+	if err != nil {
+		return err
+	}
+
+	// with PartitionByDomain the filter can select rules of every policy type,
+	// typically all the rules of a domain
+	ptype := "p"
+	if a.partitionStrategy == PartitionByDomain {
+		ptype = ""
+	}
+	key := filterKey(querySpec)
+	lines, ok := a.filterCache.get(key, version, a.now())
+	if !ok {
+		if lines, err = a.query(ctx, querySpec.Query, ptype, querySpec.Parameters); err != nil {
+			return err
+		}
+		if len(op.malformed) == 0 {
+			// the load fails, and reads the documents again next time
+			a.filterCache.put(key, version, lines, a.now())
 		}
 	}
 
 	for _, line := range lines {
-		loadPolicyLine(line, model)
+		if err := a.loadPolicyLine(ctx, line, model); err != nil {
+			return err
+		}
 	}
+	a.version.Store(version)
 	return nil
 }
 
+// LoadIncrementalFilteredPolicy appends the policy lines matching the filter to
+// the model without clearing the policy that is already loaded. Rules that are
+// already present in the model are skipped, so successive loads of overlapping
+// filters do not produce duplicates.
+func (a *Adapter) LoadIncrementalFilteredPolicy(model model.Model, filter interface{}) error {
+	return a.LoadFilteredPolicy(model, filter)
+}
+
+// DatabaseName returns the name of the Cosmos database of the adapter.
+func (a *Adapter) DatabaseName() string {
+	return a.databaseName
+}
+
+// ContainerName returns the name of the rules container of the adapter.
+func (a *Adapter) ContainerName() string {
+	return a.containerName
+}
+
 // IsFiltered returns true if the loaded policy has been filtered.
-func (a *adapter) IsFiltered() bool {
-	return a.filtered
+func (a *Adapter) IsFiltered() bool {
+	return a.filtered.Load()
 }
 
+// IDFunc returns the document ID of a rule. It must return the same ID for the
+// same rule, and different IDs for different rules of the policy type.
+type IDFunc func(ptype string, rule []string) string
+
 func policyID(ptype string, rule []string) string {
 	data := strings.Join(append([]string{ptype}, rule...), ",")
 	sum := meow.Checksum(0, []byte(data))
 	return fmt.Sprintf("%x", sum)
 }
 
+// ChecksumID is the default IDFunc, a 64-bit meow checksum of the rule.
+var ChecksumID IDFunc = policyID
+
+// SHA256ID is an IDFunc returning the hex SHA-256 of the rule. Unlike the
+// default 64-bit checksum, whose collisions become likely with millions of
+// rules and silently merge two rules, it is collision resistant. The values
+// are JSON encoded, so rules containing commas don't produce the same ID either.
+func SHA256ID(ptype string, rule []string) string {
+	data, _ := json.Marshal(append([]string{ptype}, rule...))
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// compositeIDEscaper escapes the separator, the escape character and the
+// characters Cosmos doesn't allow in IDs.
+var compositeIDEscaper = strings.NewReplacer("%", "%25", "|", "%7C", "/", "%2F", "\\", "%5C", "?", "%3F", "#", "%23")
+
+// CompositeID is an IDFunc encoding the rule itself, like "p|alice|data1|read",
+// so documents can be recognized in the Data Explorer and rules point read by
+// building their ID. "|", "%" and the characters Cosmos forbids in IDs are
+// percent-encoded. Cosmos limits IDs to 1023 bytes, so it only suits rules
+// with short values.
+func CompositeID(ptype string, rule []string) string {
+	parts := make([]string, 0, len(rule)+1)
+	for _, value := range append([]string{ptype}, rule...) {
+		parts = append(parts, compositeIDEscaper.Replace(value))
+	}
+	return strings.Join(parts, "|")
+}
+
+// ParseCompositeID returns the policy type and the rule encoded in an ID
+// generated by CompositeID.
+func ParseCompositeID(id string) (ptype string, rule []string, err error) {
+	parts := strings.Split(id, "|")
+	for i, part := range parts {
+		if parts[i], err = url.PathUnescape(part); err != nil {
+			return "", nil, fmt.Errorf("invalid composite ID %q: %w", id, err)
+		}
+	}
+	return parts[0], parts[1:], nil
+}
+
 func savePolicyLine(ptype string, rule []string) CasbinRule {
 	line := CasbinRule{
 		PType: ptype,
 	}
 
-	if len(rule) > 0 {
-		line.V0 = rule[0]
-	}
-	if len(rule) > 1 {
-		line.V1 = rule[1]
+	values := line.values()
+	for i, value := range rule {
+		if i < len(values) {
+			*values[i] = value
+		}
 	}
-	if len(rule) > 2 {
-		line.V2 = rule[2]
+
+	line.ID = policyID(ptype, rule)
+	return line
+}
+
+// checkRule returns an error if the rule cannot be stored in the configured schema.
+func (a *Adapter) checkRule(rule []string) error {
+	if !a.arraySchema && len(rule) > maxRuleValues {
+		return fmt.Errorf("rule has %d values, at most %d are supported without Options.ArraySchema", len(rule), maxRuleValues)
 	}
-	if len(rule) > 3 {
-		line.V3 = rule[3]
+	return nil
+}
+
+// validateRule returns an error if the rule can't be written: if it can't be
+// stored in the configured schema, or Options.Validator rejects it once
+// normalized.
+func (a *Adapter) validateRule(ptype string, rule []string) error {
+	if err := a.checkRule(rule); err != nil {
+		return err
 	}
-	if len(rule) > 4 {
-		line.V4 = rule[4]
+	if a.validator == nil {
+		return nil
 	}
-	if len(rule) > 5 {
-		line.V5 = rule[5]
+	rule = a.normalize(ptype, rule)
+	if err := a.validator(ptype, rule); err != nil {
+		return fmt.Errorf("%w: %s, %s: %w", ErrInvalidRule, ptype, strings.Join(rule, ", "), err)
 	}
+	return nil
+}
 
-	line.ID = policyID(ptype, rule)
+// newPolicyLine returns the document storing the rule, in the configured schema,
+// with its values normalized by Options.Normalizer.
+func (a *Adapter) newPolicyLine(ptype string, rule []string) CasbinRule {
+	return a.policyLine(ptype, a.normalize(ptype, rule))
+}
+
+// policyLine returns the document storing the rule as is.
+func (a *Adapter) policyLine(ptype string, rule []string) CasbinRule {
+	var line CasbinRule
+	if a.arraySchema {
+		line = CasbinRule{PType: ptype, Rule: append([]string{}, rule...)}
+	} else {
+		line = savePolicyLine(ptype, rule)
+	}
+	if a.idFunc != nil {
+		line.ID = a.idFunc(ptype, rule)
+	} else {
+		line.ID = policyID(ptype, rule)
+	}
+	if a.namespace != "" {
+		line.Namespace = a.namespace
+		line.ID = a.namespace + ":" + line.ID
+	}
+	if a.generational {
+		line = withGeneration(line, a.generation.Load())
+	}
+	line.PartitionKey = a.partitionKeyValue(line)
+	line.SchemaVersion = currentSchemaVersion
 	return line
 }
 
-// SavePolicy saves policy to database.
-func (a *adapter) SavePolicy(model model.Model) error {
-	ctx := context.Background()
+// SavePolicy saves policy to database. Rules of the model with the same document
+// ID are saved once.
+func (a *Adapter) SavePolicy(model model.Model) (err error) {
+	return a.savePolicy(context.Background(), model)
+}
 
-	if a.filtered {
-		return errors.New("cannot save a filtered policy")
-	}
-	if err := a.dropCollection(); err != nil {
+func (a *Adapter) savePolicy(ctx context.Context, model model.Model) (err error) {
+	ctx, op := a.startOperation(ctx, "SavePolicy")
+	defer func() { err = a.endOperation(op, err) }()
+
+	m := &Mutation{Operation: op.name, Model: model}
+	if err := a.beforeMutation(ctx, m); err != nil {
 		return err
 	}
+	defer func() { a.afterMutation(ctx, m, err) }()
+	model = m.Model
 
+	if a.filtered.Load() {
+		return errors.New("cannot save a filtered policy")
+	}
+	// the rules are checked before anything is written
 	var lines []CasbinRule
+	// the model may hold the same rule twice, or rules stored under the same
+	// ID, which would fail the second write
+	seen := map[string]bool{}
 
 	for ptype, ast := range model["p"] {
 		for _, rule := range ast.Policy {
-			line := savePolicyLine(ptype, rule)
+			if err := a.validateRule(ptype, rule); err != nil {
+				return err
+			}
+			line := a.newPolicyLine(ptype, rule)
+			if seen[line.ID] {
+				a.logger.Warn("skipped duplicate rule", "pType", ptype, "rule", rule)
+				continue
+			}
+			seen[line.ID] = true
 			lines = append(lines, line)
 		}
 	}
 
 	for ptype, ast := range model["g"] {
 		for _, rule := range ast.Policy {
-			line := savePolicyLine(ptype, rule)
+			if err := a.validateRule(ptype, rule); err != nil {
+				return err
+			}
+			line := a.newPolicyLine(ptype, rule)
+			if seen[line.ID] {
+				a.logger.Warn("skipped duplicate rule", "pType", ptype, "rule", rule)
+				continue
+			}
+			seen[line.ID] = true
 			lines = append(lines, line)
 		}
 	}
+	// Dropping the container also drops the version document, so remember it
+	// to keep the version increasing.
+	current, _, err := readVersionDocument(ctx, a.containerClient, a.versionID())
+	if err != nil {
+		return err
+	}
+	version, generation := current.Version, current.Generation
+	if a.generational {
+		generation++
+	}
+	expiries, err := a.storedExpiries(ctx, model)
+	if err != nil {
+		return err
+	}
+	if a.snapshotOnSave && a.snapshotClient != nil {
+		if _, err := a.snapshot(ctx); err != nil {
+			return err
+		}
+	}
+	switch {
+	case a.tombstones:
+	case a.generational:
+		// the previous generation, kept for the loads in flight when it was
+		// replaced, and the rules of an interrupted SavePolicy. The current
+		// generation is only replaced once the new one is complete.
+		if err := a.purgeGenerations(ctx, current.Generation); err != nil {
+			return err
+		}
+	case a.namespace != "":
+		// the container is shared with other namespaces
+		ptypes, err := a.savedPolicyTypes(ctx, model, current)
+		if err != nil {
+			return err
+		}
+		if err := a.clearNamespace(ctx, ptypes); err != nil {
+			return err
+		}
+	default:
+		if err := a.dropCollection(); err != nil {
+			return err
+		}
+	}
+
+	now := a.now()
+	kept := lines[:0]
+	for _, line := range lines {
+		if expiresAt, ok := expiries[line.ID]; ok {
+			// keep temporary rules temporary, and drop the expired ones
+			line.expire(expiresAt, now)
+			if line.expired(now) {
+				continue
+			}
+		}
+		if a.generational {
+			line = withGeneration(line, generation)
+		}
+		kept = append(kept, line)
+	}
+	lines = kept
 
+	var events []PolicyEvent
+	for _, ptype := range policyTypes(model) {
+		events = append(events, a.newEvent(ctx, EventClear, ptype, nil))
+	}
 	for _, line := range lines {
-		if err := a.save(ctx, line); err != nil {
+		events = append(events, a.newEvent(ctx, EventAdd, line.PType, policyTokens(line)))
+	}
+	if err := a.appendEvents(ctx, events...); err != nil {
+		return err
+	}
+
+	if a.tombstones {
+		// Dropping the container would hide the removals from LoadPolicyDelta,
+		// so tombstone every stored rule that is no longer in the model instead.
+		ptypes, err := a.savedPolicyTypes(ctx, model, current)
+		if err != nil {
+			return err
+		}
+		if err := a.tombstoneMissing(ctx, ptypes, lines); err != nil {
 			return err
 		}
 	}
+
+	if err := forEach(ctx, a.writeConcurrency, lines, a.save); err != nil {
+		return err
+	}
+
+	if a.tombstones {
+		return a.policyChanged(ctx, PolicyChange{})
+	}
+	ptypes := savedLineTypes(lines)
+	if err := writeVersion(ctx, a.containerClient, a.versionID(), a.versionPKField(), version+1, generation, ptypes); err != nil {
+		return err
+	}
+	// with DryRun the stored version and generation are unchanged
+	if !a.dryRun {
+		a.version.Store(version + 1)
+		a.policyTypeCache.set(version+1, ptypes)
+	}
+	if a.generational && !a.dryRun {
+		// the previous generation is kept for the loads reading it, and purged
+		// by the next SavePolicy
+		a.storeGeneration(generation)
+	}
+	return nil
+}
+
+// tombstoneMissing marks the stored rules of the policy types that are not
+// part of lines as deleted.
+func (a *Adapter) tombstoneMissing(ctx context.Context, ptypes []string, lines []CasbinRule) error {
+	keep := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		keep[line.ID] = true
+	}
+	for _, ptype := range ptypes {
+		query, parameters := a.inNamespace("SELECT * FROM c WHERE NOT IS_DEFINED(c.deleted)", nil)
+		stored, err := a.query(ctx, query, ptype, parameters)
+		if err != nil {
+			return err
+		}
+		for _, policy := range stored {
+			if keep[policy.ID] {
+				continue
+			}
+			if err := a.tombstone(ctx, policy); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tombstone replaces the stored rule with a document marked as deleted, so the
+// removal is picked up by LoadPolicyDelta.
+func (a *Adapter) tombstone(ctx context.Context, policy CasbinRule) error {
+	marshalled, err := a.tombstoneDocument(policy)
+	if err != nil {
+		return err
+	}
+	res, err := a.containerFor(policy.PType).UpsertItem(ctx, a.partitionKey(policy), marshalled, nil)
+	if err != nil {
+		return err
+	}
+	operationFrom(ctx).record(res.Response, 1)
+	return nil
+}
+
+// tombstoneDocument returns the document replacing the stored rule when it is
+// removed with tombstones enabled.
+func (a *Adapter) tombstoneDocument(policy CasbinRule) ([]byte, error) {
+	policy.Deleted = true
+	policy.Ts = 0
+	return a.encodeRule(policy)
+}
+
+// remove deletes the stored rule, or tombstones it when tombstones are enabled.
+func (a *Adapter) remove(ctx context.Context, policy CasbinRule) error {
+	if a.tombstones {
+		return a.tombstone(ctx, policy)
+	}
+	res, err := a.containerFor(policy.PType).DeleteItem(ctx, a.partitionKey(policy), policy.ID, nil)
+	if err != nil {
+		return err
+	}
+	operationFrom(ctx).record(res.Response, 1)
+	return nil
+}
+
+// removeAll removes the stored rules, recording their removal events first. The
+// rules are deleted, or tombstoned, in transactional batches of up to
+// maxBatchSize rules sharing a partition when the container is an azcosmos
+// client, one by one otherwise. A failed batch removes none of its rules and
+// stops the removal.
+func (a *Adapter) removeAll(ctx context.Context, policies []CasbinRule) error {
+	return a.removeRules(ctx, policies, true)
+}
+
+// removeRules removes the stored rules like removeAll, recording their removal
+// events only if record is set, as they may be recorded already.
+func (a *Adapter) removeRules(ctx context.Context, policies []CasbinRule, record bool) error {
+	type partition struct {
+		container string
+		key       string
+	}
+	var order []partition
+	partitions := map[partition][]CasbinRule{}
+	for _, policy := range policies {
+		p := partition{container: a.containerNameFor(policy.PType), key: policy.PType}
+		if a.partitionStrategy == PartitionByDomain {
+			p.key = policy.PartitionKey
+		}
+		if _, ok := partitions[p]; !ok {
+			order = append(order, p)
+		}
+		partitions[p] = append(partitions[p], policy)
+	}
+
+	for _, p := range order {
+		client := cosmosContainer(a.containers[p.container])
+		rules := partitions[p]
+		for start := 0; start < len(rules); start += maxBatchSize {
+			chunk := rules[start:min(start+maxBatchSize, len(rules))]
+			if record {
+				if err := a.appendEvents(ctx, a.removeEvents(ctx, chunk)...); err != nil {
+					return err
+				}
+			}
+			if client == nil {
+				for _, policy := range chunk {
+					if err := a.remove(ctx, policy); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			batch := client.NewTransactionalBatch(azcosmos.NewPartitionKeyString(p.key))
+			for _, policy := range chunk {
+				if !a.tombstones {
+					batch.DeleteItem(policy.ID, nil)
+					continue
+				}
+				marshalled, err := a.tombstoneDocument(policy)
+				if err != nil {
+					return err
+				}
+				batch.UpsertItem(marshalled, nil)
+			}
+			res, err := client.ExecuteTransactionalBatch(ctx, batch, nil)
+			if err != nil {
+				return err
+			}
+			operationFrom(ctx).record(res.Response, len(chunk))
+			if !res.Success {
+				return batchCause(res)
+			}
+		}
+	}
 	return nil
 }
 
 // AddPolicy adds a policy rule to the storage.
-func (a *adapter) AddPolicy(sec string, ptype string, rule []string) error {
-	ctx := context.Background()
+func (a *Adapter) AddPolicy(sec string, ptype string, rule []string) (err error) {
+	return a.addPolicy(context.Background(), sec, ptype, rule)
+}
+
+func (a *Adapter) addPolicy(ctx context.Context, sec string, ptype string, rule []string) (err error) {
+	ctx, op := a.startOperation(ctx, "AddPolicy")
+	defer func() { err = a.endOperation(op, err) }()
+
+	m := &Mutation{Operation: op.name, Sec: sec, PType: ptype, Rules: [][]string{rule}}
+	if err := a.beforeMutation(ctx, m); err != nil {
+		return err
+	}
+	defer func() { a.afterMutation(ctx, m, err) }()
+	if len(m.Rules) == 0 {
+		return nil
+	}
+
+	policy, err := a.addRule(ctx, ptype, m.Rules[0])
+	if err != nil {
+		return err
+	}
+	return a.policyChanged(ctx, a.ruleChange(policy))
+}
 
-	policy := savePolicyLine(ptype, rule)
-	return a.save(ctx, policy)
+// AddPolicies adds policy rules to the storage. The rules are written one by
+// one: if some fail, the others are stored and the policy version is bumped,
+// and the returned error joins a *RuleError for every failed rule, see
+// FailedRules.
+func (a *Adapter) AddPolicies(sec string, ptype string, rules [][]string) (err error) {
+	return a.addPolicies(context.Background(), sec, ptype, rules)
+}
+
+func (a *Adapter) addPolicies(ctx context.Context, sec string, ptype string, rules [][]string) (err error) {
+	ctx, op := a.startOperation(ctx, "AddPolicies")
+	defer func() { err = a.endOperation(op, err) }()
+
+	m := &Mutation{Operation: op.name, Sec: sec, PType: ptype, Rules: rules}
+	if err := a.beforeMutation(ctx, m); err != nil {
+		return err
+	}
+	defer func() { a.afterMutation(ctx, m, err) }()
+
+	var added []CasbinRule
+	var errs []error
+	for _, rule := range m.Rules {
+		policy, err := a.addRule(ctx, ptype, rule)
+		if err != nil {
+			errs = append(errs, ruleError(ctx, ptype, rule, err))
+			continue
+		}
+		added = append(added, policy)
+	}
+	if len(added) > 0 {
+		if err := a.policyChanged(ctx, a.ruleChange(added...)); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// addRule writes a rule added by AddPolicy or AddPolicies, and returns its
+// document. The policy version is not bumped.
+func (a *Adapter) addRule(ctx context.Context, ptype string, rule []string) (CasbinRule, error) {
+	rule = a.normalize(ptype, rule)
+	if err := a.validateRule(ptype, rule); err != nil {
+		return CasbinRule{}, err
+	}
+	policy := a.newPolicyLine(ptype, rule)
+	added, err := a.checkQuota(ctx, policy)
+	if err != nil {
+		return CasbinRule{}, err
+	}
+	if err := a.appendEvents(ctx, a.newEvent(ctx, EventAdd, ptype, rule)); err != nil {
+		return CasbinRule{}, err
+	}
+	if err := a.save(ctx, policy); err != nil {
+		return CasbinRule{}, err
+	}
+	added()
+	return policy, nil
 }
 
-func (a *adapter) save(ctx context.Context, policy CasbinRule) error {
-	marshalled, err := json.Marshal(policy)
+func (a *Adapter) save(ctx context.Context, policy CasbinRule) error {
+	marshalled, err := a.encodeRule(policy)
 
 	if err != nil {
 		return err
 	}
 
-	res, err := a.containerClient.CreateItem(ctx, azcosmos.NewPartitionKeyString(policy.PType), marshalled, nil)
+	var res azcosmos.ItemResponse
+	if a.tombstones {
+		// A tombstone of the same rule may exist, which is overwritten.
+		res, err = a.containerFor(policy.PType).UpsertItem(ctx, a.partitionKey(policy), marshalled, nil)
+	} else {
+		res, err = a.containerFor(policy.PType).CreateItem(ctx, a.partitionKey(policy), marshalled, nil)
+	}
 	if err != nil {
 		return err
 	}
+	operationFrom(ctx).record(res.Response, 1)
 
-	if statusCode := res.RawResponse.StatusCode; statusCode != http.StatusCreated {
+	if statusCode := res.RawResponse.StatusCode; statusCode != http.StatusCreated && statusCode != http.StatusOK {
 		return errors.New(fmt.Sprintf("Unable to save policy: unexpected status code %d", statusCode))
 	}
 	return err
 }
 
 // RemovePolicy removes a policy rule from the storage.
-func (a *adapter) RemovePolicy(sec string, ptype string, rule []string) error {
-	ctx := context.Background()
+func (a *Adapter) RemovePolicy(sec string, ptype string, rule []string) (err error) {
+	return a.removePolicy(context.Background(), sec, ptype, rule)
+}
+
+func (a *Adapter) removePolicy(ctx context.Context, sec string, ptype string, rule []string) (err error) {
+	ctx, op := a.startOperation(ctx, "RemovePolicy")
+	defer func() { err = a.endOperation(op, err) }()
 
-	policy := savePolicyLine(ptype, rule)
-	_, err := a.containerClient.DeleteItem(ctx, azcosmos.NewPartitionKeyString(policy.PType), policy.ID, nil)
+	m := &Mutation{Operation: op.name, Sec: sec, PType: ptype, Rules: [][]string{rule}}
+	if err := a.beforeMutation(ctx, m); err != nil {
+		return err
+	}
+	defer func() { a.afterMutation(ctx, m, err) }()
+	if len(m.Rules) == 0 {
+		return nil
+	}
+
+	policy, err := a.removeRule(ctx, ptype, m.Rules[0])
 	if err != nil {
 		return err
 	}
-	return err
+	return a.policyChanged(ctx, a.ruleChange(policy))
 }
 
-// RemoveFilteredPolicy removes policy rules that match the filter from the storage.
-func (a *adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
-	ctx := context.Background()
+// RemovePolicies removes policy rules from the storage. The rules are removed
+// one by one: if some fail, the others are removed and the policy version is
+// bumped, and the returned error joins a *RuleError for every failed rule,
+// see FailedRules.
+func (a *Adapter) RemovePolicies(sec string, ptype string, rules [][]string) (err error) {
+	return a.removePolicies(context.Background(), sec, ptype, rules)
+}
 
-	selector := make(map[string]interface{})
+func (a *Adapter) removePolicies(ctx context.Context, sec string, ptype string, rules [][]string) (err error) {
+	ctx, op := a.startOperation(ctx, "RemovePolicies")
+	defer func() { err = a.endOperation(op, err) }()
 
-	if fieldIndex <= 0 && 0 < fieldIndex+len(fieldValues) {
-		if fieldValues[0-fieldIndex] != "" {
-			selector["v0"] = fieldValues[0-fieldIndex]
-		}
+	m := &Mutation{Operation: op.name, Sec: sec, PType: ptype, Rules: rules}
+	if err := a.beforeMutation(ctx, m); err != nil {
+		return err
 	}
-	if fieldIndex <= 1 && 1 < fieldIndex+len(fieldValues) {
-		if fieldValues[1-fieldIndex] != "" {
-			selector["v1"] = fieldValues[1-fieldIndex]
+	defer func() { a.afterMutation(ctx, m, err) }()
+
+	var removed []CasbinRule
+	var errs []error
+	for _, rule := range m.Rules {
+		policy, err := a.removeRule(ctx, ptype, rule)
+		if err != nil {
+			errs = append(errs, ruleError(ctx, ptype, rule, err))
+			continue
 		}
+		removed = append(removed, policy)
 	}
-	if fieldIndex <= 2 && 2 < fieldIndex+len(fieldValues) {
-		if fieldValues[2-fieldIndex] != "" {
-			selector["v2"] = fieldValues[2-fieldIndex]
+	if len(removed) > 0 {
+		if err := a.policyChanged(ctx, a.ruleChange(removed...)); err != nil {
+			return err
 		}
 	}
-	if fieldIndex <= 3 && 3 < fieldIndex+len(fieldValues) {
-		if fieldValues[3-fieldIndex] != "" {
-			selector["v3"] = fieldValues[3-fieldIndex]
-		}
+	return errors.Join(errs...)
+}
+
+// removeRule removes a rule removed by RemovePolicy or RemovePolicies, and
+// returns its document. The policy version is not bumped.
+func (a *Adapter) removeRule(ctx context.Context, ptype string, rule []string) (CasbinRule, error) {
+	if err := a.checkRule(rule); err != nil {
+		return CasbinRule{}, err
 	}
-	if fieldIndex <= 4 && 4 < fieldIndex+len(fieldValues) {
-		if fieldValues[4-fieldIndex] != "" {
-			selector["v4"] = fieldValues[4-fieldIndex]
-		}
+	rule = a.normalize(ptype, rule)
+	policy := a.newPolicyLine(ptype, rule)
+	if err := a.appendEvents(ctx, a.newEvent(ctx, EventRemove, ptype, rule)); err != nil {
+		return CasbinRule{}, err
 	}
-	if fieldIndex <= 5 && 5 < fieldIndex+len(fieldValues) {
-		if fieldValues[5-fieldIndex] != "" {
-			selector["v5"] = fieldValues[5-fieldIndex]
+	copies, err := a.storedCopies(ctx, policy)
+	if err != nil {
+		return CasbinRule{}, err
+	}
+	for _, stored := range copies {
+		if err := a.remove(ctx, stored); err != nil {
+			return CasbinRule{}, err
 		}
 	}
+	a.quotas.removed(policy)
+	return policy, nil
+}
+
+// RemoveFilteredPolicy removes policy rules that match the filter from the storage.
+// The matches are deleted in transactional batches per partition, so a wide
+// filter takes a request per hundred rules rather than one per rule. Without
+// field values, the partition of the policy type may be deleted instead, which
+// Cosmos carries out in the background: a load right after it can still read
+// some of the removed rules.
+func (a *Adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) (err error) {
+	return a.removeFilteredPolicy(context.Background(), sec, ptype, fieldIndex, fieldValues...)
+}
 
-	query := "SELECT * FROM root WHERE root.pType = @pType"
-	parameters := []azcosmos.QueryParameter{{Name: "@pType", Value: ptype}}
-	for key, value := range selector {
-		query += " AND root." + key + " = @" + key
-		parameters = append(parameters, azcosmos.QueryParameter{Name: "@" + key, Value: value})
+func (a *Adapter) removeFilteredPolicy(ctx context.Context, sec string, ptype string, fieldIndex int, fieldValues ...string) (err error) {
+	ctx, op := a.startOperation(ctx, "RemoveFilteredPolicy")
+	defer func() { err = a.endOperation(op, err) }()
+
+	m := &Mutation{Operation: op.name, Sec: sec, PType: ptype, FieldIndex: fieldIndex, FieldValues: fieldValues}
+	if err := a.beforeMutation(ctx, m); err != nil {
+		return err
 	}
+	defer func() { a.afterMutation(ctx, m, err) }()
 
-	var policies []CasbinRule
-	queryPager := a.containerClient.NewQueryItemsPager(query, azcosmos.NewPartitionKeyString(ptype), &azcosmos.QueryOptions{QueryParameters: parameters})
-	for queryPager.More() {
-		res, err := queryPager.NextPage(ctx)
-		if err != nil {
+	values := a.normalizeFrom(ptype, fieldIndex, m.FieldValues)
+	if indexes, _ := a.filterValues(fieldIndex, values); len(indexes) == 0 && a.canPurge(ptype) && a.eventsClient == nil && a.auditClient == nil {
+		// every rule of the policy type is removed and no event or audit
+		// record lists them, so they are not read
+		if purged, err := a.purgeUnread(ctx, ptype); purged || err != nil {
 			return err
 		}
-		for _, item := range res.Items {
-			var policy CasbinRule
-			if err := json.Unmarshal(item, &policy); err != nil {
-				return err
-			}
-			policies = append(policies, policy)
+	}
+	policies, indexes, err := a.filteredRules(ctx, ptype, fieldIndex, values)
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+	purged, recorded := false, false
+	if len(indexes) == 0 && a.canPurge(ptype) {
+		// every rule of the policy type is removed, delete its partition once
+		// their removal is recorded
+		if err := a.appendEvents(ctx, a.removeEvents(ctx, policies)...); err != nil {
+			return err
+		}
+		recorded = true
+		if purged, err = a.purgePolicyType(ctx, ptype, policies); err != nil {
+			return err
 		}
 	}
-
-	for _, policy := range policies {
-		_, err := a.containerClient.DeleteItem(ctx, azcosmos.NewPartitionKeyString(policy.PType), policy.ID, nil)
-		if err != nil {
+	if !purged {
+		if err := a.removeRules(ctx, policies, !recorded); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	a.quotas.removed(policies...)
+	return a.policyChanged(ctx, a.ruleChange(policies...))
+}
+
+// filterValues returns the indexes of the values the filter of
+// RemoveFilteredPolicy sets, and the values.
+func (a *Adapter) filterValues(fieldIndex int, fieldValues []string) (indexes []int, values []string) {
+	end := fieldIndex + len(fieldValues)
+	if !a.arraySchema && end > maxRuleValues {
+		end = maxRuleValues
+	}
+	for i := max(fieldIndex, 0); i < end; i++ {
+		if value := fieldValues[i-fieldIndex]; value != "" {
+			indexes = append(indexes, i)
+			values = append(values, value)
+		}
+	}
+	return indexes, values
+}
+
+// filteredRules returns the stored rules matching the filter of
+// RemoveFilteredPolicy, and the indexes of the values the filter sets.
+func (a *Adapter) filteredRules(ctx context.Context, ptype string, fieldIndex int, fieldValues []string) ([]CasbinRule, []int, error) {
+	indexes, values := a.filterValues(fieldIndex, fieldValues)
+	filter := a.filterQueryFor(indexes)
+	query, parameters := filter.query, filter.parameters(ptype, values)
+	matches, err := a.query(ctx, query, ptype, parameters)
+	if err != nil {
+		return nil, nil, err
+	}
+	var policies []CasbinRule
+	for _, policy := range matches {
+		if !policy.Deleted && matchesFilter(policy, fieldIndex, fieldValues) {
+			policies = append(policies, policy)
+		}
+	}
+	return policies, indexes, nil
 }
 
 type Options struct {
 	azcosmos.ClientOptions
 	DatabaseName  string
 	ContainerName string
+	// Tombstones makes removals mark documents as deleted instead of deleting
+	// them, so LoadPolicyDelta can apply removals made by other instances.
+	Tombstones bool
+	// GenerationalSave makes SavePolicy write the rules as a new generation of
+	// documents and then switch the policy version document to it, instead of
+	// emptying the container first. The previous generation is deleted by the
+	// next SavePolicy. Loads by other instances during a SavePolicy read the
+	// complete previous policy rather than an empty or partial one, and a load
+	// overtaken by a SavePolicy reads the new generation. Every operation reads
+	// the version document first, a point read, and LoadPolicy reads it again
+	// after the rules. It has no effect with Tombstones, whose
+	// SavePolicy never empties the container, and needs the default field names
+	// with Mapper.
+	GenerationalSave bool
+	// WriteConcurrency is the number of rules SavePolicy writes in parallel.
+	// Defaults to 4, 1 writes them one by one. Raise it to save large policies
+	// faster on containers with the throughput to match.
+	WriteConcurrency int
+	// Domains tells the adapter that the model uses RBAC with domains, where the
+	// domain is v1 of p rules and v2 of g rules. Watchers then report the domains
+	// affected by a change.
+	Domains bool
+	// ArraySchema stores rules as {"id", "pType", "rule": ["alice", "data1", "read"]}
+	// instead of v0 to v5, which allows queries with ARRAY_CONTAINS. Documents
+	// in either form are read, and rewritten in the array form when saved.
+	ArraySchema bool
+	// UpgradeSchemaOnLoad makes LoadPolicy rewrite the documents it read that
+	// were written in an older schema version, see MigrateSchema.
+	UpgradeSchemaOnLoad bool
+	// IDFunc, if set, generates the document IDs of rules instead of the default
+	// checksum, for example SHA256ID, CompositeID, or IDs matching documents
+	// created by another system.
+	// Changing it for an existing container requires a SavePolicy, so rules
+	// are stored again under their new IDs. UpdatePolicy only patches the
+	// documents whose ID the update leaves unchanged, never with the default
+	// IDs.
+	IDFunc IDFunc
+	// Validator, if set, checks the rules before they are written by AddPolicy,
+	// AddPolicyWithExpiry, SavePolicy, the imports, CopyPolicy and
+	// MigrateFromSQL, which fail with an error wrapping ErrInvalidRule and the
+	// error of the validator before sending any write. Removals are not checked.
+	Validator Validator
+	// Normalizer, if set, normalizes every value of the rules written, such as
+	// TrimSpace or LowerCase, before their document IDs are computed. The values
+	// of the rules removed and of the RemoveFilteredPolicy filters are
+	// normalized the same way, so "Alice " and "alice" are one rule. Empty
+	// values are left unchanged. Rules already stored are not rewritten: a
+	// SavePolicy after loading them stores them normalized.
+	Normalizer Normalizer
+	// RuleExpiry enables TTL on the container, without a default expiry, so
+	// temporary rules added by AddPolicyWithExpiry are deleted by Cosmos.
+	RuleExpiry bool
+	// Namespace, if set, is written to every document and restricts every load
+	// and removal to the documents of the namespace, so several applications or
+	// environments can share a container. It is also part of the document IDs,
+	// and must not contain "/", "\\", "?" or "#".
+	Namespace string
+	// Mapper, if set, controls how rules map to documents, for custom field
+	// names or extra fields. RemoveFilteredPolicy then reads the whole policy
+	// type and matches the rules itself.
+	Mapper DocumentMapper
+	// Compat, if set, reads and writes documents in the schema of another
+	// adapter, see LowercaseSchema and PascalCaseSchema. It takes precedence
+	// over Mapper.
+	Compat *CompatSchema
+	// GroupingContainerName, if set, stores the rules of the g policy types in
+	// a container of their own, which can be given its own throughput and
+	// indexing policy. It is created like the rules container if it does not
+	// exist.
+	GroupingContainerName string
+	// PTypeContainers maps policy types, such as "p2" or "g", to the container
+	// storing their rules, taking precedence over GroupingContainerName.
+	// Policy types not listed are stored in the rules container. The
+	// containers are created if they don't exist.
+	PTypeContainers map[string]string
+	// MaxRules, if set, makes AddPolicy fail with a *QuotaError, matching
+	// ErrQuotaExceeded, when the namespace, or the container without one,
+	// already holds that many rules.
+	MaxRules int
+	// MaxRulesPerDomain, if set, makes AddPolicy fail with a *QuotaError when
+	// the domain of the rule already holds that many rules. It requires
+	// Options.Domains. The counts are cached for a minute, so concurrent adds
+	// through several instances may overshoot the quota slightly.
+	MaxRulesPerDomain int
+	// PartitionStrategy selects the partition key of the rules container. It
+	// is set when the container is created and can't be changed afterwards.
+	PartitionStrategy PartitionStrategy
+	// TenantCacheTTL, if set, caches the rules loaded by LoadPolicyForTenant
+	// for this long, per domain.
+	TenantCacheTTL time.Duration
+	// FilterCacheSize, if set, caches the rules loaded by LoadFilteredPolicy
+	// for this many filters, evicting the least recently used. Cached rules are
+	// reused while the policy version is unchanged, at the cost of a point
+	// read, see InvalidateFilterCache.
+	FilterCacheSize int
+	// FilterCacheTTL, if set, limits the age of the rules cached by
+	// LoadFilteredPolicy.
+	FilterCacheTTL time.Duration
+	// EventSourcing records every mutation as an immutable PolicyEvent in the
+	// event container, next to the rules container which keeps holding the
+	// current policy. See GetEvents and ReplayEvents.
+	EventSourcing bool
+	// EventContainerName is the container holding the events. Defaults to the
+	// container name with an "_events" suffix.
+	EventContainerName string
+	// Actor is recorded as the author of the events and audit records.
+	Actor string
+	// AuditContainerName, if set, enables auditing: every mutation made by
+	// AddPolicy, RemovePolicy, RemoveFilteredPolicy or SavePolicy is recorded
+	// as an AuditRecord in this container, created if it does not exist.
+	AuditContainerName string
+	// AuditCorrelationID, if set, is called at the start of every operation to
+	// get the correlation ID of its audit records, such as the ID of the
+	// request being served. A random ID is used when it returns "".
+	AuditCorrelationID func() string
+	// AuditChain chains every audit record to the previous one with a hash,
+	// so VerifyAuditChain can prove the records were not altered, removed or
+	// added since. The records are then written one at a time, as the chain
+	// doesn't fork. Requires AuditContainerName.
+	AuditChain bool
+	// SnapshotContainerName, if set, is the container holding the policy
+	// snapshots taken by SnapshotPolicy, and restored by RestorePolicyVersion.
+	// It is created if it does not exist.
+	SnapshotContainerName string
+	// SnapshotOnSave makes SavePolicy take a snapshot of the stored policy
+	// before replacing it, so a bad bulk change can be rolled back. It requires
+	// SnapshotContainerName.
+	SnapshotOnSave bool
+	// ConflictResolutionPolicy is set on the rules container when the adapter
+	// creates it. With multi-region writes, use the custom mode without a stored
+	// procedure to keep conflicts in the conflict feed, see ResolveConflicts.
+	ConflictResolutionPolicy *azcosmos.ConflictResolutionPolicy
+	// IndexingPolicy, if set, is the indexing policy of the rules containers,
+	// set when they are created and updated when it differs. Defaults to the
+	// Cosmos policy, which indexes every field.
+	IndexingPolicy *azcosmos.IndexingPolicy
+	// AnalyticalStoreTTL, if not zero, enables the analytical store on the
+	// rules containers the adapter creates, keeping the rules there for that
+	// long, or forever when negative, so the policy can be analyzed with Azure
+	// Synapse Link, such as for reports of unused permissions, without
+	// consuming the request units of the containers. The account must have
+	// Synapse Link enabled. The ttl of existing containers with an analytical
+	// store is updated when it differs; the analytical store is not enabled on
+	// existing containers without one, which is logged as a warning.
+	AnalyticalStoreTTL time.Duration
+	// SkipAutoCreate keeps the constructors from creating the database and the
+	// containers, or updating their settings, for adapters running without the
+	// permission to. Provision them with EnsureInfrastructure instead.
+	SkipAutoCreate bool
+	// DataPlaneOnly keeps the adapter from sending control-plane requests,
+	// such as reading or creating the database and the containers, for
+	// identities with Cosmos data-plane role assignments only. It implies
+	// SkipAutoCreate. SavePolicy deletes the stored rules one by one instead of
+	// recreating the containers, Ping reads the policy version instead of the
+	// container properties, ChangeFeedProcessor requires its lease container
+	// to exist, and ScaleThroughput and BurstThroughput fail.
+	DataPlaneOnly bool
+	// SkipMalformedDocuments makes the adapter skip the documents of the rules
+	// containers that are not valid rules, such as documents with fields of
+	// the wrong type or without pType or values, logging a warning for each.
+	// By default loads read every document and then fail with a
+	// *MalformedDocumentsError listing the malformed ones, and other
+	// operations fail on the first one.
+	SkipMalformedDocuments bool
+	// OnSkippedDocument, if set, is called with the JSON of the documents that
+	// are not valid rules, and why, instead of failing, for rules containers
+	// shared with other data, such as leases or the documents of other
+	// applications. The documents are skipped without a warning.
+	OnSkippedDocument func(document []byte, err error)
+	// Signer, if set, signs every rule document written with a signature in
+	// its "signature" field, which loads verify to detect the rules changed
+	// other than by the adapter, such as through the Azure portal. A custom
+	// Mapper must keep the Signature field.
+	Signer Signer
+	// SignaturePolicy is what loads do with the rules whose signature is
+	// missing or invalid, with Signer. Defaults to SignatureReject.
+	SignaturePolicy SignaturePolicy
+	// DryRun makes the adapter skip its writes, logging them at info level
+	// and reporting them to OnDryRun instead, to preview bulk policy changes
+	// on a production container. The reads and queries are made, so the
+	// writes are computed from the stored rules, and the queries are logged
+	// with LogQueries. Transactional batches are reported as their writes,
+	// SavePolicy reports the deletion of the stored documents instead of the
+	// drop of the containers, and the containers are not created. The
+	// features needing azcosmos clients, such as ChangeFeedProcessor, are not
+	// available.
+	DryRun bool
+	// OnDryRun, if set, is called with every write skipped with DryRun.
+	OnDryRun func(DryWrite)
+	// StrictDecoding makes the documents of the rules containers with fields
+	// CasbinRule doesn't have, or null fields, malformed, so they are reported
+	// like documents of the wrong shape. The Cosmos system properties, starting
+	// with an underscore, are allowed. It has no effect with Mapper or Compat.
+	StrictDecoding bool
+	// OperationTimeout, if set, bounds every call to Cosmos, retries included,
+	// whose context has no deadline, so an unreachable gateway can't hang
+	// LoadPolicy, whose context is always context.Background(). Calls made with
+	// a context that has a deadline, such as Ping(ctx), keep that deadline.
+	// Containers returned by NewContainer are not bounded.
+	OperationTimeout time.Duration
+	// LoadTimeout, WriteTimeout and BulkTimeout, if set, bound the operations
+	// of a kind as a whole, their pages and retries included, when their
+	// context has no deadline, as a full LoadPolicy takes far longer than an
+	// AddPolicy. LoadTimeout bounds the loads and queries, such as LoadPolicy,
+	// LoadFilteredPolicy or QueryRules, WriteTimeout the writes of rules, such
+	// as AddPolicy or RemoveFilteredPolicy, and BulkTimeout the operations
+	// writing or reading the whole policy: SavePolicy, the imports, exports,
+	// migrations, backups, snapshots and restores. OperationTimeout still
+	// bounds each call within them, and they bound the containers returned by
+	// NewContainer as well.
+	LoadTimeout  time.Duration
+	WriteTimeout time.Duration
+	BulkTimeout  time.Duration
+	// SecondaryRegion, if set, is a region of the account, such as "West US 2",
+	// LoadPolicy reads the rules from when loading them from the account fails
+	// because of a regional outage: a request failing without a response,
+	// timing out or failing with a server error. Enforcement can then keep
+	// loading the policy during an incident of the write region. The region is
+	// only read from. It requires an adapter created by
+	// NewAdapterFromConnectionString or NewAdapterFromCredential, and has no
+	// effect with NewContainer.
+	SecondaryRegion string
+	// AccountKeySource, if set, returns the current base64 account key, such
+	// as a secret of Azure Key Vault the key is rotated into. It is called
+	// when Cosmos rejects a request as unauthorized, after the key was
+	// regenerated, and the request is retried once signed with the key
+	// returned. It requires an adapter created by
+	// NewAdapterFromConnectionString. See also SetAccountKey.
+	AccountKeySource func(ctx context.Context) (string, error)
+	// LazyConnect keeps the constructors from sending requests to Cosmos, so
+	// applications can create their enforcers before Cosmos is reachable. The
+	// database and the containers are created by the first operation instead,
+	// which fails with the error rather than panicking, and the next operation
+	// tries again. LazyConnect has no effect with SkipAutoCreate, which sends no
+	// request either.
+	LazyConnect bool
+	// NewContainer, if set, returns the container of the given name, instead
+	// of the client given to the constructor, which may be nil. The database
+	// and the containers are not created. Scans and bulk writes fall back to
+	// queries and single writes, while ChangeFeedProcessor and the provisioning
+	// functions need azcosmos clients.
+	NewContainer func(name string) Container
+	// Now, if set, replaces time.Now for the times the adapter stores or
+	// compares with stored times: the times of events, audit records and
+	// snapshots, and the expiry of temporary rules. Tests can set a fixed
+	// clock to compare documents with golden files.
+	Now func() time.Time
+	// NewID, if set, generates the random IDs of events, audit records and
+	// correlation IDs, instead of 128 random bits, so tests can make them
+	// deterministic. Use IDFunc for the IDs of rules.
+	NewID func() string
+	// Faults, if set, injects errors, latency and throttling into the
+	// requests of the adapter, for tests. See FaultInjector.
+	Faults *FaultInjector
+	// TracerProvider is used to create a span for every adapter operation.
+	// Defaults to the global OpenTelemetry tracer provider.
+	TracerProvider trace.TracerProvider
+	// Logger receives the adapter logs: connection setup and container creation
+	// at info level, throttled requests at warn level, failed operations at error
+	// level, and queries and retries at debug level. Logs are discarded by default.
+	Logger *slog.Logger
+	// LogQueries logs every query at info level instead of debug, with its
+	// parameters and continuation tokens, to debug queries returning nothing.
+	LogQueries bool
+	// RedactQueryParameters hides the parameter values in the query logs.
+	RedactQueryParameters bool
+	// OnOperation, if set, is called after every adapter operation, to report
+	// it to any telemetry system.
+	OnOperation func(OperationInfo)
+	// Hooks run around the mutations of the policy, to veto, rewrite or
+	// observe them. See Hook.
+	Hooks []Hook
+	// MeterProvider is used to record the duration, request charge, items,
+	// query pages and errors of adapter operations. Defaults to the global
+	// OpenTelemetry meter provider.
+	MeterProvider metric.MeterProvider
 }
@@ -15,22 +15,58 @@
 package cosmosadapter
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
 	"github.com/casbin/casbin/v2/util"
+	cosmostestutil "github.com/rickdana/cosmos-casbin-adapter/testutil"
 	"github.com/stretchr/testify/assert"
-	"os"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-var testConnString = os.Getenv("TEST_COSMOS_URL")
 var options = Options{
 	DatabaseName:  "casbin",
 	ContainerName: "casbin_rule",
 }
 
-func getConnString() string {
-	return testConnString
+// getConnString returns TEST_COSMOS_URL, and skips the test when it is not set.
+func getConnString(t testing.TB) string {
+	t.Helper()
+	return cosmostestutil.ConnectionString(t)
 }
 
 func testGetPolicy(t *testing.T, e *casbin.Enforcer, res [][]string) {
@@ -53,7 +89,7 @@ func initPolicy(t *testing.T, db, coll string) {
 		ContainerName: coll,
 	}
 
-	a := NewAdapterFromConnectionSting(getConnString(), options)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
 	// This is a trick to save the current policy to the DB.
 	// We can't call e.SavePolicy() because the adapter in the enforcer is still the file adapter.
 	// The current policy means the policy in the Casbin enforcer (aka in memory).
@@ -85,7 +121,7 @@ func TestAdapter(t *testing.T) {
 	// Now the DB has policy, so we can provide a normal use case.
 	// Create an adapter and an enforcer.
 	// NewEnforcer() will load the policy automatically.
-	a := NewAdapterFromConnectionSting(getConnString(), options)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
 	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
 
 	if err != nil {
@@ -156,7 +192,7 @@ func TestAdapter(t *testing.T) {
 
 func TestDeleteFilteredAdapter(t *testing.T) {
 
-	a := NewAdapterFromConnectionSting(getConnString(), options)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
 	e, err := casbin.NewEnforcer("examples/rbac_tenant_service.conf", a)
 	if err != nil {
 		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
@@ -190,7 +226,7 @@ func TestFilteredAdapter(t *testing.T) {
 	// Now the DB has policy, so we can provide a normal use case.
 	// Create an adapter and an enforcer.
 	// NewEnforcer() will load the policy automatically.
-	a := NewAdapterFromConnectionSting(getConnString(), options)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
 	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
 	if err != nil {
 		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
@@ -249,7 +285,7 @@ func TestAdapterWithOptions(t *testing.T) {
 	// Create an adapter and an enforcer.
 	// NewEnforcer() will load the policy automatically.
 	opt := Options{DatabaseName: "mycasbindb", ContainerName: "mycasbincollection"}
-	a := NewAdapterFromConnectionSting(getConnString(), opt)
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
 	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
 	if err != nil {
 		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
@@ -312,3 +348,4208 @@ func TestAdapterWithOptions(t *testing.T) {
 	}
 	testGetPolicy(t, e, [][]string{})
 }
+
+func TestIncrementalFilteredAdapter(t *testing.T) {
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+
+	e.AddPolicy("alice", "data1", "write")
+	e.AddPolicy("bob", "data2", "write")
+
+	filter := SqlQuerySpec{Query: "SELECT * FROM root WHERE root.v0 = @v0", Parameters: []azcosmos.QueryParameter{{Name: "@v0", Value: "bob"}}}
+	if err := e.LoadFilteredPolicy(filter); err != nil {
+		t.Errorf("Expected LoadFilteredPolicy() to be successful; got %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}})
+
+	// Alice's policy is appended to bob's instead of replacing it.
+	if err := e.LoadIncrementalFilteredPolicy(Q("SELECT * FROM root WHERE root.v0 = @v0", azcosmos.QueryParameter{Name: "@v0", Value: "alice"})); err != nil {
+		t.Errorf("Expected LoadIncrementalFilteredPolicy() to be successful; got %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}, {"alice", "data1", "write"}})
+
+	// Loading an overlapping filter again does not duplicate rules.
+	if err := e.LoadIncrementalFilteredPolicy(filter); err != nil {
+		t.Errorf("Expected LoadIncrementalFilteredPolicy() to be successful; got %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}, {"alice", "data1", "write"}})
+
+	if err := e.LoadPolicy(); err != nil {
+		t.Errorf("Expected LoadPolicy() to be successful; got %v", err)
+	}
+	e.RemoveFilteredPolicy(2, "write")
+}
+
+func TestLoadPolicyDelta(t *testing.T) {
+	opt := Options{DatabaseName: options.DatabaseName, ContainerName: options.ContainerName, Tombstones: true}
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	e.AddPolicy("alice", "data1", "write")
+
+	// A second adapter plays the role of another instance sharing the container.
+	other := NewAdapterFromConnectionSting(getConnString(t), opt)
+	other.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	other.RemovePolicy("p", "p", []string{"alice", "data1", "write"})
+
+	if err := a.LoadPolicyDelta(e.GetModel()); err != nil {
+		t.Errorf("Expected LoadPolicyDelta() to be successful; got %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}})
+
+	e.RemoveFilteredPolicy(2, "write")
+}
+
+func TestLoadPolicyPages(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	e.ClearPolicy()
+
+	var cursor LoadCursor
+	for !cursor.Done {
+		if cursor, err = a.LoadPolicyPages(e.GetModel(), cursor, 1); err != nil {
+			t.Fatalf("Expected LoadPolicyPages() to be successful; got %v", err)
+		}
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func TestPollingWatcher(t *testing.T) {
+	container := newMapContainer()
+	a := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }})
+	w1 := NewPollingWatcher(a, 100*time.Millisecond)
+	defer w1.Close()
+	w2 := NewPollingWatcher(a, 100*time.Millisecond)
+	defer w2.Close()
+
+	updated := make(chan string, 1)
+	_ = w2.SetUpdateCallback(func(msg string) { updated <- msg })
+	// let w2 see the current version before it changes
+	time.Sleep(300 * time.Millisecond)
+
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("Expected AddPolicy() to be successful; got %v", err)
+	}
+	if err := w1.Update(); err != nil {
+		t.Fatalf("Expected Update() to be successful; got %v", err)
+	}
+	select {
+	case msg := <-updated:
+		change, err := ParsePolicyChange(msg)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"p"}, change.PTypes)
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the update callback to be called")
+	}
+}
+
+func TestChangeFeedProcessor(t *testing.T) {
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	p := NewChangeFeedProcessor(a, ChangeFeedProcessorOptions{PollInterval: 200 * time.Millisecond})
+	defer p.Close()
+
+	updated := make(chan string, 16)
+	_ = p.SetUpdateCallback(func(lease string) { updated <- lease })
+	// let the processor acquire its leases before writing
+	time.Sleep(time.Second)
+
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("Expected AddPolicy() to be successful; got %v", err)
+	}
+	defer a.RemovePolicy("p", "p", []string{"carol", "data3", "read"})
+
+	select {
+	case <-updated:
+	case <-time.After(10 * time.Second):
+		t.Error("Expected the update callback to be called")
+	}
+}
+
+func TestChangeFeedProcessorMalformed(t *testing.T) {
+	var skipped []string
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return newMapContainer() },
+		OnSkippedDocument: func(document []byte, err error) {
+			skipped = append(skipped, string(document))
+		},
+	})
+	p := &ChangeFeedProcessor{adapter: a, options: ChangeFeedProcessorOptions{LeaseExpiration: time.Minute}}
+
+	// the malformed documents are skipped rather than blocking the lease
+	changes := p.changes(context.Background(), [][]byte{
+		[]byte(`{"id":"bad1","pType":"p","v0":1}`),
+		[]byte(`not json`),
+		[]byte(`{"id":"good","pType":"p","v0":"alice","v1":"data1","v2":"read"}`),
+	})
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "alice", changes[0].V0)
+	assert.Equal(t, []string{`{"id":"bad1","pType":"p","v0":1}`, `not json`}, skipped)
+
+	// a lease renewed recently is not written again
+	l := &lease{Expires: time.Now().Add(time.Minute).UnixNano()}
+	assert.NoError(t, p.renewLease(context.Background(), l))
+}
+
+func TestChangeStream(t *testing.T) {
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("Expected AddPolicy() to be successful; got %v", err)
+	}
+	defer a.RemovePolicy("p", "p", []string{"carol", "data3", "read"})
+
+	for event, err := range a.ChangeStream(ctx, ChangeStreamOptions{StartFrom: time.Now().Add(-time.Minute), PollInterval: 200 * time.Millisecond}) {
+		assert.NoError(t, err)
+		if event.PType == "p" && slices.Equal(event.Rule, []string{"carol", "data3", "read"}) {
+			assert.Equal(t, EventAdd, event.Op)
+			assert.NotEmpty(t, event.Cursor.Continuations[a.containerName])
+			return
+		}
+	}
+	t.Error("Expected the change to be streamed")
+}
+
+func TestNeedsReload(t *testing.T) {
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+
+	reload, err := a.NeedsReload()
+	assert.NoError(t, err)
+	assert.False(t, reload, "Expected no reload right after LoadPolicy()")
+
+	// Our own changes are already in the enforcer.
+	e.AddPolicy("alice", "data1", "write")
+	reload, err = a.NeedsReload()
+	assert.NoError(t, err)
+	assert.False(t, reload, "Expected no reload after a change made through this adapter")
+
+	// Changes made by another instance are detected.
+	other := NewAdapterFromConnectionSting(getConnString(t), options)
+	before, err := other.GetPolicyVersion()
+	assert.NoError(t, err)
+	assert.NoError(t, other.RemovePolicy("p", "p", []string{"alice", "data1", "write"}))
+	after, err := other.GetPolicyVersion()
+	assert.NoError(t, err)
+	assert.Greater(t, after, before)
+
+	reload, err = a.NeedsReload()
+	assert.NoError(t, err)
+	assert.True(t, reload, "Expected a reload after a change made by another adapter")
+}
+
+func TestAutoReloadEnforcer(t *testing.T) {
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	e, err := casbin.NewSyncedEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewSyncedEnforcer() to be successful; got %v", err)
+	}
+	r, err := NewAutoReloadEnforcer(e, a, AutoReloadOptions{Interval: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Expected NewAutoReloadEnforcer() to be successful; got %v", err)
+	}
+	defer r.Stop()
+
+	other := NewAdapterFromConnectionSting(getConnString(t), options)
+	assert.NoError(t, other.AddPolicy("p", "p", []string{"carol", "data3", "read"}))
+	defer other.RemovePolicy("p", "p", []string{"carol", "data3", "read"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !r.HasPolicy("carol", "data3", "read") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the policy to be reloaded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestNewEnforcerWithCosmos(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+
+	e, err := NewEnforcerWithCosmos(EnforcerConfig{
+		ModelPath:        "examples/rbac_model.conf",
+		ConnectionString: getConnString(t),
+		Options:          options,
+		Synced:           true,
+		Cache:            true,
+		PollingInterval:  time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Expected NewEnforcerWithCosmos() to be successful; got %v", err)
+	}
+	ok, err := e.Enforce("alice", "data2", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, isAdapter := e.GetAdapter().(*Adapter)
+	assert.True(t, isAdapter)
+
+	if _, err := NewEnforcerWithCosmos(EnforcerConfig{ModelPath: "examples/rbac_model.conf", ConnectionString: "fwdawFGwea"}); err == nil {
+		t.Error("Expected an error for an invalid connection string")
+	}
+}
+
+func TestNewEnforcerWithCosmosDefaultNames(t *testing.T) {
+	var names []string
+	e, err := NewEnforcerWithCosmos(EnforcerConfig{
+		ModelPath:        "examples/rbac_model.conf",
+		ConnectionString: "AccountEndpoint=https://localhost:8081/;AccountKey=" + base64.StdEncoding.EncodeToString([]byte("key")) + ";",
+		Options: Options{NewContainer: func(name string) Container {
+			names = append(names, name)
+			return newMapContainer()
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Expected NewEnforcerWithCosmos() to be successful; got %v", err)
+	}
+	a := e.GetAdapter().(*Adapter)
+	assert.Equal(t, "casbin", a.databaseName)
+	assert.Equal(t, "casbin_rule", a.containerName)
+	assert.Contains(t, names, "casbin_rule")
+	assert.NotContains(t, names, "")
+}
+
+// closingTransport records the calls to CloseIdleConnections.
+type closingTransport struct {
+	transportFunc
+	closed *int
+}
+
+func (t closingTransport) CloseIdleConnections() {
+	*t.closed++
+}
+
+func TestNewEnforcerWithCosmosClosesAdapter(t *testing.T) {
+	closed := 0
+	options := Options{
+		NewContainer: func(name string) Container { return newMapContainer() },
+		Faults:       NewFaultInjector(Fault{Operation: "LoadPolicy", Err: errors.New("connection reset")}),
+	}
+	options.Transport = closingTransport{closed: &closed}
+	_, err := NewEnforcerWithCosmos(EnforcerConfig{
+		ModelPath:        "examples/rbac_model.conf",
+		ConnectionString: "AccountEndpoint=https://localhost:8081/;AccountKey=" + base64.StdEncoding.EncodeToString([]byte("key")) + ";",
+		Options:          options,
+	})
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Equal(t, 1, closed)
+}
+
+func TestPolicyChange(t *testing.T) {
+	var change PolicyChange
+	change.add("p", "domain1")
+	change.add("p", "domain2")
+	change.add("g", "")
+
+	parsed, err := ParsePolicyChange(change.String())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p", "g"}, parsed.PTypes)
+	assert.Equal(t, []string{"domain1", "domain2"}, parsed.Domains)
+
+	assert.True(t, parsed.Affects("p", "domain1"))
+	assert.True(t, parsed.Affects("g", ""))
+	assert.False(t, parsed.Affects("p2", ""))
+	assert.False(t, parsed.Affects("p", "domain3"))
+	// an unknown change affects everything
+	assert.True(t, PolicyChange{}.Affects("p2", "domain3"))
+}
+
+func TestEventSourcing(t *testing.T) {
+	opt := Options{DatabaseName: options.DatabaseName, ContainerName: options.ContainerName, EventSourcing: true, Actor: "test"}
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	start := time.Now()
+	e.AddPolicy("alice", "data1", "write")
+	time.Sleep(10 * time.Millisecond)
+	middle := time.Now()
+	e.RemovePolicy("alice", "data1", "write")
+
+	events, err := a.GetEvents("p", start, time.Time{})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, EventAdd, events[0].Op)
+		assert.Equal(t, EventRemove, events[1].Op)
+		assert.Equal(t, "test", events[1].Actor)
+	}
+
+	// Replaying up to the middle shows the rule that has been removed since.
+	m := e.GetModel().Copy()
+	m.ClearPolicy()
+	assert.NoError(t, a.ReplayEvents(m, middle))
+	assert.True(t, m.HasPolicy("p", "p", []string{"alice", "data1", "write"}))
+
+	assert.NoError(t, a.LoadPolicyAsOf(context.Background(), m, middle))
+	assert.True(t, m.HasPolicy("p", "p", []string{"alice", "data1", "write"}))
+	assert.NoError(t, a.LoadPolicyAsOf(context.Background(), m, time.Now()))
+	assert.False(t, m.HasPolicy("p", "p", []string{"alice", "data1", "write"}))
+	assert.Error(t, NewAdapterFromConnectionSting(getConnString(t), options).LoadPolicyAsOf(context.Background(), m, middle))
+}
+
+func TestEventsContext(t *testing.T) {
+	containers := map[string]*mapContainer{}
+	a := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", EventSourcing: true, NewContainer: func(name string) Container {
+		if containers[name] == nil {
+			containers[name] = newMapContainer()
+		}
+		return containers[name]
+	}})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+
+	events, err := a.GetEventsContext(context.Background(), "p", time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.ReplayEventsContext(context.Background(), m, time.Time{}))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = a.GetEventsContext(ctx, "p", time.Time{}, time.Time{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, a.ReplayEventsContext(ctx, m, time.Time{}), context.Canceled)
+
+	// the reads are operations of the adapter
+	assert.NoError(t, a.Close(context.Background()))
+	_, err = a.GetEvents("p", time.Time{}, time.Time{})
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, a.ReplayEvents(m, time.Time{}), ErrClosed)
+}
+
+func TestTimeRangeQuery(t *testing.T) {
+	query, parameters := timeRangeQuery(time.Time{}, time.Time{})
+	assert.Equal(t, "SELECT * FROM c", query)
+	assert.Empty(t, parameters)
+
+	until := time.Unix(0, 42)
+	query, parameters = timeRangeQuery(time.Time{}, until)
+	assert.Equal(t, "SELECT * FROM c WHERE c.time < @until", query)
+	assert.Equal(t, []azcosmos.QueryParameter{{Name: "@until", Value: int64(42)}}, parameters)
+
+	query, parameters = timeRangeQuery(time.Unix(0, 7), until)
+	assert.Equal(t, "SELECT * FROM c WHERE c.time >= @since AND c.time < @until", query)
+	assert.Len(t, parameters, 2)
+}
+
+func TestApplyEvents(t *testing.T) {
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	m.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	applyEvents(m, []PolicyEvent{
+		{PType: "p", Op: EventClear},
+		{PType: "p", Op: EventAdd, Rule: []string{"alice", "data1", "read"}},
+		{PType: "p", Op: EventAdd, Rule: []string{"alice", "data1", "write"}},
+		{PType: "p", Op: EventAdd, Rule: []string{"alice", "data1", "read"}},
+		{PType: "p", Op: EventRemove, Rule: []string{"alice", "data1", "write"}},
+		{PType: "g", Op: EventAdd, Rule: []string{"alice", "admin"}},
+		// not defined by the model
+		{PType: "p2", Op: EventAdd, Rule: []string{"carol", "data3", "read"}},
+		{PType: "g2", Op: EventClear},
+	})
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m["p"]["p"].Policy)
+	assert.Equal(t, [][]string{{"alice", "admin"}}, m["g"]["g"].Policy)
+}
+
+func TestReadConflicts(t *testing.T) {
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	// Single-region accounts never have conflicts, but the feed can be read.
+	_, err := a.ReadConflicts(context.Background())
+	assert.NoError(t, err)
+
+	apply, err := PreferAdds(context.Background(), Conflict{OperationType: "create", Rule: CasbinRule{PType: "p", V0: "alice"}})
+	assert.NoError(t, err)
+	assert.True(t, apply)
+	apply, err = PreferAdds(context.Background(), Conflict{OperationType: "delete", Rule: CasbinRule{PType: "p", V0: "alice"}})
+	assert.NoError(t, err)
+	assert.False(t, apply)
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	opt := options
+	opt.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	e.AddPolicy("alice", "data1", "write")
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	assert.Contains(t, names, "cosmosadapter.LoadPolicy")
+	assert.Contains(t, names, "cosmosadapter.AddPolicy")
+}
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	opt := options
+	opt.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	if _, err := casbin.NewEnforcer("examples/rbac_model.conf", a); err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(context.Background(), &rm))
+	names := map[string]bool{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			names[m.Name] = true
+		}
+	}
+	assert.True(t, names["cosmosadapter.operation.duration"])
+	assert.True(t, names["cosmosadapter.request_charge"])
+	assert.True(t, names["cosmosadapter.pages"])
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	a := &Adapter{tracer: defaultTracer(), metrics: newMetrics(nil), stats: newStatsRecorder(), logger: logger}
+
+	ctx, op := a.startOperation(context.Background(), "AddPolicy")
+	operationFrom(ctx).retry("policy version changed concurrently")
+	a.endOperation(op, errors.New("boom"))
+
+	out := buf.String()
+	assert.Contains(t, out, "retrying request")
+	assert.Contains(t, out, "operation failed")
+	assert.Contains(t, out, "operation=AddPolicy")
+}
+
+func TestRequestCharges(t *testing.T) {
+	a := &Adapter{stats: newStatsRecorder()}
+	a.stats.record(&operation{name: "LoadPolicy", start: time.Now(), requestCharge: 2.5}, nil)
+	a.stats.record(&operation{name: "LoadPolicy", start: time.Now(), requestCharge: 3}, nil)
+	a.stats.record(&operation{name: "AddPolicy", start: time.Now(), requestCharge: 6}, nil)
+	assert.Equal(t, map[string]float64{"LoadPolicy": 5.5, "AddPolicy": 6}, a.GetRequestCharges())
+
+	a.ResetRequestCharges()
+	assert.Empty(t, a.GetRequestCharges())
+	// the cumulative statistics are kept for the metrics
+	ops := a.GetOperationStats()
+	assert.Equal(t, 5.5, ops["LoadPolicy"].RequestCharge)
+	assert.Equal(t, int64(2), ops["LoadPolicy"].Count)
+}
+
+func TestOnOperation(t *testing.T) {
+	var infos []OperationInfo
+	a := &Adapter{tracer: defaultTracer(), metrics: newMetrics(nil), stats: newStatsRecorder(), logger: loggerFrom(Options{})}
+	a.onOperation = func(info OperationInfo) { infos = append(infos, info) }
+
+	ctx, op := a.startOperation(context.Background(), "AddPolicy")
+	operationFrom(ctx).record(azcosmos.Response{RequestCharge: 6.5, ActivityID: "activity"}, 1)
+	operationFrom(ctx).retry("policy version changed concurrently")
+	a.endOperation(op, nil)
+
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "AddPolicy", infos[0].Name)
+		assert.Equal(t, 6.5, infos[0].RequestCharge)
+		assert.Equal(t, "activity", infos[0].ActivityID)
+		assert.Equal(t, 1, infos[0].Retries)
+		assert.NoError(t, infos[0].Err)
+	}
+}
+
+func TestLogQueries(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	a := &Adapter{tracer: defaultTracer(), metrics: newMetrics(nil), stats: newStatsRecorder(), logger: logger, logQueries: true, redactQueryParameters: true}
+
+	_, op := a.startOperation(context.Background(), "LoadFilteredPolicy")
+	next := "token"
+	res := azcosmos.QueryItemsResponse{ContinuationToken: &next}
+	op.query("SELECT * FROM c WHERE c.v0 = @v0", "p", []azcosmos.QueryParameter{{Name: "@v0", Value: "alice"}}, "", res)
+
+	out := buf.String()
+	assert.Contains(t, out, "level=INFO")
+	assert.Contains(t, out, "@v0:[REDACTED]")
+	assert.Contains(t, out, "next_continuation=token")
+	assert.NotContains(t, out, "alice")
+}
+
+func TestErrorDiagnostics(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://account-westeurope.documents.azure.com/dbs/casbin", nil)
+	res := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"X-Ms-Substatus": {"3200"}, "X-Ms-Activity-Id": {"activity"}},
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}
+	err := wrapError("LoadPolicy", runtime.NewResponseError(res))
+
+	var cosmosErr *Error
+	if assert.True(t, errors.As(err, &cosmosErr)) {
+		assert.Equal(t, "LoadPolicy", cosmosErr.Operation)
+		assert.Equal(t, http.StatusTooManyRequests, cosmosErr.StatusCode)
+		assert.Equal(t, 3200, cosmosErr.SubStatusCode)
+		assert.Equal(t, "activity", cosmosErr.ActivityID)
+		assert.Equal(t, "account-westeurope.documents.azure.com", cosmosErr.Endpoint)
+	}
+	assert.True(t, isStatus(err, http.StatusTooManyRequests))
+
+	plain := errors.New("boom")
+	assert.Equal(t, plain, wrapError("LoadPolicy", plain))
+}
+
+type statusTransport int
+
+func (t statusTransport) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: int(t), Header: http.Header{}, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+}
+
+func TestPing(t *testing.T) {
+	for status, want := range map[int]error{
+		http.StatusUnauthorized:    ErrUnauthorized,
+		http.StatusNotFound:        ErrContainerNotFound,
+		http.StatusTooManyRequests: ErrThrottled,
+	} {
+		clientOptions := &azcosmos.ClientOptions{}
+		clientOptions.Transport = statusTransport(status)
+		clientOptions.Retry.MaxRetries = -1
+		client, err := azcosmos.NewClientFromConnectionString("AccountEndpoint=https://localhost:8081/;AccountKey=a2V5;", clientOptions)
+		assert.NoError(t, err)
+		container, err := client.NewContainer("casbin", "casbin_rule")
+		assert.NoError(t, err)
+
+		a := &Adapter{containerClient: container, tracer: defaultTracer(), metrics: newMetrics(nil), stats: newStatsRecorder(), logger: loggerFrom(Options{})}
+		err = a.Ping(context.Background())
+		assert.ErrorIs(t, err, want)
+		var cosmosErr *Error
+		assert.ErrorAs(t, err, &cosmosErr)
+	}
+}
+
+func TestCasbinRuleOmitsEmptyValues(t *testing.T) {
+	marshalled, err := json.Marshal(savePolicyLine("p", []string{"alice", "data1", "read"}))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(marshalled), "v3")
+
+	// documents written before empty values were omitted decode the same way
+	var line CasbinRule
+	assert.NoError(t, json.Unmarshal([]byte(`{"id":"1","pType":"p","v0":"alice","v1":"data1","v2":"read","v3":"","v4":"","v5":""}`), &line))
+	assert.Equal(t, []string{"alice", "data1", "read"}, policyTokens(line))
+}
+
+func TestNewPolicyLineArraySchema(t *testing.T) {
+	a := &Adapter{arraySchema: true}
+	line := a.newPolicyLine("p", []string{"alice", "data1", "read"})
+	assert.Equal(t, savePolicyLine("p", []string{"alice", "data1", "read"}).ID, line.ID)
+	assert.Empty(t, line.V0)
+	assert.Equal(t, []string{"alice", "data1", "read"}, policyTokens(line))
+}
+
+func TestArraySchema(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	opt := options
+	opt.ArraySchema = true
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", NewAdapterFromConnectionSting(getConnString(t), opt))
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	// rules stored with v0 to v5 are read and matched as well
+	e.AddPolicy("alice", "data3", "read")
+	e.RemoveFilteredPolicy(0, "alice")
+	assert.NoError(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func TestLongRules(t *testing.T) {
+	rule := []string{"alice", "data1", "read", "a3", "a4", "a5", "a6", "a7", "a8", "a9", "a10", "a11"}
+	line := savePolicyLine("p", rule)
+	assert.Equal(t, "a11", line.V11)
+	assert.Equal(t, rule, policyTokens(line))
+
+	a := &Adapter{}
+	assert.NoError(t, a.checkRule(rule))
+	assert.Error(t, a.checkRule(append(rule, "a12")))
+	a.arraySchema = true
+	assert.NoError(t, a.checkRule(append(rule, "a12")))
+}
+
+func TestIDFunc(t *testing.T) {
+	a := &Adapter{idFunc: func(ptype string, rule []string) string {
+		return ptype + "-" + strings.Join(rule, "-")
+	}}
+	assert.Equal(t, "p-alice-data1-read", a.newPolicyLine("p", []string{"alice", "data1", "read"}).ID)
+	a.arraySchema = true
+	assert.Equal(t, "p-alice-data1-read", a.newPolicyLine("p", []string{"alice", "data1", "read"}).ID)
+}
+
+func TestSHA256ID(t *testing.T) {
+	id := SHA256ID("p", []string{"alice", "data1", "read"})
+	assert.Len(t, id, 64)
+	assert.Equal(t, id, SHA256ID("p", []string{"alice", "data1", "read"}))
+	assert.NotEqual(t, SHA256ID("p", []string{"a,b", "c"}), SHA256ID("p", []string{"a", "b,c"}))
+	assert.Equal(t, policyID("p", []string{"alice"}), ChecksumID("p", []string{"alice"}))
+}
+
+func TestCompositeID(t *testing.T) {
+	assert.Equal(t, "p|alice|data1|read", CompositeID("p", []string{"alice", "data1", "read"}))
+
+	rule := []string{"a|b", "/data/1?x#y", "100%", `back\slash`}
+	id := CompositeID("p", rule)
+	assert.NotContains(t, id, "/")
+	ptype, parsed, err := ParseCompositeID(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "p", ptype)
+	assert.Equal(t, rule, parsed)
+
+	_, _, err = ParseCompositeID("p|%zz")
+	assert.Error(t, err)
+}
+
+func TestRuleExpiry(t *testing.T) {
+	now := time.Now()
+	line := savePolicyLine("p", []string{"alice", "data1", "read"})
+	line.expire(now.Add(time.Hour).Unix(), now)
+	assert.Equal(t, 3600, line.TTL)
+	assert.False(t, line.expired(now))
+	assert.True(t, line.expired(now.Add(2*time.Hour)))
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	line.ExpiresAt = now.Add(-time.Minute).Unix()
+	a := &Adapter{now: func() time.Time { return now }}
+	assert.NoError(t, a.loadPolicyLine(context.Background(), line, m))
+	assert.False(t, m.HasPolicy("p", "p", []string{"alice", "data1", "read"}))
+}
+
+func TestAddPolicyWithExpiry(t *testing.T) {
+	opt := options
+	opt.RuleExpiry = true
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	assert.NoError(t, a.AddPolicyWithExpiry("p", "p", []string{"carol", "data1", "read"}, time.Now().Add(2*time.Second)))
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	assert.True(t, e.HasPolicy("carol", "data1", "read"))
+	time.Sleep(3 * time.Second)
+	assert.NoError(t, e.LoadPolicy())
+	assert.False(t, e.HasPolicy("carol", "data1", "read"))
+}
+
+func TestAddPolicyWithExpiryContext(t *testing.T) {
+	type key struct{}
+	var got any
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		RuleExpiry:    true,
+		NewContainer:  func(name string) Container { return newMapContainer() },
+		Hooks: []Hook{{Before: func(ctx context.Context, m *Mutation) error {
+			got = ctx.Value(key{})
+			return nil
+		}}},
+	})
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	assert.NoError(t, a.AddPolicyWithExpiryContext(ctx, "p", "p", []string{"carol", "data1", "read"}, time.Now().Add(time.Hour)))
+	assert.Equal(t, "request", got)
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"carol", "data1", "read"}}, m.GetPolicy("p", "p"))
+}
+
+// projectingContainer returns only the fields selected by a query, like
+// Cosmos does.
+type projectingContainer struct {
+	*mapContainer
+}
+
+func (c *projectingContainer) NewQueryItemsPager(query string, pk azcosmos.PartitionKey, o *azcosmos.QueryOptions) *runtime.Pager[azcosmos.QueryItemsResponse] {
+	pager := c.mapContainer.NewQueryItemsPager(query, pk, o)
+	selected, _, ok := strings.Cut(strings.TrimPrefix(query, "SELECT "), " FROM ")
+	if !ok || !strings.HasPrefix(selected, "c.") {
+		return pager
+	}
+	fields := map[string]bool{}
+	for _, field := range strings.Split(selected, ", ") {
+		fields[strings.TrimPrefix(field, "c.")] = true
+	}
+	return runtime.NewPager(runtime.PagingHandler[azcosmos.QueryItemsResponse]{
+		More: func(res azcosmos.QueryItemsResponse) bool { return false },
+		Fetcher: func(ctx context.Context, res *azcosmos.QueryItemsResponse) (azcosmos.QueryItemsResponse, error) {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return page, err
+			}
+			for i, item := range page.Items {
+				var doc map[string]any
+				if err := json.Unmarshal(item, &doc); err != nil {
+					return page, err
+				}
+				for field := range doc {
+					if !fields[field] {
+						delete(doc, field)
+					}
+				}
+				if page.Items[i], err = json.Marshal(doc); err != nil {
+					return page, err
+				}
+			}
+			return page, nil
+		},
+	})
+}
+
+func TestAddPolicyWithExpiryGenerationalSave(t *testing.T) {
+	container := &projectingContainer{mapContainer: newMapContainer()}
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName:    "casbin_rule",
+		RuleExpiry:       true,
+		GenerationalSave: true,
+		NewContainer:     func(name string) Container { return container },
+	})
+	expiresAt := time.Now().Add(time.Hour)
+	assert.NoError(t, a.AddPolicyWithExpiry("p", "p", []string{"carol", "data1", "read"}, expiresAt))
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+
+	// the temporary rule stays temporary across the generations
+	for i := 0; i < 2; i++ {
+		m, err := model.NewModelFromFile("examples/rbac_model.conf")
+		assert.NoError(t, err)
+		assert.NoError(t, a.LoadPolicy(m))
+		assert.NoError(t, a.SavePolicy(m))
+	}
+	docs := map[string]CasbinRule{}
+	for _, item := range container.items[fmt.Sprint(azcosmos.NewPartitionKeyString("p"))] {
+		var doc CasbinRule
+		assert.NoError(t, json.Unmarshal(item, &doc))
+		if doc.Generation == 2 {
+			docs[doc.V0] = doc
+		}
+	}
+	assert.Len(t, docs, 2)
+	assert.Equal(t, expiresAt.Unix(), docs["carol"].ExpiresAt)
+	assert.Positive(t, docs["carol"].TTL)
+	assert.Zero(t, docs["alice"].ExpiresAt)
+	assert.Zero(t, docs["alice"].TTL)
+}
+
+func TestNamespace(t *testing.T) {
+	a := &Adapter{namespace: "app1"}
+	query, parameters := a.inNamespace("SELECT * FROM c", nil)
+	assert.Equal(t, "SELECT * FROM c WHERE c.namespace = @namespace", query)
+	assert.Equal(t, []azcosmos.QueryParameter{{Name: "@namespace", Value: "app1"}}, parameters)
+
+	query, parameters = a.inNamespace("SELECT * FROM root WHERE root.pType = @pType OR root.v0 = @v0", []azcosmos.QueryParameter{{Name: "@pType", Value: "p"}})
+	assert.Equal(t, "SELECT * FROM root WHERE root.namespace = @namespace AND (root.pType = @pType OR root.v0 = @v0)", query)
+	assert.Len(t, parameters, 2)
+
+	line := a.newPolicyLine("p", []string{"alice", "data1", "read"})
+	assert.Equal(t, "app1", line.Namespace)
+	assert.Equal(t, "app1:"+policyID("p", []string{"alice", "data1", "read"}), line.ID)
+	assert.Equal(t, "app1:policy_version", a.versionID())
+	assert.True(t, a.inOtherNamespace(CasbinRule{Namespace: "app2"}))
+	assert.False(t, (&Adapter{}).inOtherNamespace(CasbinRule{Namespace: "app2"}))
+}
+
+func TestSharedContainer(t *testing.T) {
+	opt1, opt2 := options, options
+	opt1.Namespace, opt2.Namespace = "app1", "app2"
+	a1 := NewAdapterFromConnectionSting(getConnString(t), opt1)
+	a2 := NewAdapterFromConnectionSting(getConnString(t), opt2)
+
+	e1, err := casbin.NewEnforcer("examples/rbac_model.conf", a1)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	e1.ClearPolicy()
+	e1.AddPolicy("alice", "data1", "read")
+	assert.NoError(t, e1.SavePolicy())
+
+	e2, err := casbin.NewEnforcer("examples/rbac_model.conf", a2)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	e2.ClearPolicy()
+	e2.AddPolicy("bob", "data2", "write")
+	assert.NoError(t, e2.SavePolicy())
+
+	// saving app2 kept the rules of app1
+	assert.NoError(t, e1.LoadPolicy())
+	testGetPolicy(t, e1, [][]string{{"alice", "data1", "read"}})
+	assert.NoError(t, e2.LoadPolicy())
+	testGetPolicy(t, e2, [][]string{{"bob", "data2", "write"}})
+}
+
+// upperMapper stores rules with upper case field names and a rule array.
+type upperMapper struct{}
+
+type upperDocument struct {
+	ID    string   `json:"id"`
+	PType string   `json:"pType"`
+	Rule  []string `json:"RULE"`
+	Owner string   `json:"OWNER"`
+}
+
+func (upperMapper) ToDocument(rule CasbinRule) ([]byte, error) {
+	return json.Marshal(upperDocument{ID: rule.ID, PType: rule.PType, Rule: policyTokens(rule), Owner: "casbin"})
+}
+
+func (upperMapper) FromDocument(document []byte) (CasbinRule, error) {
+	var doc upperDocument
+	err := json.Unmarshal(document, &doc)
+	return CasbinRule{ID: doc.ID, PType: doc.PType, Rule: doc.Rule}, err
+}
+
+func TestDocumentMapper(t *testing.T) {
+	a := &Adapter{mapper: upperMapper{}}
+	assert.True(t, a.customMapping())
+	assert.False(t, (&Adapter{mapper: jsonMapper{}}).customMapping())
+
+	doc, err := a.mapper.ToDocument(a.newPolicyLine("p", []string{"alice", "data1", "read"}))
+	assert.NoError(t, err)
+	assert.Contains(t, string(doc), `"RULE":["alice","data1","read"]`)
+	line, err := a.mapper.FromDocument(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "data1", "read"}, policyTokens(line))
+
+	assert.True(t, matchesFilter(line, 1, []string{"data1"}))
+	assert.True(t, matchesFilter(line, 0, []string{"alice", "", "read"}))
+	assert.False(t, matchesFilter(line, 0, []string{"bob"}))
+	assert.False(t, matchesFilter(line, 2, []string{"read", "extra"}))
+}
+
+func TestPartitionByDomain(t *testing.T) {
+	a := &Adapter{partitionStrategy: PartitionByDomain, domains: true, now: time.Now}
+	assert.Equal(t, "/partitionKey", a.partitionKeyPath())
+	assert.Equal(t, "domain1", a.newPolicyLine("p", []string{"alice", "domain1", "data1", "read"}).PartitionKey)
+	assert.Equal(t, "domain1", a.newPolicyLine("g", []string{"alice", "admin", "domain1"}).PartitionKey)
+	assert.Equal(t, "g2", a.newPolicyLine("g2", []string{"data1", "group1"}).PartitionKey)
+
+	query, parameters, _ := a.inPolicyType("SELECT * FROM c WHERE c._ts >= @ts", nil, "p")
+	assert.Equal(t, "SELECT * FROM c WHERE c.pType = @policyType AND (c._ts >= @ts)", query)
+	assert.Equal(t, []azcosmos.QueryParameter{{Name: "@policyType", Value: "p"}}, parameters)
+
+	// rules of policy types missing from the model are skipped
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.loadPolicyLine(context.Background(), CasbinRule{PType: "g2", V0: "data1", V1: "group1"}, m))
+	assert.NoError(t, a.loadPolicyLine(context.Background(), CasbinRule{PType: policyVersionID}, m))
+	assert.Empty(t, m.GetPolicy("g", "g"))
+}
+
+func TestDomainFilter(t *testing.T) {
+	opt := Options{DatabaseName: options.DatabaseName, ContainerName: "casbin_rule_by_domain", PartitionStrategy: PartitionByDomain}
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	e, err := casbin.NewEnforcer("examples/rbac_with_domains_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	e.ClearPolicy()
+	e.AddPolicy("admin", "domain1", "data1", "read")
+	e.AddPolicy("admin", "domain2", "data2", "read")
+	e.AddGroupingPolicy("alice", "admin", "domain1")
+	assert.NoError(t, e.SavePolicy())
+
+	assert.NoError(t, e.LoadFilteredPolicy(DomainFilter("domain1")))
+	testGetPolicy(t, e, [][]string{{"admin", "domain1", "data1", "read"}})
+	assert.Equal(t, [][]string{{"alice", "admin", "domain1"}}, e.GetGroupingPolicy())
+}
+
+func TestTenantCache(t *testing.T) {
+	disabled := newTenantCache(0)
+	disabled.put("domain1", 1, []CasbinRule{{PType: "p"}}, time.Now())
+	_, ok := disabled.get("domain1", 1, time.Now())
+	assert.False(t, ok)
+
+	c := newTenantCache(time.Minute)
+	now := time.Now()
+	lines := []CasbinRule{{PType: "p", V0: "admin", V1: "domain1"}}
+	c.put("domain1", 1, lines, now)
+	cached, ok := c.get("domain1", 1, now.Add(time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, lines, cached)
+	_, ok = c.get("domain1", 2, now)
+	assert.False(t, ok, "the policy version changed")
+	c.put("domain1", 1, lines, now)
+	_, ok = c.get("domain1", 1, now.Add(2*time.Minute))
+	assert.False(t, ok, "the entry expired")
+
+	assert.Equal(t, "domain1", lineDomain(CasbinRule{PType: "p", V0: "admin", V1: "domain1"}))
+	assert.Equal(t, "domain1", lineDomain(CasbinRule{PType: "g", Rule: []string{"alice", "admin", "domain1"}}))
+	assert.Equal(t, "", lineDomain(CasbinRule{PType: "g", V0: "alice", V1: "admin"}))
+}
+
+func TestLoadPolicyForTenant(t *testing.T) {
+	opt := Options{DatabaseName: options.DatabaseName, ContainerName: "casbin_rule_tenants", TenantCacheTTL: time.Minute}
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	e, err := casbin.NewEnforcer("examples/rbac_with_domains_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	e.ClearPolicy()
+	e.AddPolicy("admin", "domain1", "data1", "read")
+	e.AddPolicy("admin", "domain2", "data2", "read")
+	e.AddGroupingPolicy("alice", "admin", "domain1")
+	e.AddGroupingPolicy("bob", "admin", "domain2")
+	assert.NoError(t, e.SavePolicy())
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, a.LoadPolicyForTenant(e.GetModel(), "domain1"))
+		assert.NoError(t, e.BuildRoleLinks())
+		testGetPolicy(t, e, [][]string{{"admin", "domain1", "data1", "read"}})
+		assert.Equal(t, [][]string{{"alice", "admin", "domain1"}}, e.GetGroupingPolicy())
+		ok, _ := e.Enforce("alice", "domain1", "data1", "read")
+		assert.True(t, ok)
+	}
+
+	// the cache is bypassed once the policy changed
+	_, err = e.AddPolicy("admin", "domain1", "data3", "read")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicyForTenant(e.GetModel(), "domain1"))
+	testGetPolicy(t, e, [][]string{{"admin", "domain1", "data1", "read"}, {"admin", "domain1", "data3", "read"}})
+}
+
+func TestLoadPolicyForTenantContext(t *testing.T) {
+	a := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", Domains: true, NewContainer: func(name string) Container { return newMapContainer() }})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"admin", "domain1", "data1", "read"}))
+	m, err := model.NewModelFromFile("examples/rbac_with_domains_model.conf")
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.LoadPolicyForTenantContext(context.Background(), m, "domain1"))
+	assert.Equal(t, [][]string{{"admin", "domain1", "data1", "read"}}, m.GetPolicy("p", "p"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, a.LoadPolicyForTenantContext(ctx, m, "domain1"), context.Canceled)
+}
+
+func TestGroupingContainerName(t *testing.T) {
+	a := &Adapter{containerName: "casbin_rule"}
+	assert.Equal(t, []string{"casbin_rule"}, a.ruleContainerNames())
+	assert.Equal(t, "casbin_rule", a.containerNameFor("g"))
+
+	a.groupingContainerName = "casbin_rule_grouping"
+	assert.Equal(t, []string{"casbin_rule", "casbin_rule_grouping"}, a.ruleContainerNames())
+	assert.Equal(t, "casbin_rule", a.containerNameFor("p"))
+	assert.Equal(t, "casbin_rule_grouping", a.containerNameFor("g"))
+	assert.Equal(t, "casbin_rule_grouping", a.containerNameFor("g2"))
+}
+
+func TestSeparateGroupingContainer(t *testing.T) {
+	opt := Options{DatabaseName: options.DatabaseName, ContainerName: "casbin_rule_split", GroupingContainerName: "casbin_rule_split_grouping"}
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	assert.NoError(t, a.SavePolicy(e.GetModel()))
+
+	e, err = casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+	assert.Equal(t, [][]string{{"alice", "data2_admin"}}, e.GetGroupingPolicy())
+
+	// the grouping rule is only stored in the grouping container
+	_, err = a.containers["casbin_rule_split_grouping"].ReadItem(context.Background(), azcosmos.NewPartitionKeyString("g"), policyID("g", []string{"alice", "data2_admin"}), nil)
+	assert.NoError(t, err)
+	_, err = a.containerClient.ReadItem(context.Background(), azcosmos.NewPartitionKeyString("g"), policyID("g", []string{"alice", "data2_admin"}), nil)
+	assert.True(t, isStatus(err, http.StatusNotFound))
+}
+
+func TestPTypeContainers(t *testing.T) {
+	a := &Adapter{
+		containerName:         "casbin_rule",
+		groupingContainerName: "casbin_rule_grouping",
+		ptypeContainers:       map[string]string{"p2": "casbin_rule_p2", "g2": "casbin_rule_g2", "g3": "casbin_rule"},
+	}
+	assert.Equal(t, []string{"casbin_rule", "casbin_rule_g2", "casbin_rule_grouping", "casbin_rule_p2"}, a.ruleContainerNames())
+	assert.Equal(t, "casbin_rule", a.containerNameFor("p"))
+	assert.Equal(t, "casbin_rule_p2", a.containerNameFor("p2"))
+	assert.Equal(t, "casbin_rule_grouping", a.containerNameFor("g"))
+	assert.Equal(t, "casbin_rule_g2", a.containerNameFor("g2"))
+	assert.Equal(t, "casbin_rule", a.containerNameFor("g3"))
+}
+
+func TestPTypeContainersLoadPolicy(t *testing.T) {
+	opt := Options{DatabaseName: options.DatabaseName, ContainerName: "casbin_rule_by_ptype", PTypeContainers: map[string]string{"g": "casbin_rule_by_ptype_g"}}
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	assert.NoError(t, a.SavePolicy(e.GetModel()))
+
+	e, err = casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+	assert.Equal(t, [][]string{{"alice", "data2_admin"}}, e.GetGroupingPolicy())
+
+	_, err = e.AddGroupingPolicy("bob", "data2_admin")
+	assert.NoError(t, err)
+	_, err = a.containers["casbin_rule_by_ptype_g"].ReadItem(context.Background(), azcosmos.NewPartitionKeyString("g"), policyID("g", []string{"bob", "data2_admin"}), nil)
+	assert.NoError(t, err)
+}
+
+func TestDumpWriter(t *testing.T) {
+	lines := []CasbinRule{
+		{PType: "p", V0: "alice", V1: "data1", V2: "read"},
+		{PType: "g", Rule: []string{"alice", "admin, ops"}},
+	}
+
+	var buf bytes.Buffer
+	write, flush, err := dumpWriter(&buf, DumpCSV)
+	assert.NoError(t, err)
+	for _, line := range lines {
+		assert.NoError(t, write(line))
+	}
+	assert.NoError(t, flush())
+	assert.Equal(t, "p,alice,data1,read\ng,alice,\"admin, ops\"\n", buf.String())
+
+	buf.Reset()
+	write, flush, err = dumpWriter(&buf, DumpJSONL)
+	assert.NoError(t, err)
+	for _, line := range lines {
+		assert.NoError(t, write(line))
+	}
+	assert.NoError(t, flush())
+	assert.Equal(t, `{"pType":"p","rule":["alice","data1","read"]}`+"\n"+`{"pType":"g","rule":["alice","admin, ops"]}`+"\n", buf.String())
+
+	_, _, err = dumpWriter(&buf, DumpFormat(42))
+	assert.Error(t, err)
+}
+
+func TestDump(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+
+	var buf bytes.Buffer
+	n, err := a.Dump(context.Background(), &buf, DumpOptions{Format: DumpCSV})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	dumped := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.ElementsMatch(t, []string{
+		"p,alice,data1,read",
+		"p,bob,data2,write",
+		"p,data2_admin,data2,read",
+		"p,data2_admin,data2,write",
+		"g,alice,data2_admin",
+	}, dumped)
+}
+
+func TestDumpDomains(t *testing.T) {
+	opt := Options{DatabaseName: options.DatabaseName, ContainerName: "casbin_rule_by_domain", PartitionStrategy: PartitionByDomain}
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	e, err := casbin.NewEnforcer("examples/rbac_with_domains_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	e.ClearPolicy()
+	e.AddPolicy("admin", "domain1", "data1", "read")
+	e.AddPolicy("admin", "domain2", "data2", "read")
+	e.AddGroupingPolicy("alice", "admin", "domain1")
+	assert.NoError(t, e.SavePolicy())
+
+	var buf bytes.Buffer
+	n, err := a.Dump(context.Background(), &buf, DumpOptions{Format: DumpJSONL, Domains: []string{"domain1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Contains(t, buf.String(), `{"pType":"g","rule":["alice","admin","domain1"]}`)
+	assert.NotContains(t, buf.String(), "domain2")
+}
+
+func TestQuotaError(t *testing.T) {
+	var err error = &QuotaError{Domain: "domain1", Limit: 10, Count: 10}
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	assert.Contains(t, err.Error(), `domain "domain1" holds 10 rules`)
+
+	a := &Adapter{domains: true, quotas: newQuotas(Options{MaxRules: 100, MaxRulesPerDomain: 10})}
+	lines := []CasbinRule{
+		a.newPolicyLine("p", []string{"admin", "domain1", "data1", "read"}),
+		a.newPolicyLine("g", []string{"alice", "admin", "domain1"}),
+		a.newPolicyLine("g", []string{"bob", "admin", "domain2"}),
+	}
+	assert.Equal(t, map[string]int{"": 3, "domain1": 2, "domain2": 1}, a.quotaDomains(lines))
+
+	now := time.Now()
+	a.quotas.set("domain1", 9, now)
+	a.quotas.added(map[string]int{"domain1": 1, "domain2": 1})
+	count, ok := a.quotas.cached("domain1", now)
+	assert.True(t, ok)
+	assert.Equal(t, 10, count)
+	_, ok = a.quotas.cached("domain2", now)
+	assert.False(t, ok, "counts are only incremented once counted")
+	_, ok = a.quotas.cached("domain1", now.Add(2*quotaCountTTL))
+	assert.False(t, ok)
+	a.quotas.removed(lines[0])
+	_, ok = a.quotas.cached("domain1", now)
+	assert.False(t, ok)
+
+	assert.Nil(t, newQuotas(Options{}))
+}
+
+func TestMaxRules(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	opt := options
+	opt.MaxRules = 6
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"carol", "data1", "read"}))
+	err := a.AddPolicy("p", "p", []string{"dave", "data1", "read"})
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	var quotaErr *QuotaError
+	if assert.True(t, errors.As(err, &quotaErr)) {
+		assert.Equal(t, 6, quotaErr.Count)
+	}
+
+	assert.NoError(t, a.RemovePolicy("p", "p", []string{"carol", "data1", "read"}))
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"dave", "data1", "read"}))
+}
+
+func TestAuditTrail(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	opt := options
+	opt.AuditContainerName = "casbin_audit"
+	opt.Actor = "tester"
+	opt.AuditCorrelationID = func() string { return "request-1" }
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+
+	since := time.Now()
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"carol", "data1", "read"}))
+	assert.NoError(t, a.RemovePolicy("p", "p", []string{"carol", "data1", "read"}))
+
+	records, err := a.GetAuditRecords(context.Background(), since, time.Time{})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "AddPolicy", records[0].Operation)
+		assert.Equal(t, EventAdd, records[0].Op)
+		assert.Equal(t, []string{"carol", "data1", "read"}, records[0].Rule)
+		assert.Equal(t, "tester", records[0].Actor)
+		assert.Equal(t, "request-1", records[0].CorrelationID)
+		assert.Equal(t, "RemovePolicy", records[1].Operation)
+		assert.Equal(t, EventRemove, records[1].Op)
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	containers := map[string]*mapContainer{"casbin_rule": newMapContainer(), "casbin_audit": newMapContainer()}
+	newAdapter := func() *Adapter {
+		return NewAdapterFromClient(nil, Options{
+			ContainerName:      "casbin_rule",
+			AuditContainerName: "casbin_audit",
+			AuditChain:         true,
+			NewContainer:       func(name string) Container { return containers[name] },
+		})
+	}
+	a, b := newAdapter(), newAdapter()
+	ctx := context.Background()
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.NoError(t, a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}))
+	// another instance appends after the records of a, and a after those of b
+	assert.NoError(t, b.AddPolicy("p", "p", []string{"bob", "data2", "write"}))
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"carol", "data3", "read"}))
+
+	head, err := a.VerifyAuditChain(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), head.Seq)
+	records, err := a.GetAuditRecords(ctx, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 4)
+
+	// tampering with a record breaks the chain
+	audit := containers["casbin_audit"]
+	var id string
+	var pk azcosmos.PartitionKey
+	for _, record := range records {
+		if record.Seq == 3 {
+			id, pk = record.ID, azcosmos.NewPartitionKeyString(record.CorrelationID)
+			record.Rule = []string{"bob", "data2", "read"}
+			altered, err := json.Marshal(record)
+			assert.NoError(t, err)
+			_, err = audit.UpsertItem(ctx, pk, altered, nil)
+			assert.NoError(t, err)
+		}
+	}
+	_, err = a.VerifyAuditChain(ctx)
+	var chainErr *AuditChainError
+	if assert.ErrorAs(t, err, &chainErr) {
+		assert.Equal(t, int64(3), chainErr.Seq)
+		assert.Equal(t, "the record was altered", chainErr.Reason)
+	}
+	_, err = audit.DeleteItem(ctx, pk, id, nil)
+	assert.NoError(t, err)
+	_, err = a.VerifyAuditChain(ctx)
+	assert.ErrorIs(t, err, ErrAuditChainBroken)
+	assert.ErrorContains(t, err, "the record is missing")
+}
+
+func TestSnapshotKey(t *testing.T) {
+	a := &Adapter{}
+	assert.Equal(t, "42", a.snapshotKey(42))
+	a.namespace = "service1"
+	assert.Equal(t, "service1:42", a.snapshotKey(42))
+}
+
+func TestRestorePolicyVersion(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	opt := options
+	opt.SnapshotContainerName = "casbin_rule_snapshots"
+	opt.SnapshotOnSave = true
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	version, err := a.GetPolicyVersion()
+	assert.NoError(t, err)
+
+	// a bad bulk change
+	e.EnableAutoSave(false)
+	e.ClearPolicy()
+	e.AddPolicy("mallory", "data1", "write")
+	assert.NoError(t, e.SavePolicy())
+
+	snapshots, err := a.ListPolicySnapshots(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, snapshots, PolicySnapshot{Version: version, Time: snapshots[len(snapshots)-1].Time, Rules: 5})
+
+	assert.NoError(t, a.RestorePolicyVersion(e.GetModel(), version))
+	assert.NoError(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	assert.Error(t, a.RestorePolicyVersion(e.GetModel(), -1))
+}
+
+func TestRestorePolicyVersionContext(t *testing.T) {
+	containers := map[string]*mapContainer{}
+	a := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", SnapshotContainerName: "casbin_rule_snapshots", NewContainer: func(name string) Container {
+		if containers[name] == nil {
+			containers[name] = newMapContainer()
+		}
+		return containers[name]
+	}})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	snapshot, err := a.SnapshotPolicy(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"mallory", "data1", "write"}))
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, a.RestorePolicyVersionContext(ctx, m, snapshot.Version), context.Canceled)
+
+	assert.NoError(t, a.RestorePolicyVersionContext(context.Background(), m, snapshot.Version))
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+}
+
+func TestCompatSchema(t *testing.T) {
+	schema := LowercaseSchema
+	a := &Adapter{mapper: schema, compat: &schema}
+	assert.Equal(t, "/ptype", a.partitionKeyPath())
+	assert.Equal(t, "ptype", a.versionPKField())
+
+	// a document written by another adapter
+	line, err := schema.FromDocument([]byte(`{"id":"42","ptype":"p","v0":"alice","v1":"data1","v2":"read","_ts":1700000000}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "42", line.ID)
+	assert.Equal(t, "p", line.PType)
+	assert.Equal(t, []string{"alice", "data1", "read"}, policyTokens(line))
+	assert.Equal(t, int64(1700000000), line.Ts)
+
+	// empty values are kept, except the trailing ones
+	line, err = schema.FromDocument([]byte(`{"id":"43","ptype":"p","v0":"alice","v1":"","v2":"read","v3":""}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "", "read"}, policyTokens(line))
+	doc, err := schema.ToDocument(line)
+	assert.NoError(t, err)
+	line, err = schema.FromDocument(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "", "read"}, policyTokens(line))
+
+	doc, err = schema.ToDocument(CasbinRule{ID: "1", PType: "g", V0: "alice", V1: "admin", Namespace: "service1"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":"1","ptype":"g","v0":"alice","v1":"admin","namespace":"service1"}`, string(doc))
+
+	custom := CompatSchema{PTypeField: "type", ValueFields: []string{"sub", "obj", "act"}, PartitionKeyPath: "/tenant"}
+	doc, err = custom.ToDocument(CasbinRule{ID: "1", PType: "p", V0: "alice", V1: "data1", V2: "read"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":"1","type":"p","tenant":"p","sub":"alice","obj":"data1","act":"read"}`, string(doc))
+	_, err = custom.ToDocument(CasbinRule{PType: "p", V0: "a", V1: "b", V2: "c", V3: "d"})
+	assert.Error(t, err)
+
+	version, err := marshalVersion(policyVersion{ID: policyVersionID, PType: policyVersionID, Version: 3}, "ptype")
+	assert.NoError(t, err)
+	assert.Contains(t, string(version), `"ptype":"policy_version"`)
+}
+
+func TestCompatContainer(t *testing.T) {
+	opt := Options{DatabaseName: options.DatabaseName, ContainerName: "casbin_rule_lowercase", Compat: &LowercaseSchema}
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	// a rule written by another adapter, with an ID of its own
+	_, err := a.containerClient.UpsertItem(context.Background(), azcosmos.NewPartitionKeyString("p"),
+		[]byte(`{"id":"foreign-1","ptype":"p","v0":"carol","v1":"data3","v2":"read"}`), nil)
+	assert.NoError(t, err)
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	assert.True(t, e.HasPolicy("carol", "data3", "read"))
+
+	_, err = e.RemovePolicy("carol", "data3", "read")
+	assert.NoError(t, err)
+	assert.NoError(t, e.LoadPolicy())
+	assert.False(t, e.HasPolicy("carol", "data3", "read"))
+}
+
+// sqlRulesDriver is a database/sql driver returning the rows of a casbin_rule
+// table to every query.
+type sqlRulesDriver struct {
+	rows [][]driver.Value
+}
+
+func (d sqlRulesDriver) Open(name string) (driver.Conn, error) { return sqlRulesConn(d), nil }
+
+type sqlRulesConn sqlRulesDriver
+
+func (c sqlRulesConn) Prepare(query string) (driver.Stmt, error) { return sqlRulesStmt(c), nil }
+func (c sqlRulesConn) Close() error                              { return nil }
+func (c sqlRulesConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type sqlRulesStmt sqlRulesConn
+
+func (s sqlRulesStmt) Close() error  { return nil }
+func (s sqlRulesStmt) NumInput() int { return 0 }
+func (s sqlRulesStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s sqlRulesStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &sqlRulesRows{rows: s.rows}, nil
+}
+
+type sqlRulesRows struct {
+	rows [][]driver.Value
+}
+
+func (r *sqlRulesRows) Columns() []string {
+	return []string{"ptype", "v0", "v1", "v2", "v3", "v4", "v5"}
+}
+func (r *sqlRulesRows) Close() error { return nil }
+func (r *sqlRulesRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestReadSQLRules(t *testing.T) {
+	sql.Register("casbin_rule", sqlRulesDriver{rows: [][]driver.Value{
+		{"p", "alice", "data1", "read", nil, nil, nil},
+		{"g", "alice", "admin", "", "", "", ""},
+		{"", "ignored", nil, nil, nil, nil, nil},
+	}})
+	db, err := sql.Open("casbin_rule", "")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var rules [][]string
+	err = readSQLRules(context.Background(), db, "", func(ptype string, rule []string) error {
+		rules = append(rules, append([]string{ptype}, rule...))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"p", "alice", "data1", "read"}, {"g", "alice", "admin"}}, rules)
+
+	err = readSQLRules(context.Background(), db, "", func(ptype string, rule []string) error {
+		return errors.New("stop")
+	})
+	assert.EqualError(t, err, "stop")
+}
+
+func TestReadCSV(t *testing.T) {
+	a := &Adapter{}
+	lines, err := a.readCSV(strings.NewReader("p, alice, data1, read\n\n# comment\np, alice, data1, read\ng, alice, \"admin, ops\"\n"))
+	assert.NoError(t, err)
+	assert.Len(t, lines, 2)
+	assert.Equal(t, []string{"alice", "data1", "read"}, policyTokens(lines[0]))
+	assert.Equal(t, []string{"alice", "admin, ops"}, policyTokens(lines[1]))
+
+	_, err = a.readCSV(strings.NewReader("p, alice, data1, read\np\n"))
+	assert.EqualError(t, err, "line 2: a rule needs a policy type and a value")
+}
+
+func TestRetryThrottled(t *testing.T) {
+	throttled := &azcore.ResponseError{StatusCode: http.StatusTooManyRequests, RawResponse: &http.Response{
+		Header: http.Header{"X-Ms-Retry-After-Ms": []string{"1"}},
+	}}
+	calls := 0
+	err := retryThrottled(context.Background(), 2, func() error {
+		calls++
+		return throttled
+	})
+	assert.Equal(t, throttled, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = retryThrottled(context.Background(), 2, func() error {
+		calls++
+		if calls == 1 {
+			return throttled
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = retryThrottled(context.Background(), 2, func() error {
+		calls++
+		return errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, 1, calls)
+}
+
+func TestImportCSV(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+
+	n, err := a.ImportCSV(context.Background(), strings.NewReader("p, carol, data3, read\n"), ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	assert.True(t, e.HasPolicy("carol", "data3", "read"))
+	assert.True(t, e.HasPolicy("alice", "data1", "read"))
+
+	n, err = a.ImportCSV(context.Background(), strings.NewReader("p, carol, data3, read\ng, carol, data2_admin\n"), ImportOptions{Mode: ImportReplace})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NoError(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"carol", "data3", "read"}})
+	assert.True(t, e.HasGroupingPolicy("carol", "data2_admin"))
+}
+
+func TestExportImportDocuments(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	var buf bytes.Buffer
+	n, err := a.ExportDocuments(context.Background(), &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Contains(t, buf.String(), `"_ts":`)
+
+	opt := options
+	opt.ContainerName = "casbin_rule_copy"
+	b := NewAdapterFromConnectionSting(getConnString(t), opt)
+	n, err = b.ImportDocuments(context.Background(), &buf, ImportOptions{Mode: ImportReplace})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", b)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+	line := a.newPolicyLine("p", []string{"alice", "data1", "read"})
+	_, err = b.containerClient.ReadItem(context.Background(), b.partitionKey(line), line.ID, nil)
+	assert.NoError(t, err)
+}
+
+func TestDiffRules(t *testing.T) {
+	from := []CasbinRule{
+		{PType: "p", V0: "bob", V1: "data2", V2: "write"},
+		{PType: "p", V0: "alice", V1: "data1", V2: "read"},
+		{PType: "g", V0: "alice", V1: "admin"},
+	}
+	to := []CasbinRule{
+		{PType: "p", V0: "alice", V1: "data1", V2: "read"},
+		{PType: "p", V0: "carol", V1: "data3", V2: "read"},
+		{PType: "p", V0: "carol", V1: "data3", V2: "read"},
+	}
+	missing, extra := diffRules(from, to)
+	assert.Equal(t, []DumpRecord{{PType: "g", Rule: []string{"alice", "admin"}}, {PType: "p", Rule: []string{"bob", "data2", "write"}}}, missing)
+	assert.Equal(t, []DumpRecord{{PType: "p", Rule: []string{"carol", "data3", "read"}}}, extra)
+}
+
+func TestCopyPolicy(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	opt := options
+	opt.ContainerName = "casbin_rule_copy"
+	opt.IDFunc = SHA256ID
+	b := NewAdapterFromConnectionSting(getConnString(t), opt)
+
+	result, err := a.CopyPolicy(context.Background(), b, ImportOptions{Mode: ImportReplace, MaxRequestUnits: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result.Copied)
+	assert.Empty(t, result.Missing)
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", b)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func TestCopyPolicyTarget(t *testing.T) {
+	source := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return newMapContainer() }})
+	target := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return newMapContainer() }, GenerationalSave: true})
+	assert.NoError(t, source.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	m.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	assert.NoError(t, target.SavePolicy(m))
+
+	// the target reads its generation in its own operation
+	result, err := source.CopyPolicy(context.Background(), target, ImportOptions{Mode: ImportReplace})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Copied)
+	m.ClearPolicy()
+	assert.NoError(t, target.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+
+	// a closed target fails the copy
+	assert.NoError(t, target.Close(context.Background()))
+	_, err = source.CopyPolicy(context.Background(), target, ImportOptions{})
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestUpgradedLine(t *testing.T) {
+	a := &Adapter{mapper: jsonMapper{}, arraySchema: true}
+	now := time.Now()
+	line := CasbinRule{ID: "old-1", PType: "p", V0: "alice", V1: "data1", V2: "read", Namespace: "app1", ExpiresAt: now.Unix() + 60, TTL: 3600, Ts: 42}
+	assert.True(t, a.staleSchema(line))
+
+	upgraded := a.upgradedLine(line, now)
+	assert.False(t, a.staleSchema(upgraded))
+	assert.Equal(t, "old-1", upgraded.ID)
+	assert.Equal(t, "app1", upgraded.Namespace)
+	assert.Equal(t, []string{"alice", "data1", "read"}, upgraded.Rule)
+	assert.Empty(t, upgraded.V0)
+	assert.Equal(t, 60, upgraded.TTL)
+	assert.Zero(t, upgraded.Ts)
+
+	assert.False(t, (&Adapter{mapper: upperMapper{}}).staleSchema(line))
+	assert.False(t, a.staleSchema(CasbinRule{PType: policyVersionID}))
+}
+
+func TestMigrateSchema(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	// a document written before the schema version was stamped
+	_, err := a.containerClient.UpsertItem(context.Background(), azcosmos.NewPartitionKeyString("p"),
+		[]byte(`{"id":"old-1","pType":"p","v0":"carol","v1":"data3","v2":"read","v3":"","v4":"","v5":""}`), nil)
+	assert.NoError(t, err)
+
+	n, err := a.MigrateSchema(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	res, err := a.containerClient.ReadItem(context.Background(), azcosmos.NewPartitionKeyString("p"), "old-1", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, string(res.Value), `"schemaVersion":1`)
+	assert.NotContains(t, string(res.Value), `"v3"`)
+
+	n, err = a.MigrateSchema(context.Background())
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestSameIndexingPolicy(t *testing.T) {
+	desired := &azcosmos.IndexingPolicy{
+		Automatic:     true,
+		IncludedPaths: []azcosmos.IncludedPath{{Path: "/pType/?"}, {Path: "/v0/?"}},
+		ExcludedPaths: []azcosmos.ExcludedPath{{Path: "/*"}},
+	}
+	// as returned by the service
+	current := &azcosmos.IndexingPolicy{
+		Automatic:     true,
+		IndexingMode:  "consistent",
+		IncludedPaths: []azcosmos.IncludedPath{{Path: "/v0/?"}, {Path: "/pType/?"}},
+		ExcludedPaths: []azcosmos.ExcludedPath{{Path: "/*"}, {Path: `/"_etag"/?`}},
+	}
+	assert.True(t, sameIndexingPolicy(current, desired))
+	current.IncludedPaths = current.IncludedPaths[:1]
+	assert.False(t, sameIndexingPolicy(current, desired))
+}
+
+func TestEnsureInfrastructure(t *testing.T) {
+	client, err := azcosmos.NewClientFromConnectionString(getConnString(t), nil)
+	assert.NoError(t, err)
+	opt := Options{
+		DatabaseName:          options.DatabaseName,
+		ContainerName:         "casbin_rule_provisioned",
+		AuditContainerName:    "casbin_rule_provisioned_audit",
+		RuleExpiry:            true,
+		IndexingPolicy:        &azcosmos.IndexingPolicy{Automatic: true, IncludedPaths: []azcosmos.IncludedPath{{Path: "/*"}}},
+		GroupingContainerName: "casbin_rule_provisioned_g",
+	}
+	assert.NoError(t, EnsureInfrastructure(context.Background(), client, opt))
+	// running it again changes nothing
+	assert.NoError(t, EnsureInfrastructure(context.Background(), client, opt))
+
+	opt.SkipAutoCreate = true
+	a := NewAdapterFromClient(client, opt)
+	res, err := cosmosContainer(a.containers["casbin_rule_provisioned_g"]).Read(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, res.ContainerProperties.DefaultTimeToLive)
+
+	// container names can't contain a slash
+	assert.Error(t, EnsureInfrastructure(context.Background(), client, Options{DatabaseName: "casbin", ContainerName: "a/b"}))
+}
+
+func TestWriteInfrastructure(t *testing.T) {
+	opt := Options{
+		DatabaseName:          "casbin",
+		ContainerName:         "casbin_rule",
+		GroupingContainerName: "casbin_rule_g",
+		RuleExpiry:            true,
+		AuditContainerName:    "casbin_audit",
+	}
+	containers := DesiredContainers(opt)
+	assert.Len(t, containers, 3)
+	assert.Equal(t, "casbin_rule_g", containers[1].ID)
+	assert.Equal(t, []string{"/correlationId"}, containers[2].PartitionKeyDefinition.Paths)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteInfrastructure(&buf, opt, InfrastructureJSON))
+	var resources []map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &resources))
+	assert.Equal(t, "casbin_rule", resources[0]["id"])
+	assert.Equal(t, float64(-1), resources[0]["defaultTtl"])
+	assert.Equal(t, map[string]any{"paths": []any{"/pType"}, "kind": "Hash"}, resources[0]["partitionKey"])
+
+	buf.Reset()
+	assert.NoError(t, WriteInfrastructure(&buf, opt, InfrastructureARM))
+	var template map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &template))
+	assert.Len(t, template["resources"], 4)
+
+	buf.Reset()
+	assert.NoError(t, WriteInfrastructure(&buf, opt, InfrastructureBicep))
+	assert.Contains(t, buf.String(), "resource container1 'Microsoft.DocumentDB/databaseAccounts/sqlDatabases/containers@2024-05-15' = {\n  parent: database\n  name: 'casbin_rule_g'\n")
+	assert.Contains(t, buf.String(), "      defaultTtl: -1\n")
+	assert.Contains(t, buf.String(), "        '/pType'\n")
+
+	assert.Equal(t, `'it\'s \${x}'`, bicepString("it's ${x}"))
+}
+
+func TestAnalyticalStoreTTL(t *testing.T) {
+	assert.Nil(t, analyticalStoreTTL(0))
+	assert.Equal(t, int32(-1), *analyticalStoreTTL(-time.Second))
+	assert.Equal(t, int32(2), *analyticalStoreTTL(1500 * time.Millisecond))
+	assert.Equal(t, int32(math.MaxInt32), *analyticalStoreTTL(math.MaxInt64))
+
+	opt := Options{
+		DatabaseName:       "casbin",
+		ContainerName:      "casbin_rule",
+		AuditContainerName: "casbin_audit",
+		AnalyticalStoreTTL: -1,
+	}
+	containers := DesiredContainers(opt)
+	assert.Equal(t, int32(-1), *containers[0].AnalyticalStoreTimeToLiveInSeconds)
+	assert.Nil(t, containers[1].AnalyticalStoreTimeToLiveInSeconds)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteInfrastructure(&buf, opt, InfrastructureJSON))
+	var resources []map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &resources))
+	assert.Equal(t, float64(-1), resources[0]["analyticalStorageTtl"])
+	assert.NotContains(t, resources[1], "analyticalStorageTtl")
+}
+
+// fakeBlob is an httptest server storing a single block blob.
+type fakeBlob struct {
+	*httptest.Server
+	mu       sync.Mutex
+	blocks   map[string][]byte
+	content  []byte
+	headers  http.Header
+	requests []*http.Request
+}
+
+func newFakeBlob(t *testing.T) *fakeBlob {
+	b := &fakeBlob{blocks: map[string][]byte{}}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.requests = append(b.requests, r)
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "block":
+			b.blocks[r.URL.Query().Get("blockid")] = body
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "blocklist":
+			var list struct {
+				Latest []string `xml:"Latest"`
+			}
+			assert.NoError(t, xml.Unmarshal(body, &list))
+			b.content = nil
+			for _, id := range list.Latest {
+				b.content = append(b.content, b.blocks[id]...)
+			}
+			b.headers = r.Header.Clone()
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet && b.content != nil:
+			w.Header().Set("Content-Length", strconv.Itoa(len(b.content)))
+			w.Write(b.content)
+		default:
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func TestBlockWriter(t *testing.T) {
+	blob := newFakeBlob(t)
+	a := &Adapter{}
+	c, err := a.newBlobClient(blob.URL+"/backups/policy.jsonl.gz?sv=2021&sig=secret", BlobOptions{})
+	assert.NoError(t, err)
+
+	w := c.newBlockWriter(context.Background())
+	content := bytes.Repeat([]byte("0123456789"), blobBlockSize/5)
+	_, err = w.Write(content[:blobBlockSize-1])
+	assert.NoError(t, err)
+	_, err = w.Write(content[blobBlockSize-1:])
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Len(t, w.blocks, 2)
+	assert.Nil(t, blob.content)
+
+	assert.NoError(t, w.commit("application/gzip", map[string]string{"policyversion": "7"}))
+	assert.Equal(t, content, blob.content)
+	assert.Equal(t, "7", blob.headers.Get("x-ms-meta-policyversion"))
+	assert.Equal(t, "application/gzip", blob.headers.Get("x-ms-blob-content-type"))
+	for _, r := range blob.requests {
+		assert.Equal(t, "secret", r.URL.Query().Get("sig"))
+	}
+
+	_, err = a.newBlobClient("policy.jsonl.gz", BlobOptions{})
+	assert.Error(t, err)
+}
+
+func TestBackup(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	blob := newFakeBlob(t)
+
+	n, err := a.Backup(context.Background(), blob.URL+"/backups/policy.jsonl.gz", BlobOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	version, err := a.GetPolicyVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(version, 10), blob.headers.Get("x-ms-meta-policyversion"))
+
+	gz, err := gzip.NewReader(bytes.NewReader(blob.content))
+	assert.NoError(t, err)
+	content, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, strings.Count(string(content), "\n"))
+}
+
+func TestRestore(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	blob := newFakeBlob(t)
+	url := blob.URL + "/backups/policy.jsonl.gz"
+	_, err := a.Backup(context.Background(), url, BlobOptions{})
+	assert.NoError(t, err)
+
+	e, _ := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	_, err = e.AddPolicy("carol", "data3", "read")
+	assert.NoError(t, err)
+
+	var progress []int
+	n, err := a.Restore(context.Background(), url, RestoreOptions{
+		ImportOptions: ImportOptions{Mode: ImportReplace, BatchSize: 2, Progress: func(written int) {
+			progress = append(progress, written)
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, 5, progress[len(progress)-1])
+	assert.NoError(t, e.LoadPolicy())
+	assert.False(t, e.HasPolicy("carol", "data3", "read"))
+	assert.True(t, e.HasPolicy("alice", "data1", "read"))
+}
+
+func TestExpiredSnapshots(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	times := []time.Time{now.Add(-72 * time.Hour), now.Add(-48 * time.Hour), now.Add(-24 * time.Hour), now.Add(-time.Hour)}
+	assert.Equal(t, []int{0, 1}, expiredSnapshots(times, now, 2, 0))
+	assert.Equal(t, []int{0, 1}, expiredSnapshots(times, now, 0, 36*time.Hour))
+	assert.Equal(t, []int{0, 1}, expiredSnapshots(times, now, 3, 36*time.Hour))
+	assert.Empty(t, expiredSnapshots(times, now, 0, 0))
+	// the most recent snapshot is kept however old
+	assert.Equal(t, []int{0, 1, 2}, expiredSnapshots(times, now, 0, time.Minute))
+	assert.Empty(t, expiredSnapshots(nil, now, 1, time.Minute))
+}
+
+func TestPruneSnapshotBlobs(t *testing.T) {
+	var mu sync.Mutex
+	blobs := map[string]bool{
+		"backups/policy-20240428T120000Z.jsonl.gz": true,
+		"backups/policy-20240429T120000Z.jsonl.gz": true,
+		"backups/policy-20240430T120000Z.jsonl.gz": true,
+		"backups/policy-20240501T110000Z.jsonl.gz": true,
+		"backups/notes.txt":                        true,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("comp") == "list":
+			assert.Equal(t, "/container", r.URL.Path)
+			assert.Equal(t, "backups/", r.URL.Query().Get("prefix"))
+			// one blob per page
+			var names []string
+			for name := range blobs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			i := 0
+			if marker := r.URL.Query().Get("marker"); marker != "" {
+				i, _ = strconv.Atoi(marker)
+			}
+			fmt.Fprintf(w, "<EnumerationResults><Blobs><Blob><Name>%s</Name></Blob></Blobs>", names[i])
+			if i+1 < len(names) {
+				fmt.Fprintf(w, "<NextMarker>%d</NextMarker>", i+1)
+			}
+			fmt.Fprint(w, "</EnumerationResults>")
+		case r.Method == http.MethodDelete:
+			delete(blobs, strings.TrimPrefix(r.URL.Path, "/container/"))
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	a := &Adapter{}
+	blobClient, err := a.newBlobClient(server.URL+"/container/backups/", BlobOptions{})
+	assert.NoError(t, err)
+	s := &SnapshotScheduler{adapter: a, blobs: blobClient, options: SnapshotSchedulerOptions{Retain: 2}}
+	assert.NoError(t, s.prune(context.Background(), time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)))
+	assert.Equal(t, map[string]bool{
+		"backups/policy-20240430T120000Z.jsonl.gz": true,
+		"backups/policy-20240501T110000Z.jsonl.gz": true,
+		"backups/notes.txt":                        true,
+	}, blobs)
+}
+
+func TestSnapshotScheduler(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	opt := options
+	opt.SnapshotContainerName = "casbin_rule_snapshots"
+	a := NewAdapterFromConnectionSting(getConnString(t), opt)
+	version, err := a.GetPolicyVersion()
+	assert.NoError(t, err)
+
+	s, err := NewSnapshotScheduler(a, SnapshotSchedulerOptions{Interval: time.Hour, Retain: 1, OnError: func(err error) {
+		t.Error(err)
+	}})
+	assert.NoError(t, err)
+	s.Stop()
+	snapshots, err := a.ListPolicySnapshots(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+	assert.Equal(t, version, snapshots[0].Version)
+
+	_, err = NewSnapshotScheduler(NewAdapterFromConnectionSting(getConnString(t), options), SnapshotSchedulerOptions{})
+	assert.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	m.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+	m.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	m.AddPolicy("p", "p", []string{"carol", "data3", "read"})
+	m.AddPolicy("g", "g", []string{"alice", "data2_admin"})
+
+	csv := `p, alice, data1, read
+p, bob, data2, read
+p, dave, data4, read
+p, dave, data4, write
+g, alice, data2_admin
+g, alice, data2_admin
+`
+	diff, err := Diff(context.Background(), ModelSource(m), CSVSource(strings.NewReader(csv)))
+	assert.NoError(t, err)
+	assert.Equal(t, PolicyDiff{
+		Added:   []DumpRecord{{PType: "p", Rule: []string{"dave", "data4", "read"}}, {PType: "p", Rule: []string{"dave", "data4", "write"}}},
+		Removed: []DumpRecord{{PType: "p", Rule: []string{"carol", "data3", "read"}}},
+		Changed: []RuleChange{{
+			From: DumpRecord{PType: "p", Rule: []string{"bob", "data2", "write"}},
+			To:   DumpRecord{PType: "p", Rule: []string{"bob", "data2", "read"}},
+		}},
+	}, diff)
+	assert.False(t, diff.Empty())
+
+	diff, err = Diff(context.Background(), ModelSource(m), ModelSource(m))
+	assert.NoError(t, err)
+	assert.True(t, diff.Empty())
+
+	_, err = Diff(context.Background(), ModelSource(m), CSVSource(strings.NewReader("p\n")))
+	assert.Error(t, err)
+	_, err = Diff(context.Background(), (&Adapter{}).SnapshotSource(1), ModelSource(m))
+	assert.Error(t, err)
+}
+
+// mapContainer is a Container keeping the items in memory. Queries ignore
+// their statement and return every item of the partition.
+type mapContainer struct {
+	mu    sync.Mutex
+	items map[string]map[string][]byte
+}
+
+func newMapContainer() *mapContainer {
+	return &mapContainer{items: map[string]map[string][]byte{}}
+}
+
+func (c *mapContainer) ReadItem(ctx context.Context, pk azcosmos.PartitionKey, id string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[fmt.Sprint(pk)][id]
+	if !ok {
+		return azcosmos.ItemResponse{}, &azcore.ResponseError{StatusCode: http.StatusNotFound}
+	}
+	etag := azcore.ETag(fmt.Sprintf("%x", sha256.Sum256(item)))
+	return azcosmos.ItemResponse{Value: item, Response: azcosmos.Response{RawResponse: &http.Response{StatusCode: http.StatusOK}, ETag: etag}}, nil
+}
+
+func (c *mapContainer) CreateItem(ctx context.Context, pk azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	var doc struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(item, &doc)
+	if _, err := c.ReadItem(ctx, pk, doc.ID, nil); err == nil {
+		return azcosmos.ItemResponse{}, &azcore.ResponseError{StatusCode: http.StatusConflict}
+	}
+	return c.UpsertItem(ctx, pk, item, o)
+}
+
+func (c *mapContainer) UpsertItem(ctx context.Context, pk azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	var doc struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(item, &doc); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.items[fmt.Sprint(pk)] == nil {
+		c.items[fmt.Sprint(pk)] = map[string][]byte{}
+	}
+	c.items[fmt.Sprint(pk)][doc.ID] = item
+	return azcosmos.ItemResponse{Value: item, Response: azcosmos.Response{RawResponse: &http.Response{StatusCode: http.StatusOK}}}, nil
+}
+
+func (c *mapContainer) ReplaceItem(ctx context.Context, pk azcosmos.PartitionKey, id string, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if _, err := c.ReadItem(ctx, pk, id, nil); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.UpsertItem(ctx, pk, item, o)
+}
+
+func (c *mapContainer) DeleteItem(ctx context.Context, pk azcosmos.PartitionKey, id string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if _, err := c.ReadItem(ctx, pk, id, nil); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items[fmt.Sprint(pk)], id)
+	return azcosmos.ItemResponse{}, nil
+}
+
+func (c *mapContainer) NewQueryItemsPager(query string, pk azcosmos.PartitionKey, o *azcosmos.QueryOptions) *runtime.Pager[azcosmos.QueryItemsResponse] {
+	c.mu.Lock()
+	var items [][]byte
+	for key, partition := range c.items {
+		if fmt.Sprint(pk) == fmt.Sprint(azcosmos.NewPartitionKey()) || key == fmt.Sprint(pk) {
+			for _, item := range partition {
+				items = append(items, item)
+			}
+		}
+	}
+	c.mu.Unlock()
+	return runtime.NewPager(runtime.PagingHandler[azcosmos.QueryItemsResponse]{
+		More: func(res azcosmos.QueryItemsResponse) bool { return false },
+		Fetcher: func(ctx context.Context, res *azcosmos.QueryItemsResponse) (azcosmos.QueryItemsResponse, error) {
+			return azcosmos.QueryItemsResponse{Items: items}, nil
+		},
+	})
+}
+
+func TestNewContainer(t *testing.T) {
+	containers := map[string]*mapContainer{}
+	opt := Options{
+		DatabaseName:          "casbin",
+		ContainerName:         "casbin_rule",
+		GroupingContainerName: "casbin_rule_g",
+		NewContainer: func(name string) Container {
+			containers[name] = newMapContainer()
+			return containers[name]
+		},
+	}
+	a := NewAdapterFromClient(nil, opt)
+	assert.Len(t, containers, 2)
+	assert.NoError(t, a.Ping(context.Background()))
+
+	n, err := a.ImportCSV(context.Background(), strings.NewReader("p, alice, data1, read\np, bob, data2, write\ng, alice, admin\n"), ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Len(t, containers["casbin_rule_g"].items, 1)
+	version, err := a.GetPolicyVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), version)
+
+	var dump bytes.Buffer
+	n, err = a.Dump(context.Background(), &dump, DumpOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Contains(t, dump.String(), "bob")
+
+	// the containers can't be dropped, their documents are deleted instead
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	m.AddPolicy("p", "p", []string{"carol", "data3", "read"})
+	assert.NoError(t, a.SavePolicy(m))
+	m.ClearPolicy()
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"carol", "data3", "read"}}, m.GetPolicy("p", "p"))
+	assert.Empty(t, m.GetPolicy("g", "g"))
+}
+
+func TestClockAndIDs(t *testing.T) {
+	containers := map[string]*mapContainer{}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ids := 0
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName:      "casbin_rule",
+		AuditContainerName: "casbin_audit",
+		NewContainer: func(name string) Container {
+			containers[name] = newMapContainer()
+			return containers[name]
+		},
+		Now: func() time.Time { return now },
+		NewID: func() string {
+			ids++
+			return fmt.Sprintf("id-%d", ids)
+		},
+	})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+
+	var records []string
+	for _, partition := range containers["casbin_audit"].items {
+		for _, item := range partition {
+			records = append(records, string(item))
+		}
+	}
+	// IDs are taken by the correlation ID, the event and the audit record
+	assert.Equal(t, []string{`{"id":"id-3","operation":"AddPolicy","op":"add","pType":"p","rule":["alice","data1","read"],"time":1704164645000000000,"correlationId":"id-1"}`}, records)
+
+	// rules expire by the clock of the adapter
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	line := a.newPolicyLine("p", []string{"bob", "data2", "write"})
+	line.expire(now.Add(time.Minute).Unix(), now)
+	assert.NoError(t, a.loadPolicyLine(context.Background(), line, m))
+	now = now.Add(time.Hour)
+	assert.NoError(t, a.loadPolicyLine(context.Background(), savePolicyLine("p", []string{"carol", "data3", "read"}), m))
+	line.V0 = "dave"
+	assert.NoError(t, a.loadPolicyLine(context.Background(), line, m))
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}, {"carol", "data3", "read"}}, m.GetPolicy("p", "p"))
+}
+
+func TestFaultInjector(t *testing.T) {
+	faults := NewFaultInjector()
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return newMapContainer() },
+		Faults:        faults,
+	})
+	ctx := context.Background()
+
+	// throttled batches of imports are retried
+	faults.Add(Fault{Operation: "ImportCSV", Times: 2, StatusCode: http.StatusTooManyRequests, RetryAfter: time.Millisecond})
+	n, err := a.ImportCSV(ctx, strings.NewReader("p, alice, data1, read\n"), ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 2, faults.Injected("ImportCSV"))
+
+	faults.Add(Fault{Operation: "AddPolicy", Err: errors.New("connection reset")})
+	err = a.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	assert.ErrorContains(t, err, "connection reset")
+	faults.Reset()
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"bob", "data2", "write"}))
+
+	faults.Add(Fault{Operation: "RemovePolicy", StatusCode: http.StatusServiceUnavailable})
+	err = a.RemovePolicy("p", "p", []string{"bob", "data2", "write"})
+	assert.True(t, isStatus(err, http.StatusServiceUnavailable))
+	faults.Reset()
+
+	faults.Add(Fault{Operation: "LoadPolicy", Times: 1, Delay: 20 * time.Millisecond})
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	started := time.Now()
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.GreaterOrEqual(t, time.Since(started), 20*time.Millisecond)
+	assert.Len(t, m.GetPolicy("p", "p"), 2)
+
+	// the watcher keeps polling through failures
+	faults.Add(Fault{Operation: "PollingWatcher", Times: 3, StatusCode: http.StatusServiceUnavailable})
+	w := NewPollingWatcher(a, 5*time.Millisecond)
+	defer w.Close()
+	called := make(chan string, 1)
+	assert.NoError(t, w.SetUpdateCallback(func(s string) {
+		select {
+		case called <- s:
+		default:
+		}
+	}))
+	assert.Eventually(t, func() bool { return faults.Injected("PollingWatcher") == 3 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"carol", "data3", "read"}))
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("the watcher was not notified")
+	}
+}
+
+func TestFaultPolicy(t *testing.T) {
+	faults := NewFaultInjector(Fault{Operation: "LoadPolicy", Times: 2, StatusCode: http.StatusTooManyRequests})
+	sent := 0
+	pipeline := runtime.NewPipeline("test", "v1", runtime.PipelineOptions{}, &policy.ClientOptions{
+		PerRetryPolicies: []policy.Policy{faultPolicy{faults}},
+		Retry:            policy.RetryOptions{RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond},
+		Transport: transportFunc(func(req *http.Request) (*http.Response, error) {
+			sent++
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		}),
+	})
+
+	// the client retries the throttled requests
+	ctx, _ := (&Adapter{tracer: defaultTracer(), metrics: newMetrics(nil), stats: newStatsRecorder(), logger: loggerFrom(Options{})}).startOperation(context.Background(), "LoadPolicy")
+	req, err := runtime.NewRequest(ctx, http.MethodGet, "https://localhost/dbs")
+	assert.NoError(t, err)
+	res, err := pipeline.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, 2, faults.Injected("LoadPolicy"))
+
+	// other operations are not affected
+	req, err = runtime.NewRequest(context.Background(), http.MethodGet, "https://localhost/dbs")
+	assert.NoError(t, err)
+	_, err = pipeline.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+}
+
+type transportFunc func(req *http.Request) (*http.Response, error)
+
+func (f transportFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// fakeCosmos is a transport answering as a Cosmos account: queries return
+// documents, point reads are not found, partition deletes succeed and the
+// other writes echo their body. The SDK reads the account in the background,
+// mu guards the fields.
+type fakeCosmos struct {
+	mu sync.Mutex
+	// authorize, if set, rejects the requests it returns false for.
+	authorize func(req *http.Request) bool
+	// substatus is set on the not found responses.
+	substatus string
+	// documents is the JSON array of the documents queries return, none if
+	// empty.
+	documents string
+	// requests are the method and path of the requests sent to the database,
+	// queries the bodies of the queries.
+	requests []string
+	queries  []string
+}
+
+func (f *fakeCosmos) Do(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	respond := func(status int, body string) (*http.Response, error) {
+		header := http.Header{}
+		if status == http.StatusNotFound && f.substatus != "" {
+			header.Set("x-ms-substatus", f.substatus)
+		}
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	}
+	if f.authorize != nil && !f.authorize(req) {
+		return respond(http.StatusUnauthorized, `{"code":"Unauthorized"}`)
+	}
+	path := strings.Trim(req.URL.Path, "/")
+	if path == "" {
+		return respond(http.StatusOK, `{"id":"account","writableLocations":[],"readableLocations":[]}`)
+	}
+	f.requests = append(f.requests, req.Method+" "+path)
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	switch {
+	case strings.HasSuffix(path, "/partitionkeydelete"):
+		return respond(http.StatusOK, "")
+	case req.Header.Get("x-ms-documentdb-query") != "":
+		f.queries = append(f.queries, string(body))
+		documents := f.documents
+		if documents == "" {
+			documents = "[]"
+		}
+		return respond(http.StatusOK, `{"Documents":`+documents+`}`)
+	case req.Method == http.MethodGet:
+		return respond(http.StatusNotFound, `{"code":"NotFound"}`)
+	}
+	return respond(http.StatusCreated, string(body))
+}
+
+func TestConcurrentUse(t *testing.T) {
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		Domains:       true,
+		NewContainer:  func(name string) Container { return newMapContainer() },
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		e, err := casbin.NewSyncedEnforcer("examples/rbac_with_domains_model.conf", a)
+		assert.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := e.AddPolicy(fmt.Sprintf("user%d", i), fmt.Sprintf("domain%d", j%2), fmt.Sprintf("data%d", j), "read")
+				assert.NoError(t, err)
+				if j%2 == 0 {
+					assert.NoError(t, e.LoadFilteredPolicy(DomainFilter("domain0")))
+				} else {
+					assert.NoError(t, e.LoadPolicy())
+				}
+				_ = a.IsFiltered()
+				_, err = a.NeedsReload()
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	e, err := casbin.NewEnforcer("examples/rbac_with_domains_model.conf", a)
+	assert.NoError(t, err)
+	assert.Len(t, e.GetPolicy(), 40)
+}
+
+func TestClose(t *testing.T) {
+	faults := NewFaultInjector()
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return newMapContainer() },
+		Faults:        faults,
+	})
+	w := NewPollingWatcher(a, time.Millisecond)
+
+	// a running write completes before Close returns
+	faults.Add(Fault{Operation: "AddPolicy", Times: 1, Delay: 50 * time.Millisecond})
+	added := make(chan error, 1)
+	go func() {
+		added <- a.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+	}()
+	for faults.Injected("AddPolicy") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, a.Close(ctx), context.DeadlineExceeded)
+	select {
+	case <-w.done:
+	default:
+		t.Fatal("watcher still polling")
+	}
+	assert.NoError(t, a.Close(context.Background()))
+	select {
+	case err := <-added:
+		assert.NoError(t, err)
+	default:
+		t.Fatal("Close returned before the running write")
+	}
+
+	err := a.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	assert.ErrorIs(t, err, ErrClosed)
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, a.LoadPolicy(m), ErrClosed)
+	assert.NoError(t, a.Close(context.Background()))
+	w.Close()
+}
+
+func TestLazyConnect(t *testing.T) {
+	var sent []string
+	up := false
+	options := Options{DatabaseName: "casbin", ContainerName: "casbin_rule", LazyConnect: true}
+	options.Retry = policy.RetryOptions{MaxRetries: -1}
+	options.Transport = transportFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"id":"casbin_rule","partitionKey":{"paths":["/pType"]}}`
+		if req.URL.Path == "/" {
+			// the account properties, read by the client before the first request
+			body = `{"id":"account","writableLocations":[],"readableLocations":[]}`
+		} else {
+			sent = append(sent, req.Method+" "+req.URL.Path)
+			if !up {
+				return nil, errors.New("connection refused")
+			}
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	a := NewAdapterFromConnectionSting("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";", options)
+	assert.Empty(t, sent)
+
+	ctx := context.Background()
+	assert.ErrorContains(t, a.Ping(ctx), "connection refused")
+	assert.Equal(t, []string{"GET /dbs/casbin"}, sent)
+
+	// the checks are retried by the next operation, once
+	up = true
+	sent = nil
+	assert.NoError(t, a.Ping(ctx))
+	assert.Equal(t, []string{"GET /dbs/casbin", "GET /dbs/casbin/colls/casbin_rule", "GET /dbs/casbin/colls/casbin_rule"}, sent)
+	sent = nil
+	assert.NoError(t, a.Ping(ctx))
+	assert.Equal(t, []string{"GET /dbs/casbin/colls/casbin_rule"}, sent)
+}
+
+func TestMalformedDocuments(t *testing.T) {
+	container := newMapContainer()
+	options := Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }}
+	a := NewAdapterFromClient(nil, options)
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	ctx := context.Background()
+	for _, doc := range []string{
+		`{"id":"bad1","pType":"p","v0":1}`,
+		`{"id":"bad2","pType":"p"}`,
+		`{"id":"bad3","v0":"bob"}`,
+	} {
+		_, err := container.UpsertItem(ctx, azcosmos.NewPartitionKeyString("p"), []byte(doc), nil)
+		assert.NoError(t, err)
+	}
+
+	// loads report every malformed document
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	err = a.LoadPolicy(m)
+	assert.ErrorIs(t, err, ErrMalformedDocument)
+	var malformed *MalformedDocumentsError
+	assert.ErrorAs(t, err, &malformed)
+	var ids []string
+	for _, doc := range malformed.Documents {
+		ids = append(ids, doc.ID)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, []string{"bad1", "bad2", "bad3"}, ids)
+
+	// other operations fail on the first one
+	err = a.RemoveFilteredPolicy("p", "p", 0, "alice")
+	assert.ErrorAs(t, err, &malformed)
+	assert.Len(t, malformed.Documents, 1)
+
+	options.SkipMalformedDocuments = true
+	a = NewAdapterFromClient(nil, options)
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+
+	// foreign documents are passed to the callback
+	var mu sync.Mutex
+	skipped := map[string]string{}
+	options.OnSkippedDocument = func(document []byte, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		skipped[string(document)] = err.Error()
+	}
+	a = NewAdapterFromClient(nil, options)
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Len(t, m.GetPolicy("p", "p"), 1)
+	assert.Len(t, skipped, 3)
+	assert.Equal(t, "no values", skipped[`{"id":"bad2","pType":"p"}`])
+	assert.Equal(t, "no pType", skipped[`{"id":"bad3","v0":"bob"}`])
+}
+
+func TestValidator(t *testing.T) {
+	container := newMapContainer()
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Validator: ValidateAll(
+			NoEmptyValues,
+			MaxValueLength(16),
+			MatchValue("p", 0, regexp.MustCompile(`^user:`)),
+		),
+	})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"user:alice", "data1", "read"}))
+
+	err := a.AddPolicy("p", "p", []string{"", "data1", "read"})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+	assert.EqualError(t, err, "cosmosadapter: invalid rule: p, , data1, read: value 0 is empty")
+	assert.ErrorIs(t, a.AddPolicy("p", "p", []string{"bob", "data1", "read"}), ErrInvalidRule)
+	assert.ErrorIs(t, a.AddPolicy("p", "p", []string{"user:bob", "data1", "read-and-write-all"}), ErrInvalidRule)
+	// groupings are not subjects to the naming convention
+	assert.NoError(t, a.AddPolicy("g", "g", []string{"user:alice", "admin"}))
+
+	// a rejected SavePolicy doesn't touch the stored policy
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	m.AddPolicy("p", "p", []string{"carol", "data2", "write"})
+	assert.ErrorIs(t, a.SavePolicy(m), ErrInvalidRule)
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"user:alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+	assert.Equal(t, [][]string{{"user:alice", "admin"}}, m.GetPolicy("g", "g"))
+}
+
+func TestNormalizer(t *testing.T) {
+	container := newMapContainer()
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Normalizer:    NormalizeAll(TrimSpace, LowerCase("p", 0)),
+		Validator:     MatchValue("p", 0, regexp.MustCompile(`^[a-z]+$`)),
+	})
+	// the validator sees the normalized values
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"Alice ", "Data1", "read"}))
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"alice", "Data1", "read"}}, m.GetPolicy("p", "p"))
+
+	// the same rule before normalization is one document
+	m.AddPolicy("p", "p", []string{" ALICE", "Data1", "read"})
+	m.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	assert.NoError(t, a.SavePolicy(m))
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.ElementsMatch(t, [][]string{{"alice", "Data1", "read"}, {"bob", "data2", "write"}}, m.GetPolicy("p", "p"))
+
+	assert.NoError(t, a.RemovePolicy("p", "p", []string{"Bob", "data2", "write"}))
+	assert.NoError(t, a.RemoveFilteredPolicy("p", "p", 0, "ALICE "))
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Empty(t, m.GetPolicy("p", "p"))
+}
+
+// patchContainer applies partial document updates to the documents of a
+// mapContainer.
+type patchContainer struct {
+	*mapContainer
+	patches int
+}
+
+func (c *patchContainer) PatchItem(ctx context.Context, pk azcosmos.PartitionKey, id string, ops azcosmos.PatchOperations, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	res, err := c.ReadItem(ctx, pk, id, nil)
+	if err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	if o != nil && o.IfMatchEtag != nil && *o.IfMatchEtag != res.ETag {
+		return azcosmos.ItemResponse{}, &azcore.ResponseError{StatusCode: http.StatusPreconditionFailed}
+	}
+	var doc map[string]any
+	json.Unmarshal(res.Value, &doc)
+	var patch struct {
+		Condition  string `json:"condition"`
+		Operations []struct {
+			Op    string `json:"op"`
+			Path  string `json:"path"`
+			Value any    `json:"value"`
+		} `json:"operations"`
+	}
+	marshalled, err := json.Marshal(ops)
+	if err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	json.Unmarshal(marshalled, &patch)
+	if _, deleted := doc["deleted"]; deleted && patch.Condition != "" {
+		return azcosmos.ItemResponse{}, &azcore.ResponseError{StatusCode: http.StatusPreconditionFailed}
+	}
+	for _, op := range patch.Operations {
+		field := strings.TrimPrefix(op.Path, "/")
+		switch op.Op {
+		case "set":
+			doc[field] = op.Value
+		case "remove":
+			if _, ok := doc[field]; !ok {
+				return azcosmos.ItemResponse{}, &azcore.ResponseError{StatusCode: http.StatusBadRequest}
+			}
+			delete(doc, field)
+		}
+	}
+	item, _ := json.Marshal(doc)
+	c.patches++
+	return c.UpsertItem(ctx, pk, item, nil)
+}
+
+func TestUpdatePolicy(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	stored := func(c *patchContainer) map[string]map[string]any {
+		docs := map[string]map[string]any{}
+		for _, item := range c.items[fmt.Sprint(azcosmos.NewPartitionKeyString("p"))] {
+			var doc map[string]any
+			assert.NoError(t, json.Unmarshal(item, &doc))
+			docs[doc["id"].(string)] = doc
+		}
+		return docs
+	}
+	load := func(a *Adapter) [][]string {
+		m, err := model.NewModelFromFile("examples/rbac_model.conf")
+		assert.NoError(t, err)
+		assert.NoError(t, a.LoadPolicy(m))
+		return m.GetPolicy("p", "p")
+	}
+
+	// the default IDs derive from the values, so the rule is replaced
+	container := &patchContainer{mapContainer: newMapContainer()}
+	a := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.NoError(t, a.UpdatePolicy("p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"}))
+	assert.Equal(t, 0, container.patches)
+	assert.Len(t, stored(container), 1)
+	assert.Equal(t, [][]string{{"alice", "data1", "write"}}, load(a))
+	assert.Error(t, a.UpdatePolicy("p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"}))
+
+	// IDs left unchanged by the update are patched in place
+	container = &patchContainer{mapContainer: newMapContainer()}
+	a = NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		IDFunc:        func(ptype string, rule []string) string { return ptype + ":" + rule[0] + ":" + rule[1] },
+		Now:           func() time.Time { return now },
+		Signer:        NewHMACSigner([]byte("key")),
+	})
+	assert.NoError(t, a.AddPolicies("p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data2", "read"}}))
+	doc := stored(container)["p:alice:data1"]
+	doc["createdBy"] = "admin"
+	// written before the schema version was stamped
+	delete(doc, "schemaVersion")
+	item, err := json.Marshal(doc)
+	assert.NoError(t, err)
+	_, err = container.UpsertItem(context.Background(), azcosmos.NewPartitionKeyString("p"), item, nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.UpdatePolicy("p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"}))
+	assert.Equal(t, 1, container.patches)
+	doc = stored(container)["p:alice:data1"]
+	assert.Equal(t, "write", doc["v2"])
+	assert.Equal(t, "admin", doc["createdBy"])
+	assert.Equal(t, float64(now.Unix()), doc["updatedAt"])
+	assert.Equal(t, float64(currentSchemaVersion), doc["schemaVersion"])
+	// the signature is updated with the values
+	assert.ElementsMatch(t, [][]string{{"alice", "data1", "write"}, {"bob", "data2", "read"}}, load(a))
+
+	// a rule moving to another ID is replaced
+	assert.NoError(t, a.UpdatePolicy("p", "p", []string{"bob", "data2", "read"}, []string{"bob", "data3", "read"}))
+	assert.Equal(t, 1, container.patches)
+	assert.NotContains(t, stored(container), "p:bob:data2")
+
+	// the rules are updated one by one
+	err = a.UpdatePolicies("p", "p",
+		[][]string{{"alice", "data1", "write"}, {"carol", "data4", "read"}},
+		[][]string{{"alice", "data1", "read"}, {"carol", "data4", "write"}})
+	failed := FailedRules(err)
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, []string{"carol", "data4", "read"}, failed[0].Rule)
+	}
+	assert.Equal(t, 2, container.patches)
+	assert.Error(t, a.UpdatePolicies("p", "p", [][]string{{"alice", "data1", "read"}}, nil))
+
+	replaced, err := a.UpdateFilteredPolicies("p", "p", [][]string{{"dave", "data5", "read"}}, 0, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"bob", "data3", "read"}}, replaced)
+	assert.ElementsMatch(t, [][]string{{"alice", "data1", "read"}, {"dave", "data5", "read"}}, load(a))
+
+	// with an enforcer
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	e, err := casbin.NewEnforcer(m, a)
+	assert.NoError(t, err)
+	ok, err := e.UpdatePolicy([]string{"dave", "data5", "read"}, []string{"dave", "data5", "write"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 3, container.patches)
+	assert.ElementsMatch(t, [][]string{{"alice", "data1", "read"}, {"dave", "data5", "write"}}, load(a))
+
+	// a dry run reports the patch
+	var writes []DryWrite
+	dry := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		IDFunc:        func(ptype string, rule []string) string { return ptype + ":" + rule[0] + ":" + rule[1] },
+		DryRun:        true,
+		OnDryRun:      func(w DryWrite) { writes = append(writes, w) },
+	})
+	assert.NoError(t, dry.UpdatePolicy("p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"}))
+	assert.Equal(t, 3, container.patches)
+	// and the version bump
+	if assert.Len(t, writes, 2) {
+		assert.Equal(t, "patch", writes[0].Method)
+		assert.Contains(t, string(writes[0].Document), `"path":"/v2"`)
+	}
+}
+func TestSignedRules(t *testing.T) {
+	container := newMapContainer()
+	newAdapter := func(signer Signer, policy SignaturePolicy) *Adapter {
+		return NewAdapterFromClient(nil, Options{
+			ContainerName:   "casbin_rule",
+			NewContainer:    func(name string) Container { return container },
+			Signer:          signer,
+			SignaturePolicy: policy,
+		})
+	}
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	a := newAdapter(NewEd25519Signer(privateKey, nil), SignatureReject)
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"bob", "data2", "write"}))
+
+	load := func(a *Adapter) ([][]string, error) {
+		m, err := model.NewModelFromFile("examples/rbac_model.conf")
+		assert.NoError(t, err)
+		err = a.LoadPolicy(m)
+		return m.GetPolicy("p", "p"), err
+	}
+	// readers only need the public key
+	reader := newAdapter(NewEd25519Signer(nil, publicKey), SignatureReject)
+	rules, err := load(reader)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}}, rules)
+	assert.Error(t, reader.AddPolicy("p", "p", []string{"carol", "data3", "read"}))
+
+	// a rule changed through the portal
+	pk := fmt.Sprint(azcosmos.NewPartitionKeyString("p"))
+	for id, doc := range container.items[pk] {
+		if strings.Contains(string(doc), "bob") {
+			container.items[pk][id] = []byte(strings.Replace(string(doc), `"write"`, `"admin"`, 1))
+		}
+	}
+	_, err = load(reader)
+	assert.ErrorIs(t, err, ErrMalformedDocument)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	rules, err = load(newAdapter(NewEd25519Signer(nil, publicKey), SignatureSkip))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, rules)
+	rules, err = load(newAdapter(NewEd25519Signer(nil, publicKey), SignatureWarn))
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	// the signatures of another key don't match
+	_, err = load(newAdapter(NewHMACSigner([]byte("secret")), SignatureReject))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestPseudonymize(t *testing.T) {
+	key := []byte("secret")
+	container := newMapContainer()
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Normalizer:    NormalizeAll(Pseudonymize(key, "p", 0), Pseudonymize(key, "g", 0)),
+	})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"admin", "data1", "read"}))
+	assert.NoError(t, a.AddPolicy("g", "g", []string{"alice@example.com", Pseudonym(key, "admin")}))
+
+	// the subjects are not stored
+	for _, partition := range container.items {
+		for _, doc := range partition {
+			assert.NotContains(t, string(doc), "alice")
+			assert.NotContains(t, string(doc), "admin")
+		}
+	}
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	assert.NoError(t, err)
+	ok, err := e.Enforce(Pseudonym(key, "alice@example.com"), "data1", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = e.Enforce("alice@example.com", "data1", "read")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// pseudonyms are not pseudonymized again
+	assert.NoError(t, a.SavePolicy(e.GetModel()))
+	assert.NoError(t, e.LoadPolicy())
+	assert.Equal(t, [][]string{{Pseudonym(key, "alice@example.com"), Pseudonym(key, "admin")}}, e.GetModel().GetPolicy("g", "g"))
+	assert.NoError(t, a.RemoveFilteredPolicy("g", "g", 0, "alice@example.com"))
+	assert.NoError(t, e.LoadPolicy())
+	assert.Empty(t, e.GetModel().GetPolicy("g", "g"))
+
+	assert.NotEqual(t, Pseudonym(key, "alice"), Pseudonym([]byte("other"), "alice"))
+	assert.Panics(t, func() { Pseudonymize(nil, "p") })
+}
+
+func TestSavePolicyDuplicates(t *testing.T) {
+	var buf bytes.Buffer
+	container := newMapContainer()
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Normalizer:    TrimSpace,
+		Logger:        slog.New(slog.NewTextHandler(&buf, nil)),
+	})
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	m["p"]["p"].Policy = [][]string{
+		{"alice", "data1", "read"},
+		{"alice", "data1", "read"},
+		{"alice ", "data1", "read"},
+		{"bob", "data2", "write"},
+	}
+	assert.NoError(t, a.SavePolicy(m))
+	assert.Equal(t, 2, strings.Count(buf.String(), "skipped duplicate rule"))
+
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.ElementsMatch(t, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}}, m.GetPolicy("p", "p"))
+}
+
+func TestStrictDecoding(t *testing.T) {
+	container := newMapContainer()
+	options := Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }}
+	a := NewAdapterFromClient(nil, options)
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	ctx := context.Background()
+	for _, doc := range []string{
+		`{"id":"system","pType":"p","v0":"bob","v1":"data2","v2":"read","_rid":"abc","_etag":"\"1\""}`,
+		`{"id":"unknown","pType":"p","v0":"carol","v1":"data2","v2":"read","owner":"team"}`,
+		`{"id":"null","pType":"p","v0":"dave","v1":null,"v2":"read"}`,
+	} {
+		_, err := container.UpsertItem(ctx, azcosmos.NewPartitionKeyString("p"), []byte(doc), nil)
+		assert.NoError(t, err)
+	}
+
+	// lenient by default
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Len(t, m.GetPolicy("p", "p"), 4)
+
+	options.StrictDecoding = true
+	a = NewAdapterFromClient(nil, options)
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	err = a.LoadPolicy(m)
+	var malformed *MalformedDocumentsError
+	assert.ErrorAs(t, err, &malformed)
+	reasons := map[string]string{}
+	for _, doc := range malformed.Documents {
+		reasons[doc.ID] = doc.Err.Error()
+	}
+	assert.Equal(t, map[string]string{
+		"unknown": `unknown field "owner"`,
+		"null":    `field "v1" is null`,
+	}, reasons)
+}
+
+func TestOperationTimeout(t *testing.T) {
+	options := Options{DatabaseName: "casbin", ContainerName: "casbin_rule", SkipAutoCreate: true, OperationTimeout: 50 * time.Millisecond}
+	options.Retry = policy.RetryOptions{MaxRetries: -1}
+	options.Transport = transportFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/" {
+			body := `{"id":"account","writableLocations":[],"readableLocations":[]}`
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+		}
+		// a stuck gateway
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	a := NewAdapterFromConnectionSting("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";", options)
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	start := time.Now()
+	assert.ErrorIs(t, a.LoadPolicy(m), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// a deadline of the caller is kept
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.ErrorIs(t, a.Ping(ctx), context.DeadlineExceeded)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+// endlessContainer returns pages of one rule forever, ignoring the context.
+type endlessContainer struct {
+	*mapContainer
+	pages  int
+	onPage func(pages int)
+}
+
+func (c *endlessContainer) NewQueryItemsPager(query string, pk azcosmos.PartitionKey, o *azcosmos.QueryOptions) *runtime.Pager[azcosmos.QueryItemsResponse] {
+	return runtime.NewPager(runtime.PagingHandler[azcosmos.QueryItemsResponse]{
+		More: func(res azcosmos.QueryItemsResponse) bool { return true },
+		Fetcher: func(ctx context.Context, res *azcosmos.QueryItemsResponse) (azcosmos.QueryItemsResponse, error) {
+			c.pages++
+			c.onPage(c.pages)
+			item := fmt.Sprintf(`{"id":"%d","pType":"p","v0":"alice","v1":"data%d","v2":"read"}`, c.pages, c.pages)
+			return azcosmos.QueryItemsResponse{Items: [][]byte{[]byte(item)}}, nil
+		},
+	})
+}
+
+func TestPagingCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	container := &endlessContainer{mapContainer: newMapContainer(), onPage: func(pages int) {
+		if pages == 3 {
+			cancel()
+		}
+	}}
+	a := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }})
+
+	_, err := a.ExportDocuments(ctx, io.Discard)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 3, container.pages)
+}
+
+// hookContainer calls onCreate after every item created.
+type hookContainer struct {
+	*mapContainer
+	onCreate func()
+}
+
+func (c *hookContainer) CreateItem(ctx context.Context, pk azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	res, err := c.mapContainer.CreateItem(ctx, pk, item, o)
+	if err == nil && c.onCreate != nil {
+		c.onCreate()
+	}
+	return res, err
+}
+
+func TestGenerationalSave(t *testing.T) {
+	container := &hookContainer{mapContainer: newMapContainer()}
+	options := Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }, GenerationalSave: true}
+	a := NewAdapterFromClient(nil, options)
+	reader := NewAdapterFromClient(nil, options)
+	load := func(a *Adapter) [][]string {
+		m, err := model.NewModelFromFile("examples/rbac_model.conf")
+		assert.NoError(t, err)
+		assert.NoError(t, a.LoadPolicy(m))
+		return m.GetPolicy("p", "p")
+	}
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, load(reader))
+
+	// readers see the complete previous policy while the new one is written
+	var mu sync.Mutex
+	var during [][][]string
+	container.onCreate = func() {
+		policy := load(reader)
+		mu.Lock()
+		defer mu.Unlock()
+		during = append(during, policy)
+	}
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	m.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	m.AddPolicy("p", "p", []string{"carol", "data3", "read"})
+	assert.NoError(t, a.SavePolicy(m))
+	container.onCreate = nil
+	assert.Len(t, during, 2)
+	for _, policy := range during {
+		assert.Equal(t, [][]string{{"alice", "data1", "read"}}, policy)
+	}
+	assert.ElementsMatch(t, [][]string{{"bob", "data2", "write"}, {"carol", "data3", "read"}}, load(reader))
+
+	// the previous generation is kept for the loads in flight
+	generations := func() map[int64][]string {
+		ids := map[int64][]string{}
+		for _, item := range container.items[fmt.Sprint(azcosmos.NewPartitionKeyString("p"))] {
+			var doc CasbinRule
+			assert.NoError(t, json.Unmarshal(item, &doc))
+			ids[doc.Generation] = append(ids[doc.Generation], doc.ID)
+		}
+		return ids
+	}
+	ids := generations()
+	assert.Len(t, ids[0], 1)
+	sort.Strings(ids[1])
+	assert.Len(t, ids[1], 2)
+	assert.True(t, strings.HasSuffix(ids[1][0], ":g1"))
+
+	// later writes join the current generation
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"dave", "data4", "read"}))
+	assert.NoError(t, a.RemovePolicy("p", "p", []string{"bob", "data2", "write"}))
+	assert.ElementsMatch(t, [][]string{{"carol", "data3", "read"}, {"dave", "data4", "read"}}, load(reader))
+
+	// the documents of an interrupted SavePolicy are ignored, then purged
+	leftover := withGeneration(a.newPolicyLine("p", []string{"mallory", "data5", "write"}), 2)
+	document, err := json.Marshal(leftover)
+	assert.NoError(t, err)
+	_, err = container.UpsertItem(context.Background(), azcosmos.NewPartitionKeyString("p"), document, nil)
+	assert.NoError(t, err)
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, reader.LoadPolicy(m))
+	assert.ElementsMatch(t, [][]string{{"carol", "data3", "read"}, {"dave", "data4", "read"}}, m.GetPolicy("p", "p"))
+	assert.NoError(t, a.SavePolicy(m))
+	assert.ElementsMatch(t, [][]string{{"carol", "data3", "read"}, {"dave", "data4", "read"}}, load(reader))
+	// and the generation before the previous one is purged
+	ids = generations()
+	assert.Len(t, ids, 2)
+	assert.Len(t, ids[1], 2)
+	assert.Len(t, ids[2], 2)
+
+	// a delta can't follow a generation it didn't load
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, reader.LoadPolicy(m))
+	assert.NoError(t, a.SavePolicy(m))
+	assert.ErrorContains(t, reader.LoadPolicyDelta(m), "LoadPolicy must be called")
+}
+
+// queryHookContainer calls onQuery before running a query.
+type queryHookContainer struct {
+	*mapContainer
+	onQuery func()
+}
+
+func (c *queryHookContainer) NewQueryItemsPager(query string, pk azcosmos.PartitionKey, o *azcosmos.QueryOptions) *runtime.Pager[azcosmos.QueryItemsResponse] {
+	if onQuery := c.onQuery; onQuery != nil {
+		c.onQuery = nil
+		onQuery()
+	}
+	return c.mapContainer.NewQueryItemsPager(query, pk, o)
+}
+
+// clockContainer stamps the documents written with the _ts of its clock, and
+// applies the _ts filter of LoadPolicyDelta to the queries.
+type clockContainer struct {
+	*mapContainer
+	now     int64
+	onQuery func(pk azcosmos.PartitionKey)
+}
+
+func (c *clockContainer) stamp(item []byte) []byte {
+	var doc map[string]any
+	json.Unmarshal(item, &doc)
+	doc["_ts"] = c.now
+	stamped, _ := json.Marshal(doc)
+	return stamped
+}
+
+func (c *clockContainer) CreateItem(ctx context.Context, pk azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.mapContainer.CreateItem(ctx, pk, c.stamp(item), o)
+}
+
+func (c *clockContainer) UpsertItem(ctx context.Context, pk azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.mapContainer.UpsertItem(ctx, pk, c.stamp(item), o)
+}
+
+func (c *clockContainer) ReplaceItem(ctx context.Context, pk azcosmos.PartitionKey, id string, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.mapContainer.ReplaceItem(ctx, pk, id, c.stamp(item), o)
+}
+
+func (c *clockContainer) NewQueryItemsPager(query string, pk azcosmos.PartitionKey, o *azcosmos.QueryOptions) *runtime.Pager[azcosmos.QueryItemsResponse] {
+	if onQuery := c.onQuery; onQuery != nil {
+		onQuery(pk)
+	}
+	var since int64
+	for _, parameter := range o.QueryParameters {
+		if parameter.Name == "@ts" {
+			since = parameter.Value.(int64)
+		}
+	}
+	pager := c.mapContainer.NewQueryItemsPager(query, pk, o)
+	return runtime.NewPager(runtime.PagingHandler[azcosmos.QueryItemsResponse]{
+		More: func(res azcosmos.QueryItemsResponse) bool { return false },
+		Fetcher: func(ctx context.Context, _ *azcosmos.QueryItemsResponse) (azcosmos.QueryItemsResponse, error) {
+			res, err := pager.NextPage(ctx)
+			items := res.Items[:0]
+			for _, item := range res.Items {
+				var doc struct {
+					Ts int64 `json:"_ts"`
+				}
+				json.Unmarshal(item, &doc)
+				if doc.Ts >= since {
+					items = append(items, item)
+				}
+			}
+			res.Items = items
+			return res, err
+		},
+	})
+}
+
+func TestLoadPolicyDeltaWatermark(t *testing.T) {
+	container := &clockContainer{mapContainer: newMapContainer(), now: 1000}
+	options := Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Now:           func() time.Time { return time.Unix(container.now, 0) },
+	}
+	a := NewAdapterFromClient(nil, options)
+	writer := NewAdapterFromClient(nil, options)
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+
+	// an empty policy has a watermark too
+	assert.Error(t, a.LoadPolicyDelta(m))
+	assert.NoError(t, a.LoadPolicy(m))
+	container.now += 100
+	assert.NoError(t, writer.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.NoError(t, a.LoadPolicyDelta(m))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+
+	// writes made while the policy types are read, to a policy type read
+	// already and then to one read after, are read by the next delta
+	container.now += 100
+	var queried []string
+	container.onQuery = func(pk azcosmos.PartitionKey) {
+		queried = append(queried, fmt.Sprint(pk))
+		if len(queried) != 2 {
+			return
+		}
+		rules := map[string][]string{"p": {"bob", "data2", "read"}, "g": {"bob", "admin"}}
+		for _, ptype := range []string{"p", "g"} {
+			if fmt.Sprint(azcosmos.NewPartitionKeyString(ptype)) == queried[0] {
+				container.now += 10
+				assert.NoError(t, writer.AddPolicy(ptype, ptype, rules[ptype]))
+			}
+		}
+		for _, ptype := range []string{"p", "g"} {
+			if fmt.Sprint(azcosmos.NewPartitionKeyString(ptype)) != queried[0] {
+				container.now += 10
+				assert.NoError(t, writer.AddPolicy(ptype, ptype, rules[ptype]))
+			}
+		}
+	}
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	container.onQuery = nil
+	assert.NoError(t, a.LoadPolicyDelta(m))
+	assert.ElementsMatch(t, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "read"}}, m.GetPolicy("p", "p"))
+	assert.Equal(t, [][]string{{"bob", "admin"}}, m.GetPolicy("g", "g"))
+}
+
+func TestLoadPolicyPagesWatermark(t *testing.T) {
+	container := &clockContainer{mapContainer: newMapContainer(), now: 1000}
+	options := Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Now:           func() time.Time { return time.Unix(container.now, 0) },
+	}
+	a := NewAdapterFromClient(nil, options)
+	writer := NewAdapterFromClient(nil, options)
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+
+	// the rules written between the pages of a load of an empty policy are
+	// read by the next delta
+	var cursor LoadCursor
+	for !cursor.Done {
+		cursor, err = a.LoadPolicyPages(m, cursor, 1)
+		assert.NoError(t, err)
+		container.now += 10
+		if !cursor.Done {
+			assert.NoError(t, writer.AddPolicy("g", "g", []string{"bob", "admin"}))
+			container.now += 10
+			assert.NoError(t, writer.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+		}
+	}
+	assert.NotZero(t, cursor.Watermark)
+	assert.NoError(t, a.LoadPolicyDelta(m))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+	assert.Equal(t, [][]string{{"bob", "admin"}}, m.GetPolicy("g", "g"))
+}
+
+func TestGenerationalSaveDuringLoad(t *testing.T) {
+	container := &queryHookContainer{mapContainer: newMapContainer()}
+	options := Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }, GenerationalSave: true}
+	a := NewAdapterFromClient(nil, options)
+	reader := NewAdapterFromClient(nil, options)
+	saved, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	saved.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+	assert.NoError(t, a.SavePolicy(saved))
+
+	// a SavePolicy switching to a new generation while the rules are read
+	saves := 0
+	var save func()
+	save = func() {
+		saves++
+		saved.AddPolicy("p", "p", []string{fmt.Sprint("user", saves), "data2", "read"})
+		assert.NoError(t, a.SavePolicy(saved))
+		if saves < 2 {
+			container.onQuery = save
+		}
+	}
+	container.onQuery = save
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, reader.LoadPolicy(m))
+	assert.Equal(t, 2, saves)
+	assert.ElementsMatch(t, saved.GetPolicy("p", "p"), m.GetPolicy("p", "p"))
+
+	// loads give up when every attempt is overtaken
+	saves = -100
+	container.onQuery = save
+	assert.ErrorContains(t, reader.LoadPolicy(m), "saved during every attempt")
+}
+
+// failingContainer fails the writes of the documents of the subjects with the
+// given status.
+type failingContainer struct {
+	*mapContainer
+	fail map[string]int
+}
+
+func (c *failingContainer) failure(item []byte) error {
+	var doc CasbinRule
+	json.Unmarshal(item, &doc)
+	if status, ok := c.fail[doc.V0]; ok {
+		return &azcore.ResponseError{StatusCode: status}
+	}
+	return nil
+}
+
+func (c *failingContainer) CreateItem(ctx context.Context, pk azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := c.failure(item); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.mapContainer.CreateItem(ctx, pk, item, o)
+}
+
+func (c *failingContainer) UpsertItem(ctx context.Context, pk azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := c.failure(item); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.mapContainer.UpsertItem(ctx, pk, item, o)
+}
+
+func TestBatchFailures(t *testing.T) {
+	container := &failingContainer{mapContainer: newMapContainer(), fail: map[string]int{
+		"carol": http.StatusRequestEntityTooLarge,
+		"dave":  http.StatusTooManyRequests,
+	}}
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Validator:     NoEmptyValues,
+	})
+	load := func() [][]string {
+		m, err := model.NewModelFromFile("examples/rbac_model.conf")
+		assert.NoError(t, err)
+		assert.NoError(t, a.LoadPolicy(m))
+		return m.GetPolicy("p", "p")
+	}
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"bob", "data2", "write"}))
+
+	// the failed rules are reported, the others written
+	err := a.AddPolicies("p", "p", [][]string{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"carol", "data3", "read"},
+		{"", "data4", "read"},
+	})
+	failed := FailedRules(err)
+	assert.Len(t, failed, 3)
+	assert.Equal(t, []string{"bob", "data2", "write"}, failed[0].Rule)
+	assert.ErrorIs(t, failed[0], ErrConflict)
+	assert.Equal(t, []string{"carol", "data3", "read"}, failed[1].Rule)
+	assert.ErrorIs(t, failed[1], ErrTooLarge)
+	assert.ErrorIs(t, failed[2], ErrInvalidRule)
+	assert.ElementsMatch(t, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}}, load())
+
+	err = a.RemovePolicies("p", "p", [][]string{{"alice", "data1", "read"}, {"erin", "data5", "read"}})
+	failed = FailedRules(err)
+	assert.Len(t, failed, 1)
+	assert.Equal(t, "p", failed[0].PType)
+	assert.Equal(t, []string{"erin", "data5", "read"}, failed[0].Rule)
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, load())
+
+	// a failed batch doesn't stop an import
+	n, err := a.ImportCSV(context.Background(), strings.NewReader("p, dave, data6, read\np, frank, data7, read\n"), ImportOptions{MaxRetries: -1})
+	assert.Equal(t, 1, n)
+	failed = FailedRules(err)
+	assert.Len(t, failed, 1)
+	assert.Equal(t, []string{"dave", "data6", "read"}, failed[0].Rule)
+	assert.ErrorIs(t, failed[0], ErrThrottled)
+	assert.ElementsMatch(t, [][]string{{"bob", "data2", "write"}, {"frank", "data7", "read"}}, load())
+}
+
+// statementContainer records the statements of the queries.
+type statementContainer struct {
+	*mapContainer
+	statements []string
+}
+
+func (c *statementContainer) NewQueryItemsPager(query string, pk azcosmos.PartitionKey, o *azcosmos.QueryOptions) *runtime.Pager[azcosmos.QueryItemsResponse] {
+	c.statements = append(c.statements, query)
+	return c.mapContainer.NewQueryItemsPager(query, pk, o)
+}
+
+func TestRuleProjection(t *testing.T) {
+	assert.Equal(t, "SELECT c.id, c.pType, c.v0, c.v1, c.v2, c.v3, c.v4, c.v5, c.v6, c.v7, c.v8, c.v9, c.v10, c.v11, "+
+		"c.rule, c.partitionKey, c.namespace, c.ttl, c.expiresAt, c.deleted, c.schemaVersion, c.generation, c.signature, c.updatedAt, c._ts FROM c", ruleProjection)
+
+	for _, mapper := range []DocumentMapper{nil, upperMapper{}} {
+		container := &statementContainer{mapContainer: newMapContainer()}
+		a := NewAdapterFromClient(nil, Options{
+			ContainerName: "casbin_rule",
+			NewContainer:  func(name string) Container { return container },
+			Mapper:        mapper,
+		})
+		assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+		m, err := model.NewModelFromFile("examples/rbac_model.conf")
+		assert.NoError(t, err)
+		assert.NoError(t, a.LoadPolicy(m))
+		assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+		assert.NotEmpty(t, container.statements)
+		for _, statement := range container.statements {
+			// a custom mapper may read fields of its own
+			assert.Equal(t, mapper != nil, strings.HasPrefix(statement, "SELECT * "), statement)
+		}
+	}
+}
+
+func TestRemoveFilteredPolicyBatches(t *testing.T) {
+	initPolicy(t, options.DatabaseName, options.ContainerName)
+	a := NewAdapterFromConnectionSting(getConnString(t), options)
+	var rules [][]string
+	for i := 0; i < 2*maxBatchSize+10; i++ {
+		rules = append(rules, []string{"bulk", fmt.Sprintf("data%d", i), "read"})
+	}
+	assert.NoError(t, a.AddPolicies("p", "p", rules))
+
+	// the matches take three batches
+	assert.NoError(t, a.RemoveFilteredPolicy("p", "p", 0, "bulk"))
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	if err != nil {
+		t.Fatalf("Expected NewEnforcer() to be successful; got %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func TestForEach(t *testing.T) {
+	// at most concurrency calls run at once
+	var running, peak atomic.Int32
+	items := make([]int, 50)
+	assert.NoError(t, forEach(context.Background(), 4, items, func(ctx context.Context, i int) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		return nil
+	}))
+	assert.LessOrEqual(t, peak.Load(), int32(4))
+
+	// the errors are in the order of the items, the later items are skipped
+	var calls atomic.Int32
+	err := forEach(context.Background(), 2, []int{0, 1, 2, 3, 4, 5, 6, 7}, func(ctx context.Context, i int) error {
+		calls.Add(1)
+		switch i {
+		case 0:
+			time.Sleep(20 * time.Millisecond)
+			return errors.New("first")
+		case 1:
+			return errors.New("second")
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	assert.EqualError(t, err, "first\nsecond")
+	assert.Less(t, calls.Load(), int32(8))
+
+	// a single error is returned as is
+	sentinel := errors.New("sentinel")
+	assert.Equal(t, sentinel, forEach(context.Background(), 1, []int{0, 1}, func(ctx context.Context, i int) error {
+		return sentinel
+	}))
+
+	// SavePolicy writes every rule
+	container := newMapContainer()
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName:    "casbin_rule",
+		NewContainer:     func(name string) Container { return container },
+		WriteConcurrency: 8,
+	})
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	for i := 0; i < 500; i++ {
+		m.AddPolicy("p", "p", []string{"alice", fmt.Sprintf("data%d", i), "read"})
+	}
+	assert.NoError(t, a.SavePolicy(m))
+	m.ClearPolicy()
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Len(t, m.GetPolicy("p", "p"), 500)
+}
+
+func TestFilterCache(t *testing.T) {
+	assert.Equal(t, filterKey(SqlQuerySpec{Query: "SELECT * FROM c WHERE c.v0 = 'a  b'", Parameters: []azcosmos.QueryParameter{{Name: "@b", Value: 2}, {Name: "@a", Value: "x"}}}),
+		filterKey(SqlQuerySpec{Query: " SELECT *\n\tFROM c  WHERE c.v0 = 'a  b'", Parameters: []azcosmos.QueryParameter{{Name: "@a", Value: "x"}, {Name: "@b", Value: 2}}}))
+	assert.NotEqual(t, filterKey(SqlQuerySpec{Query: "SELECT * FROM c WHERE c.v0 = 'a  b'"}), filterKey(SqlQuerySpec{Query: "SELECT * FROM c WHERE c.v0 = 'a b'"}))
+	assert.NotEqual(t, filterKey(SqlQuerySpec{Query: `SELECT * FROM c WHERE c.v0 = 'it\'s  a'`}), filterKey(SqlQuerySpec{Query: `SELECT * FROM c WHERE c.v0 = 'it\'s a'`}))
+	assert.NotEqual(t, filterKey(*Q("SELECT * FROM c WHERE c.v0 = @v", azcosmos.QueryParameter{Name: "@v", Value: "alice"})),
+		filterKey(*Q("SELECT * FROM c WHERE c.v0 = @v", azcosmos.QueryParameter{Name: "@v", Value: "bob"})))
+
+	container := &statementContainer{mapContainer: newMapContainer()}
+	now := time.Unix(1000, 0)
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName:   "casbin_rule",
+		NewContainer:    func(name string) Container { return container },
+		FilterCacheSize: 2,
+		FilterCacheTTL:  time.Minute,
+		Now:             func() time.Time { return now },
+	})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	filters := []*SqlQuerySpec{
+		Q("SELECT * FROM c WHERE c.v0 = @v", azcosmos.QueryParameter{Name: "@v", Value: "alice"}),
+		Q("SELECT * FROM c WHERE c.v0 = @v", azcosmos.QueryParameter{Name: "@v", Value: "bob"}),
+		Q("SELECT * FROM c WHERE c.v0 = @v", azcosmos.QueryParameter{Name: "@v", Value: "carol"}),
+	}
+	queries := func(filter int) int {
+		container.statements = nil
+		m, err := model.NewModelFromFile("examples/rbac_model.conf")
+		assert.NoError(t, err)
+		assert.NoError(t, a.LoadFilteredPolicy(m, filters[filter]))
+		assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+		return len(container.statements)
+	}
+	assert.Equal(t, 1, queries(0))
+	assert.Equal(t, 0, queries(0))
+	assert.Equal(t, 1, queries(1))
+
+	// the least recently used filter is evicted
+	assert.Equal(t, 0, queries(0))
+	assert.Equal(t, 1, queries(2))
+	assert.Equal(t, 0, queries(0))
+	assert.Equal(t, 1, queries(1))
+
+	// a change of the policy version invalidates the entries
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"bob", "data2", "write"}))
+	assert.NoError(t, a.RemovePolicy("p", "p", []string{"bob", "data2", "write"}))
+	assert.Equal(t, 1, queries(0))
+	assert.Equal(t, 0, queries(0))
+
+	// so does their age
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, 1, queries(0))
+
+	a.InvalidateFilterCache()
+	assert.Equal(t, 1, queries(0))
+}
+
+func TestDecodeAllocations(t *testing.T) {
+	line := CasbinRule{PType: "p", V0: "alice", V1: "data1", V2: "read"}
+	assert.Equal(t, []string{"alice", "data1", "read"}, policyTokens(line))
+	assert.Equal(t, []string{}, policyTokens(CasbinRule{PType: "p"}))
+	assert.Equal(t, 1.0, testing.AllocsPerRun(100, func() { policyTokens(line) }))
+
+	// the pooled rules don't carry fields over to the next document
+	mapper := jsonMapper{}
+	decoded, err := mapper.FromDocument([]byte(`{"id":"1","pType":"p","v0":"alice","v1":"data1","v2":"read","v3":"extra","deleted":true}`))
+	assert.NoError(t, err)
+	assert.Equal(t, CasbinRule{ID: "1", PType: "p", V0: "alice", V1: "data1", V2: "read", V3: "extra", Deleted: true}, decoded)
+	decoded, err = mapper.FromDocument([]byte(`{"id":"2","pType":"g","v0":"bob","v1":"admin"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, CasbinRule{ID: "2", PType: "g", V0: "bob", V1: "admin"}, decoded)
+
+	assert.EqualError(t, notRuleReason(CasbinRule{PType: "p"}), "no values")
+	assert.NoError(t, notRuleReason(CasbinRule{PType: "p", Rule: []string{"alice"}}))
+}
+
+func TestFilterQueries(t *testing.T) {
+	a := &Adapter{mapper: jsonMapper{}}
+	filter := a.filterQueryFor([]int{0, 2})
+	assert.Equal(t, "SELECT * FROM root WHERE root.pType = @pType AND root.v0 = @v0 AND root.v2 = @v2", filter.query)
+	assert.Equal(t, []azcosmos.QueryParameter{{Name: "@pType", Value: "p"}, {Name: "@v0", Value: "alice"}, {Name: "@v2", Value: "read"}},
+		filter.parameters("p", []string{"alice", "read"}))
+	// built once per set of fields
+	cached, ok := a.filterQueries.Load(uint64(0b101))
+	assert.True(t, ok)
+	assert.Equal(t, filter, cached)
+	assert.Equal(t, "SELECT * FROM root WHERE root.pType = @pType", a.filterQueryFor(nil).query)
+	assert.Equal(t, "SELECT * FROM root WHERE root.pType = @pType AND root.v70 = @v70", a.filterQueryFor([]int{70}).query)
+
+	a = &Adapter{mapper: jsonMapper{}, arraySchema: true, namespace: "billing"}
+	filter = a.filterQueryFor([]int{1})
+	assert.Equal(t, "SELECT * FROM root WHERE root.namespace = @namespace AND (root.pType = @pType AND (root.v1 = @v1 OR root.rule[1] = @v1))", filter.query)
+	assert.Equal(t, []azcosmos.QueryParameter{{Name: "@pType", Value: "g"}, {Name: "@v1", Value: "admin"}, {Name: "@namespace", Value: "billing"}},
+		filter.parameters("g", []string{"admin"}))
+
+	// a custom mapping is matched by RemoveFilteredPolicy itself
+	a = &Adapter{mapper: upperMapper{}, namespace: "billing"}
+	filter = a.filterQueryFor([]int{0})
+	assert.Equal(t, "SELECT * FROM root WHERE root.namespace = @namespace", filter.query)
+	assert.Equal(t, []azcosmos.QueryParameter{{Name: "@namespace", Value: "billing"}}, filter.parameters("p", []string{"alice"}))
+}
+
+func TestLoadPolicyArray(t *testing.T) {
+	container := newMapContainer()
+	options := Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }}
+	a := NewAdapterFromClient(nil, options)
+	load := func(a *Adapter) ([][]string, error) {
+		m, err := model.NewModelFromFile("examples/rbac_model.conf")
+		assert.NoError(t, err)
+		err = a.LoadPolicy(m)
+		return m.GetPolicy("p", "p"), err
+	}
+
+	// empty values are kept, in the middle and at the end of the rules
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "", "read"}))
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"", "data2", ""}))
+	policy, err := load(a)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]string{{"alice", "", "read"}, {"", "data2", ""}}, policy)
+	assert.NoError(t, a.RemovePolicy("p", "p", []string{"", "data2", ""}))
+
+	// rules longer than their definition fail the load
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"bob", "data2", "write", "extra"}))
+	_, err = load(a)
+	assert.ErrorIs(t, err, ErrMalformedDocument)
+	options.SkipMalformedDocuments = true
+	policy, err = load(NewAdapterFromClient(nil, options))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"alice", "", "read"}}, policy)
+}
+
+func TestQueryRules(t *testing.T) {
+	container := newMapContainer()
+	now := time.Unix(1000, 0)
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Namespace:     "billing",
+		RuleExpiry:    true,
+		Now:           func() time.Time { return now },
+	})
+	other := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Namespace:     "shipping",
+	})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.NoError(t, a.AddPolicy("g", "g", []string{"alice", "admin"}))
+	assert.NoError(t, a.AddPolicyWithExpiry("p", "p", []string{"bob", "data2", "read"}, now.Add(time.Minute)))
+	assert.NoError(t, other.AddPolicy("p", "p", []string{"carol", "data3", "read"}))
+	now = now.Add(time.Hour)
+
+	rules, err := a.QueryRules(context.Background(), Q("SELECT * FROM c WHERE c.v0 = @subject", azcosmos.QueryParameter{Name: "@subject", Value: "alice"}))
+	assert.NoError(t, err)
+	var got [][]string
+	for _, rule := range rules {
+		assert.Equal(t, "billing", rule.Namespace)
+		got = append(got, append([]string{rule.PType}, policyTokens(rule)...))
+	}
+	// the fake container doesn't filter, the rules of other namespaces, the
+	// expired rules and the policy version are left out by the adapter
+	assert.ElementsMatch(t, [][]string{{"p", "alice", "data1", "read"}, {"g", "alice", "admin"}}, got)
+
+	_, err = a.QueryRules(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestClients(t *testing.T) {
+	options := Options{DatabaseName: "casbin", ContainerName: "casbin_rule", SkipAutoCreate: true}
+	a := NewAdapterFromConnectionSting("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";", options)
+	assert.Equal(t, "casbin", a.DatabaseClient().ID())
+	assert.Equal(t, "casbin_rule", a.ContainerClient().ID())
+
+	fake := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return newMapContainer() }})
+	assert.Nil(t, fake.DatabaseClient())
+	assert.Nil(t, fake.ContainerClient())
+}
+
+func TestPurgePolicyType(t *testing.T) {
+	var requests []*http.Request
+	status := http.StatusOK
+	options := Options{DatabaseName: "casbin", ContainerName: "casbin_rule", SkipAutoCreate: true}
+	options.Transport = transportFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req)
+		return &http.Response{StatusCode: status, Header: http.Header{"X-Ms-Request-Charge": {"10.5"}}, Body: http.NoBody, Request: req}, nil
+	})
+	a := NewAdapterFromConnectionString("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";", options)
+	assert.True(t, a.canPurge("g"))
+
+	ctx, op := a.startOperation(context.Background(), "RemoveFilteredPolicy")
+	purged, err := a.purgePolicyType(ctx, "g", []CasbinRule{{PType: "g", V0: "alice", V1: "admin"}})
+	assert.NoError(t, a.endOperation(op, err))
+	assert.True(t, purged)
+	assert.Len(t, requests, 1)
+	assert.Equal(t, http.MethodPost, requests[0].Method)
+	assert.Equal(t, "/dbs/casbin/colls/casbin_rule/operations/partitionkeydelete", requests[0].URL.Path)
+	assert.Equal(t, `["g"]`, requests[0].Header.Get("x-ms-documentdb-partitionkey"))
+	assert.Equal(t, 10.5, op.requestCharge)
+
+	// accounts without the feature remove the rules one by one
+	status = http.StatusBadRequest
+	purged, err = a.purgePolicyType(context.Background(), "g", nil)
+	assert.NoError(t, err)
+	assert.False(t, purged)
+	assert.False(t, a.canPurge("g"))
+
+	options.Namespace = "app"
+	assert.False(t, NewAdapterFromConnectionString("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";", options).canPurge("g"))
+	assert.False(t, NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return newMapContainer() }}).canPurge("g"))
+}
+
+func TestRemoveFilteredPolicyPurge(t *testing.T) {
+	cosmos := &fakeCosmos{documents: `[{"id":"1","pType":"g","v0":"alice","v1":"admin"}]`}
+	options := Options{DatabaseName: "casbin", ContainerName: "casbin_rule", SkipAutoCreate: true}
+	options.Transport = cosmos
+	a := NewAdapterFromConnectionString("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";", options)
+	purge := "POST dbs/casbin/colls/casbin_rule/operations/partitionkeydelete"
+
+	// only a document is read to tell whether the policy type is empty
+	assert.NoError(t, a.RemoveFilteredPolicy("g", "g", 0))
+	assert.Contains(t, cosmos.requests, purge)
+	if assert.Len(t, cosmos.queries, 1) {
+		assert.Contains(t, cosmos.queries[0], "TOP 1")
+	}
+
+	// an empty policy type is not purged
+	cosmos.documents, cosmos.requests = "", nil
+	assert.NoError(t, a.RemoveFilteredPolicy("g", "g", 0))
+	assert.NotContains(t, cosmos.requests, purge)
+
+	// the removal of the rules is recorded before their partition is deleted
+	cosmos = &fakeCosmos{documents: `[{"id":"1","pType":"g","v0":"alice","v1":"admin"}]`}
+	options.Transport, options.EventSourcing = cosmos, true
+	a = NewAdapterFromConnectionString("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";", options)
+	record := "POST dbs/casbin/colls/casbin_rule_events/docs"
+	assert.NoError(t, a.RemoveFilteredPolicy("g", "g", 0))
+	assert.Equal(t, 1, slices.Index(cosmos.requests, purge)-slices.Index(cosmos.requests, record))
+	assert.Len(t, slices.DeleteFunc(slices.Clone(cosmos.requests), func(request string) bool { return request != record }), 1)
+
+	// and the partition is kept when the removal can't be recorded
+	cosmos.requests = nil
+	cosmos.authorize = func(req *http.Request) bool { return !strings.Contains(req.URL.Path, "casbin_rule_events") }
+	assert.Error(t, a.RemoveFilteredPolicy("g", "g", 0))
+	assert.NotContains(t, cosmos.requests, purge)
+}
+
+func TestPolicyTypes(t *testing.T) {
+	container := newMapContainer()
+	a := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", Namespace: "app", NewContainer: func(name string) Container { return container }})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.NoError(t, a.AddPolicy("g", "g2", []string{"data1", "data"}))
+	ptypes, err := a.PolicyTypes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g2", "p"}, ptypes)
+
+	// the model defines no g2, whose rules SavePolicy removes as well
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	m.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	assert.NoError(t, a.SavePolicy(m))
+	container.mu.Lock()
+	assert.Empty(t, container.items[fmt.Sprint(azcosmos.NewPartitionKeyString("g2"))])
+	container.mu.Unlock()
+	ptypes, err = a.PolicyTypes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p"}, ptypes)
+
+	// the policy types are cached until the rules change
+	assert.Equal(t, []string{"p"}, a.loadedPolicyTypes(m, a.version.Load()))
+	assert.ElementsMatch(t, []string{"p", "g"}, a.loadedPolicyTypes(m, a.version.Load()+1))
+
+	// and recorded in the version document since SavePolicy, so another
+	// instance reads them without a query
+	assert.NoError(t, a.AddPolicy("g", "g2", []string{"data2", "data"}))
+	other := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", Namespace: "app", NewContainer: func(name string) Container { return container }})
+	ptypes, err = other.PolicyTypes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g2", "p"}, ptypes)
+	assert.Zero(t, other.GetOperationStats()["PolicyTypes"].Pages)
+}
+
+func TestDryRun(t *testing.T) {
+	container := newMapContainer()
+	newContainer := func(name string) Container { return container }
+	a := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: newContainer})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	stored := func() map[string]string {
+		container.mu.Lock()
+		defer container.mu.Unlock()
+		items := map[string]string{}
+		for pk, partition := range container.items {
+			for id, item := range partition {
+				items[pk+"/"+id] = string(item)
+			}
+		}
+		return items
+	}
+	before := stored()
+
+	var mu sync.Mutex
+	var writes []DryWrite
+	dry := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  newContainer,
+		DryRun:        true,
+		OnDryRun: func(w DryWrite) {
+			mu.Lock()
+			defer mu.Unlock()
+			writes = append(writes, w)
+		},
+	})
+	assert.NoError(t, dry.AddPolicy("p", "p", []string{"bob", "data2", "write"}))
+	assert.NoError(t, dry.RemovePolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.Equal(t, before, stored())
+
+	methods := map[string][]string{}
+	for _, w := range writes {
+		assert.Equal(t, "casbin_rule", w.Container)
+		methods[w.Operation] = append(methods[w.Operation], w.Method)
+		if w.Operation == "AddPolicy" && w.ID != policyVersionID {
+			var rule CasbinRule
+			assert.NoError(t, json.Unmarshal(w.Document, &rule))
+			assert.Equal(t, []string{"bob", "data2", "write"}, policyTokens(rule))
+		}
+	}
+	// the rule writes, then the policy version bumps
+	assert.Equal(t, []string{"create", "replace"}, methods["AddPolicy"])
+	assert.Equal(t, []string{"delete", "replace"}, methods["RemovePolicy"])
+
+	// SavePolicy deletes the stored documents instead of dropping the container
+	writes = nil
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	m.AddPolicy("p", "p", []string{"carol", "data3", "read"})
+	assert.NoError(t, dry.SavePolicy(m))
+	assert.Equal(t, before, stored())
+	var deleted, written []string
+	for _, w := range writes {
+		switch w.Method {
+		case "delete":
+			deleted = append(deleted, w.ID)
+		case "upsert", "create":
+			written = append(written, w.ID)
+		}
+	}
+	assert.Len(t, deleted, len(before))
+	assert.Contains(t, written, policyVersionID)
+	assert.Len(t, written, 2)
+}
+
+func TestHooks(t *testing.T) {
+	container := newMapContainer()
+	var calls []string
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Hooks: []Hook{{
+			Operations: []string{"AddPolicy", "AddPolicies"},
+			Before: func(ctx context.Context, m *Mutation) error {
+				calls = append(calls, "before "+m.Operation)
+				if m.PType == "g" {
+					return errors.New("roles are read-only")
+				}
+				// rules of other tenants are left out, subjects lower cased
+				var rules [][]string
+				for _, rule := range m.Rules {
+					if rule[1] != "other" {
+						rules = append(rules, append([]string{strings.ToLower(rule[0])}, rule[1:]...))
+					}
+				}
+				m.Rules = rules
+				return nil
+			},
+			After: func(ctx context.Context, m *Mutation, err error) {
+				calls = append(calls, fmt.Sprintf("after %s %d %v", m.Operation, len(m.Rules), err))
+			},
+		}, {
+			After: func(ctx context.Context, m *Mutation, err error) {
+				calls = append(calls, "observe "+m.Operation)
+			},
+		}},
+	})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"Alice", "data1", "read"}))
+	assert.NoError(t, a.AddPolicies("p", "p", [][]string{{"Bob", "data2", "read"}, {"carol", "other", "read"}}))
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"dave", "other", "read"}))
+	err := a.AddPolicy("g", "g", []string{"alice", "admin"})
+	assert.ErrorIs(t, err, ErrVetoed)
+	assert.ErrorContains(t, err, "roles are read-only")
+	assert.NoError(t, a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}))
+
+	assert.Equal(t, []string{
+		"before AddPolicy", "observe AddPolicy", "after AddPolicy 1 <nil>",
+		"before AddPolicies", "observe AddPolicies", "after AddPolicies 1 <nil>",
+		"before AddPolicy", "observe AddPolicy", "after AddPolicy 0 <nil>",
+		"before AddPolicy",
+		"observe RemovePolicy",
+	}, calls)
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"bob", "data2", "read"}}, m.GetPolicy("p", "p"))
+	assert.Empty(t, m.GetPolicy("g", "g"))
+
+	// a vetoed filtered removal doesn't read the rules it would remove
+	b := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return container },
+		Hooks: []Hook{{Before: func(ctx context.Context, m *Mutation) error {
+			return errors.New("read-only")
+		}}},
+	})
+	assert.ErrorIs(t, b.RemoveFilteredPolicy("p", "p", 0, "bob"), ErrVetoed)
+	assert.Zero(t, b.GetOperationStats()["RemoveFilteredPolicy"].Pages)
+	assert.Zero(t, b.GetOperationStats()["RemoveFilteredPolicy"].Items)
+}
+
+func TestRequestContext(t *testing.T) {
+	containers := map[string]*mapContainer{}
+	type userKey struct{}
+	var hookUsers []any
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName:      "casbin_rule",
+		AuditContainerName: "casbin_audit",
+		Actor:              "service",
+		EventSourcing:      true,
+		NewContainer: func(name string) Container {
+			containers[name] = newMapContainer()
+			return canceledReadsContainer{containers[name]}
+		},
+		Hooks: []Hook{{Before: func(ctx context.Context, m *Mutation) error {
+			hookUsers = append(hookUsers, ctx.Value(userKey{}))
+			return nil
+		}}},
+	})
+	ctx := context.WithValue(context.Background(), userKey{}, "alice")
+	ctx = WithCorrelationID(WithActor(ctx, "alice"), "request-1")
+	var view persist.BatchAdapter = a.WithRequestContext(ctx)
+	assert.NoError(t, view.AddPolicy("p", "p", []string{"bob", "data1", "read"}))
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"carol", "data2", "read"}))
+	assert.Equal(t, []any{"alice", nil}, hookUsers)
+
+	records := map[string]AuditRecord{}
+	for _, partition := range containers["casbin_audit"].items {
+		for _, item := range partition {
+			var record AuditRecord
+			assert.NoError(t, json.Unmarshal(item, &record))
+			records[record.Rule[0]] = record
+		}
+	}
+	assert.Equal(t, "alice", records["bob"].Actor)
+	assert.Equal(t, "request-1", records["bob"].CorrelationID)
+	assert.Equal(t, "service", records["carol"].Actor)
+	assert.NotEqual(t, "request-1", records["carol"].CorrelationID)
+
+	// the operations are canceled with the request
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, a.WithRequestContext(canceled).LoadPolicy(m), context.Canceled)
+	assert.ErrorIs(t, a.WithRequestContext(canceled).LoadIncrementalFilteredPolicy(m, SqlQuerySpec{Query: "SELECT * FROM c"}), context.Canceled)
+	assert.ErrorIs(t, a.WithRequestContext(canceled).ReplayEvents(m, time.Time{}), context.Canceled)
+	_, err = a.WithRequestContext(canceled).NeedsReload()
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = a.WithRequestContext(canceled).GetPolicyVersion()
+	assert.ErrorIs(t, err, context.Canceled)
+
+	assert.NoError(t, view.(*RequestAdapter).LoadIncrementalFilteredPolicy(m, SqlQuerySpec{Query: "SELECT * FROM c"}))
+	assert.ElementsMatch(t, [][]string{{"bob", "data1", "read"}, {"carol", "data2", "read"}}, m.GetPolicy("p", "p"))
+	reload, err := view.(*RequestAdapter).NeedsReload()
+	assert.NoError(t, err)
+	assert.False(t, reload)
+}
+
+// canceledReadsContainer is a mapContainer whose point reads fail once their
+// context is done.
+type canceledReadsContainer struct {
+	*mapContainer
+}
+
+func (c canceledReadsContainer) ReadItem(ctx context.Context, pk azcosmos.PartitionKey, id string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.mapContainer.ReadItem(ctx, pk, id, o)
+}
+
+// stuckWritesContainer is a mapContainer whose writes hang until their context
+// is done.
+type stuckWritesContainer struct {
+	*mapContainer
+}
+
+func (c stuckWritesContainer) CreateItem(ctx context.Context, pk azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	<-ctx.Done()
+	return azcosmos.ItemResponse{}, ctx.Err()
+}
+
+// unavailableContainer fails every read with a status, as during a regional
+// outage.
+type unavailableContainer struct {
+	*mapContainer
+	status int
+}
+
+func (c unavailableContainer) ReadItem(ctx context.Context, pk azcosmos.PartitionKey, id string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return azcosmos.ItemResponse{}, &azcore.ResponseError{StatusCode: c.status}
+}
+
+func TestSecondaryRegion(t *testing.T) {
+	secondary := newMapContainer()
+	seed := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return secondary }})
+	assert.NoError(t, seed.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+
+	newAdapter := func(status int) *Adapter {
+		a := NewAdapterFromClient(nil, Options{
+			ContainerName: "casbin_rule",
+			NewContainer:  func(name string) Container { return unavailableContainer{newMapContainer(), status} },
+		})
+		a.secondary, a.secondaryRegion = map[string]Container{"casbin_rule": secondary}, "West US 2"
+		return a
+	}
+	a := newAdapter(http.StatusServiceUnavailable)
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+	assert.Equal(t, int64(1), a.version.Load())
+
+	// errors that are not regional are returned
+	m.ClearPolicy()
+	assert.True(t, isStatus(newAdapter(http.StatusForbidden).LoadPolicy(m), http.StatusForbidden))
+	assert.Empty(t, m.GetPolicy("p", "p"))
+
+	b := NewAdapterFromConnectionString("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";",
+		Options{ContainerName: "casbin_rule", GroupingContainerName: "casbin_rule_g", SkipAutoCreate: true, SecondaryRegion: "West US 2"})
+	assert.Len(t, b.secondary, 2)
+	assert.Equal(t, "casbin_rule_g", b.secondary["casbin_rule_g"].(*azcosmos.ContainerClient).ID())
+
+	assert.True(t, regionalFailure(context.Background(), &net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.True(t, regionalFailure(context.Background(), &azcore.ResponseError{StatusCode: http.StatusRequestTimeout}))
+	assert.False(t, regionalFailure(context.Background(), &azcore.ResponseError{StatusCode: http.StatusNotFound}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, regionalFailure(ctx, &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable}))
+}
+
+func TestDataPlaneOnly(t *testing.T) {
+	cosmos := &fakeCosmos{}
+	options := Options{DatabaseName: "casbin", ContainerName: "casbin_rule", DataPlaneOnly: true}
+	options.Retry = policy.RetryOptions{MaxRetries: -1}
+	options.Transport = cosmos
+	a := NewAdapterFromConnectionString("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";", options)
+	assert.Empty(t, cosmos.requests)
+
+	assert.NoError(t, a.Ping(context.Background()))
+	cosmos.substatus = "1003"
+	assert.ErrorIs(t, a.Ping(context.Background()), ErrContainerNotFound)
+	cosmos.substatus = ""
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.SavePolicy(m))
+	assert.NotEmpty(t, cosmos.requests)
+	for _, request := range cosmos.requests {
+		// only the documents are read and written
+		assert.Contains(t, request, "dbs/casbin/colls/casbin_rule/docs")
+	}
+	assert.Error(t, a.ScaleThroughput(context.Background(), 1000))
+}
+
+type staticToken string
+
+func (t staticToken) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: string(t), ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestCredentialRotation(t *testing.T) {
+	accountKey, token := []byte("key"), "token1"
+	cosmos := &fakeCosmos{authorize: func(req *http.Request) bool {
+		resourceType, resourceLink := signedResource(req.URL.Path)
+		authorization := req.Header.Get("authorization")
+		return authorization == masterKeyAuthorization(accountKey, req.Method, resourceType, resourceLink, req.Header.Get("x-ms-date")) ||
+			authorization == "type=aad&ver=1.0&sig="+token
+	}}
+	options := Options{DatabaseName: "casbin", ContainerName: "casbin_rule", DataPlaneOnly: true}
+	options.Retry = policy.RetryOptions{MaxRetries: -1}
+	options.Transport = cosmos
+	rotate := func(key string, newToken string) {
+		cosmos.mu.Lock()
+		defer cosmos.mu.Unlock()
+		accountKey, token = []byte(key), newToken
+	}
+	connectionString := "AccountEndpoint=https://localhost:8081/;AccountKey=" + base64.StdEncoding.EncodeToString([]byte("key")) + ";"
+
+	a := NewAdapterFromConnectionString(connectionString, options)
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	rotate("key2", "")
+	assert.Error(t, a.AddPolicy("p", "p", []string{"bob", "data2", "read"}))
+	assert.Error(t, a.SetAccountKey("not base64"))
+	assert.NoError(t, a.SetAccountKey(base64.StdEncoding.EncodeToString([]byte("key2"))))
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"bob", "data2", "read"}))
+	assert.Error(t, a.SetCredential(staticToken("token1")))
+
+	// the key is fetched again once rejected
+	fetches := 0
+	withSource := options
+	withSource.AccountKeySource = func(ctx context.Context) (string, error) {
+		fetches++
+		return base64.StdEncoding.EncodeToString([]byte("key2")), nil
+	}
+	b := NewAdapterFromConnectionString(connectionString, withSource)
+	assert.NoError(t, b.AddPolicy("p", "p", []string{"carol", "data3", "read"}))
+	assert.NoError(t, b.AddPolicy("p", "p", []string{"dave", "data4", "read"}))
+	assert.Equal(t, 1, fetches)
+
+	rotate("", "token1")
+	c := NewAdapterFromCredential("https://localhost:8081/", staticToken("token1"), options)
+	assert.NoError(t, c.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	rotate("", "token2")
+	assert.Error(t, c.AddPolicy("p", "p", []string{"bob", "data2", "read"}))
+	assert.NoError(t, c.SetCredential(staticToken("token2")))
+	assert.NoError(t, c.AddPolicy("p", "p", []string{"bob", "data2", "read"}))
+	assert.Error(t, c.SetAccountKey(base64.StdEncoding.EncodeToString([]byte("key2"))))
+}
+
+func TestChangeEvents(t *testing.T) {
+	a := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", Namespace: "app", Domains: true, NewContainer: func(name string) Container { return newMapContainer() }})
+	events, err := a.changeEvents(context.Background(), [][]byte{
+		[]byte(`{"id":"1","pType":"p","v0":"alice","v1":"domain1","v2":"data1","v3":"read","namespace":"app","_ts":1700000000}`),
+		[]byte(`{"id":"2","pType":"g","v0":"bob","v1":"admin","v2":"domain1","namespace":"app","deleted":true,"_ts":1700000001}`),
+		[]byte(`{"id":"3","pType":"p","v0":"carol","namespace":"other"}`),
+		[]byte(`{"id":"app:policy_version","pType":"policy_version","version":3}`),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []ChangeEvent{
+		{Op: EventAdd, PType: "p", Rule: []string{"alice", "domain1", "data1", "read"}, Domain: "domain1", Time: time.Unix(1700000000, 0)},
+		{Op: EventRemove, PType: "g", Rule: []string{"bob", "admin", "domain1"}, Domain: "domain1", Time: time.Unix(1700000001, 0)},
+	}, events)
+
+	_, err = a.changeEvents(context.Background(), [][]byte{[]byte(`{"id":"4"`)})
+	assert.ErrorIs(t, err, ErrMalformedDocument)
+
+	// the generation switch of a SavePolicy is a clear
+	g := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", GenerationalSave: true, NewContainer: func(name string) Container { return newMapContainer() }})
+	events, err = g.changeEvents(context.Background(), [][]byte{
+		[]byte(`{"id":"p1:g1","pType":"p","v0":"alice","generation":1}`),
+		[]byte(`{"id":"policy_version","pType":"policy_version","version":2,"generation":1,"_ts":1700000002}`),
+		[]byte(`{"id":"p2:g1","pType":"p","v0":"bob","generation":1}`),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []ChangeEvent{
+		{Op: EventClear, Time: time.Unix(1700000002, 0)},
+		{Op: EventAdd, PType: "p", Rule: []string{"bob"}, Time: time.Unix(0, 0)},
+	}, events)
+
+	for _, err := range a.ChangeStream(context.Background(), ChangeStreamOptions{}) {
+		assert.Error(t, err)
+	}
+}
+
+func TestOperationKindTimeouts(t *testing.T) {
+	container := newMapContainer()
+	seed := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }})
+	assert.NoError(t, seed.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return stuckWritesContainer{container} },
+		WriteTimeout:  50 * time.Millisecond,
+	})
+	start := time.Now()
+	assert.ErrorIs(t, a.AddPolicy("p", "p", []string{"bob", "data2", "read"}), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	// loads are not bounded by WriteTimeout
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, a.LoadPolicy(m))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, m.GetPolicy("p", "p"))
+
+	// a deadline of the caller is kept
+	b := NewAdapterFromClient(nil, Options{
+		ContainerName: "casbin_rule",
+		NewContainer:  func(name string) Container { return stuckWritesContainer{container} },
+		WriteTimeout:  time.Hour,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.ErrorIs(t, b.WithRequestContext(ctx).AddPolicy("p", "p", []string{"bob", "data2", "read"}), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	assert.Equal(t, time.Duration(0), operationTimeouts{load: time.Second}.of("Ping"))
+	assert.Equal(t, time.Minute, operationTimeouts{bulk: time.Minute}.of("SavePolicy"))
+	assert.Equal(t, time.Second, operationTimeouts{load: time.Second}.of("GetPolicyVersion"))
+}
+
+func TestConstructorOptions(t *testing.T) {
+	defaults := newOptions(nil)
+	assert.Equal(t, "casbin", defaults.DatabaseName)
+	assert.Equal(t, "casbin_rule", defaults.ContainerName)
+
+	options := newOptions([]Option{Options{Tombstones: true, DatabaseName: "rules"}, Collection("policies")})
+	assert.True(t, options.Tombstones)
+	assert.Equal(t, "rules", options.DatabaseName)
+	assert.Equal(t, "policies", options.ContainerName)
+	// an Options value replaces the options given before it
+	options = newOptions([]Option{Database("rules"), Options{Tombstones: true}})
+	assert.Equal(t, "casbin", options.DatabaseName)
+
+	connectionString := "AccountEndpoint=https://localhost:8081/;AccountKey=" + base64.StdEncoding.EncodeToString([]byte("key")) + ";"
+	a := NewAdapterFromConnectionString(connectionString, Options{SkipAutoCreate: true}, Database("rules"))
+	assert.Equal(t, "rules", a.DatabaseClient().ID())
+	assert.Equal(t, "casbin_rule", a.ContainerClient().ID())
+	assert.NotNil(t, a.rest)
+}
+
+func TestCapabilities(t *testing.T) {
+	a := NewAdapterFromClient(nil, Options{
+		ContainerName:      "casbin_rule",
+		NewContainer:       func(name string) Container { return newMapContainer() },
+		Tombstones:         true,
+		AuditContainerName: "casbin_audit",
+	})
+	assert.Equal(t, Capabilities{
+		Batch:      true,
+		Filtered:   true,
+		Updatable:  true,
+		Context:    true,
+		Watcher:    true,
+		SoftDelete: true,
+		Audit:      true,
+	}, a.Capabilities())
+
+	connectionString := "AccountEndpoint=https://localhost:8081/;AccountKey=" + base64.StdEncoding.EncodeToString([]byte("key")) + ";"
+	b := NewAdapterFromConnectionString(connectionString, Options{SkipAutoCreate: true, RuleExpiry: true})
+	assert.True(t, b.Capabilities().ChangeFeed)
+	assert.True(t, b.Capabilities().Expiry)
+	dry := NewAdapterFromConnectionString(connectionString, Options{SkipAutoCreate: true, DryRun: true})
+	assert.False(t, dry.Capabilities().ChangeFeed)
+	assert.True(t, dry.Capabilities().DryRun)
+}
+
+func TestBurstThroughput(t *testing.T) {
+	// the offers of the rules container and of the database the grouping
+	// container shares, by resource ID
+	var mu sync.Mutex
+	offers := map[string]string{
+		"collP": `{"offerThroughput":400}`,
+		"db":    `{"offerAutopilotSettings":{"maxThroughput":4000}}`,
+	}
+	offer := func(rid string) string {
+		return fmt.Sprintf(`{"id":"%s","_rid":"%s","_self":"offers/%s/","offerResourceId":"%s","offerType":"Invalid","offerVersion":"V2","content":%s}`, rid, rid, rid, rid, offers[rid])
+	}
+	options := Options{DatabaseName: "casbin", ContainerName: "casbin_rule", GroupingContainerName: "casbin_rule_g", SkipAutoCreate: true}
+	options.Retry = policy.RetryOptions{MaxRetries: -1}
+	options.Transport = transportFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		respond := func(status int, body string) (*http.Response, error) {
+			return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+		}
+		path := strings.Trim(req.URL.Path, "/")
+		switch {
+		case path == "":
+			return respond(http.StatusOK, `{"id":"account","writableLocations":[],"readableLocations":[]}`)
+		case path == "dbs/casbin":
+			return respond(http.StatusOK, `{"id":"casbin","_rid":"db"}`)
+		case path == "dbs/casbin/colls/casbin_rule":
+			return respond(http.StatusOK, `{"id":"casbin_rule","_rid":"collP"}`)
+		case path == "dbs/casbin/colls/casbin_rule_g":
+			return respond(http.StatusOK, `{"id":"casbin_rule_g","_rid":"collG"}`)
+		case path == "offers" && req.Method == http.MethodPost:
+			body, _ := io.ReadAll(req.Body)
+			for rid := range offers {
+				if strings.Contains(string(body), "'"+rid+"'") {
+					return respond(http.StatusOK, `{"Offers":[`+offer(rid)+`]}`)
+				}
+			}
+			return respond(http.StatusOK, `{"Offers":[]}`)
+		case strings.HasPrefix(path, "offers/"):
+			rid := strings.TrimPrefix(path, "offers/")
+			if req.Method == http.MethodPut {
+				var replaced struct{ Content json.RawMessage }
+				if err := json.NewDecoder(req.Body).Decode(&replaced); err != nil {
+					return nil, err
+				}
+				offers[rid] = string(replaced.Content)
+			}
+			return respond(http.StatusOK, offer(rid))
+		}
+		return respond(http.StatusNotFound, `{}`)
+	})
+	a := NewAdapterFromConnectionString("AccountEndpoint=https://localhost:8081/;AccountKey="+base64.StdEncoding.EncodeToString([]byte("key"))+";", options)
+	ctx := context.Background()
+
+	restore, err := a.BurstThroughput(ctx, 10000)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"offerThroughput":10000}`, offers["collP"])
+	assert.JSONEq(t, `{"offerAutopilotSettings":{"maxThroughput":10000}}`, offers["db"])
+	assert.NoError(t, restore(ctx))
+	assert.JSONEq(t, `{"offerThroughput":400}`, offers["collP"])
+	assert.JSONEq(t, `{"offerAutopilotSettings":{"maxThroughput":4000}}`, offers["db"])
+
+	// only the resources below the target are raised
+	restore, err = a.BurstThroughput(ctx, 1000)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"offerThroughput":1000}`, offers["collP"])
+	assert.JSONEq(t, `{"offerAutopilotSettings":{"maxThroughput":4000}}`, offers["db"])
+	assert.NoError(t, restore(ctx))
+	assert.JSONEq(t, `{"offerThroughput":400}`, offers["collP"])
+
+	assert.NoError(t, a.ScaleThroughput(ctx, 1000))
+	assert.JSONEq(t, `{"offerThroughput":1000}`, offers["collP"])
+	assert.JSONEq(t, `{"offerAutopilotSettings":{"maxThroughput":1000}}`, offers["db"])
+
+	// custom containers have no throughput to scale, and imports fail before writing
+	container := newMapContainer()
+	b := NewAdapterFromClient(nil, Options{ContainerName: "casbin_rule", NewContainer: func(name string) Container { return container }})
+	assert.Error(t, b.ScaleThroughput(ctx, 1000))
+	_, err = b.ImportCSV(ctx, strings.NewReader("p, alice, data1, read\n"), ImportOptions{BurstThroughput: 1000})
+	assert.Error(t, err)
+	assert.Empty(t, container.items[fmt.Sprint(azcosmos.NewPartitionKeyString("p"))])
+}
@@ -0,0 +1,255 @@
+// Package appinsights reports the Cosmos calls made by the adapter to Azure
+// Application Insights as dependency telemetry.
+//
+// It talks to the Application Insights ingestion endpoint directly, so using
+// it adds no dependency to the adapter:
+//
+//	exporter, err := appinsights.NewExporter(connectionString, appinsights.ExporterOptions{RoleName: "authz"})
+//	options.OnOperation = exporter.OnOperation
+//	a := cosmosadapter.NewAdapterFromConnectionString(cosmosConnectionString, options)
+//	defer exporter.Close()
+package appinsights
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cosmosadapter "github.com/rickdana/cosmos-casbin-adapter"
+)
+
+const defaultIngestionEndpoint = "https://dc.services.visualstudio.com"
+
+// ExporterOptions configures an Exporter.
+type ExporterOptions struct {
+	// Target is reported as the dependency target, typically the host of the
+	// Cosmos account.
+	Target string
+	// RoleName is reported as the cloud role of the application.
+	RoleName string
+	// FlushInterval is the time between two uploads. Defaults to ten seconds.
+	FlushInterval time.Duration
+	// MaxBatchSize triggers an upload as soon as this many operations are
+	// waiting. Defaults to 500.
+	MaxBatchSize int
+	// HTTPClient is used for uploads. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// OnError, if set, is called with every failed upload.
+	OnError func(error)
+}
+
+// Exporter batches adapter operations and uploads them to Application Insights
+// as remote dependency telemetry.
+type Exporter struct {
+	endpoint           string
+	instrumentationKey string
+	options            ExporterOptions
+
+	mu      sync.Mutex
+	pending []envelope
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewExporter creates an exporter for the Application Insights connection
+// string and starts uploading in the background until Close is called.
+func NewExporter(connectionString string, options ExporterOptions) (*Exporter, error) {
+	e := &Exporter{
+		endpoint: defaultIngestionEndpoint,
+		options:  options,
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, part := range strings.Split(connectionString, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "instrumentationkey":
+			e.instrumentationKey = value
+		case "ingestionendpoint":
+			e.endpoint = strings.TrimSuffix(value, "/")
+		}
+	}
+	if e.instrumentationKey == "" {
+		return nil, errors.New("connection string has no InstrumentationKey")
+	}
+	if e.options.FlushInterval <= 0 {
+		e.options.FlushInterval = 10 * time.Second
+	}
+	if e.options.MaxBatchSize <= 0 {
+		e.options.MaxBatchSize = 500
+	}
+	if e.options.HTTPClient == nil {
+		e.options.HTTPClient = http.DefaultClient
+	}
+
+	go e.run()
+	return e, nil
+}
+
+// OnOperation queues the operation for upload. Use it as the
+// cosmosadapter.Options.OnOperation hook.
+func (e *Exporter) OnOperation(info cosmosadapter.OperationInfo) {
+	env := e.newEnvelope(info)
+
+	e.mu.Lock()
+	e.pending = append(e.pending, env)
+	full := len(e.pending) >= e.options.MaxBatchSize
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush uploads the queued operations.
+func (e *Exporter) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	pending := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, env := range pending {
+		if err := encoder.Encode(env); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/v2/track", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-json-stream")
+	res, err := e.options.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("uploading %d operations to application insights: %s", len(pending), res.Status)
+	}
+	return nil
+}
+
+// Close stops the exporter after uploading the queued operations.
+func (e *Exporter) Close() error {
+	e.once.Do(func() {
+		close(e.stop)
+	})
+	<-e.done
+	return e.Flush(context.Background())
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.options.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		case <-e.flush:
+		}
+		if err := e.Flush(context.Background()); err != nil && e.options.OnError != nil {
+			e.options.OnError(err)
+		}
+	}
+}
+
+// envelope is an Application Insights telemetry item.
+type envelope struct {
+	Name string            `json:"name"`
+	Time string            `json:"time"`
+	IKey string            `json:"iKey"`
+	Tags map[string]string `json:"tags,omitempty"`
+	Data envelopeData      `json:"data"`
+}
+
+type envelopeData struct {
+	BaseType string         `json:"baseType"`
+	BaseData dependencyData `json:"baseData"`
+}
+
+// dependencyData is the RemoteDependencyData telemetry schema.
+type dependencyData struct {
+	Ver        int               `json:"ver"`
+	Name       string            `json:"name"`
+	ID         string            `json:"id,omitempty"`
+	ResultCode string            `json:"resultCode"`
+	Duration   string            `json:"duration"`
+	Success    bool              `json:"success"`
+	Target     string            `json:"target,omitempty"`
+	Type       string            `json:"type"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+func (e *Exporter) newEnvelope(info cosmosadapter.OperationInfo) envelope {
+	properties := map[string]string{
+		"requestCharge": strconv.FormatFloat(info.RequestCharge, 'f', -1, 64),
+		"retries":       strconv.Itoa(info.Retries),
+	}
+	if info.ActivityID != "" {
+		properties["activityId"] = info.ActivityID
+	}
+	if info.Err != nil {
+		properties["error"] = info.Err.Error()
+	}
+
+	tags := map[string]string{}
+	if e.options.RoleName != "" {
+		tags["ai.cloud.role"] = e.options.RoleName
+	}
+
+	return envelope{
+		Name: "Microsoft.ApplicationInsights." + strings.ReplaceAll(e.instrumentationKey, "-", "") + ".RemoteDependency",
+		Time: time.Now().Add(-info.Duration).UTC().Format(time.RFC3339Nano),
+		IKey: e.instrumentationKey,
+		Tags: tags,
+		Data: envelopeData{
+			BaseType: "RemoteDependencyData",
+			BaseData: dependencyData{
+				Ver:        2,
+				Name:       info.Name,
+				ID:         info.ActivityID,
+				ResultCode: strconv.Itoa(info.StatusCode),
+				Duration:   formatDuration(info.Duration),
+				Success:    info.Err == nil,
+				Target:     e.options.Target,
+				Type:       "Azure DocumentDB",
+				Properties: properties,
+			},
+		},
+	}
+}
+
+// formatDuration formats d as d.hh:mm:ss.fffffff, the format of the
+// Application Insights schema.
+func formatDuration(d time.Duration) string {
+	ticks := d.Nanoseconds() / 100
+	return fmt.Sprintf("%d.%02d:%02d:%02d.%07d",
+		ticks/(24*36000000000),
+		ticks/36000000000%24,
+		ticks/600000000%60,
+		ticks/10000000%60,
+		ticks%10000000)
+}
@@ -0,0 +1,53 @@
+package appinsights
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cosmosadapter "github.com/rickdana/cosmos-casbin-adapter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExporter(t *testing.T) {
+	var received []envelope
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/track", r.URL.Path)
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var env envelope
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &env))
+			received = append(received, env)
+		}
+	}))
+	defer server.Close()
+
+	e, err := NewExporter("InstrumentationKey=0000-1111;IngestionEndpoint="+server.URL+"/", ExporterOptions{Target: "account.documents.azure.com", FlushInterval: time.Hour})
+	assert.NoError(t, err)
+	e.OnOperation(cosmosadapter.OperationInfo{Name: "LoadPolicy", Duration: 1500 * time.Millisecond, StatusCode: 200, RequestCharge: 2.5, ActivityID: "activity"})
+	e.OnOperation(cosmosadapter.OperationInfo{Name: "AddPolicy", StatusCode: 429, Err: errors.New("throttled")})
+	assert.NoError(t, e.Flush(context.Background()))
+	assert.NoError(t, e.Close())
+
+	if assert.Len(t, received, 2) {
+		data := received[0].Data.BaseData
+		assert.Equal(t, "Microsoft.ApplicationInsights.00001111.RemoteDependency", received[0].Name)
+		assert.Equal(t, "LoadPolicy", data.Name)
+		assert.Equal(t, "activity", data.ID)
+		assert.Equal(t, "0.00:00:01.5000000", data.Duration)
+		assert.Equal(t, "2.5", data.Properties["requestCharge"])
+		assert.True(t, data.Success)
+		assert.False(t, received[1].Data.BaseData.Success)
+		assert.Equal(t, "429", received[1].Data.BaseData.ResultCode)
+	}
+}
+
+func TestNewExporterRequiresKey(t *testing.T) {
+	_, err := NewExporter("IngestionEndpoint=https://example.com", ExporterOptions{})
+	assert.Error(t, err)
+}
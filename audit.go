@@ -0,0 +1,136 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// AuditRecord is an append-only record of a policy mutation, written to the
+// audit container when Options.AuditContainerName is set. Unlike the rules,
+// records are never removed by the adapter, so the history survives hard
+// deletes and SavePolicy.
+type AuditRecord struct {
+	ID string `json:"id"`
+	// Operation is the adapter operation, such as "AddPolicy" or "SavePolicy".
+	Operation string `json:"operation"`
+	// Op is EventAdd, EventRemove or EventClear.
+	Op    string   `json:"op"`
+	PType string   `json:"pType"`
+	Rule  []string `json:"rule,omitempty"`
+	Actor string   `json:"actor,omitempty"`
+	// Time is the time of the mutation in nanoseconds since the epoch.
+	Time int64 `json:"time"`
+	// CorrelationID is shared by the records of an adapter operation, such as
+	// the rules written by one SavePolicy, see Options.AuditCorrelationID.
+	CorrelationID string `json:"correlationId"`
+	Namespace     string `json:"namespace,omitempty"`
+	// Seq, PrevHash and Hash chain the record to the previous one with
+	// Options.AuditChain, see VerifyAuditChain. Seq is the position of the
+	// record in the chain, from 1.
+	Seq      int64  `json:"seq,omitempty"`
+	PrevHash string `json:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// createAuditContainerIfNotExist creates the audit container, partitioned by
+// correlation ID so the records of an operation are read together.
+func (a *Adapter) createAuditContainerIfNotExist(ctx context.Context, name string) error {
+	if _, err := a.db.CreateContainer(ctx, partitionedContainer(name, auditPartitionKeyPath), nil); err == nil {
+		a.logger.Info("created cosmos audit container", "database", a.databaseName, "container", name)
+	} else if !isStatus(err, http.StatusConflict) {
+		return fmt.Errorf("creating cosmos audit container: %w", err)
+	}
+	return nil
+}
+
+// correlationID returns the correlation ID of a new operation.
+func (a *Adapter) correlationID() string {
+	if a.auditCorrelationID != nil {
+		if id := a.auditCorrelationID(); id != "" {
+			return id
+		}
+	}
+	return a.newID()
+}
+
+// appendAudit writes an audit record for every event, when auditing is enabled.
+func (a *Adapter) appendAudit(ctx context.Context, events ...PolicyEvent) error {
+	if a.auditClient == nil {
+		return nil
+	}
+	op := operationFrom(ctx)
+	for _, event := range events {
+		record := AuditRecord{
+			ID:        a.newID(),
+			Op:        event.Op,
+			PType:     event.PType,
+			Rule:      event.Rule,
+			Actor:     event.Actor,
+			Time:      event.Time,
+			Namespace: event.Namespace,
+		}
+		if op != nil {
+			record.Operation = op.name
+			record.CorrelationID = op.correlationID
+		}
+		if record.CorrelationID == "" {
+			record.CorrelationID = a.correlationID()
+		}
+		if a.auditChain != nil {
+			if err := a.appendAuditChain(ctx, record); err != nil {
+				return err
+			}
+			continue
+		}
+		marshalled, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		res, err := a.auditClient.CreateItem(ctx, azcosmos.NewPartitionKeyString(record.CorrelationID), marshalled, nil)
+		if err != nil {
+			return err
+		}
+		op.record(res.Response, 0)
+	}
+	return nil
+}
+
+// GetAuditRecords returns the audit records written in [since, until), oldest
+// first. A zero since or until means no bound. It is a cross partition query.
+func (a *Adapter) GetAuditRecords(ctx context.Context, since time.Time, until time.Time) ([]AuditRecord, error) {
+	if a.auditClient == nil {
+		return nil, fmt.Errorf("auditing is not enabled")
+	}
+	query, parameters := a.inNamespace(timeRangeQuery(since, until))
+
+	var records []AuditRecord
+	queryPager := a.auditClient.NewQueryItemsPager(query, azcosmos.NewPartitionKey(), &azcosmos.QueryOptions{QueryParameters: parameters})
+	for queryPager.More() {
+		res, err := nextPage(ctx, queryPager)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range res.Items {
+			var record AuditRecord
+			if err := json.Unmarshal(item, &record); err != nil {
+				return nil, err
+			}
+			if record.Op == "" {
+				// a link of the audit chain
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+	// ORDER BY is not supported by cross partition queries through the gateway
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time < records[j].Time
+	})
+	return records, nil
+}
@@ -0,0 +1,254 @@
+package cosmosadapter
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// ErrAuditChainBroken is matched with errors.Is by the *AuditChainError
+// returned by VerifyAuditChain.
+var ErrAuditChainBroken = errors.New("cosmosadapter: audit chain broken")
+
+// AuditChainError is returned by VerifyAuditChain when the audit records
+// don't form an unbroken chain: a record was altered, removed or added other
+// than by the adapter.
+type AuditChainError struct {
+	// Seq is the position in the chain of the first break.
+	Seq int64
+	// RecordID is the ID of the audit record at Seq, if known.
+	RecordID string
+	Reason   string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("cosmosadapter: audit chain broken at %d (record %q): %s", e.Seq, e.RecordID, e.Reason)
+}
+
+func (e *AuditChainError) Is(target error) bool {
+	return target == ErrAuditChainBroken
+}
+
+// AuditChainHead is the last link of the audit chain. Auditors keep it out of
+// Cosmos, so a chain rewritten from start to end is told apart as well: it has
+// another hash at the same Seq.
+type AuditChainHead struct {
+	Seq  int64
+	Hash string
+}
+
+// auditChainLink claims a position of the audit chain for an audit record.
+// The links live in a partition of their own of the audit container, with the
+// position as ID, so concurrent writers conflict on the same position.
+type auditChainLink struct {
+	ID            string `json:"id"`
+	CorrelationID string `json:"correlationId"`
+	Seq           int64  `json:"seq"`
+	RecordID      string `json:"recordId"`
+	Hash          string `json:"hash"`
+}
+
+// auditChain is the last link of the audit chain known to the adapter.
+type auditChain struct {
+	mu    sync.Mutex
+	known bool
+	head  AuditChainHead
+}
+
+// auditChainConflicts is the number of times appending to the audit chain is
+// retried after other writers took the position.
+const auditChainConflicts = 10
+
+// auditChainPartition returns the partition of the links of the audit chain of
+// the namespace.
+func (a *Adapter) auditChainPartition() string {
+	if a.namespace != "" {
+		return "auditchain:" + a.namespace
+	}
+	return "auditchain"
+}
+
+// auditRecordHash returns the hash chaining the record to the previous one: the
+// SHA-256 of the fields of the record and of the hash of the previous record.
+func auditRecordHash(record AuditRecord) (string, error) {
+	rule := record.Rule
+	if len(rule) == 0 {
+		rule = nil
+	}
+	fields, err := json.Marshal([]any{record.Seq, record.PrevHash, record.ID, record.Operation, record.Op, record.PType,
+		rule, record.Actor, record.Time, record.CorrelationID, record.Namespace})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readAuditChainHead reads the last link of the audit chain.
+func (a *Adapter) readAuditChainHead(ctx context.Context) (AuditChainHead, error) {
+	links, err := a.readAuditChainLinks(ctx, "SELECT TOP 1 * FROM c ORDER BY c.seq DESC")
+	if err != nil || len(links) == 0 {
+		return AuditChainHead{}, err
+	}
+	last := links[len(links)-1]
+	return AuditChainHead{Seq: last.Seq, Hash: last.Hash}, nil
+}
+
+// readAuditChainLinks returns the links of the audit chain read by the query,
+// ordered by position.
+func (a *Adapter) readAuditChainLinks(ctx context.Context, query string) ([]auditChainLink, error) {
+	var links []auditChainLink
+	queryPager := a.auditClient.NewQueryItemsPager(query, azcosmos.NewPartitionKeyString(a.auditChainPartition()), nil)
+	for queryPager.More() {
+		res, err := nextPage(ctx, queryPager)
+		if err != nil {
+			return nil, err
+		}
+		operationFrom(ctx).record(res.Response, 0)
+		for _, item := range res.Items {
+			var link auditChainLink
+			if err := json.Unmarshal(item, &link); err != nil {
+				return nil, err
+			}
+			links = append(links, link)
+		}
+	}
+	slices.SortFunc(links, func(x, y auditChainLink) int {
+		return cmp.Compare(x.Seq, y.Seq)
+	})
+	return links, nil
+}
+
+// appendAuditChain writes the record chained to the last one. The record is
+// written before its link, and removed if the link can't be written, so the
+// records of the chain have a link and a record without one was not written by
+// the adapter.
+func (a *Adapter) appendAuditChain(ctx context.Context, record AuditRecord) error {
+	chain := a.auditChain
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	for range auditChainConflicts {
+		if !chain.known {
+			head, err := a.readAuditChainHead(ctx)
+			if err != nil {
+				return err
+			}
+			chain.head, chain.known = head, true
+		}
+		record.Seq, record.PrevHash = chain.head.Seq+1, chain.head.Hash
+		hash, err := auditRecordHash(record)
+		if err != nil {
+			return err
+		}
+		record.Hash = hash
+		marshalled, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		pk := azcosmos.NewPartitionKeyString(record.CorrelationID)
+		res, err := a.auditClient.CreateItem(ctx, pk, marshalled, nil)
+		if err != nil {
+			return err
+		}
+		operationFrom(ctx).record(res.Response, 0)
+
+		link, err := json.Marshal(auditChainLink{
+			ID:            fmt.Sprintf("%020d", record.Seq),
+			CorrelationID: a.auditChainPartition(),
+			Seq:           record.Seq,
+			RecordID:      record.ID,
+			Hash:          record.Hash,
+		})
+		if err == nil {
+			res, err = a.auditClient.CreateItem(ctx, azcosmos.NewPartitionKeyString(a.auditChainPartition()), link, nil)
+		}
+		if err == nil {
+			operationFrom(ctx).record(res.Response, 0)
+			chain.head = AuditChainHead{Seq: record.Seq, Hash: record.Hash}
+			return nil
+		}
+		if _, deleteErr := a.auditClient.DeleteItem(context.WithoutCancel(ctx), pk, record.ID, nil); deleteErr != nil {
+			a.logger.Warn("removing an unchained audit record failed", "id", record.ID, "error", deleteErr)
+		}
+		if !isStatus(err, http.StatusConflict) {
+			return err
+		}
+		// another instance took the position
+		chain.known = false
+	}
+	return errors.New("appending to the audit chain: too many concurrent writers")
+}
+
+// VerifyAuditChain checks that the audit records of the namespace form an
+// unbroken chain, with Options.AuditChain: every record is hashed with the
+// hash of the previous one, so altering, removing or inserting a record breaks
+// the chain from there on. It returns the last link of the chain, to compare
+// with the one kept by the auditors, and an error matching ErrAuditChainBroken
+// at the first break. Records written before Options.AuditChain was enabled
+// are not part of the chain and are not checked.
+func (a *Adapter) VerifyAuditChain(ctx context.Context) (head AuditChainHead, err error) {
+	ctx, op := a.startOperation(ctx, "VerifyAuditChain")
+	defer func() { err = a.endOperation(op, err) }()
+	if a.auditChain == nil {
+		return head, errors.New("the audit chain is not enabled")
+	}
+	links, err := a.readAuditChainLinks(ctx, "SELECT * FROM c")
+	if err != nil {
+		return head, err
+	}
+	records, err := a.GetAuditRecords(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		return head, err
+	}
+	chained := map[string]AuditRecord{}
+	for _, record := range records {
+		if record.Seq != 0 {
+			chained[record.ID] = record
+		}
+	}
+
+	for _, link := range links {
+		broken := func(reason string) error {
+			return &AuditChainError{Seq: head.Seq + 1, RecordID: link.RecordID, Reason: reason}
+		}
+		if link.Seq != head.Seq+1 {
+			return head, broken(fmt.Sprintf("the next link is at %d", link.Seq))
+		}
+		record, ok := chained[link.RecordID]
+		if !ok {
+			return head, broken("the record is missing")
+		}
+		delete(chained, link.RecordID)
+		hash, err := auditRecordHash(record)
+		if err != nil {
+			return head, err
+		}
+		switch {
+		case record.Seq != link.Seq:
+			return head, broken(fmt.Sprintf("the record is at %d", record.Seq))
+		case record.PrevHash != head.Hash:
+			return head, broken("the record is chained to another record")
+		case hash != record.Hash || hash != link.Hash:
+			return head, broken("the record was altered")
+		}
+		head = AuditChainHead{Seq: link.Seq, Hash: link.Hash}
+	}
+	if len(chained) > 0 {
+		unlinked := slices.SortedFunc(maps.Values(chained), func(x, y AuditRecord) int {
+			return cmp.Compare(x.Seq, y.Seq)
+		})
+		return head, &AuditChainError{Seq: unlinked[0].Seq, RecordID: unlinked[0].ID, Reason: "the record has no link"}
+	}
+	return head, nil
+}
@@ -0,0 +1,103 @@
+package cosmosadapter
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// backupAttempts is the number of times Backup reads the policy before giving
+// up when it keeps changing during the read.
+const backupAttempts = 3
+
+// Backup writes the stored documents, as exported by ExportDocuments, to the
+// blob as gzip compressed JSON lines, for backups independent of the point in
+// time restore of Cosmos. The blob is replaced, and labelled with the policy
+// version in its "policyversion" metadata.
+//
+// The backup is consistent: if the policy version changes while the documents
+// are read, they are read again, and the blob is only written once a read saw
+// no change. It returns the number of documents backed up.
+func (a *Adapter) Backup(ctx context.Context, blobURL string, options BlobOptions) (n int, err error) {
+	ctx, op := a.startOperation(ctx, "Backup")
+	defer func() { err = a.endOperation(op, err) }()
+	blob, err := a.newBlobClient(blobURL, options)
+	if err != nil {
+		return 0, err
+	}
+
+	n, _, err = a.backup(ctx, blob)
+	return n, err
+}
+
+// backup writes the stored documents to the blob, returning their number and
+// the policy version they were read at.
+func (a *Adapter) backup(ctx context.Context, blob *blobClient) (int, int64, error) {
+	op := operationFrom(ctx)
+	for attempt := 1; ; attempt++ {
+		before, _, err := readVersion(ctx, a.containerClient, a.versionID())
+		if err != nil {
+			return 0, 0, err
+		}
+		blocks := blob.newBlockWriter(ctx)
+		gz := gzip.NewWriter(blocks)
+		n, err := a.exportDocuments(ctx, gz)
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := gz.Close(); err != nil {
+			return 0, 0, err
+		}
+		if err := blocks.Close(); err != nil {
+			return 0, 0, err
+		}
+		after, _, err := readVersion(ctx, a.containerClient, a.versionID())
+		if err != nil {
+			return 0, 0, err
+		}
+		if before == after {
+			metadata := map[string]string{"policyversion": strconv.FormatInt(after, 10), "documents": strconv.Itoa(n)}
+			return n, after, blocks.commit("application/gzip", metadata)
+		}
+		if attempt == backupAttempts {
+			return 0, 0, fmt.Errorf("the policy changed during each of %d backup attempts", backupAttempts)
+		}
+		// the uploaded blocks are discarded by the service as they are never committed
+		op.retry("policy changed during the backup")
+	}
+}
+
+// RestoreOptions configures Restore.
+type RestoreOptions struct {
+	BlobOptions
+	// ImportOptions selects whether the stored documents missing from the
+	// backup are removed, with ImportReplace, or kept, with ImportMerge, and
+	// configures the batching and the progress callback.
+	ImportOptions
+}
+
+// Restore writes the documents of a backup taken by Backup back, as
+// ImportDocuments does, and bumps the policy version. It returns the number of
+// documents restored.
+func (a *Adapter) Restore(ctx context.Context, blobURL string, options RestoreOptions) (n int, err error) {
+	ctx, op := a.startOperation(ctx, "Restore")
+	defer func() { err = a.endOperation(op, err) }()
+	blob, err := a.newBlobClient(blobURL, options.BlobOptions)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := blob.do(ctx, http.MethodGet, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		return 0, fmt.Errorf("reading backup: %w", err)
+	}
+	defer gz.Close()
+	return a.importDocuments(ctx, gz, options.ImportOptions)
+}
@@ -0,0 +1,234 @@
+package cosmosadapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// blobBlockSize is the size of the blocks blobs are uploaded in.
+const blobBlockSize = 4 << 20
+
+// BlobOptions configures the access to the blob of Backup and Restore.
+type BlobOptions struct {
+	// Credential, if set, authenticates with Microsoft Entra ID, which requires
+	// a Storage Blob Data role on the container. Otherwise the blob URL must
+	// carry a SAS token.
+	Credential azcore.TokenCredential
+}
+
+// blobClient sends requests to a blob of Azure Blob Storage through its REST
+// API, which keeps the storage SDK out of the dependencies.
+type blobClient struct {
+	url        *url.URL
+	credential azcore.TokenCredential
+	transport  policy.Transporter
+}
+
+// newBlobClient returns the client of the blob, sending requests with the
+// transport of the adapter.
+func (a *Adapter) newBlobClient(blobURL string, options BlobOptions) (*blobClient, error) {
+	u, err := url.Parse(blobURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid blob URL %q", blobURL)
+	}
+	c := &blobClient{url: u, credential: options.Credential}
+	if a.rest != nil {
+		c.transport = a.rest.transport
+	}
+	return c, nil
+}
+
+// do sends a request to the blob, with the query parameters added to those of
+// the URL, such as a SAS token.
+func (c *blobClient) do(ctx context.Context, method string, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	u := *c.url
+	values := u.Query()
+	for k, v := range query {
+		values[k] = v
+	}
+	u.RawQuery = values.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", "2021-08-06")
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if c.credential != nil {
+		token, err := c.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{"https://storage.azure.com/.default"}})
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+	}
+
+	var res *http.Response
+	if c.transport != nil {
+		res, err = c.transport.Do(req)
+	} else {
+		res, err = http.DefaultClient.Do(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return nil, &azcore.ResponseError{
+			ErrorCode:   res.Header.Get("x-ms-error-code"),
+			StatusCode:  res.StatusCode,
+			RawResponse: &http.Response{StatusCode: res.StatusCode, Header: res.Header, Body: io.NopCloser(bytes.NewReader(body))},
+		}
+	}
+	return res, nil
+}
+
+// blob returns the client of the blob named name in the directory of the
+// client's URL, which must end with a slash or be the URL of a container.
+func (c *blobClient) blob(name string) *blobClient {
+	u := *c.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + name
+	u.RawPath = ""
+	return &blobClient{url: &u, credential: c.credential, transport: c.transport}
+}
+
+// listBlobs returns the names of the blobs in the directory of the client's
+// URL, relative to the directory.
+func (c *blobClient) listBlobs(ctx context.Context) ([]string, error) {
+	container, dir, _ := strings.Cut(strings.TrimPrefix(c.url.Path, "/"), "/")
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	u := *c.url
+	u.Path = "/" + container
+	u.RawPath = ""
+	list := &blobClient{url: &u, credential: c.credential, transport: c.transport}
+
+	var names []string
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {dir}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		res, err := list.do(ctx, http.MethodGet, query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Blobs []struct {
+				Name string `xml:"Name"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range page.Blobs {
+			names = append(names, strings.TrimPrefix(blob.Name, dir))
+		}
+		if page.NextMarker == "" {
+			return names, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+// delete deletes the blob. Deleting a blob that does not exist is not an
+// error.
+func (c *blobClient) delete(ctx context.Context) error {
+	res, err := c.do(ctx, http.MethodDelete, nil, nil, nil)
+	if err != nil {
+		if isStatus(err, http.StatusNotFound) {
+			return nil
+		}
+		return err
+	}
+	return res.Body.Close()
+}
+
+// blockWriter uploads what is written to it as uncommitted blocks of the
+// blob. The blob only changes once the blocks are committed.
+type blockWriter struct {
+	ctx    context.Context
+	blob   *blobClient
+	prefix string
+	buf    bytes.Buffer
+	blocks []string
+}
+
+// newBlockWriter returns a blockWriter. Its block IDs start with a random
+// prefix, so the blocks of another upload to the same blob don't collide.
+func (c *blobClient) newBlockWriter(ctx context.Context) *blockWriter {
+	return &blockWriter{ctx: ctx, blob: c, prefix: newEventID()}
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for w.buf.Len() >= blobBlockSize {
+		if err := w.putBlock(w.buf.Next(blobBlockSize)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close uploads the last block.
+func (w *blockWriter) Close() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	return w.putBlock(w.buf.Next(w.buf.Len()))
+}
+
+func (w *blockWriter) putBlock(block []byte) error {
+	// block IDs must have the same length within a blob
+	id := base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%s-%06d", w.prefix, len(w.blocks)))
+	res, err := w.blob.do(w.ctx, http.MethodPut, url.Values{"comp": {"block"}, "blockid": {id}}, nil, block)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	w.blocks = append(w.blocks, id)
+	return nil
+}
+
+// commit replaces the blob with the uploaded blocks, setting its content type
+// and metadata.
+func (w *blockWriter) commit(contentType string, metadata map[string]string) error {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for _, id := range w.blocks {
+		body.WriteString("<Latest>" + id + "</Latest>")
+	}
+	body.WriteString("</BlockList>")
+
+	headers := map[string]string{"x-ms-blob-content-type": contentType}
+	for k, v := range metadata {
+		headers["x-ms-meta-"+k] = v
+	}
+	res, err := w.blob.do(w.ctx, http.MethodPut, url.Values{"comp": {"blocklist"}}, headers, []byte(body.String()))
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
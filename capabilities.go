@@ -0,0 +1,68 @@
+package cosmosadapter
+
+import "github.com/casbin/casbin/v2/persist"
+
+// Capabilities reports the optional casbin interfaces an adapter implements
+// and the features its options enable, see Adapter.Capabilities.
+type Capabilities struct {
+	// Batch, Filtered and Updatable report whether the adapter implements
+	// persist.BatchAdapter, persist.FilteredAdapter and
+	// persist.UpdatableAdapter.
+	Batch     bool
+	Filtered  bool
+	Updatable bool
+	// Context reports whether the operations can run with the context of a
+	// request, see WithRequestContext.
+	Context bool
+	// Watcher reports whether a PollingWatcher can notify the changes of the
+	// policy, and ChangeFeed whether a ChangeFeedProcessor and ChangeStream
+	// can, which need the azcosmos clients: not with Options.NewContainer or
+	// Options.DryRun.
+	Watcher    bool
+	ChangeFeed bool
+	// SoftDelete reports whether removed rules are kept as tombstones, see
+	// Options.Tombstones, so LoadPolicyDelta sees the removals.
+	SoftDelete bool
+	// Expiry reports whether rules can be added with an expiry, see
+	// Options.RuleExpiry.
+	Expiry bool
+	// Domains reports whether the rules have domains, see Options.Domains.
+	Domains bool
+	// Generational reports whether SavePolicy writes a new generation of the
+	// rules, see Options.GenerationalSave.
+	Generational bool
+	// Audit, EventSourcing and Snapshots report whether the mutations are
+	// recorded in an audit container, an events container and a snapshots
+	// container.
+	Audit         bool
+	EventSourcing bool
+	Snapshots     bool
+	// DryRun reports whether the writes are skipped, see Options.DryRun.
+	DryRun bool
+}
+
+// Capabilities reports the optional interfaces the adapter implements and the
+// features its options enable, so frameworks wrapping several adapters can
+// adapt their behavior at runtime instead of asserting on the adapter type.
+func (a *Adapter) Capabilities() Capabilities {
+	var adapter any = a
+	_, batch := adapter.(persist.BatchAdapter)
+	_, filtered := adapter.(persist.FilteredAdapter)
+	_, updatable := adapter.(persist.UpdatableAdapter)
+	return Capabilities{
+		Batch:         batch,
+		Filtered:      filtered,
+		Updatable:     updatable,
+		Context:       true,
+		Watcher:       true,
+		ChangeFeed:    a.db != nil && cosmosContainer(a.containerClient) != nil,
+		SoftDelete:    a.tombstones,
+		Expiry:        a.ruleExpiry,
+		Domains:       a.domains,
+		Generational:  a.generational,
+		Audit:         a.auditClient != nil,
+		EventSourcing: a.eventsClient != nil,
+		Snapshots:     a.snapshotClient != nil,
+		DryRun:        a.dryRun,
+	}
+}
@@ -0,0 +1,524 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// ChangeFeedProcessorOptions configures a ChangeFeedProcessor.
+type ChangeFeedProcessorOptions struct {
+	// LeaseContainerName is the container holding the leases. It is created in
+	// the adapter's database if it does not exist. Defaults to the policy
+	// container name with a "_leases" suffix.
+	LeaseContainerName string
+	// ProcessorName identifies the group of competing consumers. Processors with
+	// the same name share the leases and each change is handled by one of them.
+	// Defaults to the policy container name.
+	ProcessorName string
+	// InstanceName identifies this consumer in the leases it owns. Defaults to
+	// the host name and process id.
+	InstanceName string
+	// PollInterval is the time between two reads of the change feed. Defaults to 5s.
+	PollInterval time.Duration
+	// LeaseExpiration is the time after which a lease that was not renewed can
+	// be taken over by another instance. Defaults to 60s.
+	LeaseExpiration time.Duration
+	// StartFrom is the time from which new leases read the change feed.
+	// Defaults to the time the lease is created.
+	StartFrom *time.Time
+	// Handler, if set, is called with every batch of changed rules before the
+	// update callback. A batch is checkpointed only after the handler returned
+	// nil, so changes are delivered at least once.
+	Handler func(ctx context.Context, changes []CasbinRule) error
+}
+
+// ChangeFeedProcessor reads the change feed of the rules containers and calls
+// the update callback whenever rules are changed. Progress is checkpointed in a
+// lease container, one lease per feed range of every rules container, so several processors with the
+// same ProcessorName split the feed between them and a crashed instance's
+// leases are taken over once they expire.
+//
+// It implements persist.Watcher. Update is a no-op as every write to the
+// container shows up in the change feed. Hard deletes are not part of the
+// change feed; enable Options.Tombstones to observe removals. Malformed
+// documents are skipped and reported to Options.OnSkippedDocument, or logged.
+type ChangeFeedProcessor struct {
+	adapter     *Adapter
+	leaseClient *azcosmos.ContainerClient
+	options     ChangeFeedProcessorOptions
+
+	mu       sync.Mutex
+	callback func(string)
+	leases   map[string]*lease
+
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	untrack func()
+}
+
+var _ persist.Watcher = (*ChangeFeedProcessor)(nil)
+
+// lease is the lease document of one feed range.
+type lease struct {
+	ID    string `json:"id"`
+	Group string `json:"group"`
+	// Container is the rules container of the feed range, empty for the main
+	// container.
+	Container    string      `json:"container,omitempty"`
+	Owner        string      `json:"owner,omitempty"`
+	Expires      int64       `json:"expires,omitempty"`
+	MinInclusive string      `json:"minInclusive"`
+	MaxExclusive string      `json:"maxExclusive"`
+	Continuation string      `json:"continuation,omitempty"`
+	StartFrom    int64       `json:"startFrom,omitempty"`
+	ETag         azcore.ETag `json:"-"`
+}
+
+// NewChangeFeedProcessor creates and starts a change feed processor for the
+// container used by the adapter, until Close, or Close of the adapter, is
+// called. The lease container is created if it does not exist.
+func NewChangeFeedProcessor(a *Adapter, options ChangeFeedProcessorOptions) *ChangeFeedProcessor {
+	if options.LeaseContainerName == "" {
+		options.LeaseContainerName = a.containerName + "_leases"
+	}
+	if options.ProcessorName == "" {
+		options.ProcessorName = a.containerName
+	}
+	if options.InstanceName == "" {
+		host, _ := os.Hostname()
+		options.InstanceName = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = 5 * time.Second
+	}
+	if options.LeaseExpiration <= 0 {
+		options.LeaseExpiration = 60 * time.Second
+	}
+
+	if a.db == nil {
+		panic("ChangeFeedProcessor requires an adapter with an azcosmos client")
+	}
+	leaseClient, err := a.db.NewContainer(options.LeaseContainerName)
+	if err != nil {
+		panic(fmt.Sprintf("Creating lease container client with name %s caused error: %s", options.LeaseContainerName, err.Error()))
+	}
+	properties := azcosmos.ContainerProperties{
+		ID: options.LeaseContainerName,
+		PartitionKeyDefinition: azcosmos.PartitionKeyDefinition{
+			Paths: []string{"/group"},
+		},
+	}
+	// with DataPlaneOnly, the lease container is provisioned with the others
+	if !a.dataPlaneOnly {
+		if _, err := a.db.CreateContainer(context.Background(), properties, nil); err != nil && !isStatus(err, http.StatusConflict) {
+			panic(fmt.Sprintf("Creating cosmos lease container caused error: %s", err.Error()))
+		}
+	}
+
+	p := &ChangeFeedProcessor{
+		adapter:     a,
+		leaseClient: leaseClient,
+		options:     options,
+		leases:      make(map[string]*lease),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	p.untrack = a.lifecycle.track(p.Close)
+	go p.run()
+	return p
+}
+
+// SetUpdateCallback sets the callback called after a batch of changes was read.
+// The callback receives the policy types and domains of the changed rules
+// encoded as JSON, see ParsePolicyChange.
+func (p *ChangeFeedProcessor) SetUpdateCallback(callback func(string)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.callback = callback
+	return nil
+}
+
+// Update does nothing: changes reach other instances through the change feed.
+func (p *ChangeFeedProcessor) Update() error {
+	return nil
+}
+
+// Close stops the processor and releases the leases it owns, so other
+// instances can take them over without waiting for them to expire.
+func (p *ChangeFeedProcessor) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+		p.untrack()
+	})
+	<-p.done
+}
+
+func (p *ChangeFeedProcessor) run() {
+	defer close(p.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(p.options.PollInterval)
+	defer ticker.Stop()
+
+	initialized := false
+	for {
+		if !initialized {
+			initialized = p.createLeases(ctx) == nil
+		}
+		if initialized {
+			if err := p.balance(ctx); err == nil {
+				p.process(ctx)
+			}
+		}
+
+		select {
+		case <-p.stop:
+			p.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// createLeases creates a lease for every feed range of the rules containers
+// that does not have one yet.
+func (p *ChangeFeedProcessor) createLeases(ctx context.Context) error {
+	for _, name := range p.adapter.ruleContainerNames() {
+		if err := p.createContainerLeases(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ChangeFeedProcessor) createContainerLeases(ctx context.Context, name string) error {
+	ranges, err := cosmosContainer(p.adapter.containers[name]).ReadFeedRanges(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// the leases of the main container keep the ids they had before other
+	// containers were supported
+	prefix, container := p.options.ProcessorName, ""
+	if name != p.adapter.containerName {
+		prefix, container = p.options.ProcessorName+"."+name, name
+	}
+	for _, r := range ranges {
+		l := lease{
+			ID:           fmt.Sprintf("%s.%s-%s", prefix, r.MinInclusive, r.MaxExclusive),
+			Group:        p.options.ProcessorName,
+			Container:    container,
+			MinInclusive: r.MinInclusive,
+			MaxExclusive: r.MaxExclusive,
+			StartFrom:    time.Now().UnixNano(),
+		}
+		if p.options.StartFrom != nil {
+			l.StartFrom = p.options.StartFrom.UnixNano()
+		}
+		marshalled, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		_, err = p.leaseClient.CreateItem(ctx, azcosmos.NewPartitionKeyString(l.Group), marshalled, nil)
+		if err != nil && !isStatus(err, http.StatusConflict) {
+			return err
+		}
+	}
+	return nil
+}
+
+// balance renews the leases owned by this instance and acquires free or
+// expired leases until it owns its fair share.
+func (p *ChangeFeedProcessor) balance(ctx context.Context) error {
+	all, err := p.readLeases(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	owners := map[string]bool{p.options.InstanceName: true}
+	for _, l := range all {
+		if l.Owner != "" && time.Unix(0, l.Expires).After(now) {
+			owners[l.Owner] = true
+		}
+	}
+	share := (len(all) + len(owners) - 1) / len(owners)
+
+	owned := make(map[string]*lease)
+	for _, l := range all {
+		if l.Owner == p.options.InstanceName {
+			if p.takeLease(ctx, l) == nil {
+				owned[l.ID] = l
+			}
+		}
+	}
+	for _, l := range all {
+		if len(owned) >= share {
+			break
+		}
+		if l.Owner != "" && l.Owner != p.options.InstanceName && time.Unix(0, l.Expires).After(now) {
+			continue
+		}
+		if _, ok := owned[l.ID]; ok {
+			continue
+		}
+		if p.takeLease(ctx, l) == nil {
+			owned[l.ID] = l
+		}
+	}
+
+	p.mu.Lock()
+	p.leases = owned
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *ChangeFeedProcessor) readLeases(ctx context.Context) ([]*lease, error) {
+	var leases []*lease
+	queryPager := p.leaseClient.NewQueryItemsPager("SELECT * FROM c", azcosmos.NewPartitionKeyString(p.options.ProcessorName), nil)
+	for queryPager.More() {
+		res, err := nextPage(ctx, queryPager)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range res.Items {
+			var l lease
+			if err := json.Unmarshal(item, &l); err != nil {
+				return nil, err
+			}
+			var meta struct {
+				ETag azcore.ETag `json:"_etag"`
+			}
+			if err := json.Unmarshal(item, &meta); err != nil {
+				return nil, err
+			}
+			l.ETag = meta.ETag
+			leases = append(leases, &l)
+		}
+	}
+	return leases, nil
+}
+
+// takeLease claims or renews the lease for this instance.
+func (p *ChangeFeedProcessor) takeLease(ctx context.Context, l *lease) error {
+	previous := *l
+	l.Owner = p.options.InstanceName
+	l.Expires = time.Now().Add(p.options.LeaseExpiration).UnixNano()
+	if err := p.replaceLease(ctx, l); err != nil {
+		*l = previous
+		return err
+	}
+	return nil
+}
+
+// renewLease renews the lease once half of its expiration has passed.
+func (p *ChangeFeedProcessor) renewLease(ctx context.Context, l *lease) error {
+	if time.Until(time.Unix(0, l.Expires)) > p.options.LeaseExpiration/2 {
+		return nil
+	}
+	return p.takeLease(ctx, l)
+}
+
+// replaceLease writes the lease if nobody else changed it since it was read.
+func (p *ChangeFeedProcessor) replaceLease(ctx context.Context, l *lease) error {
+	marshalled, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	res, err := p.leaseClient.ReplaceItem(ctx, azcosmos.NewPartitionKeyString(l.Group), l.ID, marshalled, &azcosmos.ItemOptions{IfMatchEtag: &l.ETag})
+	if err != nil {
+		return err
+	}
+	l.ETag = res.ETag
+	return nil
+}
+
+// process reads the change feed of every owned lease until it is drained,
+// checkpointing after each handled batch.
+func (p *ChangeFeedProcessor) process(ctx context.Context) {
+	p.mu.Lock()
+	owned := make([]*lease, 0, len(p.leases))
+	for _, l := range p.leases {
+		owned = append(owned, l)
+	}
+	p.mu.Unlock()
+
+	for _, l := range owned {
+		if err := p.processLease(ctx, l); err != nil {
+			// the batch is not checkpointed and is read again on the next poll
+			continue
+		}
+	}
+}
+
+func (p *ChangeFeedProcessor) processLease(ctx context.Context, l *lease) error {
+	container := p.containerOf(l)
+	if container == nil {
+		// the lease of a container the adapter is not configured with
+		return fmt.Errorf("unknown container %s", l.Container)
+	}
+	for {
+		// a long backlog must not let the lease expire while it drains
+		if err := p.renewLease(ctx, l); err != nil {
+			return err
+		}
+		options := &azcosmos.ChangeFeedOptions{
+			FeedRange: &azcosmos.FeedRange{MinInclusive: l.MinInclusive, MaxExclusive: l.MaxExclusive},
+		}
+		if l.Continuation != "" {
+			continuation := l.Continuation
+			options.Continuation = &continuation
+		} else {
+			startFrom := time.Unix(0, l.StartFrom)
+			options.StartFrom = &startFrom
+		}
+
+		res, err := container.ReadChangeFeed(ctx, options)
+		if err != nil {
+			return err
+		}
+		if res.Count == 0 || len(res.Items) == 0 {
+			return p.checkpoint(ctx, l, res.ContinuationToken)
+		}
+
+		changes := p.changes(ctx, res.Items)
+		if len(changes) > 0 {
+			if p.options.Handler != nil {
+				if err := p.options.Handler(ctx, changes); err != nil {
+					return err
+				}
+			}
+			p.mu.Lock()
+			callback := p.callback
+			p.mu.Unlock()
+			if callback != nil {
+				callback(p.adapter.ruleChange(changes...).String())
+			}
+		}
+
+		if err := p.checkpoint(ctx, l, res.ContinuationToken); err != nil {
+			return err
+		}
+	}
+}
+
+// changes decodes the changed rules of a page of the change feed. Malformed
+// documents are skipped, since reading them again would fail again, and
+// reported like the loads report them: to Options.OnSkippedDocument, or
+// logged.
+func (p *ChangeFeedProcessor) changes(ctx context.Context, items [][]byte) []CasbinRule {
+	changes := make([]CasbinRule, 0, len(items))
+	for _, item := range items {
+		var meta struct {
+			PType string `json:"pType"`
+		}
+		if err := json.Unmarshal(item, &meta); err != nil {
+			p.skip(ctx, item, err)
+			continue
+		}
+		if meta.PType == policyVersionID {
+			if p.adapter.generational {
+				// a SavePolicy switched to a new generation of the rules,
+				// whose documents were skipped while it was written
+				p.generationChanged(item)
+			}
+			continue
+		}
+		line, err := p.adapter.mapper.FromDocument(item)
+		if err != nil {
+			p.skip(ctx, item, err)
+			continue
+		}
+		if line.PType == "" || p.adapter.inOtherNamespace(line) || p.adapter.inOtherGeneration(line) {
+			continue
+		}
+		changes = append(changes, line)
+	}
+	return changes
+}
+
+// skip reports a malformed document of the change feed, which is skipped
+// even without Options.SkipMalformedDocuments.
+func (p *ChangeFeedProcessor) skip(ctx context.Context, document []byte, err error) {
+	if err := p.adapter.malformed(ctx, document, err); err != nil {
+		p.adapter.logger.Error("skipped malformed document of the change feed", "id", documentID(document), "error", err)
+	}
+}
+
+// generationChanged reports an unknown change of the rules when the policy
+// version document of the adapter switched to a new generation of the rules.
+func (p *ChangeFeedProcessor) generationChanged(document []byte) {
+	var doc policyVersion
+	if err := json.Unmarshal(document, &doc); err != nil || doc.ID != p.adapter.versionID() || doc.Generation <= p.adapter.generation.Load() {
+		return
+	}
+	p.adapter.storeGeneration(doc.Generation)
+	p.mu.Lock()
+	callback := p.callback
+	p.mu.Unlock()
+	if callback != nil {
+		callback(PolicyChange{Version: doc.Version}.String())
+	}
+}
+
+// containerOf returns the rules container of the lease.
+func (p *ChangeFeedProcessor) containerOf(l *lease) *azcosmos.ContainerClient {
+	if l.Container == "" {
+		return cosmosContainer(p.adapter.containerClient)
+	}
+	return cosmosContainer(p.adapter.containers[l.Container])
+}
+
+// checkpoint stores the continuation in the lease. It fails if the lease was
+// taken over by another instance in the meantime.
+func (p *ChangeFeedProcessor) checkpoint(ctx context.Context, l *lease, continuation string) error {
+	if continuation == "" || continuation == l.Continuation {
+		return nil
+	}
+	previous := l.Continuation
+	l.Continuation = continuation
+	if err := p.replaceLease(ctx, l); err != nil {
+		l.Continuation = previous
+		return err
+	}
+	return nil
+}
+
+// release gives up the leases owned by this instance.
+func (p *ChangeFeedProcessor) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, l := range p.leases {
+		l.Owner = ""
+		l.Expires = 0
+		_ = p.replaceLease(ctx, l)
+	}
+	p.leases = make(map[string]*lease)
+}
+
+// isStatus reports whether err is a Cosmos response error with the given status code.
+func isStatus(err error, statusCode int) bool {
+	var resErr *azcore.ResponseError
+	return errors.As(err, &resErr) && resErr.StatusCode == statusCode
+}
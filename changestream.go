@@ -0,0 +1,188 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"maps"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// ChangeCursor is the position of a ChangeStream in the change feeds of the
+// rules containers. A cursor can be marshalled to JSON and stored, so a
+// consumer can resume the stream where it stopped.
+type ChangeCursor struct {
+	// Continuations are the Cosmos continuation tokens of the change feeds, by
+	// rules container name.
+	Continuations map[string]string `json:"continuations,omitempty"`
+}
+
+// ChangeEvent is a change of the stored rules read from the change feed by
+// ChangeStream.
+type ChangeEvent struct {
+	// Op is EventAdd for a rule written, and EventRemove for a rule removed
+	// with Options.Tombstones: removals without tombstones are not part of the
+	// change feed. With Options.GenerationalSave, a SavePolicy is an EventClear
+	// without PType, after which the policy is to be read again.
+	Op    string
+	PType string
+	Rule  []string
+	// Domain is the domain of the rule with Options.Domains.
+	Domain string
+	// Time is the time of the change, to the second.
+	Time time.Time
+	// Cursor resumes the stream after the event. The change feed is read by
+	// pages, and the cursors of the events but the last of a page resume at
+	// the start of the page, so the events are delivered at least once.
+	Cursor ChangeCursor
+}
+
+// ChangeStreamOptions configures a ChangeStream.
+type ChangeStreamOptions struct {
+	// Cursor resumes a stream from the Cursor of the last event handled.
+	Cursor ChangeCursor
+	// StartFrom is the time from which the change feeds without a
+	// continuation in Cursor are read. Defaults to the time the stream starts.
+	StartFrom time.Time
+	// PollInterval is the time between two reads of the drained change feeds.
+	// Defaults to 5s.
+	PollInterval time.Duration
+}
+
+// ChangeStream returns a stream of the changes of the stored rules, read from
+// the change feeds of the rules containers, for consumers such as caches,
+// SIEMs or data warehouses that pull the changes at their own pace:
+//
+//	for event, err := range a.ChangeStream(ctx, cosmosadapter.ChangeStreamOptions{Cursor: stored}) {
+//		if err != nil {
+//			log.Print(err) // the read is retried after PollInterval
+//			continue
+//		}
+//		handle(event)
+//		stored = event.Cursor
+//	}
+//
+// The stream ends when ctx is done or the loop breaks. Read errors are yielded,
+// and the feed is read again after PollInterval unless the loop breaks. Unlike
+// a ChangeFeedProcessor, a stream keeps no lease: every stream reads every
+// change. It requires the azcosmos clients, not Options.NewContainer or
+// Options.DryRun.
+func (a *Adapter) ChangeStream(ctx context.Context, options ChangeStreamOptions) iter.Seq2[ChangeEvent, error] {
+	if options.PollInterval <= 0 {
+		options.PollInterval = 5 * time.Second
+	}
+	return func(yield func(ChangeEvent, error) bool) {
+		containers := map[string]*azcosmos.ContainerClient{}
+		for _, name := range a.ruleContainerNames() {
+			if containers[name] = cosmosContainer(a.containers[name]); containers[name] == nil {
+				yield(ChangeEvent{}, errors.New("ChangeStream requires an adapter with an azcosmos client"))
+				return
+			}
+		}
+		start := options.StartFrom
+		if start.IsZero() {
+			start = time.Now()
+		}
+		cursor := ChangeCursor{Continuations: maps.Clone(options.Cursor.Continuations)}
+		if cursor.Continuations == nil {
+			cursor.Continuations = map[string]string{}
+		}
+
+		for {
+			for _, name := range a.ruleContainerNames() {
+				if !a.streamContainer(ctx, name, containers[name], start, &cursor, yield) {
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(options.PollInterval):
+			}
+		}
+	}
+}
+
+// streamContainer yields the changes of a rules container until its change
+// feed is drained, advancing the cursor. It returns false when the stream
+// ends.
+func (a *Adapter) streamContainer(ctx context.Context, name string, container *azcosmos.ContainerClient, start time.Time, cursor *ChangeCursor, yield func(ChangeEvent, error) bool) bool {
+	for ctx.Err() == nil {
+		options := &azcosmos.ChangeFeedOptions{}
+		if continuation, ok := cursor.Continuations[name]; ok {
+			options.Continuation = &continuation
+		} else {
+			options.StartFrom = &start
+		}
+		res, err := container.ReadChangeFeed(ctx, options)
+		if err != nil {
+			return ctx.Err() == nil && yield(ChangeEvent{}, err)
+		}
+		events, err := a.changeEvents(ctx, res.Items)
+		if err != nil {
+			return yield(ChangeEvent{}, err)
+		}
+
+		before := ChangeCursor{Continuations: maps.Clone(cursor.Continuations)}
+		if res.ContinuationToken != "" {
+			cursor.Continuations[name] = res.ContinuationToken
+		}
+		after := ChangeCursor{Continuations: maps.Clone(cursor.Continuations)}
+		for i, event := range events {
+			event.Cursor = before
+			if i == len(events)-1 {
+				event.Cursor = after
+			}
+			if !yield(event, nil) {
+				return false
+			}
+		}
+		if res.Count == 0 || len(res.Items) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// changeEvents returns the events of the documents of a change feed page,
+// leaving out the documents of other namespaces and generations.
+func (a *Adapter) changeEvents(ctx context.Context, items [][]byte) ([]ChangeEvent, error) {
+	var events []ChangeEvent
+	for _, item := range items {
+		line, ok, err := a.decodeRule(ctx, item)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || line.PType == "" {
+			continue
+		}
+		if line.PType == policyVersionID {
+			var doc policyVersion
+			if err := json.Unmarshal(item, &doc); err == nil && a.generational && doc.ID == a.versionID() && doc.Generation > a.generation.Load() {
+				// a SavePolicy switched to a new generation of the rules,
+				// whose documents were skipped while it was written
+				a.storeGeneration(doc.Generation)
+				events = append(events, ChangeEvent{Op: EventClear, Time: time.Unix(line.Ts, 0)})
+			}
+			continue
+		}
+		if a.inOtherNamespace(line) || a.inOtherGeneration(line) {
+			continue
+		}
+		op := EventAdd
+		if line.Deleted {
+			op = EventRemove
+		}
+		events = append(events, ChangeEvent{
+			Op:     op,
+			PType:  line.PType,
+			Rule:   policyTokens(line),
+			Domain: a.ruleDomain(line),
+			Time:   time.Unix(line.Ts, 0),
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,146 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CompatSchema describes the documents written by another Casbin adapter, so
+// this adapter can be adopted on an existing container without migrating the
+// documents. Set it with Options.Compat.
+//
+// Documents are read and written with the schema's field names. The IDs of
+// existing documents are kept: rules are removed by looking them up, as their
+// IDs can't be derived from the rule. New documents get this adapter's IDs.
+type CompatSchema struct {
+	// PTypeField is the field holding the policy type, such as "ptype".
+	PTypeField string
+	// ValueFields are the fields holding the rule values, in order, such as
+	// "v0" to "v5".
+	ValueFields []string
+	// PartitionKeyPath is the partition key path of the container, such as
+	// "/ptype". Defaults to "/" followed by PTypeField.
+	PartitionKeyPath string
+}
+
+// LowercaseSchema is the schema of adapters storing the policy type in a
+// "ptype" field, the partition key, next to v0 to v5, like the SQL adapters do.
+var LowercaseSchema = CompatSchema{
+	PTypeField:  "ptype",
+	ValueFields: []string{"v0", "v1", "v2", "v3", "v4", "v5"},
+}
+
+// PascalCaseSchema is the schema of adapters storing rules with Pascal case
+// field names, such as the Entity Framework Core adapters.
+var PascalCaseSchema = CompatSchema{
+	PTypeField:  "PType",
+	ValueFields: []string{"V0", "V1", "V2", "V3", "V4", "V5"},
+}
+
+// partitionKeyPath returns the partition key path of the container.
+func (s CompatSchema) partitionKeyPath() string {
+	if s.PartitionKeyPath != "" {
+		return s.PartitionKeyPath
+	}
+	return "/" + s.PTypeField
+}
+
+// partitionKeyField returns the top level field holding the partition key.
+func (s CompatSchema) partitionKeyField() string {
+	return strings.TrimPrefix(s.partitionKeyPath(), "/")
+}
+
+// ToDocument implements DocumentMapper. The fields this adapter relies on,
+// such as namespace or deleted, are written under their default names.
+func (s CompatSchema) ToDocument(rule CasbinRule) ([]byte, error) {
+	tokens := policyTokens(rule)
+	if len(tokens) > len(s.ValueFields) {
+		return nil, fmt.Errorf("rule has %d values, the schema holds %d", len(tokens), len(s.ValueFields))
+	}
+	doc := map[string]any{"id": rule.ID, s.PTypeField: rule.PType}
+	if field := s.partitionKeyField(); field != s.PTypeField {
+		doc[field] = rule.PType
+	}
+	for i, value := range tokens {
+		doc[s.ValueFields[i]] = value
+	}
+	// the fields of CasbinRule that are not part of the rule
+	meta, err := json.Marshal(CasbinRule{
+		PartitionKey: rule.PartitionKey,
+		Namespace:    rule.Namespace,
+		TTL:          rule.TTL,
+		ExpiresAt:    rule.ExpiresAt,
+		Deleted:      rule.Deleted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(meta, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range fields {
+		if key != "id" && key != "pType" {
+			doc[key] = value
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// FromDocument implements DocumentMapper.
+func (s CompatSchema) FromDocument(document []byte) (CasbinRule, error) {
+	var rule CasbinRule
+	// read the fields this adapter relies on, such as namespace or _ts
+	if err := json.Unmarshal(document, &rule); err != nil {
+		return rule, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(document, &fields); err != nil {
+		return rule, err
+	}
+	rule.PType, _ = fields[s.PTypeField].(string)
+	rule.Rule = nil
+	// empty values are kept, only the trailing ones are dropped
+	tokens := make([]string, len(s.ValueFields))
+	for i, field := range s.ValueFields {
+		tokens[i], _ = fields[field].(string)
+	}
+	for len(tokens) > 0 && tokens[len(tokens)-1] == "" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	for i, value := range rule.values() {
+		*value = ""
+		if i < len(tokens) {
+			*value = tokens[i]
+		}
+	}
+	if len(tokens) > maxRuleValues {
+		rule.Rule = tokens
+	}
+	return rule, nil
+}
+
+// storedCopies returns the stored documents of the rule. With Options.Compat
+// documents written by another adapter have IDs that can't be derived from
+// the rule, so they are looked up.
+func (a *Adapter) storedCopies(ctx context.Context, policy CasbinRule) ([]CasbinRule, error) {
+	if a.compat == nil {
+		return []CasbinRule{policy}, nil
+	}
+	query, parameters := a.inNamespace("SELECT * FROM c", nil)
+	stored, err := a.query(ctx, query, policy.PType, parameters)
+	if err != nil {
+		return nil, err
+	}
+	tokens := policyTokens(policy)
+	var copies []CasbinRule
+	for _, line := range stored {
+		if line.PType == policy.PType && !line.Deleted && slices.Equal(policyTokens(line), tokens) {
+			copies = append(copies, line)
+		}
+	}
+	return copies, nil
+}
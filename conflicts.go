@@ -0,0 +1,177 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Conflict is an entry of the conflict feed of the rules container: a write
+// made in one region that lost against a concurrent write in another region.
+type Conflict struct {
+	// ID is the id of the conflict.
+	ID string
+	// OperationType is the losing operation: "create", "replace" or "delete".
+	OperationType string
+	// Rule is the rule document written by the losing operation.
+	Rule CasbinRule
+	// Container is the name of the rule container the conflict happened in.
+	Container string
+}
+
+// ConflictResolver decides whether the losing write of a conflict is applied
+// again. The conflict is removed from the feed once it returned without error.
+type ConflictResolver func(ctx context.Context, conflict Conflict) (apply bool, err error)
+
+// PreferAdds is a ConflictResolver that re-applies rules which were added and
+// lost against a concurrent removal, so a rule another region just added is
+// never silently dropped. Losing removals are discarded.
+func PreferAdds(ctx context.Context, conflict Conflict) (bool, error) {
+	return conflict.OperationType != "delete" && !conflict.Rule.Deleted, nil
+}
+
+// ReadConflicts returns the conflicts of the rules containers that were not
+// resolved yet. Conflicts are only kept in the feed when the container uses the
+// custom conflict resolution mode, see Options.ConflictResolutionPolicy.
+func (a *Adapter) ReadConflicts(ctx context.Context) ([]Conflict, error) {
+	if a.rest == nil {
+		return nil, errRESTUnavailable
+	}
+	var conflicts []Conflict
+	for _, name := range a.ruleContainerNames() {
+		read, err := a.readConflicts(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, read...)
+	}
+	return conflicts, nil
+}
+
+// readConflicts returns the conflicts of a rules container.
+func (a *Adapter) readConflicts(ctx context.Context, container string) ([]Conflict, error) {
+	link := fmt.Sprintf("dbs/%s/colls/%s", a.databaseName, container)
+
+	var conflicts []Conflict
+	continuation := ""
+	for {
+		headers := map[string]string{}
+		if continuation != "" {
+			headers["x-ms-continuation"] = continuation
+		}
+		res, err := a.rest.do(ctx, http.MethodGet, "conflicts", link, link+"/conflicts", headers)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Conflicts []struct {
+				ID            string `json:"id"`
+				OperationType string `json:"operationType"`
+				ResourceType  string `json:"resourceType"`
+				Content       string `json:"content"`
+			} `json:"Conflicts"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range page.Conflicts {
+			if c.ResourceType != "" && c.ResourceType != "document" {
+				continue
+			}
+			conflict := Conflict{ID: c.ID, OperationType: c.OperationType, Container: container}
+			if c.Content != "" {
+				if conflict.Rule, err = a.mapper.FromDocument([]byte(c.Content)); err != nil {
+					return nil, err
+				}
+			}
+			conflicts = append(conflicts, conflict)
+		}
+
+		continuation = res.Header.Get("x-ms-continuation")
+		if continuation == "" {
+			return conflicts, nil
+		}
+	}
+}
+
+// ResolveConflicts passes every pending conflict to the resolver, re-applies
+// the losing writes it accepts and removes the conflicts from the feed. It
+// returns the number of resolved conflicts. Call it periodically on accounts
+// with multi-region writes.
+func (a *Adapter) ResolveConflicts(ctx context.Context, resolver ConflictResolver) (int, error) {
+	conflicts, err := a.ReadConflicts(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	resolved := 0
+	for _, conflict := range conflicts {
+		apply, err := resolver(ctx, conflict)
+		if err != nil {
+			return resolved, err
+		}
+		if apply {
+			if err := a.applyConflict(ctx, conflict); err != nil {
+				return resolved, err
+			}
+		}
+		if err := a.deleteConflict(ctx, conflict); err != nil {
+			return resolved, err
+		}
+		resolved++
+	}
+	if resolved > 0 {
+		return resolved, a.policyChanged(ctx, PolicyChange{})
+	}
+	return resolved, nil
+}
+
+// applyConflict writes the losing version of the rule.
+func (a *Adapter) applyConflict(ctx context.Context, conflict Conflict) error {
+	rule := conflict.Rule
+	pk := a.partitionKey(rule)
+	if conflict.OperationType == "delete" {
+		_, err := a.containerFor(rule.PType).DeleteItem(ctx, pk, rule.ID, nil)
+		if err != nil && !isStatus(err, http.StatusNotFound) {
+			return err
+		}
+		return nil
+	}
+	rule.Ts = 0
+	marshalled, err := a.encodeRule(rule)
+	if err != nil {
+		return err
+	}
+	_, err = a.containerFor(rule.PType).UpsertItem(ctx, pk, marshalled, nil)
+	return err
+}
+
+func (a *Adapter) deleteConflict(ctx context.Context, conflict Conflict) error {
+	container := conflict.Container
+	if container == "" {
+		container = a.containerName
+	}
+	link := fmt.Sprintf("dbs/%s/colls/%s/conflicts/%s", a.databaseName, container, conflict.ID)
+	pkValue := conflict.Rule.PType
+	if a.partitionStrategy == PartitionByDomain {
+		pkValue = conflict.Rule.PartitionKey
+	}
+	pk, err := json.Marshal([]string{pkValue})
+	if err != nil {
+		return err
+	}
+	res, err := a.rest.do(ctx, http.MethodDelete, "conflicts", link, link, map[string]string{
+		"x-ms-documentdb-partitionkey": string(pk),
+	})
+	if err != nil {
+		if isStatus(err, http.StatusNotFound) {
+			return nil
+		}
+		return err
+	}
+	return res.Body.Close()
+}
@@ -0,0 +1,87 @@
+package cosmosadapter
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// Container is the part of *azcosmos.ContainerClient the adapter reads and
+// writes items with, which *azcosmos.ContainerClient implements. Set
+// Options.NewContainer to use another implementation, such as a fake in unit
+// tests.
+type Container interface {
+	ReadItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error)
+	CreateItem(ctx context.Context, partitionKey azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error)
+	UpsertItem(ctx context.Context, partitionKey azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error)
+	ReplaceItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error)
+	DeleteItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error)
+	NewQueryItemsPager(query string, partitionKey azcosmos.PartitionKey, o *azcosmos.QueryOptions) *runtime.Pager[azcosmos.QueryItemsResponse]
+}
+
+var _ Container = (*azcosmos.ContainerClient)(nil)
+
+// Patcher is implemented by the containers supporting partial document
+// updates, such as *azcosmos.ContainerClient. UpdatePolicy patches the rules in
+// place in the containers of Options.NewContainer implementing it.
+type Patcher interface {
+	PatchItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, ops azcosmos.PatchOperations, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error)
+}
+
+var _ Patcher = (*azcosmos.ContainerClient)(nil)
+
+// patcherOf returns the container as a Patcher, if it supports partial
+// document updates. The containers wrapped by the adapter support them when
+// the container they wrap does.
+func patcherOf(container Container) (Patcher, bool) {
+	switch c := container.(type) {
+	case dryRunContainer:
+		if _, ok := patcherOf(c.Container); !ok {
+			return nil, false
+		}
+	case faultContainer:
+		if _, ok := patcherOf(c.Container); !ok {
+			return nil, false
+		}
+	}
+	patcher, ok := container.(Patcher)
+	return patcher, ok
+}
+
+// cosmosContainer returns the azcosmos client of the container, nil when the
+// container is another implementation. The features the interface does not
+// cover, such as the change feed or transactional batches, need it.
+func cosmosContainer(container Container) *azcosmos.ContainerClient {
+	client, _ := container.(*azcosmos.ContainerClient)
+	return client
+}
+
+// ContainerClient returns the azcosmos client of the main rules container, so
+// custom operations, such as maintenance scripts, can reuse the configured
+// client of the adapter. The clients of the containers set by
+// Options.GroupingContainerName or Options.PTypeContainers can be created
+// from DatabaseClient. It is nil with Options.NewContainer. The writes made
+// with it are not skipped by Options.DryRun.
+func (a *Adapter) ContainerClient() *azcosmos.ContainerClient {
+	if c, ok := a.containerClient.(dryRunContainer); ok {
+		return cosmosContainer(c.Container)
+	}
+	return cosmosContainer(a.containerClient)
+}
+
+// DatabaseClient returns the azcosmos client of the database of the adapter.
+// It is nil with Options.NewContainer.
+func (a *Adapter) DatabaseClient() *azcosmos.DatabaseClient {
+	return a.db
+}
+
+// nextPage returns the next page of the pager, or the error of the context
+// once it is done. Paging loops call it, so they stop between pages when their
+// caller is gone even if the container doesn't check the context itself.
+func nextPage(ctx context.Context, pager *runtime.Pager[azcosmos.QueryItemsResponse]) (azcosmos.QueryItemsResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return azcosmos.QueryItemsResponse{}, err
+	}
+	return pager.NextPage(ctx)
+}
@@ -0,0 +1,95 @@
+package cosmosadapter
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// newRuleContainers creates the clients of the containers holding the rules.
+func (a *Adapter) newRuleContainers(options Options) {
+	a.containers = map[string]Container{a.containerName: a.containerClient}
+	a.groupingContainerName = options.GroupingContainerName
+	a.ptypeContainers = options.PTypeContainers
+	for _, name := range a.ruleContainerNames()[1:] {
+		a.containers[name] = a.newContainer(name)
+	}
+}
+
+// containerNameFor returns the name of the container holding the rules of the
+// policy type.
+func (a *Adapter) containerNameFor(ptype string) string {
+	if name, ok := a.ptypeContainers[ptype]; ok && name != "" {
+		return name
+	}
+	if a.groupingContainerName != "" && ptype != "" && ptype[:1] == "g" {
+		return a.groupingContainerName
+	}
+	return a.containerName
+}
+
+// containerFor returns the container holding the rules of the policy type.
+func (a *Adapter) containerFor(ptype string) Container {
+	return a.containers[a.containerNameFor(ptype)]
+}
+
+// ruleContainerNames returns the names of the containers holding rules, the
+// main container first. The policy version document is always stored in the
+// main container.
+func (a *Adapter) ruleContainerNames() []string {
+	seen := map[string]bool{a.containerName: true}
+	var others []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			others = append(others, name)
+		}
+	}
+	add(a.groupingContainerName)
+	for _, name := range a.ptypeContainers {
+		add(name)
+	}
+	sort.Strings(others)
+	return append([]string{a.containerName}, others...)
+}
+
+// queryPolicyTypes runs the query for every policy type. The policy types
+// stored in different containers are queried concurrently.
+func (a *Adapter) queryPolicyTypes(ctx context.Context, query string, ptypes []string, parameters []azcosmos.QueryParameter) ([]CasbinRule, error) {
+	byContainer := map[string][]int{}
+	for i, ptype := range ptypes {
+		name := a.containerNameFor(ptype)
+		byContainer[name] = append(byContainer[name], i)
+	}
+
+	results := make([][]CasbinRule, len(ptypes))
+	errs := make([]error, len(ptypes))
+	var wg sync.WaitGroup
+	for _, group := range byContainer {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, i := range group {
+				if results[i], errs[i] = a.query(ctx, query, ptypes[i], parameters); errs[i] != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range ptypes {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		total += len(results[i])
+	}
+	lines := make([]CasbinRule, 0, total)
+	for i := range ptypes {
+		lines = append(lines, results[i]...)
+	}
+	return lines, nil
+}
@@ -0,0 +1,168 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInconsistentCopy is matched with errors.Is by the error CopyPolicy returns
+// when its consistency check finds differences.
+var ErrInconsistentCopy = errors.New("cosmosadapter: inconsistent copy")
+
+// CopyResult is the outcome of CopyPolicy.
+type CopyResult struct {
+	// Copied is the number of rules written to the target.
+	Copied int
+	// Missing are the rules of the source the consistency check did not find
+	// in the target.
+	Missing []DumpRecord
+	// Extra are the rules of the target that are not in the source, only
+	// checked with ImportReplace.
+	Extra []DumpRecord
+}
+
+// CopyPolicy copies the rules of the adapter to the target adapter, which may
+// use another database, account, container or partition strategy. Documents
+// are written in the schema of the target, with its IDs, partition keys and
+// namespace. Temporary rules keep their expiry, tombstones are not copied.
+//
+// The copy is online: the source is read with queries and can be used
+// meanwhile. The target is written in an operation of its own, so a closed
+// target fails with ErrClosed and a LazyConnect one connects first. The writes are batched and paced as configured by options,
+// see ImportCSV. Once written, the rules of both adapters are read again and
+// compared: differences are returned in the result with an error matching
+// ErrInconsistentCopy. As rules are upserted, the copy can be run again to
+// catch up with the changes made during the previous one.
+func (a *Adapter) CopyPolicy(ctx context.Context, target *Adapter, options ImportOptions) (result CopyResult, err error) {
+	ctx, op := a.startOperation(ctx, "CopyPolicy")
+	defer func() { err = a.endOperation(op, err) }()
+
+	now := a.now()
+	var lines []CasbinRule
+	err = a.scan(ctx, nil, 0, func(line CasbinRule) error {
+		tokens := policyTokens(line)
+		if err := target.validateRule(line.PType, tokens); err != nil {
+			return err
+		}
+		copied := target.newPolicyLine(line.PType, tokens)
+		if line.ExpiresAt != 0 {
+			copied.expire(line.ExpiresAt, now)
+		}
+		lines = append(lines, copied)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	result.Copied, err = target.writeCopy(ctx, lines, options)
+	if err != nil {
+		return result, err
+	}
+
+	source, err := a.scanRules(ctx)
+	if err != nil {
+		return result, err
+	}
+	copied, err := target.readCopy(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.Missing, result.Extra = diffRules(source, copied)
+	if options.Mode != ImportReplace {
+		result.Extra = nil
+	}
+	if len(result.Missing) > 0 || len(result.Extra) > 0 {
+		return result, fmt.Errorf("%w: %d rules missing, %d extra", ErrInconsistentCopy, len(result.Missing), len(result.Extra))
+	}
+	return result, nil
+}
+
+// writeCopy writes the rules copied by CopyPolicy to the adapter, its target,
+// and returns the number written.
+func (a *Adapter) writeCopy(ctx context.Context, lines []CasbinRule, options ImportOptions) (copied int, err error) {
+	ctx, op := a.startOperation(ctx, "CopyPolicy")
+	defer func() { err = a.endOperation(op, err) }()
+
+	var events []PolicyEvent
+	for _, line := range lines {
+		events = append(events, a.newEvent(ctx, EventAdd, line.PType, policyTokens(line)))
+	}
+	if err := a.appendEvents(ctx, events...); err != nil {
+		return 0, err
+	}
+	restore, err := a.burst(ctx, options)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = errors.Join(err, restore()) }()
+	copied, err = a.bulkWrite(ctx, lines, options)
+	if err != nil {
+		return copied, err
+	}
+	if options.Mode == ImportReplace {
+		if err := a.removeOthers(ctx, lines); err != nil {
+			return copied, err
+		}
+	}
+	a.quotas.removed(lines...)
+	return copied, a.policyChanged(ctx, PolicyChange{})
+}
+
+// readCopy returns the rules of the adapter, the target of a CopyPolicy, for
+// its consistency check.
+func (a *Adapter) readCopy(ctx context.Context) (_ []CasbinRule, err error) {
+	ctx, op := a.startOperation(ctx, "CopyPolicy")
+	defer func() { err = a.endOperation(op, err) }()
+	return a.scanRules(ctx)
+}
+
+// scanRules returns every stored rule.
+func (a *Adapter) scanRules(ctx context.Context) ([]CasbinRule, error) {
+	var lines []CasbinRule
+	err := a.scan(ctx, nil, 0, func(line CasbinRule) error {
+		lines = append(lines, line)
+		return nil
+	})
+	return lines, err
+}
+
+// diffRules returns the rules of from missing in to, and the rules of to that
+// are not in from, sorted.
+func diffRules(from []CasbinRule, to []CasbinRule) (missing []DumpRecord, extra []DumpRecord) {
+	key := func(line CasbinRule) string {
+		return line.PType + "\x00" + strings.Join(policyTokens(line), "\x00")
+	}
+	inFrom := map[string]bool{}
+	for _, line := range from {
+		inFrom[key(line)] = true
+	}
+	inTo := map[string]bool{}
+	for _, line := range to {
+		inTo[key(line)] = true
+	}
+	for _, line := range from {
+		if k := key(line); !inTo[k] {
+			inTo[k] = true
+			missing = append(missing, DumpRecord{PType: line.PType, Rule: policyTokens(line)})
+		}
+	}
+	for _, line := range to {
+		if k := key(line); !inFrom[k] {
+			inFrom[k] = true
+			extra = append(extra, DumpRecord{PType: line.PType, Rule: policyTokens(line)})
+		}
+	}
+	compare := func(x, y DumpRecord) int {
+		if c := strings.Compare(x.PType, y.PType); c != 0 {
+			return c
+		}
+		return slices.Compare(x.Rule, y.Rule)
+	}
+	slices.SortFunc(missing, compare)
+	slices.SortFunc(extra, compare)
+	return missing, extra
+}
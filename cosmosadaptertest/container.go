@@ -0,0 +1,386 @@
+package cosmosadaptertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	cosmosadapter "github.com/rickdana/cosmos-casbin-adapter"
+)
+
+// defaultPageSize is the number of items of a query page when the query
+// options don't give one, as in Cosmos.
+const defaultPageSize = 100
+
+// Container is an in-memory cosmosadapter.Container. Items are stored by
+// partition key and ID, with the _ts and _etag system properties set on every
+// write. Items with a positive ttl property expire as with a container whose
+// time to live is enabled. Queries support the subset of the Cosmos SQL the
+// adapter generates, see Query. Calls with a done context fail with its error,
+// like the requests of the Cosmos client. It is safe for concurrent use.
+type Container struct {
+	id string
+
+	mu         sync.Mutex
+	partitions map[string]map[string]*item
+	etag       int64
+	now        func() time.Time
+}
+
+var _ cosmosadapter.Container = (*Container)(nil)
+
+// item is a stored document, decoded twice: exactly, with numbers kept as
+// json.Number, to be returned, and with numbers as float64, to be queried.
+type item struct {
+	partitionKey string
+	document     map[string]any
+	values       map[string]any
+	etag         azcore.ETag
+	expires      time.Time
+}
+
+// NewContainer returns an empty container.
+func NewContainer(id string) *Container {
+	return &Container{id: id, partitions: map[string]map[string]*item{}, now: time.Now}
+}
+
+// ID returns the name of the container.
+func (c *Container) ID() string {
+	return c.id
+}
+
+// Len returns the number of items of the container.
+func (c *Container) Len() int {
+	return len(c.Items())
+}
+
+// Items returns the documents of the container, ordered by partition key and
+// ID.
+func (c *Container) Items() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var documents [][]byte
+	for _, item := range c.scan(allPartitions) {
+		marshalled, _ := json.Marshal(item.document)
+		documents = append(documents, marshalled)
+	}
+	return documents
+}
+
+// ReadItem implements cosmosadapter.Container.
+func (c *Container) ReadItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stored := c.get(key(partitionKey), itemID)
+	if stored == nil {
+		return azcosmos.ItemResponse{}, responseError(http.StatusNotFound, "NotFound")
+	}
+	return response(http.StatusOK, stored)
+}
+
+// CreateItem implements cosmosadapter.Container.
+func (c *Container) CreateItem(ctx context.Context, partitionKey azcosmos.PartitionKey, document []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.write(ctx, partitionKey, "", document, o, func(stored *item) (int, error) {
+		if stored != nil {
+			return 0, responseError(http.StatusConflict, "Conflict")
+		}
+		return http.StatusCreated, nil
+	})
+}
+
+// UpsertItem implements cosmosadapter.Container.
+func (c *Container) UpsertItem(ctx context.Context, partitionKey azcosmos.PartitionKey, document []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.write(ctx, partitionKey, "", document, o, func(stored *item) (int, error) {
+		if stored == nil {
+			return http.StatusCreated, nil
+		}
+		return http.StatusOK, nil
+	})
+}
+
+// ReplaceItem implements cosmosadapter.Container.
+func (c *Container) ReplaceItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, document []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.write(ctx, partitionKey, itemID, document, o, func(stored *item) (int, error) {
+		if stored == nil {
+			return 0, responseError(http.StatusNotFound, "NotFound")
+		}
+		return http.StatusOK, nil
+	})
+}
+
+// DeleteItem implements cosmosadapter.Container.
+func (c *Container) DeleteItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pk := key(partitionKey)
+	stored := c.get(pk, itemID)
+	if stored == nil {
+		return azcosmos.ItemResponse{}, responseError(http.StatusNotFound, "NotFound")
+	}
+	if err := checkETag(stored, o); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	delete(c.partitions[pk], itemID)
+	return azcosmos.ItemResponse{Response: azcosmos.Response{RawResponse: &http.Response{StatusCode: http.StatusNoContent, Header: http.Header{}}, RequestCharge: 1}}, nil
+}
+
+// NewQueryItemsPager implements cosmosadapter.Container. An empty partition
+// key queries every partition. Pages hold QueryOptions.PageSizeHint items, 100
+// by default, and can be resumed with QueryOptions.ContinuationToken. An
+// unsupported query fails with a 400 response error.
+func (c *Container) NewQueryItemsPager(query string, partitionKey azcosmos.PartitionKey, o *azcosmos.QueryOptions) *runtime.Pager[azcosmos.QueryItemsResponse] {
+	if o == nil {
+		o = &azcosmos.QueryOptions{}
+	}
+	pageSize := int(o.PageSizeHint)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	offset := 0
+	if o.ContinuationToken != nil {
+		offset, _ = strconv.Atoi(*o.ContinuationToken)
+	}
+
+	var results [][]byte
+	q, err := parseQuery(query, o.QueryParameters)
+	if err == nil {
+		results, err = c.query(q, key(partitionKey))
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: %w", responseError(http.StatusBadRequest, "BadRequest"), err)
+	}
+
+	return runtime.NewPager(runtime.PagingHandler[azcosmos.QueryItemsResponse]{
+		More: func(page azcosmos.QueryItemsResponse) bool {
+			return page.ContinuationToken != nil
+		},
+		Fetcher: func(ctx context.Context, page *azcosmos.QueryItemsResponse) (azcosmos.QueryItemsResponse, error) {
+			if err != nil {
+				return azcosmos.QueryItemsResponse{}, err
+			}
+			if err := ctx.Err(); err != nil {
+				return azcosmos.QueryItemsResponse{}, err
+			}
+			start := offset
+			if page != nil && page.ContinuationToken != nil {
+				start, _ = strconv.Atoi(*page.ContinuationToken)
+			}
+			start = min(start, len(results))
+			end := min(start+pageSize, len(results))
+			res := azcosmos.QueryItemsResponse{
+				Response: azcosmos.Response{RawResponse: &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, RequestCharge: 1},
+				Items:    results[start:end],
+			}
+			if end < len(results) {
+				continuation := strconv.Itoa(end)
+				res.ContinuationToken = &continuation
+			}
+			return res, nil
+		},
+	})
+}
+
+// Query runs the query on the container, across every partition, and returns
+// the results. It supports the subset of the Cosmos SQL the adapter generates:
+//
+//	SELECT [DISTINCT] * | VALUE path | path [AS name], ... FROM alias [WHERE condition] [ORDER BY path [ASC|DESC], ...]
+//
+// Conditions combine comparisons (=, !=, <>, <, <=, >, >=), IN lists, AND, OR,
+// NOT and parentheses, over paths such as c.v0 or c.rule[1], parameters and
+// literals, and the functions IS_DEFINED, IS_NULL, ARRAY_CONTAINS,
+// ARRAY_LENGTH, STARTSWITH, ENDSWITH, CONTAINS, LOWER and UPPER.
+func (c *Container) Query(query string, parameters ...azcosmos.QueryParameter) ([][]byte, error) {
+	q, err := parseQuery(query, parameters)
+	if err != nil {
+		return nil, err
+	}
+	return c.query(q, allPartitions)
+}
+
+func (c *Container) query(q *query, pk string) ([][]byte, error) {
+	c.mu.Lock()
+	var matches []*item
+	for _, stored := range c.scan(pk) {
+		if q.matches(stored.values) {
+			matches = append(matches, stored)
+		}
+	}
+	c.mu.Unlock()
+
+	values := make([]map[string]any, len(matches))
+	for i, stored := range matches {
+		values[i] = stored.values
+	}
+	results := make([][]byte, 0, len(matches))
+	seen := map[string]bool{}
+	for _, i := range q.order(values) {
+		result, ok := q.result(matches[i].document)
+		if !ok {
+			continue
+		}
+		marshalled, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		if q.distinct {
+			if seen[string(marshalled)] {
+				continue
+			}
+			seen[string(marshalled)] = true
+		}
+		results = append(results, marshalled)
+	}
+	return results, nil
+}
+
+// scan returns the unexpired items of the partition, or of every partition
+// with allPartitions, ordered by partition key and ID. Expired items are
+// removed.
+func (c *Container) scan(pk string) []*item {
+	now := c.now()
+	var items []*item
+	for partition, stored := range c.partitions {
+		if pk != allPartitions && partition != pk {
+			continue
+		}
+		for id, item := range stored {
+			if !item.expires.IsZero() && !now.Before(item.expires) {
+				delete(stored, id)
+				continue
+			}
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].partitionKey != items[j].partitionKey {
+			return items[i].partitionKey < items[j].partitionKey
+		}
+		return items[i].document["id"].(string) < items[j].document["id"].(string)
+	})
+	return items
+}
+
+// get returns the unexpired item, nil if there is none.
+func (c *Container) get(pk string, id string) *item {
+	stored := c.partitions[pk][id]
+	if stored == nil {
+		return nil
+	}
+	if !stored.expires.IsZero() && !c.now().Before(stored.expires) {
+		delete(c.partitions[pk], id)
+		return nil
+	}
+	return stored
+}
+
+// write stores the document once check, called with the stored item if any,
+// returned the status of the response.
+func (c *Container) write(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, document []byte, o *azcosmos.ItemOptions, check func(*item) (int, error)) (azcosmos.ItemResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	doc, values, err := decode(document)
+	if err != nil {
+		return azcosmos.ItemResponse{}, responseError(http.StatusBadRequest, "BadRequest")
+	}
+	id, _ := doc["id"].(string)
+	if id == "" || itemID != "" && id != itemID {
+		return azcosmos.ItemResponse{}, responseError(http.StatusBadRequest, "BadRequest")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pk := key(partitionKey)
+	stored := c.get(pk, id)
+	status, err := check(stored)
+	if err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	if stored != nil {
+		if err := checkETag(stored, o); err != nil {
+			return azcosmos.ItemResponse{}, err
+		}
+	} else if o != nil && o.IfMatchEtag != nil {
+		return azcosmos.ItemResponse{}, responseError(http.StatusPreconditionFailed, "PreconditionFailed")
+	}
+
+	now := c.now()
+	c.etag++
+	written := &item{partitionKey: pk, document: doc, values: values, etag: azcore.ETag(fmt.Sprintf("\"%08d\"", c.etag))}
+	doc["_ts"], values["_ts"] = json.Number(strconv.FormatInt(now.Unix(), 10)), float64(now.Unix())
+	doc["_etag"], values["_etag"] = string(written.etag), string(written.etag)
+	if ttl, ok := values["ttl"].(float64); ok && ttl > 0 {
+		written.expires = now.Add(time.Duration(ttl) * time.Second)
+	}
+	if c.partitions[pk] == nil {
+		c.partitions[pk] = map[string]*item{}
+	}
+	c.partitions[pk][id] = written
+	return response(status, written)
+}
+
+// checkETag fails when the options ask for another version of the item.
+func checkETag(stored *item, o *azcosmos.ItemOptions) error {
+	if o != nil && o.IfMatchEtag != nil && *o.IfMatchEtag != stored.etag {
+		return responseError(http.StatusPreconditionFailed, "PreconditionFailed")
+	}
+	return nil
+}
+
+// decode decodes a document exactly and with float64 numbers.
+func decode(document []byte) (map[string]any, map[string]any, error) {
+	var doc map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, nil, err
+	}
+	var values map[string]any
+	if err := json.Unmarshal(document, &values); err != nil {
+		return nil, nil, err
+	}
+	return doc, values, nil
+}
+
+func response(status int, stored *item) (azcosmos.ItemResponse, error) {
+	marshalled, err := json.Marshal(stored.document)
+	if err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return azcosmos.ItemResponse{
+		Response: azcosmos.Response{RawResponse: &http.Response{StatusCode: status, Header: http.Header{}}, RequestCharge: 1, ETag: stored.etag},
+		Value:    marshalled,
+	}, nil
+}
+
+func responseError(status int, code string) error {
+	return &azcore.ResponseError{
+		StatusCode:  status,
+		ErrorCode:   code,
+		RawResponse: &http.Response{StatusCode: status, Header: http.Header{}, Body: http.NoBody},
+	}
+}
+
+// allPartitions is the key of the empty partition key, which queries every
+// partition.
+var allPartitions = key(azcosmos.NewPartitionKey())
+
+// key returns the string a partition key is stored under.
+func key(pk azcosmos.PartitionKey) string {
+	return fmt.Sprint(pk)
+}
@@ -0,0 +1,330 @@
+package cosmosadaptertest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/stretchr/testify/assert"
+
+	cosmosadapter "github.com/rickdana/cosmos-casbin-adapter"
+)
+
+func statusOf(err error) int {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode
+	}
+	return 0
+}
+
+func TestQuery(t *testing.T) {
+	c := NewContainer("rules")
+	ctx := context.Background()
+	pk := azcosmos.NewPartitionKeyString("p")
+	for _, doc := range []string{
+		`{"id":"1","pType":"p","v0":"alice","v1":"data1","v2":"read","rule":["alice","data1","read"],"n":3}`,
+		`{"id":"2","pType":"p","v0":"bob","v1":"data2","v2":"write","rule":["bob","data2","write"],"n":1}`,
+		`{"id":"3","pType":"g","v0":"alice","v1":"admin","rule":["alice","admin"],"n":2}`,
+	} {
+		_, err := c.CreateItem(ctx, pk, []byte(doc), nil)
+		assert.NoError(t, err)
+	}
+
+	ids := func(query string, parameters ...azcosmos.QueryParameter) []string {
+		t.Helper()
+		results, err := c.Query(query, parameters...)
+		assert.NoError(t, err)
+		var ids []string
+		for _, result := range results {
+			ids = append(ids, string(result))
+		}
+		return ids
+	}
+	assert.Equal(t, []string{`{"id":"1"}`, `{"id":"3"}`},
+		ids("SELECT c.id FROM c WHERE c.v0 = @v0", azcosmos.QueryParameter{Name: "@v0", Value: "alice"}))
+	assert.Equal(t, []string{`{"id":"2"}`, `{"id":"3"}`, `{"id":"1"}`},
+		ids("SELECT c.id FROM c ORDER BY c.n"))
+	assert.Equal(t, []string{`{"id":"2"}`},
+		ids("SELECT c.id FROM c WHERE c.pType = 'p' AND NOT (c.v0 = 'alice' OR c.n > 2)"))
+	assert.Equal(t, []string{`{"id":"1"}`, `{"id":"2"}`},
+		ids("SELECT c.id FROM c WHERE IS_DEFINED(c.v2) AND c.v1 IN ('data1', 'data2')"))
+	assert.Equal(t, []string{`{"id":"3"}`},
+		ids("SELECT c.id FROM c WHERE ARRAY_CONTAINS(c.rule, 'admin') AND ARRAY_LENGTH(c.rule) = 2"))
+	assert.Equal(t, []string{`{"id":"1"}`},
+		ids("SELECT c.id FROM c WHERE STARTSWITH(c.v1, 'dat') AND c.rule[2] = 'read'"))
+	assert.Equal(t, []string{`"alice"`, `"bob"`}, ids("SELECT DISTINCT VALUE c.v0 FROM c"))
+	// undefined values are left out of the results
+	assert.Equal(t, []string{`"read"`, `"write"`}, ids("SELECT VALUE c.v2 FROM c"))
+	// comparisons with undefined values are not true
+	assert.Empty(t, ids("SELECT c.id FROM c WHERE c.missing = 1 OR c.missing != 1"))
+
+	_, err := c.Query("SELECT c.id FROM c WHERE c.v0 = @missing")
+	assert.Error(t, err)
+	_, err = c.Query("DELETE FROM c")
+	assert.Error(t, err)
+}
+
+func TestItems(t *testing.T) {
+	c := NewContainer("rules")
+	ctx := context.Background()
+	pk := azcosmos.NewPartitionKeyString("p")
+
+	res, err := c.CreateItem(ctx, pk, []byte(`{"id":"1","v0":"alice"}`), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.RawResponse.StatusCode)
+	_, err = c.CreateItem(ctx, pk, []byte(`{"id":"1","v0":"alice"}`), nil)
+	assert.Equal(t, http.StatusConflict, statusOf(err))
+	_, err = c.ReadItem(ctx, azcosmos.NewPartitionKeyString("g"), "1", nil)
+	assert.Equal(t, http.StatusNotFound, statusOf(err))
+
+	// optimistic concurrency
+	stale := res.ETag
+	res, err = c.ReplaceItem(ctx, pk, "1", []byte(`{"id":"1","v0":"bob"}`), &azcosmos.ItemOptions{IfMatchEtag: &stale})
+	assert.NoError(t, err)
+	_, err = c.ReplaceItem(ctx, pk, "1", []byte(`{"id":"1","v0":"carol"}`), &azcosmos.ItemOptions{IfMatchEtag: &stale})
+	assert.Equal(t, http.StatusPreconditionFailed, statusOf(err))
+	assert.NotEqual(t, stale, res.ETag)
+
+	_, err = c.DeleteItem(ctx, pk, "1", nil)
+	assert.NoError(t, err)
+	_, err = c.DeleteItem(ctx, pk, "1", nil)
+	assert.Equal(t, http.StatusNotFound, statusOf(err))
+
+	// items expire after their ttl
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	_, err = c.UpsertItem(ctx, pk, []byte(`{"id":"2","ttl":10}`), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.Len())
+	now = now.Add(11 * time.Second)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestPager(t *testing.T) {
+	c := NewContainer("rules")
+	ctx := context.Background()
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		_, err := c.CreateItem(ctx, azcosmos.NewPartitionKeyString(id), []byte(`{"id":"`+id+`"}`), nil)
+		assert.NoError(t, err)
+	}
+
+	pager := c.NewQueryItemsPager("SELECT * FROM c", azcosmos.PartitionKey{}, &azcosmos.QueryOptions{PageSizeHint: 2})
+	var pages, items int
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		assert.NoError(t, err)
+		pages++
+		items += len(page.Items)
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, 5, items)
+
+	// a single partition
+	pager = c.NewQueryItemsPager("SELECT * FROM c", azcosmos.NewPartitionKeyString("3"), nil)
+	page, err := pager.NextPage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(page.Items))
+
+	pager = c.NewQueryItemsPager("SELECT FROM", azcosmos.PartitionKey{}, nil)
+	_, err = pager.NextPage(ctx)
+	assert.Equal(t, http.StatusBadRequest, statusOf(err))
+}
+
+func TestAdapter(t *testing.T) {
+	a, db := NewAdapter(cosmosadapter.Options{})
+	e, err := casbin.NewEnforcer("../examples/rbac_model.conf", a)
+	assert.NoError(t, err)
+
+	_, err = e.AddPolicy("alice", "data1", "read")
+	assert.NoError(t, err)
+	_, err = e.AddPolicy("bob", "data2", "write")
+	assert.NoError(t, err)
+	_, err = e.AddPolicy("bob", "data1", "read")
+	assert.NoError(t, err)
+	_, err = e.AddGroupingPolicy("carol", "admin")
+	assert.NoError(t, err)
+	assert.NotZero(t, db.Container("casbin_rule").Len())
+
+	// a second adapter on the same database sees the rules
+	e2, err := casbin.NewEnforcer("../examples/rbac_model.conf", NewAdapterWithDatabase(db, cosmosadapter.Options{}))
+	assert.NoError(t, err)
+	ok, err := e2.Enforce("bob", "data2", "write")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, e2.GetPolicy(), 3)
+
+	_, err = e.RemoveFilteredPolicy(0, "bob")
+	assert.NoError(t, err)
+	assert.NoError(t, e2.LoadPolicy())
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, e2.GetPolicy())
+	assert.Equal(t, [][]string{{"carol", "admin"}}, e2.GetGroupingPolicy())
+}
+
+func TestFilteredAdapter(t *testing.T) {
+	a, _ := NewAdapter(cosmosadapter.Options{PartitionStrategy: cosmosadapter.PartitionByDomain})
+	e, err := casbin.NewEnforcer("../examples/rbac_with_domains_model.conf", a)
+	assert.NoError(t, err)
+	_, err = e.AddPolicy("admin", "domain1", "data1", "read")
+	assert.NoError(t, err)
+	_, err = e.AddPolicy("admin", "domain2", "data2", "read")
+	assert.NoError(t, err)
+
+	assert.NoError(t, e.LoadFilteredPolicy(cosmosadapter.DomainFilter("domain1")))
+	assert.Equal(t, [][]string{{"admin", "domain1", "data1", "read"}}, e.GetPolicy())
+}
+
+func TestDistinctValues(t *testing.T) {
+	a, _ := NewAdapter(cosmosadapter.Options{Domains: true})
+	e, err := casbin.NewEnforcer("../examples/rbac_with_domains_model.conf", a)
+	assert.NoError(t, err)
+	for _, rule := range [][]string{
+		{"admin", "domain1", "data1", "read"},
+		{"admin", "domain1", "data1", "write"},
+		{"reader", "domain2", "data2", "read"},
+	} {
+		_, err = e.AddPolicy(rule)
+		assert.NoError(t, err)
+	}
+	_, err = e.AddGroupingPolicy("alice", "admin", "domain3")
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	subjects, err := a.GetAllSubjects(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "reader"}, subjects)
+	objects, err := a.GetAllObjects(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"data1", "data2"}, objects)
+	actions, err := a.GetAllActions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"read", "write"}, actions)
+	domains, err := a.GetAllDomains(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"domain1", "domain2", "domain3"}, domains)
+
+	// removed rules are left out
+	_, err = e.RemoveFilteredPolicy(0, "reader")
+	assert.NoError(t, err)
+	subjects, err = a.GetAllSubjects(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, subjects)
+
+	b, _ := NewAdapter(cosmosadapter.Options{})
+	_, err = b.GetAllDomains(ctx)
+	assert.Error(t, err)
+}
+
+func TestRandomPolicies(t *testing.T) {
+	policies, groupings := RandomPolicies(1000, SeedOptions{Seed: 1, Domains: 3})
+	assert.Len(t, policies, 1000)
+	assert.Len(t, groupings, 100)
+	assert.Len(t, policies[0], 4)
+	assert.Len(t, groupings[0], 3)
+	again, _ := RandomPolicies(1000, SeedOptions{Seed: 1, Domains: 3})
+	assert.Equal(t, policies, again)
+
+	a, db := NewAdapter(cosmosadapter.Options{})
+	n, err := SeedRandomPolicies(context.Background(), a, 1000, SeedOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1100, n)
+	// the rules and the policy version
+	assert.Equal(t, 1101, db.Container("casbin_rule").Len())
+}
+
+var benchmarkSizes = []int{1000, 10000, 100000}
+
+// runBenchmarks runs the benchmark on every backend and size, with an adapter
+// seeded with the rules of RandomPolicies. The emulator backend is skipped
+// without TEST_COSMOS_URL.
+func runBenchmarks(b *testing.B, benchmark func(b *testing.B, a *cosmosadapter.Adapter, n int)) {
+	for _, backend := range []string{"memory", "emulator"} {
+		for _, n := range benchmarkSizes {
+			b.Run(fmt.Sprintf("%s/%d", backend, n), func(b *testing.B) {
+				options := cosmosadapter.Options{ContainerName: fmt.Sprintf("bench_%d", n)}
+				var a *cosmosadapter.Adapter
+				if backend == "emulator" {
+					a = NewEmulatorAdapter(b, options)
+				} else {
+					a, _ = NewAdapter(options)
+				}
+				seed := SeedOptions{ImportOptions: cosmosadapter.ImportOptions{Mode: cosmosadapter.ImportReplace}}
+				if _, err := SeedRandomPolicies(context.Background(), a, n, seed); err != nil {
+					b.Fatal(err)
+				}
+				b.ResetTimer()
+				benchmark(b, a, n)
+			})
+		}
+	}
+}
+
+func benchmarkModel(b *testing.B) model.Model {
+	m, err := model.NewModelFromFile("../examples/rbac_model.conf")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return m
+}
+
+func BenchmarkLoadPolicy(b *testing.B) {
+	runBenchmarks(b, func(b *testing.B, a *cosmosadapter.Adapter, n int) {
+		m := benchmarkModel(b)
+		for i := 0; i < b.N; i++ {
+			m.ClearPolicy()
+			if err := a.LoadPolicy(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSavePolicy(b *testing.B) {
+	runBenchmarks(b, func(b *testing.B, a *cosmosadapter.Adapter, n int) {
+		b.StopTimer()
+		m := benchmarkModel(b)
+		if err := a.LoadPolicy(m); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		for i := 0; i < b.N; i++ {
+			if err := a.SavePolicy(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkRemoveFilteredPolicy removes the rules of an object, about 1% of
+// the rules, which are imported again between iterations.
+func BenchmarkRemoveFilteredPolicy(b *testing.B) {
+	runBenchmarks(b, func(b *testing.B, a *cosmosadapter.Adapter, n int) {
+		b.StopTimer()
+		policies, _ := RandomPolicies(n, SeedOptions{})
+		var removed bytes.Buffer
+		for _, rule := range policies {
+			if rule[1] == "data0" {
+				fmt.Fprintf(&removed, "p, %s\n", strings.Join(rule, ", "))
+			}
+		}
+		for i := 0; i < b.N; i++ {
+			if _, err := a.ImportCSV(context.Background(), bytes.NewReader(removed.Bytes()), cosmosadapter.ImportOptions{}); err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			if err := a.RemoveFilteredPolicy("p", "p", 1, "data0"); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+		}
+	})
+}
@@ -0,0 +1,713 @@
+package cosmosadaptertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// undefined is the value of a missing property, which is neither null nor
+// any other value: comparisons with it are undefined as well.
+type undefinedValue struct{}
+
+var undefined = undefinedValue{}
+
+// query is a parsed query of the subset of the Cosmos SQL described by
+// Container.Query.
+type query struct {
+	alias      string
+	projection []projection
+	// value is the expression of a SELECT VALUE query
+	value    expr
+	distinct bool
+	where    expr
+	orderBy  []ordering
+}
+
+type projection struct {
+	name  string
+	value expr
+}
+
+type ordering struct {
+	value      expr
+	descending bool
+}
+
+// expr is an expression evaluated on a document.
+type expr func(doc map[string]any) any
+
+// parseQuery parses the query, resolving its parameters.
+func parseQuery(text string, parameters []azcosmos.QueryParameter) (*query, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, parameters: map[string]any{}}
+	for _, parameter := range parameters {
+		// normalize the parameter values to the types of decoded documents
+		marshalled, err := json.Marshal(parameter.Value)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", parameter.Name, err)
+		}
+		var value any
+		if err := json.Unmarshal(marshalled, &value); err != nil {
+			return nil, err
+		}
+		p.parameters[parameter.Name] = value
+	}
+	q, err := p.parseQuery()
+	if err != nil {
+		return nil, fmt.Errorf("unsupported query %q: %w", text, err)
+	}
+	return q, nil
+}
+
+type parser struct {
+	tokens     []string
+	pos        int
+	alias      string
+	parameters map[string]any
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	if token != "" {
+		p.pos++
+	}
+	return token
+}
+
+// keyword consumes the next token if it is the keyword.
+func (p *parser) keyword(keyword string) bool {
+	if strings.EqualFold(p.peek(), keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(token string) error {
+	if next := p.next(); !strings.EqualFold(next, token) {
+		return fmt.Errorf("expected %s, got %q", token, next)
+	}
+	return nil
+}
+
+func (p *parser) parseQuery() (*query, error) {
+	q := &query{}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	q.distinct = p.keyword("DISTINCT")
+	// the projection is parsed once the alias is known
+	start := p.pos
+	for p.peek() != "" && !strings.EqualFold(p.peek(), "FROM") {
+		p.next()
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	q.alias = p.next()
+	if !isIdentifier(q.alias) {
+		return nil, fmt.Errorf("invalid alias %q", q.alias)
+	}
+	p.alias = q.alias
+
+	if p.keyword("WHERE") {
+		where, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		q.where = where
+	}
+	if p.keyword("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			value, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			o := ordering{value: value}
+			if p.keyword("DESC") {
+				o.descending = true
+			} else {
+				p.keyword("ASC")
+			}
+			q.orderBy = append(q.orderBy, o)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	if token := p.peek(); token != "" {
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+
+	p.pos = start
+	if p.keyword("VALUE") {
+		value, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		q.value = value
+	} else if p.peek() == "*" {
+		p.next()
+	} else {
+		for {
+			first := p.pos
+			value, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			// a path is named after its last property
+			name := fmt.Sprintf("$%d", len(q.projection)+1)
+			if p.tokens[first] == q.alias && p.pos-first >= 3 && p.tokens[p.pos-2] == "." {
+				name = p.tokens[p.pos-1]
+			}
+			if p.keyword("AS") {
+				name = p.next()
+			}
+			q.projection = append(q.projection, projection{name: name, value: value})
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	if !strings.EqualFold(p.peek(), "FROM") {
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+	return q, nil
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(doc map[string]any) any {
+			x, y := l(doc), right(doc)
+			if x == true || y == true {
+				return true
+			}
+			if x == false && y == false {
+				return false
+			}
+			return undefined
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(doc map[string]any) any {
+			x, y := l(doc), right(doc)
+			if x == false || y == false {
+				return false
+			}
+			if x == true && y == true {
+				return true
+			}
+			return undefined
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if !p.keyword("NOT") {
+		return p.parseComparison()
+	}
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return func(doc map[string]any) any {
+		if b, ok := operand(doc).(bool); ok {
+			return !b
+		}
+		return undefined
+	}, nil
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.keyword("IN") {
+		list, err := p.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		return func(doc map[string]any) any {
+			value := left(doc)
+			if value == undefined {
+				return undefined
+			}
+			for _, item := range list {
+				if equal(value, item(doc)) == true {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	operator := p.peek()
+	switch operator {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		p.next()
+	default:
+		return left, nil
+	}
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return func(doc map[string]any) any {
+		x, y := left(doc), right(doc)
+		switch operator {
+		case "=":
+			return equal(x, y)
+		case "!=", "<>":
+			if eq, ok := equal(x, y).(bool); ok {
+				return !eq
+			}
+			return undefined
+		}
+		c, ok := compare(x, y)
+		if !ok {
+			return undefined
+		}
+		switch operator {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default:
+			return c >= 0
+		}
+	}, nil
+}
+
+// parseArguments parses a parenthesized list of expressions.
+func (p *parser) parseArguments() ([]expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []expr
+	for p.peek() != ")" {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek() == "," {
+			p.next()
+		} else if p.peek() != ")" {
+			return nil, fmt.Errorf("expected , or ), got %q", p.peek())
+		}
+	}
+	p.next()
+	return args, nil
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of query")
+	case token == "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case token[0] == '@':
+		value, ok := p.parameters[token]
+		if !ok {
+			return nil, fmt.Errorf("missing parameter %s", token)
+		}
+		return func(map[string]any) any { return value }, nil
+	case token[0] == '\'' || token[0] == '"':
+		value := token[1 : len(token)-1]
+		return func(map[string]any) any { return value }, nil
+	case token[0] == '-' || unicode.IsDigit(rune(token[0])):
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(map[string]any) any { return value }, nil
+	case strings.EqualFold(token, "true"):
+		return func(map[string]any) any { return true }, nil
+	case strings.EqualFold(token, "false"):
+		return func(map[string]any) any { return false }, nil
+	case strings.EqualFold(token, "null"):
+		return func(map[string]any) any { return nil }, nil
+	case token == p.alias:
+		return p.parsePath()
+	case isIdentifier(token) && p.peek() == "(":
+		args, err := p.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		return function(strings.ToUpper(token), args)
+	default:
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+}
+
+// parsePath parses the properties and indexes following the alias.
+func (p *parser) parsePath() (expr, error) {
+	var steps []any
+	for {
+		switch p.peek() {
+		case ".":
+			p.next()
+			name := p.next()
+			if !isIdentifier(name) {
+				return nil, fmt.Errorf("invalid property %q", name)
+			}
+			steps = append(steps, name)
+		case "[":
+			p.next()
+			index := p.next()
+			if n, err := strconv.Atoi(index); err == nil {
+				steps = append(steps, n)
+			} else if index != "" && (index[0] == '"' || index[0] == '\'') {
+				steps = append(steps, index[1:len(index)-1])
+			} else {
+				return nil, fmt.Errorf("invalid index %q", index)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		default:
+			return func(doc map[string]any) any {
+				var value any = doc
+				for _, step := range steps {
+					switch step := step.(type) {
+					case string:
+						object, ok := value.(map[string]any)
+						if !ok {
+							return undefined
+						}
+						if value, ok = object[step]; !ok {
+							return undefined
+						}
+					case int:
+						array, ok := value.([]any)
+						if !ok || step < 0 || step >= len(array) {
+							return undefined
+						}
+						value = array[step]
+					}
+				}
+				return value
+			}, nil
+		}
+	}
+}
+
+// function returns the call of a built-in function.
+func function(name string, args []expr) (expr, error) {
+	arity := map[string][2]int{
+		"IS_DEFINED":     {1, 1},
+		"IS_NULL":        {1, 1},
+		"ARRAY_CONTAINS": {2, 3},
+		"ARRAY_LENGTH":   {1, 1},
+		"STARTSWITH":     {2, 3},
+		"ENDSWITH":       {2, 3},
+		"CONTAINS":       {2, 3},
+		"LOWER":          {1, 1},
+		"UPPER":          {1, 1},
+	}
+	bounds, ok := arity[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported function %s", name)
+	}
+	if len(args) < bounds[0] || len(args) > bounds[1] {
+		return nil, fmt.Errorf("%s takes %d to %d arguments", name, bounds[0], bounds[1])
+	}
+	return func(doc map[string]any) any {
+		values := make([]any, len(args))
+		for i, arg := range args {
+			values[i] = arg(doc)
+		}
+		switch name {
+		case "IS_DEFINED":
+			return values[0] != undefined
+		case "IS_NULL":
+			return values[0] == nil
+		case "ARRAY_CONTAINS":
+			array, ok := values[0].([]any)
+			if !ok {
+				return undefined
+			}
+			partial := len(values) == 3 && values[2] == true
+			for _, item := range array {
+				if equal(item, values[1]) == true || partial && containsObject(item, values[1]) {
+					return true
+				}
+			}
+			return false
+		case "ARRAY_LENGTH":
+			array, ok := values[0].([]any)
+			if !ok {
+				return undefined
+			}
+			return float64(len(array))
+		case "LOWER", "UPPER":
+			s, ok := values[0].(string)
+			if !ok {
+				return undefined
+			}
+			if name == "LOWER" {
+				return strings.ToLower(s)
+			}
+			return strings.ToUpper(s)
+		}
+		s, ok1 := values[0].(string)
+		sub, ok2 := values[1].(string)
+		if !ok1 || !ok2 {
+			return undefined
+		}
+		if len(values) == 3 && values[2] == true {
+			s, sub = strings.ToLower(s), strings.ToLower(sub)
+		}
+		switch name {
+		case "STARTSWITH":
+			return strings.HasPrefix(s, sub)
+		case "ENDSWITH":
+			return strings.HasSuffix(s, sub)
+		default:
+			return strings.Contains(s, sub)
+		}
+	}, nil
+}
+
+// containsObject reports whether the object has the properties of part.
+func containsObject(object any, part any) bool {
+	o, ok1 := object.(map[string]any)
+	p, ok2 := part.(map[string]any)
+	if !ok1 || !ok2 {
+		return false
+	}
+	for k, v := range p {
+		if equal(o[k], v) != true {
+			return false
+		}
+	}
+	return true
+}
+
+// equal compares two values: values of different types are not equal, and
+// comparisons with undefined are undefined.
+func equal(x any, y any) any {
+	if x == undefined || y == undefined {
+		return undefined
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// typeOrder ranks the types of values in the order of Cosmos.
+func typeOrder(v any) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	case []any:
+		return 4
+	case map[string]any:
+		return 5
+	default:
+		return -1
+	}
+}
+
+// compare orders two values of the same scalar type.
+func compare(x any, y any) (int, bool) {
+	switch x := x.(type) {
+	case float64:
+		if y, ok := y.(float64); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if y, ok := y.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := y.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case !x:
+				return -1, true
+			}
+			return 1, true
+		}
+	case nil:
+		if y == nil {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// matches reports whether the document satisfies the condition of the query.
+func (q *query) matches(doc map[string]any) bool {
+	return q.where == nil || q.where(doc) == true
+}
+
+// order returns the indexes of the documents in the order of the ORDER BY
+// clause. Undefined values come first, then values ordered by type.
+func (q *query) order(docs []map[string]any) []int {
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		for _, o := range q.orderBy {
+			x, y := o.value(docs[order[i]]), o.value(docs[order[j]])
+			c := typeOrder(x) - typeOrder(y)
+			if c == 0 {
+				c, _ = compare(x, y)
+			}
+			if c != 0 {
+				return (c < 0) != o.descending
+			}
+		}
+		return false
+	})
+	return order
+}
+
+// result returns the result of the query for the document, false if it has
+// none: SELECT VALUE skips the undefined values.
+func (q *query) result(doc map[string]any) (any, bool) {
+	if q.value != nil {
+		value := q.value(doc)
+		return value, value != undefined
+	}
+	return q.project(doc), true
+}
+
+// project returns the result of the query for the document.
+func (q *query) project(doc map[string]any) map[string]any {
+	if q.projection == nil {
+		return doc
+	}
+	result := map[string]any{}
+	for _, p := range q.projection {
+		if value := p.value(doc); value != undefined {
+			result[p.name] = value
+		}
+	}
+	return result
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(r == '_' || unicode.IsLetter(r) || i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenize splits the query into identifiers, parameters, literals and
+// operators.
+func tokenize(text string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(text[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in %q", text)
+			}
+			tokens = append(tokens, text[i:i+end+2])
+			i += end + 2
+		case c == '!' || c == '<' || c == '>':
+			if i+1 < len(text) && (text[i+1] == '=' || c == '<' && text[i+1] == '>') {
+				tokens = append(tokens, text[i:i+2])
+				i += 2
+			} else if c == '!' {
+				return nil, fmt.Errorf("unexpected ! in %q", text)
+			} else {
+				tokens = append(tokens, text[i:i+1])
+				i++
+			}
+		case strings.IndexByte("()[],.*=", c) >= 0:
+			tokens = append(tokens, text[i:i+1])
+			i++
+		default:
+			start := i
+			if c == '@' || c == '-' {
+				i++
+			}
+			for i < len(text) && (text[i] == '_' || unicode.IsLetter(rune(text[i])) || unicode.IsDigit(rune(text[i])) ||
+				text[i] == '.' && start < i && unicode.IsDigit(rune(text[start])) && unicode.IsDigit(rune(text[i-1]))) {
+				i++
+			}
+			if i == start || i == start+1 && (c == '@' || c == '-') {
+				return nil, fmt.Errorf("unexpected %q in %q", c, text)
+			}
+			tokens = append(tokens, text[start:i])
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,75 @@
+package cosmosadaptertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+
+	cosmosadapter "github.com/rickdana/cosmos-casbin-adapter"
+)
+
+// SeedOptions configures RandomPolicies and SeedRandomPolicies.
+type SeedOptions struct {
+	// Seed makes the generated rules reproducible.
+	Seed int64
+	// Domains, if set, spreads the rules over this many domains, "domain0" to
+	// "domainN", with the domain as the second value of the "p" rules and the
+	// third of the "g" rules, as in rbac_with_domains_model.conf.
+	Domains int
+	// ImportOptions configures the import of the rules; ImportReplace removes
+	// the rules seeded before.
+	cosmosadapter.ImportOptions
+}
+
+// RandomPolicies returns n distinct random "p" rules such as
+// {"user12", "data3", "read"}, of n/10+1 users, 100 objects and the actions
+// read and write, and n/10 "g" rules assigning the first users one of 10 roles,
+// such as {"user0", "role4"}.
+func RandomPolicies(n int, options SeedOptions) (policies [][]string, groupings [][]string) {
+	rng := rand.New(rand.NewSource(options.Seed))
+	users := n/10 + 1
+	domain := func() []string {
+		if options.Domains <= 0 {
+			return nil
+		}
+		return []string{fmt.Sprintf("domain%d", rng.Intn(options.Domains))}
+	}
+
+	seen := make(map[string]bool, n)
+	for len(policies) < n {
+		user := fmt.Sprintf("user%d", rng.Intn(users))
+		rule := append(append([]string{user}, domain()...), fmt.Sprintf("data%d", rng.Intn(100)), []string{"read", "write"}[rng.Intn(2)])
+		key := fmt.Sprint(rule)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		policies = append(policies, rule)
+	}
+	for i := 0; i < n/10; i++ {
+		groupings = append(groupings, append([]string{fmt.Sprintf("user%d", i), fmt.Sprintf("role%d", rng.Intn(10))}, domain()...))
+	}
+	return policies, groupings
+}
+
+// SeedRandomPolicies writes the rules of RandomPolicies with the bulk import
+// of the adapter, in transactional batches on Cosmos, to set up benchmarks and
+// load tests. It returns the number of rules written.
+func SeedRandomPolicies(ctx context.Context, a *cosmosadapter.Adapter, n int, options SeedOptions) (int, error) {
+	policies, groupings := RandomPolicies(n, options)
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for _, rule := range policies {
+		w.Write(append([]string{"p"}, rule...))
+	}
+	for _, rule := range groupings {
+		w.Write(append([]string{"g"}, rule...))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return 0, err
+	}
+	return a.ImportCSV(ctx, &buf, options.ImportOptions)
+}
@@ -0,0 +1,240 @@
+package cosmosadapter
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// accountKey is the account key the requests are signed with, replaced by
+// SetAccountKey or fetched again from Options.AccountKeySource.
+type accountKey struct {
+	key atomic.Pointer[[]byte]
+	// rotated is set once the key differs from the one the azcosmos clients
+	// were created with, which they keep signing with.
+	rotated atomic.Bool
+	source  func(ctx context.Context) (string, error)
+	// mu serializes the fetches from source.
+	mu sync.Mutex
+}
+
+func newAccountKey(key []byte, source func(ctx context.Context) (string, error)) *accountKey {
+	k := &accountKey{source: source}
+	k.key.Store(&key)
+	return k
+}
+
+func (k *accountKey) get() []byte {
+	return *k.key.Load()
+}
+
+// set replaces the key with a base64 encoded key.
+func (k *accountKey) set(key string) error {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("decode account key: %w", err)
+	}
+	if len(decoded) == 0 {
+		return errors.New("empty account key")
+	}
+	k.key.Store(&decoded)
+	k.rotated.Store(true)
+	return nil
+}
+
+// refresh fetches the key from Options.AccountKeySource once Cosmos rejected a
+// request signed with stale, unless another request fetched it meanwhile. It
+// reports whether the key changed.
+func (k *accountKey) refresh(ctx context.Context, stale []byte) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !bytes.Equal(k.get(), stale) {
+		return true, nil
+	}
+	key, err := k.source(ctx)
+	if err != nil {
+		return false, fmt.Errorf("fetching the account key: %w", err)
+	}
+	if err := k.set(key); err != nil {
+		return false, err
+	}
+	return !bytes.Equal(k.get(), stale), nil
+}
+
+// masterKeyAuthorization returns the authorization header of a request signed
+// with the account key.
+func masterKeyAuthorization(key []byte, method string, resourceType string, resourceLink string, date string) string {
+	stringToSign := strings.ToLower(method) + "\n" + strings.ToLower(resourceType) + "\n" + resourceLink + "\n" + strings.ToLower(date) + "\n\n"
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	return url.QueryEscape("type=master&ver=1.0&sig=" + signature)
+}
+
+// signedResource returns the resource type and link a request to the path is
+// signed over: "docs" and "dbs/db/colls/c/docs/id" for the document
+// /dbs/db/colls/c/docs/id, and "docs" and "dbs/db/colls/c" for the feed
+// /dbs/db/colls/c/docs. Offers are addressed by their lowercased resource ID.
+func signedResource(path string) (resourceType string, resourceLink string) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		// the database account
+		return "", ""
+	}
+	segments := strings.Split(path, "/")
+	if len(segments)%2 == 1 {
+		return segments[len(segments)-1], strings.Join(segments[:len(segments)-1], "/")
+	}
+	resourceType = segments[len(segments)-2]
+	if resourceType == "offers" {
+		return resourceType, strings.ToLower(segments[len(segments)-1])
+	}
+	return resourceType, path
+}
+
+// keyPolicy is a pipeline policy signing the requests of the azcosmos clients
+// with the current account key. The clients copy the key they are created
+// with, so once it is replaced the requests they signed are signed again. A
+// request rejected as unauthorized is retried once with the key fetched from
+// Options.AccountKeySource.
+type keyPolicy struct {
+	key *accountKey
+}
+
+func (p keyPolicy) Do(req *policy.Request) (*http.Response, error) {
+	key := p.key.get()
+	if p.key.rotated.Load() {
+		p.sign(req, key)
+	}
+	res, err := req.Next()
+	if err != nil || res.StatusCode != http.StatusUnauthorized || p.key.source == nil {
+		return res, err
+	}
+	changed, refreshErr := p.key.refresh(req.Raw().Context(), key)
+	if refreshErr != nil || !changed {
+		// the response tells why the request was rejected
+		return res, nil
+	}
+	if err := req.RewindBody(); err != nil {
+		return res, nil
+	}
+	res.Body.Close()
+	p.sign(req, p.key.get())
+	return req.Next()
+}
+
+func (p keyPolicy) sign(req *policy.Request, key []byte) {
+	raw := req.Raw()
+	resourceType, resourceLink := signedResource(raw.URL.Path)
+	raw.Header.Set("authorization", masterKeyAuthorization(key, raw.Method, resourceType, resourceLink, raw.Header.Get("x-ms-date")))
+}
+
+// rotatingCredential is the token credential of an adapter, replaced by
+// SetCredential.
+type rotatingCredential struct {
+	credential atomic.Pointer[azcore.TokenCredential]
+	// replaced is set once the credential differs from the one the tokens
+	// cached by the azcosmos clients were requested from.
+	replaced atomic.Bool
+	// scopes are the scopes the azcosmos clients request the tokens for.
+	scopes atomic.Pointer[[]string]
+}
+
+func newRotatingCredential(credential azcore.TokenCredential) *rotatingCredential {
+	c := &rotatingCredential{}
+	c.credential.Store(&credential)
+	return c
+}
+
+func (c *rotatingCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if options.Scopes != nil {
+		c.scopes.CompareAndSwap(nil, &options.Scopes)
+	}
+	return (*c.credential.Load()).GetToken(ctx, options)
+}
+
+func (c *rotatingCredential) set(credential azcore.TokenCredential) {
+	c.credential.Store(&credential)
+	c.replaced.Store(true)
+}
+
+// tokenPolicy is a pipeline policy authorizing the requests of the azcosmos
+// clients with a token of the current credential. The clients cache the
+// tokens until they expire, so once the credential is replaced the requests
+// they authorized are authorized again. The credential caches the tokens as
+// well, such as the azidentity credentials do.
+type tokenPolicy struct {
+	credential *rotatingCredential
+}
+
+func (p tokenPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if scopes := p.credential.scopes.Load(); scopes != nil && p.credential.replaced.Load() {
+		token, err := p.credential.GetToken(req.Raw().Context(), policy.TokenRequestOptions{Scopes: *scopes})
+		if err != nil {
+			return nil, err
+		}
+		req.Raw().Header.Set("authorization", "type=aad&ver=1.0&sig="+token.Token)
+	}
+	return req.Next()
+}
+
+// withAuthorizationPolicy returns the client options authorizing the requests
+// with the current account key or credential, keyPolicy or tokenPolicy. It
+// runs first after the authorization policy of the azcosmos client.
+func withAuthorizationPolicy(clientOptions *azcosmos.ClientOptions, authorization policy.Policy) *azcosmos.ClientOptions {
+	clientOptions.PerRetryPolicies = append([]policy.Policy{authorization}, clientOptions.PerRetryPolicies...)
+	return clientOptions
+}
+
+// tokenCredential returns the credential of an adapter created with
+// NewAdapterFromCredential.
+func (c *restClient) tokenCredential() (*rotatingCredential, bool) {
+	if c == nil {
+		return nil, false
+	}
+	rotating, ok := c.credential.(*rotatingCredential)
+	return rotating, ok
+}
+
+// SetAccountKey replaces the base64 account key the requests are signed with,
+// such as the secondary key while the primary key is regenerated, for adapters
+// created with NewAdapterFromConnectionString. The requests in flight are not
+// interrupted: those sent already complete with the previous key, and their
+// retries are signed with the new one.
+func (a *Adapter) SetAccountKey(key string) error {
+	if a.rest == nil || a.rest.key == nil {
+		return errors.New("SetAccountKey requires an adapter created with NewAdapterFromConnectionString")
+	}
+	if err := a.rest.key.set(key); err != nil {
+		return err
+	}
+	a.logger.Info("replaced the cosmos account key")
+	return nil
+}
+
+// SetCredential replaces the token credential of an adapter created with
+// NewAdapterFromCredential, such as to switch to another managed identity. The
+// requests in flight are not interrupted: the tokens are requested from the new
+// credential by the next requests and retries.
+func (a *Adapter) SetCredential(credential azcore.TokenCredential) error {
+	rotating, ok := a.rest.tokenCredential()
+	if !ok {
+		return errors.New("SetCredential requires an adapter created with NewAdapterFromCredential")
+	}
+	rotating.set(credential)
+	a.logger.Info("replaced the cosmos credential")
+	return nil
+}
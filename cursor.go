@@ -0,0 +1,125 @@
+package cosmosadapter
+
+import (
+	"context"
+	"sort"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// LoadCursor is the position of a resumable load started with LoadPolicyPages.
+// The zero value starts a load from the beginning. A cursor can be marshalled to
+// JSON and stored, so an interrupted load can continue where it stopped.
+type LoadCursor struct {
+	// PType is the policy type (partition) currently being read.
+	PType string `json:"pType,omitempty"`
+	// ContinuationToken is the Cosmos continuation token within PType.
+	ContinuationToken string `json:"continuationToken,omitempty"`
+	// Watermark is the _ts from which LoadPolicyDelta reads the documents
+	// modified once the load is done: the time the load started, less a
+	// margin, so the rules written while the pages are read are not missed.
+	Watermark int64 `json:"watermark,omitempty"`
+	// Version is the policy version read when the load started.
+	Version int64 `json:"version,omitempty"`
+	// Generation is the generation of the rules read, with
+	// Options.GenerationalSave.
+	Generation int64 `json:"generation,omitempty"`
+	// Done is true once every policy type has been read completely.
+	Done bool `json:"done,omitempty"`
+}
+
+// LoadPolicyPages loads at most maxPages query pages of policy into the model,
+// starting at cursor, and returns the cursor to pass to the next call. The model
+// must not be cleared between calls. Once the returned cursor is Done the model
+// holds the full policy, exactly as after LoadPolicy, and the watermark used by
+// LoadPolicyDelta is set. A maxPages of zero or less reads until done.
+func (a *Adapter) LoadPolicyPages(model model.Model, cursor LoadCursor, maxPages int) (_ LoadCursor, err error) {
+	return a.loadPolicyPages(context.Background(), model, cursor, maxPages)
+}
+
+func (a *Adapter) loadPolicyPages(ctx context.Context, model model.Model, cursor LoadCursor, maxPages int) (_ LoadCursor, err error) {
+	ctx, op := a.startOperation(ctx, "LoadPolicyPages")
+	defer func() { err = a.endOperation(op, err) }()
+	op.loading = true
+	if cursor.Done {
+		return cursor, nil
+	}
+	a.filtered.Store(false)
+
+	if cursor.PType == "" && cursor.ContinuationToken == "" && cursor.Watermark == 0 {
+		current, watermark, err := a.readLoadVersion(ctx, a.containerClient)
+		if err != nil {
+			return cursor, err
+		}
+		cursor.Version, cursor.Watermark = current.Version, watermark
+		cursor.Generation = a.generation.Load()
+	} else if a.generation.Load() != cursor.Generation {
+		// the rules read so far were replaced
+		return cursor, errGenerationChanged
+	}
+
+	ptypes := policyTypes(model)
+	sort.Strings(ptypes)
+
+	start := 0
+	if cursor.PType != "" {
+		start = sort.SearchStrings(ptypes, cursor.PType)
+	}
+
+	pages := 0
+	for i := start; i < len(ptypes); i++ {
+		ptype := ptypes[i]
+		if ptype != cursor.PType {
+			cursor.PType = ptype
+			cursor.ContinuationToken = ""
+		}
+
+		for {
+			if maxPages > 0 && pages >= maxPages {
+				return cursor, nil
+			}
+			queryOptions := &azcosmos.QueryOptions{}
+			if cursor.ContinuationToken != "" {
+				queryOptions.ContinuationToken = &cursor.ContinuationToken
+			}
+			query, parameters := a.inNamespace(a.selectRules(), nil)
+			query, parameters, pk := a.inPolicyType(query, parameters, ptype)
+			queryOptions.QueryParameters = parameters
+			queryPager := a.containerFor(ptype).NewQueryItemsPager(query, pk, queryOptions)
+			res, err := nextPage(ctx, queryPager)
+			if err != nil {
+				return cursor, err
+			}
+			pages++
+			op.query(query, ptype, parameters, cursor.ContinuationToken, res)
+
+			for _, item := range res.Items {
+				line, ok, err := a.decodeRule(ctx, item)
+				if err != nil {
+					return cursor, err
+				}
+				if !ok || a.inOtherGeneration(line) {
+					continue
+				}
+				if err := a.loadPolicyLine(ctx, line, model); err != nil {
+					return cursor, err
+				}
+			}
+
+			if res.ContinuationToken == nil || *res.ContinuationToken == "" {
+				cursor.ContinuationToken = ""
+				break
+			}
+			cursor.ContinuationToken = *res.ContinuationToken
+		}
+	}
+
+	cursor.PType = ""
+	cursor.Done = true
+	a.watermark.Store(cursor.Watermark)
+	a.loaded.Store(true)
+	a.version.Store(cursor.Version)
+	a.loadedGeneration.Store(cursor.Generation)
+	return cursor, nil
+}
@@ -0,0 +1,159 @@
+package cosmosadapter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+)
+
+// PolicySource reads a set of rules to compare with Diff.
+type PolicySource func(ctx context.Context) ([]DumpRecord, error)
+
+// LiveSource returns the source of the stored rules of the adapter.
+func (a *Adapter) LiveSource() PolicySource {
+	return func(ctx context.Context) ([]DumpRecord, error) {
+		var records []DumpRecord
+		err := a.scan(ctx, nil, 0, func(line CasbinRule) error {
+			records = append(records, DumpRecord{PType: line.PType, Rule: policyTokens(line)})
+			return nil
+		})
+		return records, err
+	}
+}
+
+// SnapshotSource returns the source of the rules of the snapshot of the
+// version, see SnapshotPolicy.
+func (a *Adapter) SnapshotSource(version int64) PolicySource {
+	return func(ctx context.Context) ([]DumpRecord, error) {
+		if a.snapshotClient == nil {
+			return nil, fmt.Errorf("snapshots require Options.SnapshotContainerName")
+		}
+		return a.readSnapshot(ctx, version)
+	}
+}
+
+// CSVSource returns the source of the rules of a policy CSV, in the format of
+// the casbin file adapter. The reader is read once.
+func CSVSource(r io.Reader) PolicySource {
+	return func(ctx context.Context) ([]DumpRecord, error) {
+		var records []DumpRecord
+		err := readCSVRules(r, func(ptype string, rule []string) error {
+			records = append(records, DumpRecord{PType: ptype, Rule: rule})
+			return nil
+		})
+		return records, err
+	}
+}
+
+// ModelSource returns the source of the rules of a model, such as the policy
+// loaded by an enforcer.
+func ModelSource(m model.Model) PolicySource {
+	return func(ctx context.Context) ([]DumpRecord, error) {
+		var records []DumpRecord
+		for _, sec := range []string{"p", "g"} {
+			for ptype, ast := range m[sec] {
+				for _, rule := range ast.Policy {
+					records = append(records, DumpRecord{PType: ptype, Rule: slices.Clone(rule)})
+				}
+			}
+		}
+		return records, nil
+	}
+}
+
+// PolicyDiff is the difference between two sets of rules, sorted.
+type PolicyDiff struct {
+	// Added are the rules of the second set that are not in the first.
+	Added []DumpRecord
+	// Removed are the rules of the first set that are not in the second.
+	Removed []DumpRecord
+	// Changed pairs a removed rule with the added rule that only differs from
+	// it by the last value, such as the action or the effect, when there is a
+	// single such rule. Changed rules are not listed as added or removed.
+	Changed []RuleChange
+}
+
+// RuleChange is a rule of the first set replaced by a rule of the second.
+type RuleChange struct {
+	From DumpRecord
+	To   DumpRecord
+}
+
+// Empty reports whether the sets of rules are the same.
+func (d PolicyDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff compares two sets of rules, such as the stored rules of two
+// containers, a policy CSV and the stored rules, or a snapshot and the stored
+// rules, before and after migrations or bulk imports:
+//
+//	diff, err := cosmosadapter.Diff(ctx, a.SnapshotSource(version), a.LiveSource())
+//
+// Duplicate rules are compared once.
+func Diff(ctx context.Context, from PolicySource, to PolicySource) (PolicyDiff, error) {
+	fromRecords, err := from(ctx)
+	if err != nil {
+		return PolicyDiff{}, err
+	}
+	toRecords, err := to(ctx)
+	if err != nil {
+		return PolicyDiff{}, err
+	}
+	return diffRecords(fromRecords, toRecords), nil
+}
+
+// diffRecords returns the difference between two sets of rules.
+func diffRecords(from []DumpRecord, to []DumpRecord) PolicyDiff {
+	toLines := func(records []DumpRecord) []CasbinRule {
+		lines := make([]CasbinRule, len(records))
+		for i, record := range records {
+			lines[i] = CasbinRule{PType: record.PType, Rule: record.Rule}
+		}
+		return lines
+	}
+	removed, added := diffRules(toLines(from), toLines(to))
+
+	// the rules of the same length sharing everything but the last value, a
+	// rule of a single value can't be changed
+	prefix := func(record DumpRecord) string {
+		if len(record.Rule) < 2 {
+			return ""
+		}
+		return fmt.Sprintf("%s\x00%d\x00%s", record.PType, len(record.Rule), strings.Join(record.Rule[:len(record.Rule)-1], "\x00"))
+	}
+	count := func(records []DumpRecord) map[string]int {
+		counts := map[string]int{}
+		for _, record := range records {
+			counts[prefix(record)]++
+		}
+		return counts
+	}
+	removedCounts, addedCounts := count(removed), count(added)
+	addedBy := map[string]DumpRecord{}
+	for _, record := range added {
+		addedBy[prefix(record)] = record
+	}
+
+	var diff PolicyDiff
+	changed := map[string]bool{}
+	for _, record := range removed {
+		k := prefix(record)
+		if k != "" && removedCounts[k] == 1 && addedCounts[k] == 1 {
+			changed[k] = true
+			diff.Changed = append(diff.Changed, RuleChange{From: record, To: addedBy[k]})
+			continue
+		}
+		diff.Removed = append(diff.Removed, record)
+	}
+	for _, record := range added {
+		if !changed[prefix(record)] {
+			diff.Added = append(diff.Added, record)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,87 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// DryWrite is a write skipped by an adapter with Options.DryRun.
+type DryWrite struct {
+	// Operation is the adapter operation making the write, such as
+	// "AddPolicy".
+	Operation string
+	// Container is the name of the container written to.
+	Container string
+	// Method is "create", "upsert", "replace", "patch" or "delete".
+	Method       string
+	PartitionKey azcosmos.PartitionKey
+	// ID is the ID of the written document.
+	ID string
+	// Document is the JSON of the written document, or of the patch
+	// operations for patches, nil for deletes.
+	Document []byte
+}
+
+// dryRunContainer reports the writes to a container of an adapter with
+// Options.DryRun instead of making them. The reads and queries are made, so
+// the operations compute their writes from the stored rules.
+type dryRunContainer struct {
+	Container
+	name    string
+	adapter *Adapter
+}
+
+// write reports a skipped write, and returns the response of a successful one.
+func (c dryRunContainer) write(ctx context.Context, method string, status int, partitionKey azcosmos.PartitionKey, id string, document []byte) (azcosmos.ItemResponse, error) {
+	if id == "" {
+		var doc struct {
+			ID string `json:"id"`
+		}
+		_ = json.Unmarshal(document, &doc)
+		id = doc.ID
+	}
+	w := DryWrite{Container: c.name, Method: method, PartitionKey: partitionKey, ID: id, Document: document}
+	if op := operationFrom(ctx); op != nil {
+		w.Operation = op.name
+	}
+	c.adapter.logger.Info("dry run write", "operation", w.Operation, "container", w.Container, "method", w.Method, "id", w.ID, "document", string(w.Document))
+	if c.adapter.onDryRun != nil {
+		c.adapter.onDryRun(w)
+	}
+	return azcosmos.ItemResponse{Value: document, Response: azcosmos.Response{RawResponse: &http.Response{StatusCode: status, Header: http.Header{}}}}, nil
+}
+
+func (c dryRunContainer) CreateItem(ctx context.Context, partitionKey azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.write(ctx, "create", http.StatusCreated, partitionKey, "", item)
+}
+
+func (c dryRunContainer) UpsertItem(ctx context.Context, partitionKey azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.write(ctx, "upsert", http.StatusOK, partitionKey, "", item)
+}
+
+func (c dryRunContainer) ReplaceItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.write(ctx, "replace", http.StatusOK, partitionKey, itemID, item)
+}
+
+func (c dryRunContainer) PatchItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, ops azcosmos.PatchOperations, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	document, err := json.Marshal(ops)
+	if err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.write(ctx, "patch", http.StatusOK, partitionKey, itemID, document)
+}
+
+func (c dryRunContainer) DeleteItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	return c.write(ctx, "delete", http.StatusNoContent, partitionKey, itemID, nil)
+}
+
+// withDryRun wraps the container when the adapter has Options.DryRun.
+func (a *Adapter) withDryRun(name string, container Container) Container {
+	if !a.dryRun {
+		return container
+	}
+	return dryRunContainer{Container: container, name: name, adapter: a}
+}
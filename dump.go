@@ -0,0 +1,307 @@
+package cosmosadapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// DumpFormat is the output format of Dump.
+type DumpFormat int
+
+const (
+	// DumpCSV writes rules the way the casbin file adapter reads them, one
+	// rule per line starting with its policy type: p,alice,data1,read
+	DumpCSV DumpFormat = iota
+	// DumpJSONL writes one JSON object per line: {"pType":"p","rule":["alice","data1","read"]}
+	DumpJSONL
+)
+
+// DumpOptions configures Dump.
+type DumpOptions struct {
+	Format DumpFormat
+	// Domains, if set, restricts the dump to the rules of these domains, see
+	// Options.Domains. With PartitionByDomain only their partitions are read.
+	Domains []string
+	// Concurrency is the number of partitions scanned in parallel. Defaults to 4.
+	Concurrency int
+}
+
+// DumpRecord is a rule as written by Dump with DumpJSONL.
+type DumpRecord struct {
+	PType string   `json:"pType"`
+	Rule  []string `json:"rule"`
+}
+
+// dumpScan is a part of a rules container scanned by Dump.
+type dumpScan struct {
+	container Container
+	feedRange *azcosmos.FeedRange
+	pk        *azcosmos.PartitionKey
+}
+
+// Dump streams every rule of every rules container to w, for compliance
+// exports and backups, without loading them into a model. The partitions are
+// scanned in parallel through the change feed, which returns the current
+// version of every document, so rules are written in no particular order.
+// It returns the number of rules written.
+func (a *Adapter) Dump(ctx context.Context, w io.Writer, options DumpOptions) (n int, err error) {
+	ctx, op := a.startOperation(ctx, "Dump")
+	defer func() { err = a.endOperation(op, err) }()
+	write, flush, err := dumpWriter(w, options.Format)
+	if err != nil {
+		return 0, err
+	}
+	err = a.scan(ctx, options.Domains, options.Concurrency, func(line CasbinRule) error {
+		if err := write(line); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, flush()
+}
+
+// ExportDocuments streams the stored documents of every rules container to w
+// as JSON lines, exactly as stored: with their IDs, the tombstones and the
+// metadata fields, such as namespace or expiresAt, and the system fields of
+// Cosmos, such as _ts. The policy version document is not exported. Write them
+// back with ImportDocuments. It returns the number of documents written.
+func (a *Adapter) ExportDocuments(ctx context.Context, w io.Writer) (n int, err error) {
+	ctx, op := a.startOperation(ctx, "ExportDocuments")
+	defer func() { err = a.endOperation(op, err) }()
+	return a.exportDocuments(ctx, w)
+}
+
+func (a *Adapter) exportDocuments(ctx context.Context, w io.Writer) (n int, err error) {
+	var buf bytes.Buffer
+	err = a.scanDocuments(ctx, nil, 0, true, func(item scannedRule) error {
+		buf.Reset()
+		if err := json.Compact(&buf, item.document); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// scan calls visit with every stored rule, of the domains if any are given,
+// scanning up to concurrency partitions in parallel. visit is not called
+// concurrently. Scanning stops at the first error.
+func (a *Adapter) scan(ctx context.Context, domains []string, concurrency int, visit func(CasbinRule) error) error {
+	return a.scanDocuments(ctx, domains, concurrency, false, func(item scannedRule) error {
+		return visit(item.line)
+	})
+}
+
+// scannedRule is a rule read by a scan, with its stored document.
+type scannedRule struct {
+	line     CasbinRule
+	document []byte
+}
+
+// scanDocuments is scan, passing the stored documents along. With all, the
+// tombstones and expired temporary rules are visited as well.
+func (a *Adapter) scanDocuments(ctx context.Context, domains []string, concurrency int, all bool, visit func(scannedRule) error) error {
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	inDomains := map[string]bool{}
+	for _, domain := range domains {
+		inDomains[domain] = true
+	}
+
+	scans, err := a.dumpScans(ctx, domains)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lines := make(chan scannedRule)
+	errs := make(chan error, len(scans))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, scan := range scans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := a.dumpScan(ctx, scan, lines); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	now := a.now()
+	for item := range lines {
+		line := item.line
+		if err != nil || a.inOtherNamespace(line) || a.inOtherGeneration(line) || !all && (line.Deleted || line.expired(now)) {
+			continue
+		}
+		if len(inDomains) > 0 && !inDomains[lineDomain(line)] {
+			continue
+		}
+		if err = visit(item); err != nil {
+			cancel()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// dumpScans returns the parts of the rules containers to scan: the partitions
+// of the domains with PartitionByDomain, every feed range otherwise.
+func (a *Adapter) dumpScans(ctx context.Context, domains []string) ([]dumpScan, error) {
+	var scans []dumpScan
+	for _, name := range a.ruleContainerNames() {
+		container := a.containers[name]
+		if a.partitionStrategy == PartitionByDomain && len(domains) > 0 {
+			for _, domain := range domains {
+				pk := azcosmos.NewPartitionKeyString(domain)
+				scans = append(scans, dumpScan{container: container, pk: &pk})
+			}
+			continue
+		}
+		if cosmosContainer(container) == nil {
+			// other containers have no change feed, they are queried
+			scans = append(scans, dumpScan{container: container})
+			continue
+		}
+		ranges, err := cosmosContainer(container).ReadFeedRanges(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		for i := range ranges {
+			scans = append(scans, dumpScan{container: container, feedRange: &ranges[i]})
+		}
+	}
+	return scans, nil
+}
+
+// dumpScan reads the change feed of the scan from the beginning until it is
+// drained, sending the rules to lines.
+func (a *Adapter) dumpScan(ctx context.Context, scan dumpScan, lines chan<- scannedRule) error {
+	container := cosmosContainer(scan.container)
+	if container == nil {
+		return a.queryScan(ctx, scan, lines)
+	}
+	options := &azcosmos.ChangeFeedOptions{FeedRange: scan.feedRange, PartitionKey: scan.pk}
+	for {
+		res, err := container.ReadChangeFeed(ctx, options)
+		if err != nil {
+			return err
+		}
+		operationFrom(ctx).record(res.Response, len(res.Items))
+		if res.Count == 0 || len(res.Items) == 0 {
+			return nil
+		}
+		if err := a.sendScanned(ctx, res.Items, lines); err != nil {
+			return err
+		}
+		continuation := res.ContinuationToken
+		options.Continuation = &continuation
+	}
+}
+
+// queryScan reads every document of the scan with a query, for the containers
+// without a change feed.
+func (a *Adapter) queryScan(ctx context.Context, scan dumpScan, lines chan<- scannedRule) error {
+	pk := azcosmos.NewPartitionKey()
+	if scan.pk != nil {
+		pk = *scan.pk
+	}
+	queryPager := scan.container.NewQueryItemsPager("SELECT * FROM c", pk, nil)
+	for queryPager.More() {
+		res, err := nextPage(ctx, queryPager)
+		if err != nil {
+			return err
+		}
+		operationFrom(ctx).query("SELECT * FROM c", "", nil, "", res)
+		if err := a.sendScanned(ctx, res.Items, lines); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendScanned sends the rules of the scanned documents to lines, skipping the
+// policy version documents.
+func (a *Adapter) sendScanned(ctx context.Context, items [][]byte, lines chan<- scannedRule) error {
+	for _, item := range items {
+		var meta struct {
+			PType string `json:"pType"`
+		}
+		if err := json.Unmarshal(item, &meta); err != nil {
+			return err
+		}
+		if meta.PType == policyVersionID {
+			continue
+		}
+		line, err := a.mapper.FromDocument(item)
+		if err != nil {
+			return err
+		}
+		if line.PType == "" {
+			continue
+		}
+		select {
+		case lines <- scannedRule{line: line, document: item}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// dumpWriter returns the functions writing a rule to w in the format, and
+// flushing what was written.
+func dumpWriter(w io.Writer, format DumpFormat) (func(CasbinRule) error, func() error, error) {
+	switch format {
+	case DumpJSONL:
+		encoder := json.NewEncoder(w)
+		write := func(line CasbinRule) error {
+			return encoder.Encode(DumpRecord{PType: line.PType, Rule: policyTokens(line)})
+		}
+		return write, func() error { return nil }, nil
+	case DumpCSV:
+		writer := csv.NewWriter(w)
+		write := func(line CasbinRule) error {
+			return writer.Write(append([]string{line.PType}, policyTokens(line)...))
+		}
+		flush := func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+		return write, flush, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown dump format %d", format)
+	}
+}
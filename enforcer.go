@@ -0,0 +1,135 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// EnforcerConfig configures NewEnforcerWithCosmos.
+type EnforcerConfig struct {
+	// ModelPath is the path of the model file. ModelText is used when it is empty.
+	ModelPath string
+	// ModelText is the model definition itself.
+	ModelText string
+
+	// Client is used to connect to Cosmos when set. Otherwise ConnectionString
+	// is used, or Endpoint with Credential.
+	Client           *azcosmos.Client
+	ConnectionString string
+	Endpoint         string
+	Credential       azcore.TokenCredential
+	// Options are passed to the adapter. An empty DatabaseName or
+	// ContainerName defaults to "casbin" or "casbin_rule".
+	Options Options
+
+	// Synced selects a casbin.SyncedEnforcer (or SyncedCachedEnforcer), safe for
+	// concurrent use.
+	Synced bool
+	// Cache enables caching of enforcement results, with entries expiring after
+	// CacheExpireTime if it is set.
+	Cache           bool
+	CacheExpireTime time.Duration
+
+	// PollingInterval attaches a PollingWatcher polling at this interval when set.
+	PollingInterval time.Duration
+	// ChangeFeed attaches a ChangeFeedProcessor with these options when set.
+	// It takes precedence over PollingInterval.
+	ChangeFeed *ChangeFeedProcessorOptions
+}
+
+// NewEnforcerWithCosmos creates the adapter, the enforcer and the optional
+// watcher described by config, and returns the enforcer with its policy loaded.
+// The adapter is available through e.GetAdapter(). On error, the adapter is
+// closed.
+func NewEnforcerWithCosmos(config EnforcerConfig) (e casbin.IEnforcer, err error) {
+	var m model.Model
+	switch {
+	case config.ModelPath != "":
+		m, err = model.NewModelFromFile(config.ModelPath)
+	case config.ModelText != "":
+		m, err = model.NewModelFromString(config.ModelText)
+	default:
+		err = errors.New("either ModelPath or ModelText is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newConfiguredAdapter(config)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			// stops the watcher as well
+			_ = a.Close(context.Background())
+		}
+	}()
+
+	switch {
+	case config.Synced && config.Cache:
+		var se *casbin.SyncedCachedEnforcer
+		if se, err = casbin.NewSyncedCachedEnforcer(m, a); err == nil && config.CacheExpireTime > 0 {
+			se.SetExpireTime(config.CacheExpireTime)
+		}
+		e = se
+	case config.Cache:
+		var ce *casbin.CachedEnforcer
+		if ce, err = casbin.NewCachedEnforcer(m, a); err == nil && config.CacheExpireTime > 0 {
+			ce.SetExpireTime(config.CacheExpireTime)
+		}
+		e = ce
+	case config.Synced:
+		e, err = casbin.NewSyncedEnforcer(m, a)
+	default:
+		e, err = casbin.NewEnforcer(m, a)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var w persist.Watcher
+	switch {
+	case config.ChangeFeed != nil:
+		w = NewChangeFeedProcessor(a, *config.ChangeFeed)
+	case config.PollingInterval > 0:
+		w = NewPollingWatcher(a, config.PollingInterval)
+	}
+	if w != nil {
+		if err = e.SetWatcher(w); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// newConfiguredAdapter creates the adapter described by config, with the
+// default database and container names, turning the constructor panics into
+// errors.
+func newConfiguredAdapter(config EnforcerConfig) (a *Adapter, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("creating cosmos adapter: %v", r)
+		}
+	}()
+
+	options := newOptions([]Option{config.Options})
+	switch {
+	case config.Client != nil:
+		return NewAdapterFromClient(config.Client, options), nil
+	case config.ConnectionString != "":
+		return newAdapterFromConnectionString(config.ConnectionString, options), nil
+	case config.Endpoint != "" && config.Credential != nil:
+		return newAdapterFromCredential(config.Endpoint, config.Credential, options), nil
+	default:
+		return nil, errors.New("one of Client, ConnectionString or Endpoint with Credential is required")
+	}
+}
@@ -0,0 +1,167 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// Error is returned by adapter operations failing because of a Cosmos
+// request. It carries the diagnostics Azure support asks for, and wraps the
+// *azcore.ResponseError.
+type Error struct {
+	// Operation is the adapter operation, such as "LoadPolicy".
+	Operation string
+	// StatusCode and SubStatusCode are the Cosmos status codes.
+	StatusCode    int
+	SubStatusCode int
+	// ActivityID identifies the failed request.
+	ActivityID string
+	// Endpoint is the account or regional endpoint that served the request.
+	Endpoint string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("cosmosadapter: %s failed (status %d, substatus %d, activity %s, endpoint %s): %v",
+		e.Operation, e.StatusCode, e.SubStatusCode, e.ActivityID, e.Endpoint, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// wrapError wraps err in an *Error if it is a Cosmos response error, and
+// returns it unchanged otherwise.
+func wrapError(operation string, err error) error {
+	var resErr *azcore.ResponseError
+	if !errors.As(err, &resErr) {
+		return err
+	}
+	var wrapped *Error
+	if errors.As(err, &wrapped) {
+		return err
+	}
+
+	e := &Error{Operation: operation, StatusCode: resErr.StatusCode, Err: err}
+	if res := resErr.RawResponse; res != nil {
+		e.SubStatusCode, _ = strconv.Atoi(res.Header.Get("x-ms-substatus"))
+		e.ActivityID = res.Header.Get("x-ms-activity-id")
+		if res.Request != nil {
+			e.Endpoint = res.Request.URL.Host
+		}
+	}
+	return e
+}
+
+// ErrMalformedDocument is matched with errors.Is by the errors reporting
+// documents of the rules containers that are not valid rules.
+var ErrMalformedDocument = errors.New("cosmosadapter: malformed document")
+
+// MalformedDocument is a document of a rules container that is not a valid
+// rule: it can't be decoded, or has no pType or no values.
+type MalformedDocument struct {
+	// ID is the id of the document, empty if it has none.
+	ID  string
+	Err error
+}
+
+// MalformedDocumentsError is returned by the operations reading malformed
+// documents, unless Options.SkipMalformedDocuments is set. Loads read every
+// document before failing, so it lists all of them. It matches
+// ErrMalformedDocument with errors.Is.
+type MalformedDocumentsError struct {
+	Documents []MalformedDocument
+}
+
+func (e *MalformedDocumentsError) Error() string {
+	first := e.Documents[0]
+	msg := fmt.Sprintf("cosmosadapter: malformed document %q: %v", first.ID, first.Err)
+	if len(e.Documents) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.Documents)-1)
+	}
+	return msg
+}
+
+func (e *MalformedDocumentsError) Is(target error) bool {
+	return target == ErrMalformedDocument
+}
+
+// Unwrap returns the errors of the documents.
+func (e *MalformedDocumentsError) Unwrap() []error {
+	errs := make([]error, len(e.Documents))
+	for i, doc := range e.Documents {
+		errs[i] = doc.Err
+	}
+	return errs
+}
+
+// Errors matched by the errors of the rules of batch operations, see
+// RuleError.
+var (
+	// ErrConflict means a document with the ID of the rule exists already.
+	ErrConflict = errors.New("cosmosadapter: conflict")
+	// ErrTooLarge means the document of the rule exceeds the maximum size of
+	// a Cosmos item.
+	ErrTooLarge = errors.New("cosmosadapter: document too large")
+)
+
+// RuleError is the failure of one rule of a batch operation: AddPolicies,
+// RemovePolicies, ImportCSV or ImportDocuments. The other rules of the batch
+// are written, and the operation returns the RuleErrors of the failed rules
+// joined with errors.Join, see FailedRules. Err matches ErrConflict,
+// ErrThrottled, ErrTooLarge or ErrInvalidRule with errors.Is when applicable.
+type RuleError struct {
+	PType string
+	Rule  []string
+	Err   error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("cosmosadapter: rule %s, %s: %v", e.PType, strings.Join(e.Rule, ", "), e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// FailedRules returns the failed rules of the error of a batch operation, so
+// they can be retried. It returns nil if the error has no RuleError, such as
+// an error failing the whole operation.
+func FailedRules(err error) []*RuleError {
+	switch e := err.(type) {
+	case *RuleError:
+		return []*RuleError{e}
+	case interface{ Unwrap() []error }:
+		var failed []*RuleError
+		for _, err := range e.Unwrap() {
+			failed = append(failed, FailedRules(err)...)
+		}
+		return failed
+	case interface{ Unwrap() error }:
+		return FailedRules(e.Unwrap())
+	}
+	return nil
+}
+
+// ruleError returns the failure of a rule of the operation of the context,
+// matching the sentinel error of its status.
+func ruleError(ctx context.Context, ptype string, rule []string, err error) *RuleError {
+	switch {
+	case isStatus(err, http.StatusConflict):
+		err = fmt.Errorf("%w: %w", ErrConflict, err)
+	case isStatus(err, http.StatusTooManyRequests):
+		err = fmt.Errorf("%w: %w", ErrThrottled, err)
+	case isStatus(err, http.StatusRequestEntityTooLarge):
+		err = fmt.Errorf("%w: %w", ErrTooLarge, err)
+	}
+	if op := operationFrom(ctx); op != nil {
+		err = wrapError(op.name, err)
+	}
+	return &RuleError{PType: ptype, Rule: rule, Err: err}
+}
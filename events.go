@@ -0,0 +1,263 @@
+package cosmosadapter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// Operations recorded in policy events.
+const (
+	// EventAdd records a rule being added.
+	EventAdd = "add"
+	// EventRemove records a rule being removed.
+	EventRemove = "remove"
+	// EventClear records every rule of the policy type being removed, which
+	// SavePolicy does before adding the saved rules again.
+	EventClear = "clear"
+)
+
+// PolicyEvent is an immutable record of a policy mutation, written when
+// Options.EventSourcing is enabled.
+type PolicyEvent struct {
+	ID    string   `json:"id"`
+	PType string   `json:"pType"`
+	Op    string   `json:"op"`
+	Rule  []string `json:"rule,omitempty"`
+	// Time is the time of the mutation in nanoseconds since the epoch.
+	Time  int64  `json:"time"`
+	Actor string `json:"actor,omitempty"`
+	// Namespace is the Options.Namespace of the adapter that recorded the event.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// eventContainerName returns the name of the container holding the events.
+func eventContainerName(options Options) string {
+	if options.EventContainerName != "" {
+		return options.EventContainerName
+	}
+	return options.ContainerName + "_events"
+}
+
+// createEventContainerIfNotExist creates the event container, partitioned by
+// policy type like the rules container.
+func (a *Adapter) createEventContainerIfNotExist(ctx context.Context, name string) error {
+	if _, err := a.db.CreateContainer(ctx, partitionedContainer(name, eventPartitionKeyPath), nil); err == nil {
+		a.logger.Info("created cosmos event container", "database", a.databaseName, "container", name)
+	} else if !isStatus(err, http.StatusConflict) {
+		return fmt.Errorf("creating cosmos event container: %w", err)
+	}
+	return nil
+}
+
+func (a *Adapter) newEvent(ctx context.Context, op string, ptype string, rule []string) PolicyEvent {
+	return PolicyEvent{
+		ID:    a.newID(),
+		PType: ptype,
+		Op:    op,
+		Rule:  rule,
+		Time:  a.now().UnixNano(),
+		Actor: a.actorFrom(ctx),
+		// the events container is shared like the rules container
+		Namespace: a.namespace,
+	}
+}
+
+// removeEvents returns the events recording the removal of the rules.
+func (a *Adapter) removeEvents(ctx context.Context, policies []CasbinRule) []PolicyEvent {
+	events := make([]PolicyEvent, len(policies))
+	for i, policy := range policies {
+		events[i] = a.newEvent(ctx, EventRemove, policy.PType, policyTokens(policy))
+	}
+	return events
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("Generating event id caused error: %s", err.Error()))
+	}
+	return hex.EncodeToString(b)
+}
+
+// appendEvents writes the events, when event sourcing is enabled, and their
+// audit records, when auditing is enabled. Events are
+// written before the rules container is changed, so the history never misses
+// a mutation that is visible in the current policy.
+func (a *Adapter) appendEvents(ctx context.Context, events ...PolicyEvent) error {
+	if err := a.appendAudit(ctx, events...); err != nil {
+		return err
+	}
+	if a.eventsClient == nil {
+		return nil
+	}
+	for _, event := range events {
+		marshalled, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		res, err := a.eventsClient.CreateItem(ctx, azcosmos.NewPartitionKeyString(event.PType), marshalled, nil)
+		if err != nil {
+			return err
+		}
+		operationFrom(ctx).record(res.Response, 0)
+	}
+	return nil
+}
+
+// GetEvents returns the events of the policy type recorded in [since, until),
+// oldest first. A zero since or until means no bound.
+func (a *Adapter) GetEvents(ptype string, since time.Time, until time.Time) ([]PolicyEvent, error) {
+	return a.getEvents(context.Background(), ptype, since, until)
+}
+
+// GetEventsContext is GetEvents with a context.
+func (a *Adapter) GetEventsContext(ctx context.Context, ptype string, since time.Time, until time.Time) ([]PolicyEvent, error) {
+	return a.getEvents(ctx, ptype, since, until)
+}
+
+func (a *Adapter) getEvents(ctx context.Context, ptype string, since time.Time, until time.Time) (_ []PolicyEvent, err error) {
+	ctx, op := a.startOperation(ctx, "GetEvents")
+	defer func() { err = a.endOperation(op, err) }()
+	if a.eventsClient == nil {
+		return nil, fmt.Errorf("event sourcing is not enabled")
+	}
+	query, parameters := a.inNamespace(timeRangeQuery(since, until))
+	query += " ORDER BY c.time"
+
+	var events []PolicyEvent
+	queryPager := a.eventsClient.NewQueryItemsPager(query, azcosmos.NewPartitionKeyString(ptype), &azcosmos.QueryOptions{QueryParameters: parameters})
+	for queryPager.More() {
+		res, err := nextPage(ctx, queryPager)
+		if err != nil {
+			return nil, err
+		}
+		operationFrom(ctx).query(query, ptype, parameters, "", res)
+		for _, item := range res.Items {
+			var event PolicyEvent
+			if err := json.Unmarshal(item, &event); err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// timeRangeQuery returns the query of the documents whose time is in
+// [since, until). A zero since or until means no bound.
+func timeRangeQuery(since time.Time, until time.Time) (string, []azcosmos.QueryParameter) {
+	var conditions []string
+	var parameters []azcosmos.QueryParameter
+	if !since.IsZero() {
+		conditions = append(conditions, "c.time >= @since")
+		parameters = append(parameters, azcosmos.QueryParameter{Name: "@since", Value: since.UnixNano()})
+	}
+	if !until.IsZero() {
+		conditions = append(conditions, "c.time < @until")
+		parameters = append(parameters, azcosmos.QueryParameter{Name: "@until", Value: until.UnixNano()})
+	}
+	query := "SELECT * FROM c"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return query, parameters
+}
+
+// ReplayEvents rebuilds the policy from the recorded events into the model,
+// applying every event before until. A zero until replays the full history,
+// which yields the current policy. The model should be empty.
+func (a *Adapter) ReplayEvents(model model.Model, until time.Time) error {
+	return a.replayEvents(context.Background(), model, until)
+}
+
+// ReplayEventsContext is ReplayEvents with a context.
+func (a *Adapter) ReplayEventsContext(ctx context.Context, model model.Model, until time.Time) error {
+	return a.replayEvents(ctx, model, until)
+}
+
+func (a *Adapter) replayEvents(ctx context.Context, model model.Model, until time.Time) (err error) {
+	ctx, op := a.startOperation(ctx, "ReplayEvents")
+	defer func() { err = a.endOperation(op, err) }()
+	for _, ptype := range policyTypes(model) {
+		events, err := a.getEvents(ctx, ptype, time.Time{}, until)
+		if err != nil {
+			return err
+		}
+		applyEvents(model, events)
+	}
+	return nil
+}
+
+// LoadPolicyAsOf loads the policy as it was at t into the model, replacing its
+// rules, to answer questions such as what a user could do last Tuesday. The
+// policy is rebuilt from the recorded history: the events when
+// Options.EventSourcing is enabled, otherwise the audit records when
+// Options.AuditContainerName is set. Mutations made before the history was
+// recorded are missing. The rules of policy types the model does not define
+// are skipped.
+func (a *Adapter) LoadPolicyAsOf(ctx context.Context, model model.Model, t time.Time) (err error) {
+	ctx, op := a.startOperation(ctx, "LoadPolicyAsOf")
+	defer func() { err = a.endOperation(op, err) }()
+
+	// the mutations made at t are part of the policy as of t
+	until := t.Add(time.Nanosecond)
+	var events []PolicyEvent
+	switch {
+	case a.eventsClient != nil:
+		for _, ptype := range policyTypes(model) {
+			ptypeEvents, err := a.getEvents(ctx, ptype, time.Time{}, until)
+			if err != nil {
+				return err
+			}
+			events = append(events, ptypeEvents...)
+		}
+	case a.auditClient != nil:
+		records, err := a.GetAuditRecords(ctx, time.Time{}, until)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			events = append(events, PolicyEvent{PType: record.PType, Op: record.Op, Rule: record.Rule, Time: record.Time})
+		}
+	default:
+		return fmt.Errorf("LoadPolicyAsOf requires Options.EventSourcing or Options.AuditContainerName")
+	}
+
+	model.ClearPolicy()
+	applyEvents(model, events)
+	return nil
+}
+
+// applyEvents applies the events, oldest first, to the model. Events of policy
+// types the model does not define are skipped.
+func applyEvents(model model.Model, events []PolicyEvent) {
+	for _, event := range events {
+		if event.PType == "" {
+			continue
+		}
+		sec := event.PType[:1]
+		if _, ok := model[sec][event.PType]; !ok {
+			continue
+		}
+		switch event.Op {
+		case EventAdd:
+			if !model.HasPolicy(sec, event.PType, event.Rule) {
+				model.AddPolicy(sec, event.PType, event.Rule)
+			}
+		case EventRemove:
+			model.RemovePolicy(sec, event.PType, event.Rule)
+		case EventClear:
+			model[sec][event.PType].Policy = nil
+			model[sec][event.PType].PolicyMap = map[string]int{}
+		}
+	}
+}
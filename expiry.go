@@ -0,0 +1,119 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// expired reports whether the rule has an expiry that has passed. Cosmos
+// deletes expired documents in the background, so they can still be read
+// for a while.
+func (line CasbinRule) expired(now time.Time) bool {
+	return line.ExpiresAt != 0 && line.ExpiresAt <= now.Unix()
+}
+
+// expire sets the expiry of the rule, and the ttl that makes Cosmos delete it.
+func (line *CasbinRule) expire(expiresAt int64, now time.Time) {
+	line.ExpiresAt = expiresAt
+	line.TTL = int(expiresAt - now.Unix())
+	if line.TTL < 1 {
+		line.TTL = 1
+	}
+}
+
+// AddPolicyWithExpiry adds a temporary rule to the storage, which Cosmos
+// deletes at expiresAt. Loads skip the rule once it has expired, even if Cosmos
+// has not deleted it yet. Options.RuleExpiry must be enabled.
+//
+// Like AddPolicy, it only changes the storage: the rule must be added to the
+// enforcer as well, or loaded by the next LoadPolicy. An expired rule stays in
+// an enforcer until the next LoadPolicy.
+func (a *Adapter) AddPolicyWithExpiry(sec string, ptype string, rule []string, expiresAt time.Time) (err error) {
+	return a.addPolicyWithExpiry(context.Background(), sec, ptype, rule, expiresAt)
+}
+
+// AddPolicyWithExpiryContext is AddPolicyWithExpiry with a context.
+func (a *Adapter) AddPolicyWithExpiryContext(ctx context.Context, sec string, ptype string, rule []string, expiresAt time.Time) (err error) {
+	return a.addPolicyWithExpiry(ctx, sec, ptype, rule, expiresAt)
+}
+
+func (a *Adapter) addPolicyWithExpiry(ctx context.Context, sec string, ptype string, rule []string, expiresAt time.Time) (err error) {
+	ctx, op := a.startOperation(ctx, "AddPolicyWithExpiry")
+	defer func() { err = a.endOperation(op, err) }()
+
+	if !a.ruleExpiry {
+		return errors.New("rule expiry requires Options.RuleExpiry")
+	}
+	now := a.now()
+	if !expiresAt.After(now) {
+		return errors.New("expiry is in the past")
+	}
+	m := &Mutation{Operation: op.name, Sec: sec, PType: ptype, Rules: [][]string{rule}, ExpiresAt: expiresAt}
+	if err := a.beforeMutation(ctx, m); err != nil {
+		return err
+	}
+	defer func() { a.afterMutation(ctx, m, err) }()
+	if len(m.Rules) == 0 {
+		return nil
+	}
+	rule = a.normalize(ptype, m.Rules[0])
+	if err := a.validateRule(ptype, rule); err != nil {
+		return err
+	}
+	policy := a.newPolicyLine(ptype, rule)
+	policy.expire(expiresAt.Unix(), now)
+	added, err := a.checkQuota(ctx, policy)
+	if err != nil {
+		return err
+	}
+	if err := a.appendEvents(ctx, a.newEvent(ctx, EventAdd, ptype, rule)); err != nil {
+		return err
+	}
+	if err := a.save(ctx, policy); err != nil {
+		return err
+	}
+	added()
+	return a.policyChanged(ctx, a.ruleChange(policy))
+}
+
+// ensureTTL enables TTL on an existing container, without a default expiry,
+// so the ttl of temporary rules is honoured.
+func (a *Adapter) ensureTTL(ctx context.Context, container *azcosmos.ContainerClient, properties *azcosmos.ContainerProperties) error {
+	if properties == nil || properties.DefaultTimeToLive != nil {
+		return nil
+	}
+	noDefault := int32(-1)
+	properties.DefaultTimeToLive = &noDefault
+	if _, err := container.Replace(ctx, *properties, nil); err != nil {
+		return err
+	}
+	a.logger.Info("enabled ttl on cosmos container", "database", a.databaseName, "container", properties.ID)
+	return nil
+}
+
+// storedExpiries returns the expiry of the temporary rules of the model's
+// policy types, by document ID, so SavePolicy doesn't make them permanent. The
+// generation is selected for the rules of the current one to be kept.
+func (a *Adapter) storedExpiries(ctx context.Context, model model.Model) (map[string]int64, error) {
+	expiries := map[string]int64{}
+	if !a.ruleExpiry {
+		return expiries, nil
+	}
+	for _, ptype := range policyTypes(model) {
+		query, parameters := a.inNamespace("SELECT c.id, c.pType, c.partitionKey, c.namespace, c.generation, c.expiresAt FROM c WHERE IS_DEFINED(c.expiresAt)", nil)
+		lines, err := a.query(ctx, query, ptype, parameters)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			if line.ExpiresAt != 0 {
+				expiries[line.ID] = line.ExpiresAt
+			}
+		}
+	}
+	return expiries, nil
+}
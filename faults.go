@@ -0,0 +1,263 @@
+package cosmosadapter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// Fault is a failure injected into the requests of an adapter operation by a
+// FaultInjector.
+type Fault struct {
+	// Operation is the name of the operation whose requests fail, such as
+	// "LoadPolicy" or "PollingWatcher" for the polls of watchers. Empty
+	// matches every request.
+	Operation string
+	// Times is the number of requests the fault applies to, every request
+	// when 0.
+	Times int
+	// Delay delays the requests, before they are sent or fail.
+	Delay time.Duration
+	// StatusCode, if set, answers the requests with this status instead of
+	// sending them, such as http.StatusTooManyRequests or
+	// http.StatusServiceUnavailable.
+	StatusCode int
+	// RetryAfter is the x-ms-retry-after-ms of throttled responses.
+	RetryAfter time.Duration
+	// Err, if set, fails the requests with this error instead of sending them,
+	// like a network failure.
+	Err error
+}
+
+// FaultInjector makes the requests of an adapter fail, in tests, to verify how
+// the code handles errors, latency and throttling without depending on Cosmos
+// to produce them:
+//
+//	faults := cosmosadapter.NewFaultInjector(cosmosadapter.Fault{
+//		Operation:  "AddPolicy",
+//		Times:      2,
+//		StatusCode: http.StatusTooManyRequests,
+//	})
+//	options.Faults = faults
+//
+// Faults apply to every attempt of the requests of the Cosmos clients the
+// constructors create from the options, so the client retries them like
+// actual failures, or to the calls of the containers of Options.NewContainer.
+// The first matching fault that is not used up applies.
+type FaultInjector struct {
+	mu       sync.Mutex
+	faults   []*injectedFault
+	injected map[string]int
+}
+
+type injectedFault struct {
+	Fault
+	used int
+}
+
+// NewFaultInjector returns an injector of the faults.
+func NewFaultInjector(faults ...Fault) *FaultInjector {
+	f := &FaultInjector{injected: map[string]int{}}
+	for _, fault := range faults {
+		f.Add(fault)
+	}
+	return f
+}
+
+// Add adds a fault, applied after the faults added before.
+func (f *FaultInjector) Add(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &injectedFault{Fault: fault})
+}
+
+// Reset removes the faults, so the requests succeed again.
+func (f *FaultInjector) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+}
+
+// Injected returns the number of requests of the operation a fault was
+// injected into, of every operation for the empty name.
+func (f *FaultInjector) Injected(operation string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if operation == "" {
+		n := 0
+		for _, count := range f.injected {
+			n += count
+		}
+		return n
+	}
+	return f.injected[operation]
+}
+
+// take returns the fault of the next request of the operation, nil if none.
+func (f *FaultInjector) take(operation string) *Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, fault := range f.faults {
+		if fault.Operation != "" && fault.Operation != operation || fault.Times > 0 && fault.used >= fault.Times {
+			continue
+		}
+		fault.used++
+		f.injected[operation]++
+		injected := fault.Fault
+		return &injected
+	}
+	return nil
+}
+
+// inject applies the fault of the next request in the context, if any. It
+// returns the response to answer with, or the error to fail with, both nil
+// when the request must be sent.
+func (f *FaultInjector) inject(ctx context.Context, req *http.Request) (*http.Response, error) {
+	fault := f.take(faultOperation(ctx))
+	if fault == nil {
+		return nil, nil
+	}
+	if fault.Delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(fault.Delay):
+		}
+	}
+	if fault.Err != nil {
+		return nil, fault.Err
+	}
+	if fault.StatusCode == 0 {
+		return nil, nil
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	if fault.RetryAfter > 0 {
+		header.Set("x-ms-retry-after-ms", strconv.FormatInt(fault.RetryAfter.Milliseconds(), 10))
+	}
+	body := fmt.Sprintf(`{"code":%q,"message":"injected fault"}`, strings.ReplaceAll(http.StatusText(fault.StatusCode), " ", ""))
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", fault.StatusCode, http.StatusText(fault.StatusCode)),
+		StatusCode: fault.StatusCode,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+type faultScopeKey struct{}
+
+// withFaultScope names the requests made outside of operations, such as the
+// polls of watchers, for Fault.Operation.
+func withFaultScope(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, faultScopeKey{}, name)
+}
+
+// faultOperation returns the name faults match the requests of the context
+// with.
+func faultOperation(ctx context.Context) string {
+	if op := operationFrom(ctx); op != nil {
+		return op.name
+	}
+	name, _ := ctx.Value(faultScopeKey{}).(string)
+	return name
+}
+
+// faultPolicy is a pipeline policy injecting the faults into the requests of
+// the Cosmos client.
+type faultPolicy struct {
+	faults *FaultInjector
+}
+
+func (p faultPolicy) Do(req *policy.Request) (*http.Response, error) {
+	res, err := p.faults.inject(req.Raw().Context(), req.Raw())
+	if err != nil || res != nil {
+		return res, err
+	}
+	return req.Next()
+}
+
+// faultContainer injects the faults into the calls of a container of
+// Options.NewContainer.
+type faultContainer struct {
+	Container
+	faults *FaultInjector
+}
+
+// fail returns the error of the next call, nil when the call must be made.
+func (c faultContainer) fail(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://localhost/", nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.faults.inject(ctx, req)
+	if err != nil || res == nil {
+		return err
+	}
+	return runtime.NewResponseError(res)
+}
+
+func (c faultContainer) ReadItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := c.fail(ctx); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.Container.ReadItem(ctx, partitionKey, itemID, o)
+}
+
+func (c faultContainer) CreateItem(ctx context.Context, partitionKey azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := c.fail(ctx); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.Container.CreateItem(ctx, partitionKey, item, o)
+}
+
+func (c faultContainer) UpsertItem(ctx context.Context, partitionKey azcosmos.PartitionKey, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := c.fail(ctx); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.Container.UpsertItem(ctx, partitionKey, item, o)
+}
+
+func (c faultContainer) ReplaceItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, item []byte, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := c.fail(ctx); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.Container.ReplaceItem(ctx, partitionKey, itemID, item, o)
+}
+
+func (c faultContainer) PatchItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, ops azcosmos.PatchOperations, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := c.fail(ctx); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.Container.(Patcher).PatchItem(ctx, partitionKey, itemID, ops, o)
+}
+
+func (c faultContainer) DeleteItem(ctx context.Context, partitionKey azcosmos.PartitionKey, itemID string, o *azcosmos.ItemOptions) (azcosmos.ItemResponse, error) {
+	if err := c.fail(ctx); err != nil {
+		return azcosmos.ItemResponse{}, err
+	}
+	return c.Container.DeleteItem(ctx, partitionKey, itemID, o)
+}
+
+func (c faultContainer) NewQueryItemsPager(query string, partitionKey azcosmos.PartitionKey, o *azcosmos.QueryOptions) *runtime.Pager[azcosmos.QueryItemsResponse] {
+	pager := c.Container.NewQueryItemsPager(query, partitionKey, o)
+	return runtime.NewPager(runtime.PagingHandler[azcosmos.QueryItemsResponse]{
+		More: func(azcosmos.QueryItemsResponse) bool {
+			return pager.More()
+		},
+		Fetcher: func(ctx context.Context, _ *azcosmos.QueryItemsResponse) (azcosmos.QueryItemsResponse, error) {
+			if err := c.fail(ctx); err != nil {
+				return azcosmos.QueryItemsResponse{}, err
+			}
+			return pager.NextPage(ctx)
+		},
+	})
+}
@@ -0,0 +1,151 @@
+package cosmosadapter
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// filterCache holds the rules loaded by LoadFilteredPolicy for the most
+// recently used filters.
+type filterCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// recent orders the entries from the most to the least recently used
+	recent *list.List
+}
+
+type filterEntry struct {
+	key      string
+	lines    []CasbinRule
+	version  int64
+	loadedAt time.Time
+}
+
+func newFilterCache(size int, ttl time.Duration) *filterCache {
+	if size <= 0 {
+		return nil
+	}
+	return &filterCache{size: size, ttl: ttl, entries: map[string]*list.Element{}, recent: list.New()}
+}
+
+// filterKey returns the cache key of a filter: its query with the white space
+// outside string literals collapsed, and its parameters sorted by name, so the
+// same filter written differently shares an entry.
+func filterKey(spec SqlQuerySpec) string {
+	parameters := make([]string, 0, len(spec.Parameters))
+	for _, p := range spec.Parameters {
+		value, err := json.Marshal(p.Value)
+		if err != nil {
+			value = []byte(fmt.Sprint(p.Value))
+		}
+		parameters = append(parameters, p.Name+"="+string(value))
+	}
+	sort.Strings(parameters)
+	return collapseSpace(spec.Query) + "\x00" + strings.Join(parameters, "\x00")
+}
+
+// collapseSpace replaces the runs of white space of a query by a space, and
+// trims it, leaving the string literals unchanged.
+func collapseSpace(query string) string {
+	var b strings.Builder
+	var quote rune
+	space, escaped := false, false
+	for _, r := range strings.TrimSpace(query) {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// get returns the cached rules of the filter if they were loaded at the given
+// policy version and are not older than the ttl.
+func (c *filterCache) get(key string, version int64, now time.Time) ([]CasbinRule, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*filterEntry)
+	if entry.version != version || c.ttl > 0 && now.Sub(entry.loadedAt) > c.ttl {
+		c.recent.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.recent.MoveToFront(element)
+	return entry.lines, true
+}
+
+// put caches the rules of the filter, evicting the least recently used entry
+// when the cache is full.
+func (c *filterCache) put(key string, version int64, lines []CasbinRule, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &filterEntry{key: key, lines: lines, version: version, loadedAt: now}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.recent.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.recent.PushFront(entry)
+	if c.recent.Len() > c.size {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*filterEntry).key)
+	}
+}
+
+// clear drops every entry.
+func (c *filterCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*list.Element{}
+	c.recent.Init()
+}
+
+// InvalidateFilterCache drops the rules cached by LoadFilteredPolicy, see
+// Options.FilterCacheSize. Entries loaded at an older policy version are never
+// used, but are only dropped when looked up again; call it from the update
+// callback of a watcher to free them as soon as another instance changes the
+// policy:
+//
+//	w.SetUpdateCallback(func(string) { a.InvalidateFilterCache() })
+func (a *Adapter) InvalidateFilterCache() {
+	a.filterCache.clear()
+}
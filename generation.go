@@ -0,0 +1,108 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// errGenerationChanged is returned by the loads continuing an earlier load,
+// LoadPolicyDelta and LoadPolicyPages, when a SavePolicy with
+// Options.GenerationalSave replaced the rules since.
+var errGenerationChanged = errors.New("the policy was saved since the last load: LoadPolicy must be called")
+
+// generationLoadAttempts is the number of times LoadPolicy reads the rules
+// when SavePolicy switches to a new generation while they are read.
+const generationLoadAttempts = 5
+
+// refreshGeneration reads the generation of the rules from the policy version
+// document.
+func (a *Adapter) refreshGeneration(ctx context.Context) error {
+	doc, _, err := readVersionDocument(ctx, a.containerClient, a.versionID())
+	if err != nil {
+		return err
+	}
+	a.storeGeneration(doc.Generation)
+	return nil
+}
+
+// storeGeneration remembers the generation, unless a later one is known
+// already.
+func (a *Adapter) storeGeneration(generation int64) {
+	for {
+		current := a.generation.Load()
+		if generation <= current || a.generation.CompareAndSwap(current, generation) {
+			return
+		}
+	}
+}
+
+// inOtherGeneration reports whether the document belongs to another
+// generation of the rules than the current one: a generation being written by
+// a SavePolicy, or replaced by one and not purged yet.
+func (a *Adapter) inOtherGeneration(line CasbinRule) bool {
+	return a.generational && line.PType != policyVersionID && line.Generation != a.generation.Load()
+}
+
+// withGeneration returns the document of the rule in the given generation. The
+// generation is part of the document ID, so the generations of a rule can be
+// stored side by side.
+func withGeneration(line CasbinRule, generation int64) CasbinRule {
+	if line.Generation != 0 {
+		line.ID = strings.TrimSuffix(line.ID, fmt.Sprintf(":g%d", line.Generation))
+	}
+	line.Generation = generation
+	if generation != 0 {
+		line.ID += fmt.Sprintf(":g%d", generation)
+	}
+	return line
+}
+
+// purgeGenerations deletes the rules of the namespace of every generation but
+// keep.
+func (a *Adapter) purgeGenerations(ctx context.Context, keep int64) error {
+	query := "SELECT c.id, c.pType, c.partitionKey, c.namespace, c.generation FROM c WHERE IS_DEFINED(c.generation)"
+	var parameters []azcosmos.QueryParameter
+	if keep != 0 {
+		query = "SELECT c.id, c.pType, c.partitionKey, c.namespace, c.generation FROM c WHERE (NOT IS_DEFINED(c.generation) OR c.generation != @generation)"
+		parameters = []azcosmos.QueryParameter{{Name: "@generation", Value: keep}}
+	}
+	query, parameters = a.inNamespace(query, parameters)
+	for _, name := range a.ruleContainerNames() {
+		container := a.containers[name]
+		var lines []CasbinRule
+		queryPager := container.NewQueryItemsPager(query, azcosmos.NewPartitionKey(), &azcosmos.QueryOptions{QueryParameters: parameters})
+		for queryPager.More() {
+			res, err := nextPage(ctx, queryPager)
+			if err != nil {
+				return err
+			}
+			operationFrom(ctx).query(query, "", parameters, "", res)
+			for _, item := range res.Items {
+				line, err := a.mapper.FromDocument(item)
+				if err != nil {
+					return err
+				}
+				if line.PType == "" || line.PType == policyVersionID || line.Generation == keep || a.inOtherNamespace(line) {
+					continue
+				}
+				lines = append(lines, line)
+			}
+		}
+		// deleted once read, as deletions would shift the pages
+		for _, line := range lines {
+			res, err := container.DeleteItem(ctx, a.partitionKey(line), line.ID, nil)
+			if err != nil && !isStatus(err, http.StatusNotFound) {
+				return err
+			}
+			if err == nil {
+				operationFrom(ctx).record(res.Response, 1)
+			}
+		}
+	}
+	return nil
+}
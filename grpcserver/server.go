@@ -0,0 +1,220 @@
+// Package grpcserver exposes a Casbin enforcer backed by the Cosmos adapter as
+// a gRPC service, in the style of casbin-server, so services written in other
+// languages can share the policy store:
+//
+//	e, err := cosmosadapter.NewEnforcerWithCosmos(config)
+//	s := grpc.NewServer()
+//	grpcserver.RegisterPolicyServiceServer(s, grpcserver.NewServer(e))
+//	s.Serve(listener)
+//
+// The service is defined in policy.proto, from which clients can be generated.
+// The package is a module of its own, so the adapter doesn't depend on gRPC.
+package grpcserver
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative policy.proto
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/casbin/casbin/v2"
+	cosmosadapter "github.com/rickdana/cosmos-casbin-adapter"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements PolicyServiceServer with an enforcer. Requests are
+// serialized around policy changes, so the enforcer needs no locking of its
+// own. Policy changes are written through the enforcer's adapter when auto
+// save is enabled, which is the default.
+type Server struct {
+	UnimplementedPolicyServiceServer
+
+	mu       sync.RWMutex
+	enforcer casbin.IEnforcer
+}
+
+// NewServer returns a Server using the enforcer, whose policy must be loaded.
+func NewServer(enforcer casbin.IEnforcer) *Server {
+	return &Server{enforcer: enforcer}
+}
+
+func (s *Server) Enforce(ctx context.Context, req *EnforceRequest) (*EnforceResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	allowed, err := s.enforcer.Enforce(values(req.Values)...)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &EnforceResponse{Allowed: allowed}, nil
+}
+
+func (s *Server) BatchEnforce(ctx context.Context, req *BatchEnforceRequest) (*BatchEnforceResponse, error) {
+	requests := make([][]interface{}, len(req.Requests))
+	for i, r := range req.Requests {
+		requests[i] = values(r.GetValues())
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	allowed, err := s.enforcer.BatchEnforce(requests)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &BatchEnforceResponse{Allowed: allowed}, nil
+}
+
+func (s *Server) LoadPolicy(ctx context.Context, req *LoadPolicyRequest) (*LoadPolicyResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enforcer.LoadPolicy(); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &LoadPolicyResponse{}, nil
+}
+
+func (s *Server) GetPolicy(ctx context.Context, req *GetPolicyRequest) (*GetPolicyResponse, error) {
+	ptype := pType(req.PType)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var policy [][]string
+	if grouping(ptype) {
+		policy = s.enforcer.GetNamedGroupingPolicy(ptype)
+	} else {
+		policy = s.enforcer.GetNamedPolicy(ptype)
+	}
+	res := &GetPolicyResponse{Rules: make([]*Rule, len(policy))}
+	for i, rule := range policy {
+		res.Rules[i] = &Rule{Values: rule}
+	}
+	return res, nil
+}
+
+func (s *Server) HasPolicy(ctx context.Context, req *PolicyRequest) (*BoolResponse, error) {
+	ptype := pType(req.PType)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if grouping(ptype) {
+		return &BoolResponse{Value: s.enforcer.HasNamedGroupingPolicy(ptype, values(req.Values)...)}, nil
+	}
+	return &BoolResponse{Value: s.enforcer.HasNamedPolicy(ptype, values(req.Values)...)}, nil
+}
+
+func (s *Server) AddPolicy(ctx context.Context, req *PolicyRequest) (*BoolResponse, error) {
+	if len(req.Values) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "a rule needs values")
+	}
+	ptype := pType(req.PType)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var added bool
+	var err error
+	if grouping(ptype) {
+		added, err = s.enforcer.AddNamedGroupingPolicy(ptype, values(req.Values)...)
+	} else {
+		added, err = s.enforcer.AddNamedPolicy(ptype, values(req.Values)...)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &BoolResponse{Value: added}, nil
+}
+
+func (s *Server) RemovePolicy(ctx context.Context, req *PolicyRequest) (*BoolResponse, error) {
+	ptype := pType(req.PType)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed bool
+	var err error
+	if grouping(ptype) {
+		removed, err = s.enforcer.RemoveNamedGroupingPolicy(ptype, values(req.Values)...)
+	} else {
+		removed, err = s.enforcer.RemoveNamedPolicy(ptype, values(req.Values)...)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &BoolResponse{Value: removed}, nil
+}
+
+func (s *Server) RemoveFilteredPolicy(ctx context.Context, req *FilteredPolicyRequest) (*BoolResponse, error) {
+	if req.FieldIndex < 0 {
+		return nil, status.Error(codes.InvalidArgument, "field_index must not be negative")
+	}
+	ptype := pType(req.PType)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed bool
+	var err error
+	if grouping(ptype) {
+		removed, err = s.enforcer.RemoveFilteredNamedGroupingPolicy(ptype, int(req.FieldIndex), req.FieldValues...)
+	} else {
+		removed, err = s.enforcer.RemoveFilteredNamedPolicy(ptype, int(req.FieldIndex), req.FieldValues...)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &BoolResponse{Value: removed}, nil
+}
+
+func (s *Server) GetRolesForUser(ctx context.Context, req *UserRequest) (*ValuesResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	roles, err := s.enforcer.GetRolesForUser(req.Name, domain(req.Domain)...)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &ValuesResponse{Values: roles}, nil
+}
+
+func (s *Server) GetUsersForRole(ctx context.Context, req *UserRequest) (*ValuesResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users, err := s.enforcer.GetUsersForRole(req.Name, domain(req.Domain)...)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &ValuesResponse{Values: users}, nil
+}
+
+// pType returns the policy type of a request, "p" when empty.
+func pType(ptype string) string {
+	if ptype == "" {
+		return "p"
+	}
+	return ptype
+}
+
+// grouping reports whether the policy type is in the g section.
+func grouping(ptype string) bool {
+	return strings.HasPrefix(ptype, "g")
+}
+
+func values(rule []string) []interface{} {
+	params := make([]interface{}, len(rule))
+	for i, value := range rule {
+		params[i] = value
+	}
+	return params
+}
+
+func domain(domain string) []string {
+	if domain == "" {
+		return nil
+	}
+	return []string{domain}
+}
+
+// toStatus returns the status of an error of a policy change.
+func toStatus(err error) error {
+	var cosmosErr *cosmosadapter.Error
+	switch {
+	case errors.Is(err, cosmosadapter.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.As(err, &cosmosErr):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
@@ -0,0 +1,84 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T) PolicyServiceClient {
+	t.Helper()
+	e, err := casbin.NewEnforcer("../examples/rbac_model.conf", "../examples/rbac_policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterPolicyServiceServer(s, NewServer(e))
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewPolicyServiceClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	res, err := client.Enforce(ctx, &EnforceRequest{Values: []string{"alice", "data2", "read"}})
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	batch, err := client.BatchEnforce(ctx, &BatchEnforceRequest{Requests: []*EnforceRequest{
+		{Values: []string{"bob", "data2", "write"}},
+		{Values: []string{"bob", "data1", "read"}},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, batch.Allowed)
+
+	added, err := client.AddPolicy(ctx, &PolicyRequest{Values: []string{"bob", "data1", "read"}})
+	assert.NoError(t, err)
+	assert.True(t, added.Value)
+	has, err := client.HasPolicy(ctx, &PolicyRequest{Values: []string{"bob", "data1", "read"}})
+	assert.NoError(t, err)
+	assert.True(t, has.Value)
+
+	_, err = client.AddPolicy(ctx, &PolicyRequest{PType: "g", Values: []string{"bob", "data2_admin"}})
+	assert.NoError(t, err)
+	users, err := client.GetUsersForRole(ctx, &UserRequest{Name: "data2_admin"})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice", "bob"}, users.Values)
+	roles, err := client.GetRolesForUser(ctx, &UserRequest{Name: "bob"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"data2_admin"}, roles.Values)
+
+	removed, err := client.RemoveFilteredPolicy(ctx, &FilteredPolicyRequest{FieldIndex: 0, FieldValues: []string{"bob"}})
+	assert.NoError(t, err)
+	assert.True(t, removed.Value)
+	policy, err := client.GetPolicy(ctx, &GetPolicyRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, policy.Rules, 3)
+
+	removed, err = client.RemovePolicy(ctx, &PolicyRequest{PType: "g", Values: []string{"bob", "data2_admin"}})
+	assert.NoError(t, err)
+	assert.True(t, removed.Value)
+	grouping, err := client.GetPolicy(ctx, &GetPolicyRequest{PType: "g"})
+	assert.NoError(t, err)
+	assert.Len(t, grouping.Rules, 1)
+
+	_, err = client.AddPolicy(ctx, &PolicyRequest{})
+	assert.Error(t, err)
+}
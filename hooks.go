@@ -0,0 +1,104 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+)
+
+// ErrVetoed is matched with errors.Is by the errors of the mutations vetoed by
+// a Before hook, which wrap the error of the hook as well.
+var ErrVetoed = errors.New("cosmosadapter: vetoed by a hook")
+
+// Mutation is a change of the policy made through the adapter, passed to the
+// hooks of Options.Hooks.
+type Mutation struct {
+	// Operation is the adapter operation, such as "AddPolicy" or
+	// "SavePolicy".
+	Operation string
+	Sec       string
+	PType     string
+	// Rules are the rules added or removed, a single rule for AddPolicy,
+	// AddPolicyWithExpiry and RemovePolicy. Before hooks can change their
+	// values, or leave rules out to skip them.
+	Rules [][]string
+	// NewRules are the rules replacing Rules for UpdatePolicy and
+	// UpdatePolicies, or the rules replacing the matches of the filter for
+	// UpdateFilteredPolicies. Before hooks can change their values.
+	NewRules [][]string
+	// FieldIndex and FieldValues are the filter of RemoveFilteredPolicy and
+	// UpdateFilteredPolicies.
+	// Before hooks can change the values.
+	FieldIndex  int
+	FieldValues []string
+	// Model is the model saved by SavePolicy. Before hooks can change its
+	// rules.
+	Model model.Model
+	// ExpiresAt is the expiry of the rule of AddPolicyWithExpiry.
+	ExpiresAt time.Time
+}
+
+// Hook runs around the mutations of the policy made through an adapter, to
+// authorize, rewrite or observe them without wrapping the adapter:
+//
+//	options.Hooks = []cosmosadapter.Hook{{
+//		Operations: []string{"AddPolicy", "AddPolicies"},
+//		Before: func(ctx context.Context, m *cosmosadapter.Mutation) error {
+//			if m.PType == "g" {
+//				return errors.New("roles are managed by the directory")
+//			}
+//			return nil
+//		},
+//	}}
+//
+// The hooks only change what is stored: an enforcer applies the rules it was
+// given to its model whatever the hooks do.
+type Hook struct {
+	// Operations are the operations the hook applies to: AddPolicy,
+	// AddPolicies, AddPolicyWithExpiry, RemovePolicy, RemovePolicies,
+	// RemoveFilteredPolicy, UpdatePolicy, UpdatePolicies,
+	// UpdateFilteredPolicies or SavePolicy. Empty applies it to all of them.
+	Operations []string
+	// Before, if set, is called before the mutation is made, in the order of
+	// the hooks. It can change the mutation, or veto it by returning an
+	// error: the operation then fails with an error wrapping ErrVetoed and
+	// the error, before any rule is read or written. It runs within the
+	// operation, once the adapter connected with Options.LazyConnect and read
+	// the current generation with Options.GenerationalSave.
+	Before func(ctx context.Context, m *Mutation) error
+	// After, if set, is called once the mutation is made or has failed, with
+	// the error of the operation, in the reverse order of the hooks. It is
+	// not called for vetoed mutations.
+	After func(ctx context.Context, m *Mutation, err error)
+}
+
+// appliesTo reports whether the hook runs around the operation.
+func (h Hook) appliesTo(operation string) bool {
+	return len(h.Operations) == 0 || slices.Contains(h.Operations, operation)
+}
+
+// beforeMutation runs the Before hooks of the mutation.
+func (a *Adapter) beforeMutation(ctx context.Context, m *Mutation) error {
+	for _, h := range a.hooks {
+		if h.Before == nil || !h.appliesTo(m.Operation) {
+			continue
+		}
+		if err := h.Before(ctx, m); err != nil {
+			return fmt.Errorf("%w: %w", ErrVetoed, err)
+		}
+	}
+	return nil
+}
+
+// afterMutation runs the After hooks of the mutation.
+func (a *Adapter) afterMutation(ctx context.Context, m *Mutation, err error) {
+	for _, h := range slices.Backward(a.hooks) {
+		if h.After != nil && h.appliesTo(m.Operation) {
+			h.After(ctx, m, err)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// maxBatchSize is the number of operations a transactional batch holds at most.
+const maxBatchSize = 100
+
+// ImportMode selects what ImportCSV and ImportDocuments do with the stored
+// rules.
+type ImportMode int
+
+const (
+	// ImportMerge adds the imported rules and keeps the stored ones.
+	ImportMerge ImportMode = iota
+	// ImportReplace removes the stored rules that are not imported, once the
+	// imported rules are written.
+	ImportReplace
+)
+
+// ImportOptions configures ImportCSV and ImportDocuments.
+type ImportOptions struct {
+	Mode ImportMode
+	// BatchSize is the number of rules written per transactional batch, at
+	// most 100. Defaults to 100.
+	BatchSize int
+	// MaxRetries is the number of times a throttled (429) batch is retried,
+	// after the retries of the client. Defaults to 5, a negative value disables
+	// the retries.
+	MaxRetries int
+	// MaxRequestUnits, if set, paces the writes to consume about this many
+	// request units per second, leaving the rest of the throughput of the
+	// container to the applications.
+	MaxRequestUnits float64
+	// Progress, if set, is called after every batch with the number of
+	// documents written so far. It is not called concurrently.
+	Progress func(written int)
+	// Concurrency is the number of batches written in parallel. Defaults to 4,
+	// 1 writes them one by one.
+	Concurrency int
+	// BurstThroughput, if set, raises the throughput of the rules containers
+	// to this many request units per second while the rules are written, and
+	// restores it afterwards, see BurstThroughput.
+	BurstThroughput int32
+}
+
+// ImportCSV writes the rules of a policy CSV, in the format of the casbin file
+// adapter, in transactional batches of rules sharing a partition. Rules are
+// upserted, so the rules already stored are not duplicated. It returns the
+// number of rules imported. A failed batch doesn't stop the import: the error
+// then joins a *RuleError for every rule not written, see FailedRules.
+//
+// Quotas are not enforced. The policy version is bumped once, at the end.
+func (a *Adapter) ImportCSV(ctx context.Context, r io.Reader, options ImportOptions) (n int, err error) {
+	ctx, op := a.startOperation(ctx, "ImportCSV")
+	defer func() { err = a.endOperation(op, err) }()
+
+	lines, err := a.readCSV(r)
+	if err != nil {
+		return 0, err
+	}
+	var events []PolicyEvent
+	for _, line := range lines {
+		events = append(events, a.newEvent(ctx, EventAdd, line.PType, policyTokens(line)))
+	}
+	if err := a.appendEvents(ctx, events...); err != nil {
+		return 0, err
+	}
+	restore, err := a.burst(ctx, options)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = errors.Join(err, restore()) }()
+	written, failures := a.bulkWrite(ctx, lines, options)
+	return a.imported(ctx, lines, written, failures, options)
+}
+
+// imported finishes an import that wrote written of the lines. The failures
+// of some rules, returned as they are, don't keep the rules written from
+// being reported, nor the others from being removed with ImportReplace.
+func (a *Adapter) imported(ctx context.Context, lines []CasbinRule, written int, failures error, options ImportOptions) (int, error) {
+	if failures != nil && FailedRules(failures) == nil {
+		return written, failures
+	}
+	if options.Mode == ImportReplace {
+		if err := a.removeOthers(ctx, lines); err != nil {
+			return written, err
+		}
+	}
+	a.quotas.removed(lines...)
+	if err := a.policyChanged(ctx, PolicyChange{}); err != nil {
+		return written, err
+	}
+	return written, failures
+}
+
+// ImportDocuments writes documents exported by ExportDocuments, or any JSON
+// lines of rule documents, exactly as they are: with their IDs, the tombstones
+// and the metadata fields. The system fields of Cosmos, starting with an
+// underscore, are dropped as they are set by the service. Documents keep their
+// namespace, which may not be the namespace of the adapter. Options.Mode and
+// the batching are those of ImportCSV. It returns the number of documents
+// written.
+func (a *Adapter) ImportDocuments(ctx context.Context, r io.Reader, options ImportOptions) (n int, err error) {
+	ctx, op := a.startOperation(ctx, "ImportDocuments")
+	defer func() { err = a.endOperation(op, err) }()
+	return a.importDocuments(ctx, r, options)
+}
+
+func (a *Adapter) importDocuments(ctx context.Context, r io.Reader, options ImportOptions) (n int, err error) {
+	var items []scannedRule
+	decoder := json.NewDecoder(r)
+	for {
+		var fields map[string]json.RawMessage
+		if err := decoder.Decode(&fields); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		for field := range fields {
+			if strings.HasPrefix(field, "_") {
+				delete(fields, field)
+			}
+		}
+		document, err := json.Marshal(fields)
+		if err != nil {
+			return 0, err
+		}
+		line, err := a.mapper.FromDocument(document)
+		if err != nil {
+			return 0, err
+		}
+		if line.ID == "" {
+			return 0, fmt.Errorf("document %d has no id", len(items)+1)
+		}
+		if line.PType == "" || line.PType == policyVersionID {
+			continue
+		}
+		if generation := a.generation.Load(); a.generational && line.Generation != generation {
+			// the documents join the current generation of the rules
+			line = withGeneration(line, generation)
+			fields["id"], _ = json.Marshal(line.ID)
+			fields["generation"], _ = json.Marshal(generation)
+			if generation == 0 {
+				delete(fields, "generation")
+			}
+			if document, err = json.Marshal(fields); err != nil {
+				return 0, err
+			}
+		}
+		items = append(items, scannedRule{line: line, document: document})
+	}
+
+	var events []PolicyEvent
+	var lines []CasbinRule
+	for _, item := range items {
+		lines = append(lines, item.line)
+		if !item.line.Deleted {
+			events = append(events, a.newEvent(ctx, EventAdd, item.line.PType, policyTokens(item.line)))
+		}
+	}
+	if err := a.appendEvents(ctx, events...); err != nil {
+		return 0, err
+	}
+	restore, err := a.burst(ctx, options)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = errors.Join(err, restore()) }()
+	written, failures := a.bulkUpsert(ctx, items, options)
+	return a.imported(ctx, lines, written, failures, options)
+}
+
+// readCSV parses a policy CSV. Duplicate rules are dropped.
+func (a *Adapter) readCSV(r io.Reader) ([]CasbinRule, error) {
+	var lines []CasbinRule
+	seen := map[string]bool{}
+	err := readCSVRules(r, func(ptype string, rule []string) error {
+		if err := a.validateRule(ptype, rule); err != nil {
+			return err
+		}
+		line := a.newPolicyLine(ptype, rule)
+		if !seen[line.ID] {
+			seen[line.ID] = true
+			lines = append(lines, line)
+		}
+		return nil
+	})
+	return lines, err
+}
+
+// readCSVRules calls fn with every rule of a policy CSV. Empty lines and lines
+// starting with # are skipped, and the spaces around values are trimmed.
+func readCSVRules(r io.Reader, fn func(ptype string, rule []string) error) error {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		if len(record) < 2 || record[0] == "" {
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("line %d: a rule needs a policy type and a value", line)
+		}
+		if err := fn(record[0], record[1:]); err != nil {
+			return err
+		}
+	}
+}
+
+// removeOthers removes the stored rules that are not among the lines.
+func (a *Adapter) removeOthers(ctx context.Context, lines []CasbinRule) error {
+	keep := map[string]bool{}
+	for _, line := range lines {
+		keep[line.ID] = true
+	}
+	var others []CasbinRule
+	err := a.scan(ctx, nil, 0, func(line CasbinRule) error {
+		// with Options.Compat a copy of an imported rule may have another ID,
+		// and is removed as well
+		if !keep[line.ID] {
+			others = append(others, line)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var events []PolicyEvent
+	for _, line := range others {
+		events = append(events, a.newEvent(ctx, EventRemove, line.PType, policyTokens(line)))
+	}
+	if err := a.appendEvents(ctx, events...); err != nil {
+		return err
+	}
+	for _, line := range others {
+		if err := a.remove(ctx, line); err != nil && !isStatus(err, http.StatusNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkWrite upserts the lines in transactional batches of lines sharing a
+// container and a partition, see bulkUpsert.
+func (a *Adapter) bulkWrite(ctx context.Context, lines []CasbinRule, options ImportOptions) (int, error) {
+	items := make([]scannedRule, 0, len(lines))
+	for _, line := range lines {
+		marshalled, err := a.encodeRule(line)
+		if err != nil {
+			return 0, err
+		}
+		items = append(items, scannedRule{line: line, document: marshalled})
+	}
+	return a.bulkUpsert(ctx, items, options)
+}
+
+// bulkUpsert upserts the documents in transactional batches of documents
+// sharing a container and a partition. A failed batch doesn't stop the
+// others: it returns the number of documents written and the *RuleErrors of
+// the failed ones, joined.
+func (a *Adapter) bulkUpsert(ctx context.Context, items []scannedRule, options ImportOptions) (int, error) {
+	size := options.BatchSize
+	if size <= 0 || size > maxBatchSize {
+		size = maxBatchSize
+	}
+	retries := options.MaxRetries
+	if retries == 0 {
+		retries = 5
+	}
+
+	type partition struct {
+		container string
+		key       string
+	}
+	var order []partition
+	partitions := map[partition][]scannedRule{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		line := item.line
+		// a batch can't write the same document twice
+		if seen[line.ID] {
+			continue
+		}
+		seen[line.ID] = true
+		p := partition{container: a.containerNameFor(line.PType), key: line.PType}
+		if a.partitionStrategy == PartitionByDomain {
+			p.key = line.PartitionKey
+		}
+		if _, ok := partitions[p]; !ok {
+			order = append(order, p)
+		}
+		partitions[p] = append(partitions[p], item)
+	}
+
+	type chunk struct {
+		container Container
+		pk        azcosmos.PartitionKey
+		items     []scannedRule
+	}
+	var chunks []chunk
+	for _, p := range order {
+		partitionItems := partitions[p]
+		for start := 0; start < len(partitionItems); start += size {
+			chunks = append(chunks, chunk{
+				container: a.containers[p.container],
+				pk:        azcosmos.NewPartitionKeyString(p.key),
+				items:     partitionItems[start:min(start+size, len(partitionItems))],
+			})
+		}
+	}
+
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultWriteConcurrency
+	}
+	var mu sync.Mutex
+	written := 0
+	var errs []error
+	err := forEach(ctx, concurrency, chunks, func(ctx context.Context, part chunk) error {
+		started := time.Now()
+		var charge float32
+		err := retryThrottled(ctx, retries, func() error {
+			c, err := upsertChunk(ctx, part.container, part.pk, part.items)
+			charge += c
+			return err
+		})
+		failed := FailedRules(err)
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			return err
+		case len(failed) == 0:
+			// the request failed, none of the documents is written
+			for _, item := range part.items {
+				failed = append(failed, ruleError(ctx, item.line.PType, policyTokens(item.line), err))
+			}
+		}
+		mu.Lock()
+		for _, failure := range failed {
+			errs = append(errs, failure)
+		}
+		written += len(part.items) - len(failed)
+		if options.Progress != nil {
+			options.Progress(written)
+		}
+		mu.Unlock()
+		// the workers share the request units
+		return pace(ctx, started, float64(charge), options.MaxRequestUnits/float64(concurrency))
+	})
+	if err != nil {
+		return written, err
+	}
+	return written, errors.Join(errs...)
+}
+
+// upsertChunk upserts the documents, in a transactional batch when the
+// container is an azcosmos client, one by one otherwise. It returns the
+// request charge, and the *RuleErrors of the documents not written, joined.
+func upsertChunk(ctx context.Context, container Container, pk azcosmos.PartitionKey, chunk []scannedRule) (float32, error) {
+	client := cosmosContainer(container)
+	if client == nil {
+		var charge float32
+		var errs []error
+		for _, item := range chunk {
+			res, err := container.UpsertItem(ctx, pk, item.document, nil)
+			if err != nil {
+				if ctx.Err() != nil {
+					return charge, err
+				}
+				errs = append(errs, ruleError(ctx, item.line.PType, policyTokens(item.line), err))
+				continue
+			}
+			operationFrom(ctx).record(res.Response, 1)
+			charge += res.RequestCharge
+		}
+		return charge, errors.Join(errs...)
+	}
+
+	batch := client.NewTransactionalBatch(pk)
+	for _, item := range chunk {
+		batch.UpsertItem(item.document, nil)
+	}
+	res, err := client.ExecuteTransactionalBatch(ctx, batch, nil)
+	if err != nil {
+		return 0, err
+	}
+	operationFrom(ctx).record(res.Response, len(chunk))
+	if !res.Success {
+		return res.RequestCharge, batchError(ctx, res, chunk)
+	}
+	return res.RequestCharge, nil
+}
+
+// batchError returns the *RuleErrors of a failed transactional batch, which
+// writes none of its documents, joined. The operation that failed the batch
+// has its own status, the others fail with the error of the batch.
+func batchError(ctx context.Context, res azcosmos.TransactionalBatchResponse, chunk []scannedRule) error {
+	cause := batchCause(res)
+	errs := make([]error, len(chunk))
+	for i, item := range chunk {
+		err := fmt.Errorf("not written, another rule of the transactional batch failed: %v", cause)
+		if i < len(res.OperationResults) && res.OperationResults[i].StatusCode != http.StatusFailedDependency {
+			err = cause
+		}
+		errs[i] = ruleError(ctx, item.line.PType, policyTokens(item.line), err)
+	}
+	return errors.Join(errs...)
+}
+
+// batchCause returns the error of the operation that failed a transactional
+// batch, the others failing with http.StatusFailedDependency.
+func batchCause(res azcosmos.TransactionalBatchResponse) error {
+	for _, result := range res.OperationResults {
+		if result.StatusCode != http.StatusFailedDependency {
+			return &azcore.ResponseError{
+				StatusCode: int(result.StatusCode),
+				ErrorCode:  http.StatusText(int(result.StatusCode)),
+			}
+		}
+	}
+	return errors.New("transactional batch failed")
+}
+
+// pace waits until the charge of a request sent at started fits in
+// maxRequestUnits per second. A zero maxRequestUnits does not wait.
+func pace(ctx context.Context, started time.Time, charge float64, maxRequestUnits float64) error {
+	if maxRequestUnits <= 0 {
+		return nil
+	}
+	wait := time.Duration(charge/maxRequestUnits*float64(time.Second)) - time.Since(started)
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// retryThrottled calls fn until it does not return a 429, at most retries
+// more times, waiting as long as asked by the service or backing off
+// exponentially.
+func retryThrottled(ctx context.Context, retries int, fn func() error) error {
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !isStatus(err, http.StatusTooManyRequests) {
+			return err
+		}
+		wait := backoff
+		var resErr *azcore.ResponseError
+		if errors.As(err, &resErr) && resErr.RawResponse != nil {
+			if ms, err := strconv.Atoi(resErr.RawResponse.Header.Get("x-ms-retry-after-ms")); err == nil {
+				wait = time.Duration(ms) * time.Millisecond
+			}
+		}
+		operationFrom(ctx).retry("request throttled")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
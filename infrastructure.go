@@ -0,0 +1,357 @@
+package cosmosadapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// EnsureInfrastructure creates the database and every container the adapter
+// described by options uses, if they don't exist: the rules containers, with
+// their partition key, indexing policy, TTL and conflict resolution policy,
+// and the event, audit and snapshot containers. On existing rules containers
+// it enables TTL and updates the indexing policy as configured. It can be run
+// any number of times.
+//
+// Run it from deploy pipelines, with the permission to manage the account, and
+// create the adapters of the application with Options.SkipAutoCreate. Unlike
+// the constructors, it returns errors rather than panicking. The rules
+// containers have no unique keys: the document IDs are unique per partition.
+func EnsureInfrastructure(ctx context.Context, client *azcosmos.Client, options Options) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ensuring cosmos infrastructure: %v", r)
+		}
+	}()
+	options.SkipAutoCreate = true
+	a := NewAdapterFromClient(client, options)
+	return a.createInfrastructure(ctx, options)
+}
+
+// ensureIndexingPolicy replaces the indexing policy of an existing container
+// when it differs from Options.IndexingPolicy.
+func (a *Adapter) ensureIndexingPolicy(ctx context.Context, container *azcosmos.ContainerClient, properties *azcosmos.ContainerProperties) error {
+	if properties == nil {
+		return nil
+	}
+	if sameIndexingPolicy(properties.IndexingPolicy, a.indexingPolicy) {
+		return nil
+	}
+	properties.IndexingPolicy = a.indexingPolicy
+	if _, err := container.Replace(ctx, *properties, nil); err != nil {
+		return err
+	}
+	a.logger.Info("updated the indexing policy of cosmos container", "database", a.databaseName, "container", properties.ID)
+	return nil
+}
+
+// analyticalStoreTTL returns the analytical store ttl in seconds of the
+// duration, -1 to never expire the rules, or nil to disable the analytical
+// store, see Options.AnalyticalStoreTTL.
+func analyticalStoreTTL(ttl time.Duration) *int32 {
+	var seconds int32
+	switch {
+	case ttl == 0:
+		return nil
+	case ttl < 0:
+		seconds = -1
+	default:
+		rounded := ttl / time.Second
+		if ttl%time.Second != 0 {
+			rounded++
+		}
+		seconds = int32(min(rounded, math.MaxInt32))
+	}
+	return &seconds
+}
+
+// ensureAnalyticalStoreTTL updates the analytical store ttl of an existing
+// container when it differs from Options.AnalyticalStoreTTL. The analytical
+// store of a container can only be enabled when it is created.
+func (a *Adapter) ensureAnalyticalStoreTTL(ctx context.Context, container *azcosmos.ContainerClient, properties *azcosmos.ContainerProperties) error {
+	if properties == nil {
+		return nil
+	}
+	current := properties.AnalyticalStoreTimeToLiveInSeconds
+	if current == nil || *current == 0 {
+		a.logger.Warn("the analytical store is not enabled on the existing cosmos container", "database", a.databaseName, "container", properties.ID)
+		return nil
+	}
+	if *current == *a.analyticalStoreTTL {
+		return nil
+	}
+	properties.AnalyticalStoreTimeToLiveInSeconds = a.analyticalStoreTTL
+	if _, err := container.Replace(ctx, *properties, nil); err != nil {
+		return err
+	}
+	a.logger.Info("updated the analytical store ttl of cosmos container", "database", a.databaseName, "container", properties.ID)
+	return nil
+}
+
+// etagPath is excluded from indexing by the service on every container.
+const etagPath = `/"_etag"/?`
+
+// sameIndexingPolicy reports whether a container's indexing policy matches the
+// desired one, ignoring the defaults the service fills in.
+func sameIndexingPolicy(current *azcosmos.IndexingPolicy, desired *azcosmos.IndexingPolicy) bool {
+	normalize := func(policy *azcosmos.IndexingPolicy) ([]byte, error) {
+		var normalized azcosmos.IndexingPolicy
+		if policy != nil {
+			normalized = *policy
+		}
+		if normalized.IndexingMode == "" {
+			normalized.IndexingMode = azcosmos.IndexingModeConsistent
+		}
+		// the service returns the mode in lower case
+		normalized.IndexingMode = azcosmos.IndexingMode(strings.ToLower(string(normalized.IndexingMode)))
+		normalized.IncludedPaths = slices.Clone(normalized.IncludedPaths)
+		slices.SortFunc(normalized.IncludedPaths, func(x, y azcosmos.IncludedPath) int {
+			return strings.Compare(x.Path, y.Path)
+		})
+		normalized.ExcludedPaths = slices.DeleteFunc(slices.Clone(normalized.ExcludedPaths), func(path azcosmos.ExcludedPath) bool {
+			return path.Path == etagPath
+		})
+		slices.SortFunc(normalized.ExcludedPaths, func(x, y azcosmos.ExcludedPath) int {
+			return strings.Compare(x.Path, y.Path)
+		})
+		return json.Marshal(normalized)
+	}
+	x, err := normalize(current)
+	if err != nil {
+		return false
+	}
+	y, err := normalize(desired)
+	return err == nil && string(x) == string(y)
+}
+
+// The partition key paths of the containers next to the rules containers.
+const (
+	eventPartitionKeyPath    = "/pType"
+	auditPartitionKeyPath    = "/correlationId"
+	snapshotPartitionKeyPath = "/snapshot"
+)
+
+// partitionedContainer returns the properties of a container partitioned by
+// the path, with the defaults of Cosmos otherwise.
+func partitionedContainer(name string, path string) azcosmos.ContainerProperties {
+	return azcosmos.ContainerProperties{
+		ID: name,
+		PartitionKeyDefinition: azcosmos.PartitionKeyDefinition{
+			Paths: []string{path},
+		},
+	}
+}
+
+// DesiredContainers returns the properties of the containers the adapter
+// described by options expects, as created by EnsureInfrastructure: the rules
+// containers first, then the event, audit and snapshot containers.
+func DesiredContainers(options Options) []azcosmos.ContainerProperties {
+	a := &Adapter{
+		containerName:            options.ContainerName,
+		ruleExpiry:               options.RuleExpiry,
+		compat:                   options.Compat,
+		groupingContainerName:    options.GroupingContainerName,
+		ptypeContainers:          options.PTypeContainers,
+		partitionStrategy:        options.PartitionStrategy,
+		conflictResolutionPolicy: options.ConflictResolutionPolicy,
+		indexingPolicy:           options.IndexingPolicy,
+		analyticalStoreTTL:       analyticalStoreTTL(options.AnalyticalStoreTTL),
+	}
+	var containers []azcosmos.ContainerProperties
+	for _, name := range a.ruleContainerNames() {
+		containers = append(containers, a.containerProperties(name))
+	}
+	if options.EventSourcing {
+		containers = append(containers, partitionedContainer(eventContainerName(options), eventPartitionKeyPath))
+	}
+	if options.AuditContainerName != "" {
+		containers = append(containers, partitionedContainer(options.AuditContainerName, auditPartitionKeyPath))
+	}
+	if options.SnapshotContainerName != "" {
+		containers = append(containers, partitionedContainer(options.SnapshotContainerName, snapshotPartitionKeyPath))
+	}
+	return containers
+}
+
+// InfrastructureFormat is the output format of WriteInfrastructure.
+type InfrastructureFormat int
+
+const (
+	// InfrastructureJSON writes a JSON array of the container resources, as
+	// accepted by the Cosmos REST API and the resource property of ARM
+	// templates and Terraform's azapi provider.
+	InfrastructureJSON InfrastructureFormat = iota
+	// InfrastructureARM writes an ARM template creating the database and the
+	// containers in the account given by the accountName parameter.
+	InfrastructureARM
+	// InfrastructureBicep writes a Bicep file creating the database and the
+	// containers in the account given by the accountName parameter.
+	InfrastructureBicep
+)
+
+// armAPIVersion is the API version of the Microsoft.DocumentDB resources
+// written by WriteInfrastructure.
+const armAPIVersion = "2024-05-15"
+
+// WriteInfrastructure writes the database and the containers the adapter
+// described by options expects, see DesiredContainers, in the format, so the
+// provisioning can be owned by ARM, Bicep or Terraform. Regenerate it when the
+// options change, and create the adapters with Options.SkipAutoCreate.
+func WriteInfrastructure(w io.Writer, options Options, format InfrastructureFormat) error {
+	var resources []any
+	for _, properties := range DesiredContainers(options) {
+		resource, err := containerResource(properties)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, resource)
+	}
+
+	switch format {
+	case InfrastructureJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(resources)
+	case InfrastructureARM:
+		template := armTemplate(options.DatabaseName, resources)
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(template)
+	case InfrastructureBicep:
+		_, err := io.WriteString(w, bicepFile(options.DatabaseName, resources))
+		return err
+	default:
+		return fmt.Errorf("unknown infrastructure format %d", format)
+	}
+}
+
+// containerResource returns the container properties as the JSON resource of
+// the REST API, with keys in a stable order.
+func containerResource(properties azcosmos.ContainerProperties) (map[string]any, error) {
+	marshalled, err := json.Marshal(properties)
+	if err != nil {
+		return nil, err
+	}
+	var resource map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(marshalled))
+	decoder.UseNumber()
+	if err := decoder.Decode(&resource); err != nil {
+		return nil, err
+	}
+	// the rules containers rely on the document IDs being unique per partition
+	resource["uniqueKeyPolicy"] = map[string]any{"uniqueKeys": []any{}}
+	return resource, nil
+}
+
+func armTemplate(database string, resources []any) map[string]any {
+	databaseName := fmt.Sprintf("[format('{0}/{1}', parameters('accountName'), '%s')]", database)
+	databaseID := fmt.Sprintf("[resourceId('Microsoft.DocumentDB/databaseAccounts/sqlDatabases', parameters('accountName'), '%s')]", database)
+	armResources := []any{map[string]any{
+		"type":       "Microsoft.DocumentDB/databaseAccounts/sqlDatabases",
+		"apiVersion": armAPIVersion,
+		"name":       databaseName,
+		"properties": map[string]any{"resource": map[string]any{"id": database}},
+	}}
+	for _, resource := range resources {
+		name := resource.(map[string]any)["id"]
+		armResources = append(armResources, map[string]any{
+			"type":       "Microsoft.DocumentDB/databaseAccounts/sqlDatabases/containers",
+			"apiVersion": armAPIVersion,
+			"name":       fmt.Sprintf("[format('{0}/{1}/{2}', parameters('accountName'), '%s', '%s')]", database, name),
+			"dependsOn":  []any{databaseID},
+			"properties": map[string]any{"resource": resource},
+		})
+	}
+	return map[string]any{
+		"$schema":        "https://schema.management.azure.com/schemas/2019-04-01/deploymentTemplate.json#",
+		"contentVersion": "1.0.0.0",
+		"parameters": map[string]any{
+			"accountName": map[string]any{"type": "string"},
+		},
+		"resources": armResources,
+	}
+}
+
+func bicepFile(database string, resources []any) string {
+	var b strings.Builder
+	b.WriteString("param accountName string\n\n")
+	fmt.Fprintf(&b, "resource account 'Microsoft.DocumentDB/databaseAccounts@%s' existing = {\n  name: accountName\n}\n\n", armAPIVersion)
+	fmt.Fprintf(&b, "resource database 'Microsoft.DocumentDB/databaseAccounts/sqlDatabases@%s' = {\n", armAPIVersion)
+	fmt.Fprintf(&b, "  parent: account\n  name: %s\n  properties: {\n    resource: {\n      id: %s\n    }\n  }\n}\n", bicepString(database), bicepString(database))
+	for i, resource := range resources {
+		name := resource.(map[string]any)["id"].(string)
+		fmt.Fprintf(&b, "\nresource container%d 'Microsoft.DocumentDB/databaseAccounts/sqlDatabases/containers@%s' = {\n", i, armAPIVersion)
+		fmt.Fprintf(&b, "  parent: database\n  name: %s\n  properties: {\n    resource: ", bicepString(name))
+		writeBicepValue(&b, resource, "    ")
+		b.WriteString("\n  }\n}\n")
+	}
+	return b.String()
+}
+
+var bicepIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// writeBicepValue writes a decoded JSON value as a Bicep expression.
+func writeBicepValue(b *strings.Builder, value any, indent string) {
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			b.WriteString("{}")
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteString("{\n")
+		for _, key := range keys {
+			b.WriteString(indent + "  ")
+			if bicepIdentifier.MatchString(key) {
+				b.WriteString(key)
+			} else {
+				b.WriteString(bicepString(key))
+			}
+			b.WriteString(": ")
+			writeBicepValue(b, v[key], indent+"  ")
+			b.WriteString("\n")
+		}
+		b.WriteString(indent + "}")
+	case []any:
+		if len(v) == 0 {
+			b.WriteString("[]")
+			return
+		}
+		b.WriteString("[\n")
+		for _, item := range v {
+			b.WriteString(indent + "  ")
+			writeBicepValue(b, item, indent+"  ")
+			b.WriteString("\n")
+		}
+		b.WriteString(indent + "]")
+	case string:
+		b.WriteString(bicepString(v))
+	case json.Number:
+		b.WriteString(v.String())
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	default:
+		b.WriteString("null")
+	}
+}
+
+// bicepString returns s as a Bicep string literal.
+func bicepString(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", `\${`)
+	return "'" + replacer.Replace(s) + "'"
+}
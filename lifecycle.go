@@ -0,0 +1,151 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// ErrClosed is returned by the operations of an adapter after Close.
+var ErrClosed = errors.New("cosmosadapter: adapter closed")
+
+// lifecycle tracks the background components started on an adapter and its
+// running operations, for Close, and the deferred infrastructure checks of
+// Options.LazyConnect. The zero value is an open and connected adapter.
+type lifecycle struct {
+	mu         sync.Mutex
+	closed     bool
+	running    int
+	idle       chan struct{}
+	components []*component
+	// transport is Options.Transport, whose idle connections are closed by
+	// Close.
+	transport policy.Transporter
+
+	// pending creates the infrastructure of a lazily connected adapter, until
+	// it succeeds, guarded by connectMu.
+	connectMu sync.Mutex
+	pending   func(ctx context.Context) error
+	connected atomic.Bool
+}
+
+// component is a background component stopped by Close.
+type component struct {
+	stop func()
+}
+
+// track registers the stop function of a background component, and returns
+// the function removing it, called by the component when it is stopped.
+func (l *lifecycle) track(stop func()) (untrack func()) {
+	c := &component{stop: stop}
+	l.mu.Lock()
+	l.components = append(l.components, c)
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, tracked := range l.components {
+			if tracked == c {
+				l.components = append(l.components[:i], l.components[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// begin counts a starting operation, and returns false if the adapter is
+// closed, unless the operation is nested in a running one, which Close waits
+// for.
+func (l *lifecycle) begin(nested bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed && !nested {
+		return false
+	}
+	l.running++
+	return true
+}
+
+// end counts an operation that began as finished.
+func (l *lifecycle) end() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	if l.closed && l.running == 0 {
+		close(l.idle)
+	}
+}
+
+// connect runs the infrastructure checks deferred by Options.LazyConnect, if
+// they did not succeed yet. Concurrent operations wait for the first one.
+func (l *lifecycle) connect(ctx context.Context) error {
+	if l.connected.Load() {
+		return nil
+	}
+	l.connectMu.Lock()
+	defer l.connectMu.Unlock()
+	if l.pending != nil {
+		if err := l.pending(ctx); err != nil {
+			return err
+		}
+		l.pending = nil
+	}
+	l.connected.Store(true)
+	return nil
+}
+
+// close marks the adapter as closed, and returns a channel closed once its
+// running operations finished.
+func (l *lifecycle) close() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		l.idle = make(chan struct{})
+		if l.running == 0 {
+			close(l.idle)
+		}
+	}
+	return l.idle
+}
+
+// Close shuts the adapter down: it stops the background components started on
+// it, the PollingWatchers, ChangeFeedProcessors, SnapshotSchedulers and
+// AutoReloadEnforcers, most recent first, waits for the running operations,
+// such as a SavePolicy or an import, to complete their writes, and closes the
+// idle connections of Options.Transport if it has a CloseIdleConnections
+// method, like an *http.Client. Operations started afterwards fail with
+// ErrClosed, without sending requests.
+//
+// If ctx is done before the running operations complete, Close returns its
+// error; they keep running, and calling Close again waits for them. Close is
+// safe to call several times.
+func (a *Adapter) Close(ctx context.Context) error {
+	// stop the components first, so the operations they are running complete
+	// rather than fail
+	for {
+		a.lifecycle.mu.Lock()
+		components := a.lifecycle.components
+		a.lifecycle.components = nil
+		a.lifecycle.mu.Unlock()
+		if len(components) == 0 {
+			break
+		}
+		for i := len(components) - 1; i >= 0; i-- {
+			components[i].stop()
+		}
+	}
+
+	select {
+	case <-a.lifecycle.close():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if t, ok := a.lifecycle.transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package cosmosadapter
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// loggerFrom returns the logger configured in the options, or a logger
+// discarding everything.
+func loggerFrom(options Options) *slog.Logger {
+	if options.Logger != nil {
+		return options.Logger
+	}
+	return slog.New(slog.DiscardHandler)
+}
+
+// throttleLogger is a pipeline policy logging throttled (429) responses.
+type throttleLogger struct {
+	logger *slog.Logger
+}
+
+func (p throttleLogger) Do(req *policy.Request) (*http.Response, error) {
+	res, err := req.Next()
+	if err == nil && res.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := strconv.Atoi(res.Header.Get("x-ms-retry-after-ms"))
+		p.logger.Warn("cosmos request throttled",
+			"method", req.Raw().Method,
+			"path", req.Raw().URL.Path,
+			"retry_after_ms", retryAfter)
+	}
+	return res, err
+}
@@ -0,0 +1,138 @@
+package cosmosadapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DocumentMapper controls how rules map to Cosmos documents, to use custom
+// field names or extra fields, or to coexist with documents written by other
+// tools. Set it with Options.Mapper.
+//
+// The document must keep the "id" field, and the rule's PType in the "pType"
+// field, the partition key. The features relying on other fields
+// (Tombstones, Namespace, RuleExpiry, GenerationalSave) need them under their
+// default names.
+type DocumentMapper interface {
+	// ToDocument returns the JSON document storing the rule.
+	ToDocument(rule CasbinRule) ([]byte, error)
+	// FromDocument decodes a stored document. It may receive projections of
+	// documents holding only the fields used internally, such as id and pType.
+	FromDocument(document []byte) (CasbinRule, error)
+}
+
+// jsonMapper is the default DocumentMapper, storing CasbinRule as is. When
+// strict, it rejects the documents with fields CasbinRule doesn't have, see
+// Options.StrictDecoding.
+type jsonMapper struct {
+	strict bool
+}
+
+func (jsonMapper) ToDocument(rule CasbinRule) ([]byte, error) {
+	return json.Marshal(rule)
+}
+
+func (m jsonMapper) FromDocument(document []byte) (CasbinRule, error) {
+	// decoded into a pooled rule, which would otherwise escape to the heap
+	// for every document of a load
+	decoded := rulePool.Get().(*CasbinRule)
+	defer func() {
+		*decoded = CasbinRule{}
+		rulePool.Put(decoded)
+	}()
+	if err := json.Unmarshal(document, decoded); err != nil {
+		return *decoded, err
+	}
+	rule := *decoded
+	if m.strict && rule.PType != policyVersionID {
+		return rule, checkRuleFields(document)
+	}
+	return rule, nil
+}
+
+var rulePool = sync.Pool{New: func() any { return new(CasbinRule) }}
+
+// ruleFields are the JSON field names of CasbinRule.
+var ruleFields = func() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(CasbinRule{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = true
+	}
+	return fields
+}()
+
+// checkRuleFields returns an error if the document has a field CasbinRule
+// doesn't have, or a null field. The Cosmos system properties, starting with
+// an underscore, are allowed.
+func checkRuleFields(document []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(document, &fields); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	// report the same field first whatever the map order
+	sort.Strings(names)
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, "_"):
+		case !ruleFields[name]:
+			return fmt.Errorf("unknown field %q", name)
+		case string(fields[name]) == "null":
+			return fmt.Errorf("field %q is null", name)
+		}
+	}
+	return nil
+}
+
+// customMapping reports whether a custom DocumentMapper is configured, in which
+// case the adapter can't rely on the stored field names in its queries.
+func (a *Adapter) customMapping() bool {
+	_, ok := a.mapper.(jsonMapper)
+	return !ok
+}
+
+// ruleProjection selects the fields of CasbinRule, leaving out the system
+// properties the adapter doesn't use, such as _rid, _self, _etag and
+// _attachments, to cut the size and request charge of the pages of the loads.
+var ruleProjection = func() string {
+	var fields []string
+	t := reflect.TypeOf(CasbinRule{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, "c."+name)
+	}
+	return "SELECT " + strings.Join(fields, ", ") + " FROM c"
+}()
+
+// selectRules returns the "SELECT ... FROM c" of the queries loading rules: a
+// projection on the fields of CasbinRule, or every field with a custom
+// DocumentMapper, which may read fields of its own.
+func (a *Adapter) selectRules() string {
+	if a.customMapping() {
+		return "SELECT * FROM c"
+	}
+	return ruleProjection
+}
+
+// matchesFilter reports whether the rule matches the filter of RemoveFilteredPolicy.
+func matchesFilter(line CasbinRule, fieldIndex int, fieldValues []string) bool {
+	tokens := policyTokens(line)
+	for i, value := range fieldValues {
+		if value == "" {
+			continue
+		}
+		if fieldIndex+i >= len(tokens) || tokens[fieldIndex+i] != value {
+			return false
+		}
+	}
+	return true
+}
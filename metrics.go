@@ -0,0 +1,71 @@
+package cosmosadapter
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// metrics holds the instruments recording adapter operations.
+type metrics struct {
+	duration      metric.Float64Histogram
+	requestCharge metric.Float64Counter
+	items         metric.Int64Counter
+	pages         metric.Int64Counter
+	errors        metric.Int64Counter
+}
+
+// newMetrics creates the instruments from the meter provider, or from the
+// global one when it is nil. Instrument creation errors are reported to the
+// OpenTelemetry error handler, the instrument still being usable.
+func newMetrics(provider metric.MeterProvider) *metrics {
+	if provider == nil {
+		provider = otel.GetMeterProvider()
+	}
+	meter := provider.Meter(instrumentationName)
+
+	var m metrics
+	var err error
+	if m.duration, err = meter.Float64Histogram("cosmosadapter.operation.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of adapter operations.")); err != nil {
+		otel.Handle(err)
+	}
+	if m.requestCharge, err = meter.Float64Counter("cosmosadapter.request_charge",
+		metric.WithUnit("{RU}"),
+		metric.WithDescription("Request units consumed by adapter operations.")); err != nil {
+		otel.Handle(err)
+	}
+	if m.items, err = meter.Int64Counter("cosmosadapter.items",
+		metric.WithUnit("{item}"),
+		metric.WithDescription("Documents read or written by adapter operations.")); err != nil {
+		otel.Handle(err)
+	}
+	if m.pages, err = meter.Int64Counter("cosmosadapter.pages",
+		metric.WithUnit("{page}"),
+		metric.WithDescription("Query pages fetched by adapter operations.")); err != nil {
+		otel.Handle(err)
+	}
+	if m.errors, err = meter.Int64Counter("cosmosadapter.errors",
+		metric.WithUnit("{error}"),
+		metric.WithDescription("Adapter operations that failed.")); err != nil {
+		otel.Handle(err)
+	}
+	return &m
+}
+
+// record records a finished operation.
+func (m *metrics) record(op *operation, err error) {
+	ctx := context.Background()
+	attrs := metric.WithAttributes(attribute.String("db.operation", op.name))
+	m.duration.Record(ctx, time.Since(op.start).Seconds(), attrs)
+	m.requestCharge.Add(ctx, op.requestCharge, attrs)
+	m.items.Add(ctx, int64(op.items), attrs)
+	m.pages.Add(ctx, int64(op.pages), attrs)
+	if err != nil {
+		m.errors.Add(ctx, 1, attrs)
+	}
+}
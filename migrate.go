@@ -0,0 +1,97 @@
+package cosmosadapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// sqlBatchSize is the number of rules MigrateFromSQL reads before writing them.
+const sqlBatchSize = 1000
+
+// MigrateFromSQL copies the rules of the casbin_rule table used by the SQL
+// adapters, such as the gorm and xorm adapters, into Cosmos. The table has a
+// ptype column and the v0 to v5 columns, which may be NULL. An empty table
+// name means "casbin_rule". The table name is used as is in the query, so it
+// must not come from untrusted input.
+//
+// Rules are upserted, so the migration can be run again after a failure, and
+// the stored rules that are not in the table are kept. It returns the number of
+// rules written. The driver of the database must be registered by the caller.
+func (a *Adapter) MigrateFromSQL(ctx context.Context, db *sql.DB, table string) (n int, err error) {
+	ctx, op := a.startOperation(ctx, "MigrateFromSQL")
+	defer func() { err = a.endOperation(op, err) }()
+
+	var batch []CasbinRule
+	flush := func() error {
+		var events []PolicyEvent
+		for _, line := range batch {
+			events = append(events, a.newEvent(ctx, EventAdd, line.PType, policyTokens(line)))
+		}
+		if err := a.appendEvents(ctx, events...); err != nil {
+			return err
+		}
+		written, err := a.bulkWrite(ctx, batch, ImportOptions{})
+		n += written
+		if err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+	err = readSQLRules(ctx, db, table, func(ptype string, rule []string) error {
+		if err := a.validateRule(ptype, rule); err != nil {
+			return err
+		}
+		batch = append(batch, a.newPolicyLine(ptype, rule))
+		if len(batch) < sqlBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, a.policyChanged(ctx, PolicyChange{})
+}
+
+// readSQLRules calls fn with every rule of the casbin_rule table. Rows without
+// a policy type are skipped, and the values stop at the first empty one.
+func readSQLRules(ctx context.Context, db *sql.DB, table string, fn func(ptype string, rule []string) error) error {
+	if table == "" {
+		table = "casbin_rule"
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT ptype, v0, v1, v2, v3, v4, v5 FROM %s", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ptype sql.NullString
+		values := make([]sql.NullString, 6)
+		if err := rows.Scan(&ptype, &values[0], &values[1], &values[2], &values[3], &values[4], &values[5]); err != nil {
+			return err
+		}
+		if ptype.String == "" {
+			continue
+		}
+		var rule []string
+		for _, value := range values {
+			if value.String == "" {
+				break
+			}
+			rule = append(rule, value.String)
+		}
+		if err := fn(ptype.String, rule); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
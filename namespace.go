@@ -0,0 +1,66 @@
+package cosmosadapter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// versionID returns the id of the policy version document of the namespace.
+func (a *Adapter) versionID() string {
+	if a.namespace == "" {
+		return policyVersionID
+	}
+	return a.namespace + ":" + policyVersionID
+}
+
+// inNamespace restricts one of the adapter's own queries to the documents of
+// the namespace.
+func (a *Adapter) inNamespace(query string, parameters []azcosmos.QueryParameter) (string, []azcosmos.QueryParameter) {
+	if a.namespace == "" {
+		return query, parameters
+	}
+	return restrictQuery(query, parameters, "namespace", "@namespace", a.namespace)
+}
+
+// restrictQuery adds the condition field = value to a query of the form
+// "SELECT ... FROM alias [WHERE condition]".
+func restrictQuery(query string, parameters []azcosmos.QueryParameter, field string, name string, value string) (string, []azcosmos.QueryParameter) {
+	from := strings.Index(query, " FROM ") + len(" FROM ")
+	alias := strings.Fields(query[from:])[0]
+	predicate := alias + "." + field + " = " + name
+	if where := strings.Index(query, " WHERE "); where >= 0 {
+		query = query[:where] + " WHERE " + predicate + " AND (" + query[where+len(" WHERE "):] + ")"
+	} else {
+		query += " WHERE " + predicate
+	}
+	return query, append(append([]azcosmos.QueryParameter{}, parameters...), azcosmos.QueryParameter{Name: name, Value: value})
+}
+
+// inOtherNamespace reports whether the document belongs to another namespace
+// than the adapter's.
+func (a *Adapter) inOtherNamespace(line CasbinRule) bool {
+	return a.namespace != "" && line.Namespace != a.namespace
+}
+
+// clearNamespace deletes the rules of the namespace of the policy types.
+// SavePolicy uses it instead of dropping a container shared with other
+// namespaces.
+func (a *Adapter) clearNamespace(ctx context.Context, ptypes []string) error {
+	for _, ptype := range ptypes {
+		query, parameters := a.inNamespace("SELECT c.id, c.pType, c.partitionKey, c.namespace FROM c", nil)
+		lines, err := a.query(ctx, query, ptype, parameters)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			res, err := a.containerFor(line.PType).DeleteItem(ctx, a.partitionKey(line), line.ID, nil)
+			if err != nil {
+				return err
+			}
+			operationFrom(ctx).record(res.Response, 1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package cosmosadapter
+
+import (
+	"slices"
+	"strings"
+)
+
+// Normalizer returns the value stored at index of a rule of the policy type,
+// see Options.Normalizer. It must be safe for concurrent use, and normalizing
+// a normalized value must not change it.
+type Normalizer func(ptype string, index int, value string) string
+
+// NormalizeAll returns a Normalizer applying the normalizers in order.
+func NormalizeAll(normalizers ...Normalizer) Normalizer {
+	return func(ptype string, index int, value string) string {
+		for _, normalizer := range normalizers {
+			value = normalizer(ptype, index, value)
+		}
+		return value
+	}
+}
+
+// TrimSpace is a Normalizer removing the leading and trailing white space of
+// every value, so "alice " and "alice" are the same subject.
+func TrimSpace(ptype string, index int, value string) string {
+	return strings.TrimSpace(value)
+}
+
+// LowerCase returns a Normalizer lowercasing the values at the indexes of the
+// rules of the policy type, such as 0 and 1 for the subjects and objects of
+// "p" rules, or every value of the rules if no index is given. Values of other
+// policy types are left unchanged.
+func LowerCase(ptype string, indexes ...int) Normalizer {
+	return atIndexes(ptype, indexes, strings.ToLower)
+}
+
+// atIndexes returns a Normalizer applying f to the values at the indexes of
+// the rules of the policy type, or to every value of the rules if there are no
+// indexes.
+func atIndexes(ptype string, indexes []int, f func(value string) string) Normalizer {
+	return func(rulePType string, index int, value string) string {
+		if rulePType != ptype {
+			return value
+		}
+		if len(indexes) == 0 || slices.Contains(indexes, index) {
+			return f(value)
+		}
+		return value
+	}
+}
+
+// normalize returns the rule with its values normalized by Options.Normalizer.
+// The rule passed in is not modified.
+func (a *Adapter) normalize(ptype string, rule []string) []string {
+	return a.normalizeFrom(ptype, 0, rule)
+}
+
+// normalizeFrom normalizes values starting at index fieldIndex of a rule, as
+// passed to RemoveFilteredPolicy. Empty values match any value and are kept.
+func (a *Adapter) normalizeFrom(ptype string, fieldIndex int, values []string) []string {
+	if a.normalizer == nil {
+		return values
+	}
+	normalized := make([]string, len(values))
+	for i, value := range values {
+		if value != "" {
+			value = a.normalizer(ptype, fieldIndex+i, value)
+		}
+		normalized[i] = value
+	}
+	return normalized
+}
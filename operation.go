@@ -0,0 +1,372 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/rickdana/cosmos-casbin-adapter"
+
+// operation tracks a single adapter operation, such as a LoadPolicy or an
+// AddPolicy call, across the Cosmos requests it makes. The requests may be
+// made concurrently, mu guards the counters.
+type operation struct {
+	name   string
+	start  time.Time
+	span   trace.Span
+	logger *slog.Logger
+	// adapter runs the operation. The operations it starts meanwhile are
+	// nested in it, those of other adapters, such as the target of a
+	// CopyPolicy, are not.
+	adapter *Adapter
+
+	mu            sync.Mutex
+	requestCharge float64
+	items         int
+	pages         int
+	statusCode    int
+	activityID    string
+	requests      int
+	attempts      int
+	retries       int
+
+	// logQueries and redactQueryParameters are copied from the options.
+	logQueries            bool
+	redactQueryParameters bool
+	// correlationID is recorded in the audit records of the operation.
+	correlationID string
+	// err, if set, fails the operation without sending its requests: ErrClosed
+	// after Close, or the error of the deferred infrastructure checks or of
+	// reading the generation of the rules.
+	err error
+	// began is set if the operation counts as running for Close.
+	began bool
+	// cancel releases the deadline set by the timeout of the kind of the
+	// operation, if any.
+	cancel context.CancelFunc
+	// loading is set by loads, which collect the malformed documents they
+	// skip in malformed, guarded by mu, to report them all at the end.
+	loading   bool
+	malformed []MalformedDocument
+	// secondary is set once LoadPolicy fell back to reading from
+	// Options.SecondaryRegion, see readContainer.
+	secondary bool
+}
+
+type operationKey struct{}
+
+// OperationInfo describes a finished adapter operation, see Options.OnOperation.
+type OperationInfo struct {
+	// Name is the operation, such as "LoadPolicy" or "AddPolicy".
+	Name     string
+	Duration time.Duration
+	// StatusCode is the HTTP status of the last Cosmos response, or of the
+	// failed request. It is zero if the operation made no request.
+	StatusCode int
+	// RequestCharge is the request units consumed by all the Cosmos requests.
+	RequestCharge float64
+	// ActivityID identifies the last Cosmos request, for Azure support.
+	ActivityID string
+	// Retries counts the requests retried, by the SDK because of throttling or
+	// transient failures, or by the adapter after a concurrent update. SDK
+	// retries are only counted for adapters created by
+	// NewAdapterFromConnectionString or NewAdapterFromCredential, which
+	// configure the client.
+	Retries int
+	Err     error
+}
+
+// startOperation starts tracking the operation and returns a context carrying it.
+func (a *Adapter) startOperation(ctx context.Context, name string) (context.Context, *operation) {
+	op := &operation{
+		name:                  name,
+		start:                 time.Now(),
+		adapter:               a,
+		logger:                a.logger.With("operation", name),
+		logQueries:            a.logQueries,
+		redactQueryParameters: a.redactQueryParameters,
+	}
+	request := requestFrom(ctx)
+	if request.correlationID != "" {
+		op.correlationID = request.correlationID
+		op.logger = op.logger.With("correlation_id", request.correlationID)
+	} else if a.auditClient != nil {
+		op.correlationID = a.correlationID()
+	}
+	if request.actor != "" {
+		op.logger = op.logger.With("actor", request.actor)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		if timeout := a.timeouts.of(name); timeout > 0 {
+			ctx, op.cancel = context.WithTimeout(ctx, timeout)
+		}
+	}
+	parent := operationFrom(ctx)
+	nested := parent != nil && parent.err == nil && parent.adapter == a
+	op.began = a.lifecycle.begin(nested)
+	if !op.began {
+		op.err = ErrClosed
+	} else if !nested {
+		op.err = a.lifecycle.connect(ctx)
+		if op.err == nil && a.generational {
+			op.err = a.refreshGeneration(ctx)
+		}
+	}
+	if op.err != nil {
+		// fail the requests of the operation without sending them
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		cancel(op.err)
+	}
+	ctx, op.span = a.tracer.Start(ctx, "cosmosadapter."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "cosmosdb"),
+			attribute.String("db.name", a.databaseName),
+			attribute.String("db.cosmosdb.container", a.containerName),
+			attribute.String("db.operation", name),
+		))
+	if request.correlationID != "" {
+		op.span.SetAttributes(attribute.String("cosmosadapter.correlation_id", request.correlationID))
+	}
+	if request.actor != "" {
+		op.span.SetAttributes(attribute.String("enduser.id", request.actor))
+	}
+	return context.WithValue(ctx, operationKey{}, op), op
+}
+
+// endOperation finishes tracking the operation, and returns err with the
+// Cosmos diagnostics attached.
+func (a *Adapter) endOperation(op *operation, err error) error {
+	if op.began {
+		defer a.lifecycle.end()
+	}
+	if op.err != nil {
+		err = op.err
+	}
+	if err == nil && len(op.malformed) > 0 {
+		err = &MalformedDocumentsError{Documents: op.malformed}
+	}
+	err = wrapError(op.name, err)
+	op.span.SetAttributes(
+		attribute.Float64("db.cosmosdb.request_charge", op.requestCharge),
+		attribute.Int("db.cosmosdb.item_count", op.items),
+	)
+	if err != nil {
+		op.span.RecordError(err)
+		op.span.SetStatus(codes.Error, err.Error())
+		if isStatus(err, http.StatusTooManyRequests) {
+			op.logger.Warn("operation throttled", "error", err)
+		}
+		op.logger.Error("operation failed", "error", err, "request_charge", op.requestCharge)
+	} else {
+		op.logger.Debug("operation completed", "duration", time.Since(op.start), "request_charge", op.requestCharge, "items", op.items)
+	}
+	op.span.End()
+	if op.cancel != nil {
+		op.cancel()
+	}
+	a.metrics.record(op, err)
+	a.stats.record(op, err)
+
+	if a.onOperation != nil {
+		var cosmosErr *Error
+		if errors.As(err, &cosmosErr) {
+			op.statusCode = cosmosErr.StatusCode
+			op.activityID = cosmosErr.ActivityID
+		}
+		a.onOperation(OperationInfo{
+			Name:          op.name,
+			Duration:      time.Since(op.start),
+			StatusCode:    op.statusCode,
+			RequestCharge: op.requestCharge,
+			ActivityID:    op.activityID,
+			Retries:       op.retries + op.attempts - op.requests,
+			Err:           err,
+		})
+	}
+	return err
+}
+
+// operationFrom returns the operation tracked by the context, or nil.
+func operationFrom(ctx context.Context) *operation {
+	op, _ := ctx.Value(operationKey{}).(*operation)
+	return op
+}
+
+// record accounts for a Cosmos response returning the given number of items.
+func (op *operation) record(res azcosmos.Response, items int) {
+	if op == nil {
+		return
+	}
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.requestCharge += float64(res.RequestCharge)
+	op.items += items
+	op.activityID = res.ActivityID
+	if res.RawResponse != nil {
+		op.statusCode = res.RawResponse.StatusCode
+	}
+}
+
+// query records a query page executed on behalf of the operation.
+func (op *operation) query(statement string, ptype string, parameters []azcosmos.QueryParameter, continuation string, res azcosmos.QueryItemsResponse) {
+	if op == nil {
+		return
+	}
+	op.record(res.Response, len(res.Items))
+	op.mu.Lock()
+	op.pages++
+	op.mu.Unlock()
+	level := slog.LevelDebug
+	attrs := []any{
+		"statement", statement,
+		"pType", ptype,
+		"request_charge", res.RequestCharge,
+		"items", len(res.Items),
+	}
+	if op.logQueries {
+		level = slog.LevelInfo
+		next := ""
+		if res.ContinuationToken != nil {
+			next = *res.ContinuationToken
+		}
+		attrs = append(attrs,
+			"parameters", op.queryParameters(parameters),
+			"continuation", continuation,
+			"next_continuation", next)
+	}
+	op.logger.Log(context.Background(), level, "query executed", attrs...)
+	op.span.AddEvent("query", trace.WithAttributes(
+		attribute.String("db.statement", statement),
+		attribute.String("db.cosmosdb.partition_key", ptype),
+		attribute.Float64("db.cosmosdb.request_charge", float64(res.RequestCharge)),
+		attribute.Int("db.cosmosdb.item_count", len(res.Items)),
+	))
+}
+
+// queryParameters returns the query parameters to log, with their values
+// redacted if Options.RedactQueryParameters is set.
+func (op *operation) queryParameters(parameters []azcosmos.QueryParameter) map[string]any {
+	logged := make(map[string]any, len(parameters))
+	for _, p := range parameters {
+		if op.redactQueryParameters {
+			logged[p.Name] = "[REDACTED]"
+		} else {
+			logged[p.Name] = p.Value
+		}
+	}
+	return logged
+}
+
+// retry logs a request retried on behalf of the operation.
+func (op *operation) retry(reason string) {
+	if op == nil {
+		return
+	}
+	op.mu.Lock()
+	op.retries++
+	op.mu.Unlock()
+	op.logger.Debug("retrying request", "reason", reason)
+}
+
+// newClientOptions returns the client options of the options, with the
+// policies counting the requests of operations and logging throttled ones.
+func newClientOptions(options Options) *azcosmos.ClientOptions {
+	clientOptions := options.ClientOptions
+	clientOptions.PerCallPolicies = append(append([]policy.Policy{}, clientOptions.PerCallPolicies...), requestCounter{})
+	clientOptions.PerRetryPolicies = append(append([]policy.Policy{}, clientOptions.PerRetryPolicies...), requestCounter{attempts: true})
+	if options.OperationTimeout > 0 {
+		clientOptions.PerCallPolicies = append(clientOptions.PerCallPolicies, callTimeout(options.OperationTimeout))
+	}
+	if options.Logger != nil {
+		// throttled requests are retried by the SDK, so they are otherwise only
+		// visible as latency
+		clientOptions.PerRetryPolicies = append(clientOptions.PerRetryPolicies, throttleLogger{options.Logger})
+	}
+	if options.Faults != nil {
+		// last, so the faults are seen like responses of Cosmos
+		clientOptions.PerRetryPolicies = append(clientOptions.PerRetryPolicies, faultPolicy{options.Faults})
+	}
+	return &clientOptions
+}
+
+// requestCounter is a pipeline policy counting the requests, or the attempts
+// when installed per retry, made on behalf of the operation in the request
+// context. It fails the requests of operations that must not send any.
+type requestCounter struct {
+	attempts bool
+}
+
+func (p requestCounter) Do(req *policy.Request) (*http.Response, error) {
+	if op := operationFrom(req.Raw().Context()); op != nil {
+		if op.err != nil {
+			return nil, op.err
+		}
+		op.mu.Lock()
+		if p.attempts {
+			op.attempts++
+		} else {
+			op.requests++
+		}
+		op.mu.Unlock()
+	}
+	return req.Next()
+}
+
+// callTimeout is a pipeline policy bounding the calls, retries included, whose
+// context has no deadline, see Options.OperationTimeout.
+type callTimeout time.Duration
+
+func (p callTimeout) Do(req *policy.Request) (*http.Response, error) {
+	ctx := req.Raw().Context()
+	if _, ok := ctx.Deadline(); ok {
+		return req.Next()
+	}
+	// the response body is read within the pipeline, so the context can be
+	// canceled on return
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p))
+	defer cancel()
+	return req.WithContext(ctx).Next()
+}
+
+// operationTimeouts are the timeouts of the kinds of operations, see
+// Options.LoadTimeout.
+type operationTimeouts struct {
+	load  time.Duration
+	write time.Duration
+	bulk  time.Duration
+}
+
+// of returns the timeout of the operation, 0 if it has none.
+func (t operationTimeouts) of(operation string) time.Duration {
+	switch operation {
+	case "LoadPolicy", "LoadPolicyDelta", "LoadFilteredPolicy", "LoadPolicyPages", "LoadPolicyForTenant",
+		"LoadPolicyAsOf", "GetEvents", "ReplayEvents", "QueryRules", "ListPolicySnapshots", "PolicyTypes", "VerifyAuditChain",
+		"GetPolicyVersion", "GetAllSubjects", "GetAllObjects", "GetAllActions", "GetAllDomains":
+		return t.load
+	case "AddPolicy", "AddPolicies", "AddPolicyWithExpiry", "RemovePolicy", "RemovePolicies",
+		"RemoveFilteredPolicy", "UpdatePolicy", "UpdatePolicies", "UpdateFilteredPolicies", "DeletePolicySnapshot", "ScaleThroughput", "BurstThroughput", "RestoreThroughput":
+		return t.write
+	case "SavePolicy", "ImportCSV", "ImportDocuments", "MigrateFromSQL", "MigrateSchema", "CopyPolicy",
+		"Backup", "Restore", "RestorePolicyVersion", "SnapshotPolicy", "Dump", "ExportDocuments":
+		return t.bulk
+	}
+	return 0
+}
+
+// defaultTracer returns the tracer used when Options.TracerProvider is not set.
+func defaultTracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(instrumentationName)
+}
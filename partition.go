@@ -0,0 +1,68 @@
+package cosmosadapter
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// PartitionStrategy selects the partition key of the rules container.
+type PartitionStrategy int
+
+const (
+	// PartitionByPType partitions rules by policy type, the default.
+	PartitionByPType PartitionStrategy = iota
+	// PartitionByDomain partitions rules by domain, v1 of p rules and v2 of g
+	// rules, so the rules of a tenant share a logical partition and tenant
+	// scoped loads are single partition queries. It implies Options.Domains.
+	// Rules without a domain are partitioned by policy type.
+	PartitionByDomain
+)
+
+// partitionKeyPath returns the partition key path of the rules container.
+func (a *Adapter) partitionKeyPath() string {
+	if a.partitionStrategy == PartitionByDomain {
+		return "/partitionKey"
+	}
+	if a.compat != nil {
+		return a.compat.partitionKeyPath()
+	}
+	return "/pType"
+}
+
+// partitionKeyValue returns the value of the partition key field of a rule.
+func (a *Adapter) partitionKeyValue(line CasbinRule) string {
+	if a.partitionStrategy != PartitionByDomain {
+		return ""
+	}
+	if domain := a.ruleDomain(line); domain != "" {
+		return domain
+	}
+	return line.PType
+}
+
+// partitionKey returns the partition key of a stored rule.
+func (a *Adapter) partitionKey(line CasbinRule) azcosmos.PartitionKey {
+	if a.partitionStrategy == PartitionByDomain {
+		return azcosmos.NewPartitionKeyString(line.PartitionKey)
+	}
+	return azcosmos.NewPartitionKeyString(line.PType)
+}
+
+// inPolicyType scopes a query to the rules of a policy type. With the default
+// strategy it is a query of the policy type's partition, otherwise a cross
+// partition query filtering on pType. An empty ptype queries every partition.
+func (a *Adapter) inPolicyType(query string, parameters []azcosmos.QueryParameter, ptype string) (string, []azcosmos.QueryParameter, azcosmos.PartitionKey) {
+	if ptype == "" {
+		return query, parameters, azcosmos.NewPartitionKey()
+	}
+	if a.partitionStrategy != PartitionByDomain {
+		return query, parameters, azcosmos.NewPartitionKeyString(ptype)
+	}
+	query, parameters = restrictQuery(query, parameters, "pType", "@policyType", ptype)
+	return query, parameters, azcosmos.NewPartitionKey()
+}
+
+// DomainFilter returns a LoadFilteredPolicy filter loading the rules of the
+// domain, a single partition query with PartitionByDomain.
+func DomainFilter(domain string) *SqlQuerySpec {
+	return Q("SELECT * FROM c WHERE c.partitionKey = @domain", azcosmos.QueryParameter{Name: "@domain", Value: domain})
+}
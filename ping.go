@@ -0,0 +1,76 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// Errors returned by Ping, wrapping the *Error describing the failed request.
+var (
+	// ErrUnauthorized means the credentials were rejected or lack permissions.
+	ErrUnauthorized = errors.New("cosmosadapter: unauthorized")
+	// ErrContainerNotFound means the database or the container does not exist.
+	ErrContainerNotFound = errors.New("cosmosadapter: container not found")
+	// ErrThrottled means Cosmos is rate limiting the account.
+	ErrThrottled = errors.New("cosmosadapter: throttled")
+)
+
+// Ping reads the container properties, a cheap request, to check that Cosmos
+// is reachable with the configured credentials. It is suitable for readiness
+// probes: the returned error matches ErrUnauthorized, ErrContainerNotFound or
+// ErrThrottled with errors.Is when applicable.
+func (a *Adapter) Ping(ctx context.Context) (err error) {
+	ctx, op := a.startOperation(ctx, "Ping")
+	defer func() { err = a.endOperation(op, err) }()
+
+	if cosmosContainer(a.containerClient) == nil {
+		// other containers have no properties, read the policy version instead
+		_, _, err = readVersion(ctx, a.containerClient, a.versionID())
+	} else if a.dataPlaneOnly {
+		err = a.pingVersion(ctx)
+	} else {
+		var res azcosmos.ContainerResponse
+		res, err = cosmosContainer(a.containerClient).Read(ctx, nil)
+		if err == nil {
+			op.record(res.Response, 0)
+		}
+	}
+	switch {
+	case err == nil:
+		return nil
+	case isStatus(err, http.StatusUnauthorized), isStatus(err, http.StatusForbidden):
+		return fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	case isStatus(err, http.StatusNotFound):
+		return fmt.Errorf("%w: %w", ErrContainerNotFound, err)
+	case isStatus(err, http.StatusTooManyRequests):
+		return fmt.Errorf("%w: %w", ErrThrottled, err)
+	default:
+		return err
+	}
+}
+
+// substatusOwnerNotFound is the substatus of the 404 responses of the requests
+// to a database or a container that doesn't exist.
+const substatusOwnerNotFound = "1003"
+
+// pingVersion reads the policy version, a data-plane request, with
+// Options.DataPlaneOnly. A missing policy version is not an error, unlike a
+// missing container.
+func (a *Adapter) pingVersion(ctx context.Context) error {
+	res, err := a.containerClient.ReadItem(ctx, azcosmos.NewPartitionKeyString(policyVersionID), a.versionID(), nil)
+	if err == nil {
+		operationFrom(ctx).record(res.Response, 0)
+		return nil
+	}
+	var resErr *azcore.ResponseError
+	if errors.As(err, &resErr) && resErr.StatusCode == http.StatusNotFound &&
+		(resErr.RawResponse == nil || resErr.RawResponse.Header.Get("x-ms-substatus") != substatusOwnerNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultWriteConcurrency is the number of writes run in parallel by SavePolicy
+// and the imports unless configured otherwise.
+const defaultWriteConcurrency = 4
+
+// forEach calls fn for every item, running up to concurrency calls at once.
+// Once a call fails, the items not started yet are skipped, while the running
+// calls complete. The errors are returned in the order of the items rather
+// than of their completion, joined, so the first one doesn't depend on the
+// scheduling.
+func forEach[T any](ctx context.Context, concurrency int, items []T, fn func(context.Context, T) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	errs := make([]error, len(items))
+	var failed atomic.Bool
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		sem <- struct{}{}
+		if failed.Load() {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if errs[i] = fn(ctx, item); errs[i] != nil {
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failures []error
+	for _, err := range errs {
+		if err != nil {
+			failures = append(failures, err)
+		}
+	}
+	if len(failures) == 1 {
+		// returned as is, to keep its message and type
+		return failures[0]
+	}
+	return errors.Join(failures...)
+}
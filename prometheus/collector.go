@@ -0,0 +1,81 @@
+// Package prometheus exposes the operation statistics of a Cosmos adapter as
+// Prometheus metrics. It is a module of its own, so the adapter doesn't depend
+// on the Prometheus client:
+//
+//	prometheus.MustRegister(cosmosprometheus.NewCollector(a))
+package prometheus
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	cosmosadapter "github.com/rickdana/cosmos-casbin-adapter"
+)
+
+// Collector exposes the operation statistics of an adapter as Prometheus
+// metrics. It reports the same operations as the OpenTelemetry metrics,
+// labelled by operation.
+type Collector struct {
+	adapter *cosmosadapter.Adapter
+
+	operations        *prometheus.Desc
+	errors            *prometheus.Desc
+	duration          *prometheus.Desc
+	requestCharge     *prometheus.Desc
+	lastRequestCharge *prometheus.Desc
+	items             *prometheus.Desc
+	pages             *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns a collector for the adapter, to register with a
+// Prometheus registry.
+func NewCollector(a *cosmosadapter.Adapter) *Collector {
+	labels := []string{"operation"}
+	constLabels := prometheus.Labels{"database": a.DatabaseName(), "container": a.ContainerName()}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("cosmosadapter_"+name, help, labels, constLabels)
+	}
+	return &Collector{
+		adapter:           a,
+		operations:        desc("operations_total", "Number of adapter operations."),
+		errors:            desc("operation_errors_total", "Number of adapter operations that failed."),
+		duration:          desc("operation_duration_seconds_total", "Total time spent in adapter operations."),
+		requestCharge:     desc("request_charge_total", "Request units consumed by adapter operations."),
+		lastRequestCharge: desc("last_request_charge", "Request units consumed by the last adapter operation."),
+		items:             desc("items_total", "Documents read or written by adapter operations."),
+		pages:             desc("pages_total", "Query pages fetched by adapter operations."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.operations
+	ch <- c.errors
+	ch <- c.duration
+	ch <- c.requestCharge
+	ch <- c.lastRequestCharge
+	ch <- c.items
+	ch <- c.pages
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ops := c.adapter.GetOperationStats()
+	names := make([]string, 0, len(ops))
+	for name := range ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := ops[name]
+		ch <- prometheus.MustNewConstMetric(c.operations, prometheus.CounterValue, float64(s.Count), name)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(s.Errors), name)
+		ch <- prometheus.MustNewConstMetric(c.duration, prometheus.CounterValue, s.Duration.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(c.requestCharge, prometheus.CounterValue, s.RequestCharge, name)
+		ch <- prometheus.MustNewConstMetric(c.lastRequestCharge, prometheus.GaugeValue, s.LastRequestCharge, name)
+		ch <- prometheus.MustNewConstMetric(c.items, prometheus.CounterValue, float64(s.Items), name)
+		ch <- prometheus.MustNewConstMetric(c.pages, prometheus.CounterValue, float64(s.Pages), name)
+	}
+}
@@ -0,0 +1,31 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	cosmosadapter "github.com/rickdana/cosmos-casbin-adapter"
+	"github.com/rickdana/cosmos-casbin-adapter/cosmosadaptertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	a, _ := cosmosadaptertest.NewAdapter(cosmosadapter.Options{DatabaseName: "casbin", ContainerName: "casbin_rule"})
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.NoError(t, a.AddPolicy("p", "p", []string{"bob", "data2", "write"}))
+	assert.Error(t, a.RemovePolicy("p", "p", []string{"carol", "data3", "read"}))
+
+	c := NewCollector(a)
+	assert.Equal(t, 14, testutil.CollectAndCount(c))
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP cosmosadapter_operations_total Number of adapter operations.
+# TYPE cosmosadapter_operations_total counter
+cosmosadapter_operations_total{container="casbin_rule",database="casbin",operation="AddPolicy"} 2
+cosmosadapter_operations_total{container="casbin_rule",database="casbin",operation="RemovePolicy"} 1
+# HELP cosmosadapter_operation_errors_total Number of adapter operations that failed.
+# TYPE cosmosadapter_operation_errors_total counter
+cosmosadapter_operation_errors_total{container="casbin_rule",database="casbin",operation="AddPolicy"} 0
+cosmosadapter_operation_errors_total{container="casbin_rule",database="casbin",operation="RemovePolicy"} 1
+`), "cosmosadapter_operations_total", "cosmosadapter_operation_errors_total"))
+}
@@ -0,0 +1,50 @@
+package cosmosadapter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"slices"
+	"strings"
+)
+
+// pseudonymPrefix starts the pseudonyms, which are not pseudonymized again.
+const pseudonymPrefix = "hmac:"
+
+// Pseudonym returns the pseudonym of a value, such as a user ID or an email
+// address: "hmac:" followed by the unpadded base64url HMAC-SHA256 of the value
+// with the key. The same value and key always give the same pseudonym, which
+// can't be reversed without the key. A value starting with "hmac:" is taken for
+// a pseudonym and returned as is, so pseudonymizing is idempotent.
+//
+// Enforcers of pseudonymized rules are given the pseudonyms of the subjects:
+//
+//	ok, err := e.Enforce(cosmosadapter.Pseudonym(key, user), "data1", "read")
+func Pseudonym(key []byte, value string) string {
+	if strings.HasPrefix(value, pseudonymPrefix) {
+		return value
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return pseudonymPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Pseudonymize returns a Normalizer replacing the values at the indexes of the
+// rules of the policy type with their Pseudonym, such as 0 for the subjects of
+// "p" rules, or every value of the rules if no index is given, so the subjects
+// are not stored in Cosmos. Values of other policy types are left unchanged.
+// It panics if the key is empty.
+//
+// Patterns such as those of keyMatch don't match pseudonyms, and the
+// pseudonyms of a key can't be converted to those of another, so the values
+// pseudonymized must be compared for equality only, and the key kept for the
+// lifetime of the rules.
+func Pseudonymize(key []byte, ptype string, indexes ...int) Normalizer {
+	if len(key) == 0 {
+		panic("cosmosadapter: Pseudonymize requires a key")
+	}
+	key = slices.Clone(key)
+	return atIndexes(ptype, indexes, func(value string) string {
+		return Pseudonym(key, value)
+	})
+}
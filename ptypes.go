@@ -0,0 +1,133 @@
+package cosmosadapter
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/casbin/casbin/v2/model"
+)
+
+// policyTypeCache holds the policy types stored at a policy version. Every
+// change of the rules bumps the version, so the cached policy types are
+// reused, by every instance, until the rules change.
+type policyTypeCache struct {
+	mu      sync.Mutex
+	version int64
+	ptypes  []string
+	ok      bool
+}
+
+// get returns the policy types cached for the version.
+func (c *policyTypeCache) get(version int64) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.ok || c.version != version {
+		return nil, false
+	}
+	return slices.Clone(c.ptypes), true
+}
+
+func (c *policyTypeCache) set(version int64, ptypes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version, c.ptypes, c.ok = version, slices.Clone(ptypes), true
+}
+
+// PolicyTypes returns the policy types of the stored rules of the namespace,
+// sorted, such as "p", "p2", "g" and "g2", including those the model of the
+// application doesn't define. They are read from the policy version document,
+// which records the policy types written by SavePolicy and changed since, so
+// they may include policy types whose rules were all removed since the last
+// SavePolicy. When they are not recorded, as after an import or before the
+// first SavePolicy, they are discovered with a query reading the policy type
+// of every document. They are cached until the rules change.
+func (a *Adapter) PolicyTypes(ctx context.Context) (ptypes []string, err error) {
+	ctx, op := a.startOperation(ctx, "PolicyTypes")
+	defer func() { err = a.endOperation(op, err) }()
+	doc, _, err := readVersionDocument(ctx, a.containerClient, a.versionID())
+	if err != nil {
+		return nil, err
+	}
+	return a.storedPolicyTypes(ctx, doc)
+}
+
+// storedPolicyTypes returns the policy types stored at the policy version of
+// the version document, discovering them unless they are recorded or cached.
+func (a *Adapter) storedPolicyTypes(ctx context.Context, doc policyVersion) ([]string, error) {
+	version := doc.Version
+	if ptypes, ok := a.policyTypeCache.get(version); ok {
+		return ptypes, nil
+	}
+	if doc.StoredPTypes != nil {
+		a.policyTypeCache.set(version, *doc.StoredPTypes)
+		return slices.Clone(*doc.StoredPTypes), nil
+	}
+	query := "SELECT c.pType, c.namespace FROM c"
+	if a.customMapping() {
+		// the stored field names are unknown
+		query = "SELECT * FROM c"
+	}
+	query, parameters := a.inNamespace(query, nil)
+	lines, err := a.query(ctx, query, "", parameters)
+	if err != nil {
+		return nil, err
+	}
+	var ptypes []string
+	for _, line := range lines {
+		if line.PType != "" && line.PType != policyVersionID && !a.inOtherNamespace(line) {
+			ptypes = append(ptypes, line.PType)
+		}
+	}
+	slices.Sort(ptypes)
+	ptypes = slices.Compact(ptypes)
+	a.policyTypeCache.set(version, ptypes)
+	return ptypes, nil
+}
+
+// savedPolicyTypes returns the policy types SavePolicy replaces: those of the
+// model, and those stored, so the rules of policy types removed from the model
+// are not left behind.
+func (a *Adapter) savedPolicyTypes(ctx context.Context, model model.Model, current policyVersion) ([]string, error) {
+	stored, err := a.storedPolicyTypes(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+	ptypes := policyTypes(model)
+	for _, ptype := range stored {
+		if !slices.Contains(ptypes, ptype) {
+			ptypes = append(ptypes, ptype)
+		}
+	}
+	return ptypes, nil
+}
+
+// savedLineTypes returns the sorted policy types of the rules written by
+// SavePolicy, the policy types stored once it replaced the rules.
+func savedLineTypes(lines []CasbinRule) []string {
+	var ptypes []string
+	for _, line := range lines {
+		ptypes = append(ptypes, line.PType)
+	}
+	slices.Sort(ptypes)
+	return slices.Compact(ptypes)
+}
+
+// loadedPolicyTypes returns the policy types of the model LoadPolicy queries at
+// the policy version: those with stored rules when they are cached, every
+// policy type of the model otherwise.
+func (a *Adapter) loadedPolicyTypes(model model.Model, version int64) []string {
+	ptypes := policyTypes(model)
+	stored, ok := a.policyTypeCache.get(version)
+	if !ok {
+		return ptypes
+	}
+	for _, ptype := range stored {
+		if !slices.Contains(ptypes, ptype) {
+			a.logger.Warn("the rules of a policy type the model doesn't define are not loaded", "pType", ptype)
+		}
+	}
+	return slices.DeleteFunc(ptypes, func(ptype string) bool {
+		return !slices.Contains(stored, ptype)
+	})
+}
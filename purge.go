@@ -0,0 +1,79 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// canPurge reports whether the rules of the policy type are all the documents
+// of its partition, so RemoveFilteredPolicy without field values can delete
+// the partition instead of each rule: not with a namespace, tombstones,
+// generations or PartitionByDomain, whose partitions hold other documents or
+// whose removals are writes, nor with containers that are not Cosmos
+// containers, such as with Options.DryRun.
+func (a *Adapter) canPurge(ptype string) bool {
+	return ptype != "" && a.rest != nil && !a.partitionDeleteUnsupported.Load() &&
+		a.namespace == "" && !a.tombstones && !a.generational && a.partitionStrategy != PartitionByDomain &&
+		cosmosContainer(a.containerFor(ptype)) != nil
+}
+
+// purgeUnread removes every rule of the policy type like purgePolicyType,
+// without reading them: a single document is read to skip the empty policy
+// types. The change notified affects every domain of the policy type, and the
+// quotas are counted again. It returns false when the partition can't be
+// deleted, for the rules to be read and removed one by one.
+func (a *Adapter) purgeUnread(ctx context.Context, ptype string) (bool, error) {
+	stored, err := a.query(ctx, "SELECT TOP 1 * FROM root", ptype, nil)
+	if err != nil {
+		return false, err
+	}
+	if len(stored) == 0 {
+		return true, nil
+	}
+	if purged, err := a.purgePolicyType(ctx, ptype, nil); !purged || err != nil {
+		return false, err
+	}
+	a.quotas.reset()
+	return true, a.policyChanged(ctx, PolicyChange{PTypes: []string{ptype}})
+}
+
+// purgePolicyType removes the rules of the policy type, found by
+// RemoveFilteredPolicy, with a single delete of their partition, which Cosmos
+// runs in the background, instead of a delete per rule. The account must have
+// the delete by partition key feature enabled: purgePolicyType returns false
+// when Cosmos rejects the request, and the rules are then removed one by one.
+// The removal of the rules must be recorded before, see appendEvents.
+func (a *Adapter) purgePolicyType(ctx context.Context, ptype string, policies []CasbinRule) (bool, error) {
+	link := fmt.Sprintf("dbs/%s/colls/%s", a.databaseName, a.containerNameFor(ptype))
+	pk, err := json.Marshal([]string{ptype})
+	if err != nil {
+		return false, err
+	}
+	res, err := a.rest.do(ctx, http.MethodPost, "partitionkey", link, link+"/operations/partitionkeydelete", map[string]string{
+		"x-ms-documentdb-partitionkey": string(pk),
+	})
+	var resErr *azcore.ResponseError
+	if errors.As(err, &resErr) && resErr.StatusCode >= 400 && resErr.StatusCode < 500 && resErr.StatusCode != http.StatusTooManyRequests {
+		a.partitionDeleteUnsupported.Store(true)
+		a.logger.Warn("deleting a cosmos partition failed, removing the rules one by one", "database", a.databaseName, "container", a.containerNameFor(ptype), "status", resErr.StatusCode, "error", err)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	charge, _ := strconv.ParseFloat(res.Header.Get("x-ms-request-charge"), 32)
+	operationFrom(ctx).record(azcosmos.Response{
+		RawResponse:   res,
+		RequestCharge: float32(charge),
+		ActivityID:    res.Header.Get("x-ms-activity-id"),
+	}, len(policies))
+	return true, nil
+}
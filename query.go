@@ -1,6 +1,12 @@
 package cosmosadapter
 
-import "github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
 
 type QueryParam struct {
 	Name  string      `json:"name"`
@@ -17,3 +23,113 @@ func Q(query string, queryParams ...azcosmos.QueryParameter) *SqlQuerySpec {
 }
 
 type P = QueryParam
+
+// QueryRules runs the query on every rules container and returns the rules it
+// selects, for reports or administration tools, without loading them into a
+// model. The query must select whole documents, such as
+// "SELECT * FROM c WHERE c.v0 = @subject". Like the filters of
+// LoadFilteredPolicy it is not rewritten: the rules of other namespaces are
+// skipped, as are the removed and expired rules.
+func (a *Adapter) QueryRules(ctx context.Context, spec *SqlQuerySpec) (rules []CasbinRule, err error) {
+	ctx, op := a.startOperation(ctx, "QueryRules")
+	defer func() { err = a.endOperation(op, err) }()
+	if spec == nil {
+		return nil, fmt.Errorf("invalid query: nil *SqlQuerySpec")
+	}
+	lines, err := a.query(ctx, spec.Query, "", spec.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	now := a.now()
+	for _, line := range lines {
+		if line.PType == "" || line.PType == policyVersionID || line.Deleted || line.expired(now) {
+			continue
+		}
+		rules = append(rules, line)
+	}
+	return rules, nil
+}
+
+// toQuerySpec converts a filter passed to LoadFilteredPolicy into a SqlQuerySpec.
+func toQuerySpec(filter interface{}) (SqlQuerySpec, error) {
+	switch f := filter.(type) {
+	case SqlQuerySpec:
+		return f, nil
+	case *SqlQuerySpec:
+		if f == nil {
+			return SqlQuerySpec{}, fmt.Errorf("invalid filter: nil *SqlQuerySpec")
+		}
+		return *f, nil
+	default:
+		return SqlQuerySpec{}, fmt.Errorf("invalid filter type %T: expected SqlQuerySpec", filter)
+	}
+}
+
+// filterQuery is the query of RemoveFilteredPolicy matching a set of fields.
+type filterQuery struct {
+	query string
+	// byPType is set when the query matches the policy type
+	byPType bool
+	// names are the parameters of the values of the fields, in order
+	names     []string
+	namespace string
+}
+
+// filterQueryFor returns the query of RemoveFilteredPolicy matching the fields
+// at the indexes, in increasing order. It is built once per set of indexes,
+// and its conditions and parameters are in the order of the fields, so a
+// filter always produces the same query.
+func (a *Adapter) filterQueryFor(indexes []int) filterQuery {
+	// the sets of the fields of rules with up to 64 values are cached
+	var key uint64
+	for _, index := range indexes {
+		if index >= 64 {
+			return a.newFilterQuery(indexes)
+		}
+		key |= 1 << index
+	}
+	if cached, ok := a.filterQueries.Load(key); ok {
+		return cached.(filterQuery)
+	}
+	filter := a.newFilterQuery(indexes)
+	a.filterQueries.Store(key, filter)
+	return filter
+}
+
+func (a *Adapter) newFilterQuery(indexes []int) filterQuery {
+	if a.customMapping() {
+		// the stored field names are unknown, the rules are matched by the caller
+		query, _ := a.inNamespace("SELECT * FROM root", nil)
+		return filterQuery{query: query, namespace: a.namespace}
+	}
+	var b strings.Builder
+	b.WriteString("SELECT * FROM root WHERE root.pType = @pType")
+	names := make([]string, len(indexes))
+	for i, index := range indexes {
+		names[i] = fmt.Sprintf("@v%d", index)
+		if a.arraySchema {
+			// match the documents written before the option was enabled as well
+			fmt.Fprintf(&b, " AND (root.v%d = %s OR root.rule[%d] = %s)", index, names[i], index, names[i])
+		} else {
+			fmt.Fprintf(&b, " AND root.v%d = %s", index, names[i])
+		}
+	}
+	query, _ := a.inNamespace(b.String(), nil)
+	return filterQuery{query: query, byPType: true, names: names, namespace: a.namespace}
+}
+
+// parameters returns the parameters of the query for the policy type and the
+// values of the fields.
+func (q filterQuery) parameters(ptype string, values []string) []azcosmos.QueryParameter {
+	parameters := make([]azcosmos.QueryParameter, 0, len(q.names)+2)
+	if q.byPType {
+		parameters = append(parameters, azcosmos.QueryParameter{Name: "@pType", Value: ptype})
+	}
+	for i, name := range q.names {
+		parameters = append(parameters, azcosmos.QueryParameter{Name: name, Value: values[i]})
+	}
+	if q.namespace != "" {
+		parameters = append(parameters, azcosmos.QueryParameter{Name: "@namespace", Value: q.namespace})
+	}
+	return parameters
+}
@@ -0,0 +1,238 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// ErrQuotaExceeded is matched with errors.Is by the *QuotaError returned when
+// adding a rule would exceed Options.MaxRules or Options.MaxRulesPerDomain.
+var ErrQuotaExceeded = errors.New("cosmosadapter: quota exceeded")
+
+// QuotaError is returned when adding a rule would exceed a quota.
+type QuotaError struct {
+	// Domain is the domain over its quota, empty for Options.MaxRules.
+	Domain string
+	// Namespace is the Options.Namespace of the adapter.
+	Namespace string
+	// Limit is the quota and Count the number of rules stored.
+	Limit int
+	Count int
+}
+
+func (e *QuotaError) Error() string {
+	if e.Domain != "" {
+		return fmt.Sprintf("cosmosadapter: quota exceeded: domain %q holds %d rules, the limit is %d", e.Domain, e.Count, e.Limit)
+	}
+	return fmt.Sprintf("cosmosadapter: quota exceeded: namespace %q holds %d rules, the limit is %d", e.Namespace, e.Count, e.Limit)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// quotaCountTTL is how long a rule count is trusted before it is counted
+// again, which picks up the changes made by other instances.
+const quotaCountTTL = time.Minute
+
+// quotas holds the rule counts checked against the quotas, by domain. The
+// empty domain holds the count of the namespace.
+type quotas struct {
+	maxRules          int
+	maxRulesPerDomain int
+
+	mu     sync.Mutex
+	counts map[string]quotaCount
+}
+
+type quotaCount struct {
+	count     int
+	countedAt time.Time
+}
+
+func newQuotas(options Options) *quotas {
+	if options.MaxRules <= 0 && options.MaxRulesPerDomain <= 0 {
+		return nil
+	}
+	return &quotas{
+		maxRules:          options.MaxRules,
+		maxRulesPerDomain: options.MaxRulesPerDomain,
+		counts:            map[string]quotaCount{},
+	}
+}
+
+// cached returns the count of the domain if it is recent enough.
+func (q *quotas) cached(domain string, now time.Time) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c, ok := q.counts[domain]
+	if !ok || now.Sub(c.countedAt) > quotaCountTTL {
+		return 0, false
+	}
+	return c.count, true
+}
+
+func (q *quotas) set(domain string, count int, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.counts[domain] = quotaCount{count: count, countedAt: now}
+}
+
+// added accounts for rules added to the domains.
+func (q *quotas) added(domains map[string]int) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for domain, n := range domains {
+		if c, ok := q.counts[domain]; ok {
+			c.count += n
+			q.counts[domain] = c
+		}
+	}
+}
+
+// removed drops the counts of the domains rules were removed from, to count
+// them again on the next check rather than guess whether the rules existed.
+func (q *quotas) removed(lines ...CasbinRule) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.counts, "")
+	for _, line := range lines {
+		delete(q.counts, lineDomain(line))
+	}
+}
+
+// reset drops every count, after rules were removed without reading them.
+func (q *quotas) reset() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	clear(q.counts)
+}
+
+// quotaDomains returns the number of rules added per quota: the namespace under
+// the empty domain, and the domains of the rules when MaxRulesPerDomain is set.
+func (a *Adapter) quotaDomains(lines []CasbinRule) map[string]int {
+	domains := map[string]int{}
+	if a.quotas.maxRules > 0 {
+		domains[""] = len(lines)
+	}
+	if a.quotas.maxRulesPerDomain > 0 {
+		for _, line := range lines {
+			if domain := a.ruleDomain(line); domain != "" {
+				domains[domain]++
+			}
+		}
+	}
+	return domains
+}
+
+// checkQuota returns a *QuotaError if adding the rules would exceed a quota.
+// On success it returns the function to call once the rules were added.
+func (a *Adapter) checkQuota(ctx context.Context, lines ...CasbinRule) (func(), error) {
+	if a.quotas == nil {
+		return func() {}, nil
+	}
+	domains := a.quotaDomains(lines)
+	for domain, n := range domains {
+		if err := a.checkDomainQuota(ctx, domain, n); err != nil {
+			return nil, err
+		}
+	}
+	return func() { a.quotas.added(domains) }, nil
+}
+
+// checkDomainQuota returns a *QuotaError if adding n rules to the domain, or
+// to the namespace for the empty domain, would exceed its quota.
+func (a *Adapter) checkDomainQuota(ctx context.Context, domain string, n int) error {
+	limit := a.quotas.maxRules
+	if domain != "" {
+		limit = a.quotas.maxRulesPerDomain
+	}
+	count, err := a.ruleCount(ctx, domain)
+	if err != nil {
+		return err
+	}
+	if count+n > limit {
+		return &QuotaError{Domain: domain, Namespace: a.namespace, Limit: limit, Count: count}
+	}
+	return nil
+}
+
+// checkReplaceQuota returns a *QuotaError if replacing the rules stale with
+// updated would exceed a quota, counting only the rules added beyond those
+// removed. On success it returns the function to call once the rules were
+// replaced.
+func (a *Adapter) checkReplaceQuota(ctx context.Context, stale []CasbinRule, updated []CasbinRule) (func(), error) {
+	if a.quotas == nil {
+		return func() {}, nil
+	}
+	removed := a.quotaDomains(stale)
+	added := map[string]int{}
+	for domain, n := range a.quotaDomains(updated) {
+		if n > removed[domain] {
+			added[domain] = n - removed[domain]
+		}
+	}
+	for domain, n := range added {
+		if err := a.checkDomainQuota(ctx, domain, n); err != nil {
+			return nil, err
+		}
+	}
+	return func() {
+		a.quotas.removed(stale...)
+		a.quotas.added(added)
+	}, nil
+}
+
+// ruleCount returns the number of rules of the domain, or of the namespace for
+// the empty domain, from the cache if possible.
+func (a *Adapter) ruleCount(ctx context.Context, domain string) (int, error) {
+	now := a.now()
+	if count, ok := a.quotas.cached(domain, now); ok {
+		return count, nil
+	}
+
+	// the rules are counted below, as aggregates can't span partitions
+	query := "SELECT * FROM c WHERE c.pType != @versionType"
+	parameters := []azcosmos.QueryParameter{{Name: "@versionType", Value: policyVersionID}}
+	switch {
+	case domain == "" || a.customMapping():
+	case a.partitionStrategy == PartitionByDomain:
+		filter := DomainFilter(domain)
+		query, parameters = filter.Query, filter.Parameters
+	default:
+		query = "SELECT * FROM c WHERE (c.v1 = @domain OR c.v2 = @domain OR c.rule[1] = @domain OR c.rule[2] = @domain)"
+		parameters = []azcosmos.QueryParameter{{Name: "@domain", Value: domain}}
+	}
+	query, parameters = a.inNamespace(query, parameters)
+	lines, err := a.query(ctx, query, "", parameters)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, line := range lines {
+		if line.PType == "" || line.PType == policyVersionID || line.Deleted || line.expired(now) {
+			continue
+		}
+		if domain != "" && lineDomain(line) != domain {
+			continue
+		}
+		count++
+	}
+	a.quotas.set(domain, count, now)
+	return count, nil
+}
@@ -0,0 +1,165 @@
+package cosmosadapter
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// AutoReloadOptions configures an AutoReloadEnforcer.
+type AutoReloadOptions struct {
+	// Interval is the time between two checks of the policy version. The policy
+	// is only reloaded when NeedsReload reports a change. Defaults to one minute.
+	Interval time.Duration
+	// Jitter is the maximum random delay added to every interval, so instances
+	// started together don't reload at the same moment. Defaults to a tenth of
+	// the interval.
+	Jitter time.Duration
+	// MaxBackoff caps the delay between attempts after failed reloads. The delay
+	// doubles with every consecutive failure. Defaults to five minutes.
+	MaxBackoff time.Duration
+	// Watcher, if set, is attached to the enforcer and triggers a reload as soon
+	// as it reports a change instead of waiting for the next interval.
+	Watcher persist.Watcher
+	// OnError, if set, is called with every failed check or reload.
+	OnError func(error)
+}
+
+// AutoReloadEnforcer is a casbin.SyncedEnforcer that reloads its policy from
+// Cosmos on a schedule and on watcher notifications.
+type AutoReloadEnforcer struct {
+	*casbin.SyncedEnforcer
+	adapter *Adapter
+	options AutoReloadOptions
+
+	trigger chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	untrack func()
+}
+
+// NewAutoReloadEnforcer starts reloading the policy of e, which must use a as its
+// adapter, until Stop, or Close of the adapter, is called.
+func NewAutoReloadEnforcer(e *casbin.SyncedEnforcer, a *Adapter, options AutoReloadOptions) (*AutoReloadEnforcer, error) {
+	if options.Interval <= 0 {
+		options.Interval = time.Minute
+	}
+	if options.Jitter <= 0 {
+		options.Jitter = options.Interval / 10
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = 5 * time.Minute
+	}
+
+	r := &AutoReloadEnforcer{
+		SyncedEnforcer: e,
+		adapter:        a,
+		options:        options,
+		trigger:        make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	if options.Watcher != nil {
+		if err := e.SetWatcher(options.Watcher); err != nil {
+			return nil, err
+		}
+		// replace the default callback, which reloads synchronously and ignores errors
+		if err := options.Watcher.SetUpdateCallback(func(string) { r.Trigger() }); err != nil {
+			return nil, err
+		}
+	}
+
+	r.untrack = a.lifecycle.track(r.Stop)
+	go r.run()
+	return r, nil
+}
+
+// Trigger requests a reload without waiting for the next interval.
+func (r *AutoReloadEnforcer) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Stop stops reloading. It does not close the watcher.
+func (r *AutoReloadEnforcer) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+		r.untrack()
+	})
+	<-r.done
+}
+
+func (r *AutoReloadEnforcer) run() {
+	defer close(r.done)
+
+	failures := 0
+	timer := time.NewTimer(r.delay(failures))
+	defer timer.Stop()
+
+	for {
+		force := false
+		select {
+		case <-r.stop:
+			return
+		case <-r.trigger:
+			if failures > 0 {
+				// still backing off, the reload happens when the timer fires
+				continue
+			}
+			force = true
+		case <-timer.C:
+		}
+
+		if err := r.reload(force); err != nil {
+			failures++
+			if r.options.OnError != nil {
+				r.options.OnError(err)
+			}
+		} else {
+			failures = 0
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(r.delay(failures))
+	}
+}
+
+// reload reloads the policy if it changed since the last load, or always when
+// force is set.
+func (r *AutoReloadEnforcer) reload(force bool) error {
+	if !force {
+		changed, err := r.adapter.NeedsReload()
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return nil
+		}
+	}
+	return r.LoadPolicy()
+}
+
+// delay returns the time until the next check after the given number of
+// consecutive failures.
+func (r *AutoReloadEnforcer) delay(failures int) time.Duration {
+	d := r.options.Interval
+	for i := 0; i < failures && d < r.options.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.options.MaxBackoff {
+		d = r.options.MaxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(r.options.Jitter)+1))
+}
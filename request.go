@@ -0,0 +1,162 @@
+package cosmosadapter
+
+import (
+	"context"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+)
+
+type requestKey struct{}
+
+// requestValues are the values of a request bound to a context by WithActor
+// and WithCorrelationID.
+type requestValues struct {
+	actor         string
+	correlationID string
+}
+
+func requestFrom(ctx context.Context) requestValues {
+	values, _ := ctx.Value(requestKey{}).(requestValues)
+	return values
+}
+
+// WithActor returns a context recording actor as the author of the events and
+// audit records of the operations run with it, instead of Options.Actor, such
+// as the user of a request changing the policy.
+func WithActor(ctx context.Context, actor string) context.Context {
+	values := requestFrom(ctx)
+	values.actor = actor
+	return context.WithValue(ctx, requestKey{}, values)
+}
+
+// WithCorrelationID returns a context giving the operations run with it the
+// correlation ID, such as the ID of a request, instead of one from
+// Options.AuditCorrelationID. It is recorded in the audit records, and added
+// to the logs and spans of the operations.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	values := requestFrom(ctx)
+	values.correlationID = id
+	return context.WithValue(ctx, requestKey{}, values)
+}
+
+// actorFrom returns the author of the mutations made with the context.
+func (a *Adapter) actorFrom(ctx context.Context) string {
+	if actor := requestFrom(ctx).actor; actor != "" {
+		return actor
+	}
+	return a.actor
+}
+
+// RequestAdapter is a view of an Adapter running the operations of the casbin
+// interfaces, which take no context, with the context of a request. See
+// Adapter.WithRequestContext.
+type RequestAdapter struct {
+	*Adapter
+	ctx context.Context
+}
+
+var (
+	_ persist.FilteredAdapter  = (*RequestAdapter)(nil)
+	_ persist.BatchAdapter     = (*RequestAdapter)(nil)
+	_ persist.UpdatableAdapter = (*RequestAdapter)(nil)
+)
+
+// WithRequestContext returns a view of the adapter running its operations with
+// ctx, so the actor and correlation ID set with WithActor and
+// WithCorrelationID are recorded, the hooks of Options.Hooks receive ctx, and
+// the operations are canceled with the request:
+//
+//	ctx := cosmosadapter.WithActor(r.Context(), user)
+//	err := a.WithRequestContext(ctx).AddPolicy("p", "p", rule)
+//
+// The view shares the state of the adapter, and is cheap to create per
+// request. The methods taking a context use the one they are given.
+func (a *Adapter) WithRequestContext(ctx context.Context) *RequestAdapter {
+	return &RequestAdapter{Adapter: a, ctx: ctx}
+}
+
+func (r *RequestAdapter) LoadPolicy(model model.Model) error {
+	return r.loadPolicy(r.ctx, model)
+}
+
+func (r *RequestAdapter) LoadPolicyDelta(model model.Model) error {
+	return r.loadPolicyDelta(r.ctx, model)
+}
+
+func (r *RequestAdapter) LoadFilteredPolicy(model model.Model, filter interface{}) error {
+	return r.loadFilteredPolicy(r.ctx, model, filter)
+}
+
+func (r *RequestAdapter) LoadIncrementalFilteredPolicy(model model.Model, filter interface{}) error {
+	return r.loadFilteredPolicy(r.ctx, model, filter)
+}
+
+func (r *RequestAdapter) LoadPolicyPages(model model.Model, cursor LoadCursor, maxPages int) (LoadCursor, error) {
+	return r.loadPolicyPages(r.ctx, model, cursor, maxPages)
+}
+
+func (r *RequestAdapter) LoadPolicyForTenant(model model.Model, domain string) error {
+	return r.loadPolicyForTenant(r.ctx, model, domain)
+}
+
+func (r *RequestAdapter) GetPolicyVersion() (int64, error) {
+	return r.getPolicyVersion(r.ctx)
+}
+
+func (r *RequestAdapter) NeedsReload() (bool, error) {
+	return r.needsReload(r.ctx)
+}
+
+func (r *RequestAdapter) RestorePolicyVersion(model model.Model, version int64) error {
+	return r.restorePolicyVersion(r.ctx, model, version)
+}
+
+func (r *RequestAdapter) SavePolicy(model model.Model) error {
+	return r.savePolicy(r.ctx, model)
+}
+
+func (r *RequestAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return r.addPolicy(r.ctx, sec, ptype, rule)
+}
+
+func (r *RequestAdapter) AddPolicies(sec string, ptype string, rules [][]string) error {
+	return r.addPolicies(r.ctx, sec, ptype, rules)
+}
+
+func (r *RequestAdapter) AddPolicyWithExpiry(sec string, ptype string, rule []string, expiresAt time.Time) error {
+	return r.addPolicyWithExpiry(r.ctx, sec, ptype, rule, expiresAt)
+}
+
+func (r *RequestAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	return r.removePolicy(r.ctx, sec, ptype, rule)
+}
+
+func (r *RequestAdapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
+	return r.removePolicies(r.ctx, sec, ptype, rules)
+}
+
+func (r *RequestAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	return r.removeFilteredPolicy(r.ctx, sec, ptype, fieldIndex, fieldValues...)
+}
+
+func (r *RequestAdapter) UpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
+	return r.updatePolicy(r.ctx, sec, ptype, oldRule, newRule)
+}
+
+func (r *RequestAdapter) UpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
+	return r.updatePolicies(r.ctx, sec, ptype, oldRules, newRules)
+}
+
+func (r *RequestAdapter) UpdateFilteredPolicies(sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	return r.updateFilteredPolicies(r.ctx, sec, ptype, newRules, fieldIndex, fieldValues...)
+}
+
+func (r *RequestAdapter) GetEvents(ptype string, since time.Time, until time.Time) ([]PolicyEvent, error) {
+	return r.getEvents(r.ctx, ptype, since, until)
+}
+
+func (r *RequestAdapter) ReplayEvents(model model.Model, until time.Time) error {
+	return r.replayEvents(r.ctx, model, until)
+}
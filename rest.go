@@ -0,0 +1,115 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// errRESTUnavailable is returned by features that need the account credentials,
+// which the adapter doesn't know when it was created with NewAdapterFromClient.
+var errRESTUnavailable = errors.New("not available for adapters created from a client: use NewAdapterFromConnectionString or NewAdapterFromCredential")
+
+// restClient sends signed requests to Cosmos REST resources azcosmos has no API
+// for, such as the conflict feed.
+type restClient struct {
+	endpoint   string
+	key        *accountKey
+	credential azcore.TokenCredential
+	transport  policy.Transporter
+}
+
+// newRESTClientFromConnectionString parses the account endpoint and key of a
+// connection string. The key is fetched again from keySource, if set, when it
+// is rejected.
+func newRESTClientFromConnectionString(connectionString string, transport policy.Transporter, keySource func(ctx context.Context) (string, error)) (*restClient, error) {
+	c := &restClient{transport: transport}
+	for _, part := range strings.Split(connectionString, ";") {
+		keyVal := strings.SplitN(part, "=", 2)
+		if len(keyVal) < 2 {
+			continue
+		}
+		switch {
+		case strings.EqualFold(keyVal[0], "AccountEndpoint"):
+			c.endpoint = keyVal[1]
+		case strings.EqualFold(keyVal[0], "AccountKey"):
+			key, err := base64.StdEncoding.DecodeString(keyVal[1])
+			if err != nil {
+				return nil, fmt.Errorf("decode account key: %w", err)
+			}
+			c.key = newAccountKey(key, keySource)
+		}
+	}
+	if c.endpoint == "" || c.key == nil {
+		return nil, errors.New("connection string requires AccountEndpoint and AccountKey")
+	}
+	return c, nil
+}
+
+// do sends the request. resourceType and resourceLink are the values the
+// authorization signature is computed over, path is the request path.
+func (c *restClient) do(ctx context.Context, method string, resourceType string, resourceLink string, path string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.endpoint, "/")+"/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	date := strings.Replace(time.Now().UTC().Format(time.RFC1123), "UTC", "GMT", 1)
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("x-ms-version", "2020-07-15")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	auth, err := c.authorization(ctx, method, resourceType, resourceLink, date)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("authorization", auth)
+
+	var res *http.Response
+	if c.transport != nil {
+		res, err = c.transport.Do(req)
+	} else {
+		res, err = http.DefaultClient.Do(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return nil, &azcore.ResponseError{
+			ErrorCode:   strings.TrimSpace(string(body)),
+			StatusCode:  res.StatusCode,
+			RawResponse: res,
+		}
+	}
+	return res, nil
+}
+
+// authorization builds the authorization header, signed with the account key
+// or carrying an Entra ID token.
+func (c *restClient) authorization(ctx context.Context, method string, resourceType string, resourceLink string, date string) (string, error) {
+	if c.key != nil {
+		return masterKeyAuthorization(c.key.get(), method, resourceType, resourceLink, date), nil
+	}
+
+	u, err := url.Parse(c.endpoint)
+	if err != nil {
+		return "", err
+	}
+	token, err := c.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{"https://" + u.Hostname() + "/.default"}})
+	if err != nil {
+		return "", err
+	}
+	return url.QueryEscape("type=aad&ver=1.0&sig=" + token.Token), nil
+}
@@ -0,0 +1,209 @@
+package cosmosadapter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotBlobLayout is the time layout of the names of the blobs written by
+// a SnapshotScheduler, which sort by time.
+const snapshotBlobLayout = "20060102T150405Z"
+
+// SnapshotSchedulerOptions configures a SnapshotScheduler.
+type SnapshotSchedulerOptions struct {
+	// Interval is the time between two snapshots. Defaults to one hour.
+	Interval time.Duration
+	// BlobURL, if set, is the URL of a blob container, or of a directory in a
+	// container ending with a slash, the snapshots are backed up to with Backup,
+	// as blobs named policy-<time>.jsonl.gz. Otherwise the snapshots are taken
+	// with SnapshotPolicy, which requires Options.SnapshotContainerName.
+	BlobURL string
+	// BlobOptions configures the access to the blobs of BlobURL, which needs
+	// the list and delete permissions for the pruning.
+	BlobOptions BlobOptions
+	// Retain is the number of snapshots kept, the older ones are deleted. Zero
+	// keeps every snapshot.
+	Retain int
+	// MaxAge, if set, deletes the snapshots older than this. The most recent
+	// snapshot is always kept.
+	MaxAge time.Duration
+	// OnError, if set, is called with every failed snapshot or pruning.
+	OnError func(error)
+}
+
+// SnapshotScheduler takes snapshots of the policy of an adapter periodically
+// and prunes the old ones, so rollbacks have recent restore points without an
+// external job. A snapshot is only taken when the policy version changed since
+// the previous one taken by the scheduler.
+type SnapshotScheduler struct {
+	adapter *Adapter
+	options SnapshotSchedulerOptions
+	blobs   *blobClient
+
+	lastVersion int64
+	taken       bool
+
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	untrack func()
+}
+
+// NewSnapshotScheduler starts taking snapshots of the policy of the adapter,
+// a first one right away, until Stop, or Close of the adapter, is called.
+func NewSnapshotScheduler(a *Adapter, options SnapshotSchedulerOptions) (*SnapshotScheduler, error) {
+	if options.Interval <= 0 {
+		options.Interval = time.Hour
+	}
+	if options.Retain < 0 {
+		return nil, fmt.Errorf("retain must not be negative")
+	}
+	s := &SnapshotScheduler{
+		adapter: a,
+		options: options,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if options.BlobURL != "" {
+		blobs, err := a.newBlobClient(options.BlobURL, options.BlobOptions)
+		if err != nil {
+			return nil, err
+		}
+		s.blobs = blobs
+	} else if a.snapshotClient == nil {
+		return nil, fmt.Errorf("snapshots require Options.SnapshotContainerName or a blob URL")
+	}
+
+	s.untrack = a.lifecycle.track(s.Stop)
+	go s.run()
+	return s, nil
+}
+
+// Stop stops taking snapshots, waiting for a running one to complete.
+func (s *SnapshotScheduler) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+		s.untrack()
+	})
+	<-s.done
+}
+
+func (s *SnapshotScheduler) run() {
+	defer close(s.done)
+	ctx := context.Background()
+
+	ticker := time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.snapshot(ctx, s.adapter.now()); err != nil && s.options.OnError != nil {
+			s.options.OnError(err)
+		}
+		if err := s.prune(ctx, s.adapter.now()); err != nil && s.options.OnError != nil {
+			s.options.OnError(err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshot takes a snapshot if the policy changed since the previous one.
+func (s *SnapshotScheduler) snapshot(ctx context.Context, now time.Time) error {
+	a := s.adapter
+	version, _, err := readVersion(ctx, a.containerClient, a.versionID())
+	if err != nil {
+		return err
+	}
+	if s.taken && version == s.lastVersion {
+		return nil
+	}
+	if s.blobs != nil {
+		name := "policy-" + now.UTC().Format(snapshotBlobLayout) + ".jsonl.gz"
+		ctx, op := a.startOperation(ctx, "Backup")
+		_, version, err = a.backup(ctx, s.blobs.blob(name))
+		err = a.endOperation(op, err)
+	} else {
+		var snapshot PolicySnapshot
+		snapshot, err = a.SnapshotPolicy(ctx)
+		version = snapshot.Version
+	}
+	if err != nil {
+		return err
+	}
+	s.lastVersion, s.taken = version, true
+	return nil
+}
+
+// prune deletes the snapshots that are not retained.
+func (s *SnapshotScheduler) prune(ctx context.Context, now time.Time) error {
+	if s.options.Retain == 0 && s.options.MaxAge <= 0 {
+		return nil
+	}
+	if s.blobs != nil {
+		return s.pruneBlobs(ctx, now)
+	}
+
+	snapshots, err := s.adapter.ListPolicySnapshots(ctx)
+	if err != nil {
+		return err
+	}
+	// sorted by version, which follows the time they were taken at
+	times := make([]time.Time, len(snapshots))
+	for i, snapshot := range snapshots {
+		times[i] = time.Unix(0, snapshot.Time)
+	}
+	for _, i := range expiredSnapshots(times, now, s.options.Retain, s.options.MaxAge) {
+		if err := s.adapter.DeletePolicySnapshot(ctx, snapshots[i].Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SnapshotScheduler) pruneBlobs(ctx context.Context, now time.Time) error {
+	names, err := s.blobs.listBlobs(ctx)
+	if err != nil {
+		return err
+	}
+	var snapshots []string
+	var times []time.Time
+	sort.Strings(names)
+	for _, name := range names {
+		stamp, ok := strings.CutPrefix(name, "policy-")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(snapshotBlobLayout, strings.TrimSuffix(stamp, ".jsonl.gz"))
+		if err != nil {
+			// not a blob of the scheduler
+			continue
+		}
+		snapshots = append(snapshots, name)
+		times = append(times, t)
+	}
+	for _, i := range expiredSnapshots(times, now, s.options.Retain, s.options.MaxAge) {
+		if err := s.blobs.blob(snapshots[i]).delete(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiredSnapshots returns the indexes of the snapshots taken at times, oldest
+// first, that are beyond the retained count or older than maxAge. The most
+// recent snapshot is never expired.
+func expiredSnapshots(times []time.Time, now time.Time, retain int, maxAge time.Duration) []int {
+	var expired []int
+	for i, t := range times[:max(len(times)-1, 0)] {
+		if (retain > 0 && i < len(times)-retain) || (maxAge > 0 && now.Sub(t) > maxAge) {
+			expired = append(expired, i)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,97 @@
+package cosmosadapter
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// currentSchemaVersion is the schema version of the documents written by the
+// adapter. Version 0 documents, written before the version was stamped, may
+// hold empty values, and version 1 documents omit them.
+const currentSchemaVersion = 1
+
+// staleSchema reports whether the document of the rule was written in an older
+// schema version. Documents of a custom mapping are never upgraded, as the
+// mapping may not store the schema version.
+func (a *Adapter) staleSchema(line CasbinRule) bool {
+	return !a.customMapping() && line.PType != "" && line.PType != policyVersionID &&
+		line.SchemaVersion < currentSchemaVersion
+}
+
+// upgradedLine returns the rule in the current schema, keeping its document ID,
+// partition and metadata.
+func (a *Adapter) upgradedLine(line CasbinRule, now time.Time) CasbinRule {
+	tokens := policyTokens(line)
+	upgraded := line
+	if a.checkRule(tokens) == nil {
+		upgraded = a.policyLine(line.PType, tokens)
+		upgraded.ID = line.ID
+		upgraded.PartitionKey = line.PartitionKey
+		upgraded.Namespace = line.Namespace
+		upgraded.Deleted = line.Deleted
+		upgraded.Generation = line.Generation
+	}
+	upgraded.SchemaVersion = currentSchemaVersion
+	upgraded.Ts = 0
+	if line.ExpiresAt != 0 {
+		// the ttl counts from the last write
+		upgraded.expire(line.ExpiresAt, now)
+	}
+	return upgraded
+}
+
+// upgradeSchema rewrites the documents of the rules in the current schema. The
+// documents are replaced, so a rule removed meanwhile is not written again. It
+// returns the number of documents rewritten.
+func (a *Adapter) upgradeSchema(ctx context.Context, lines []CasbinRule) (int, error) {
+	now := a.now()
+	n := 0
+	for _, line := range lines {
+		if line.expired(now) {
+			continue
+		}
+		marshalled, err := a.encodeRule(a.upgradedLine(line, now))
+		if err != nil {
+			return n, err
+		}
+		err = retryThrottled(ctx, 5, func() error {
+			res, err := a.containerFor(line.PType).ReplaceItem(ctx, a.partitionKey(line), line.ID, marshalled, nil)
+			if err != nil {
+				return err
+			}
+			operationFrom(ctx).record(res.Response, 1)
+			return nil
+		})
+		if isStatus(err, http.StatusNotFound) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// MigrateSchema rewrites every stored document written in an older schema
+// version in the current one, including tombstones, so the adapter can drop
+// the support of old documents later. Documents are rewritten in place: the
+// rules and the policy version don't change. It returns the number of
+// documents rewritten. See Options.UpgradeSchemaOnLoad for a lazy upgrade.
+func (a *Adapter) MigrateSchema(ctx context.Context) (n int, err error) {
+	ctx, op := a.startOperation(ctx, "MigrateSchema")
+	defer func() { err = a.endOperation(op, err) }()
+
+	var stale []CasbinRule
+	err = a.scanDocuments(ctx, nil, 0, true, func(item scannedRule) error {
+		if a.staleSchema(item.line) {
+			stale = append(stale, item.line)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return a.upgradeSchema(ctx, stale)
+}
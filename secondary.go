@@ -0,0 +1,68 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// newSecondaryClientOptions returns the client options of the client reading
+// from Options.SecondaryRegion.
+func newSecondaryClientOptions(options Options) *azcosmos.ClientOptions {
+	clientOptions := newClientOptions(options)
+	clientOptions.PreferredRegions = []string{options.SecondaryRegion}
+	return clientOptions
+}
+
+// setSecondary creates the clients of the rules containers LoadPolicy reads
+// from Options.SecondaryRegion. Custom containers have no secondary.
+func (a *Adapter) setSecondary(client *azcosmos.Client, region string) {
+	if a.newContainerFunc != nil {
+		return
+	}
+	db, err := client.NewDatabase(a.databaseName)
+	if err != nil {
+		panic(fmt.Sprintf("Creating the cosmos database client of the secondary region caused error: %s", err.Error()))
+	}
+	a.secondaryRegion = region
+	a.secondary = map[string]Container{}
+	for _, name := range a.ruleContainerNames() {
+		container, err := db.NewContainer(name)
+		if err != nil {
+			panic(fmt.Sprintf("Creating the cosmos container client of the secondary region caused error: %s", err.Error()))
+		}
+		a.secondary[name] = container
+	}
+}
+
+// readContainer returns the container a read of the operation of the context
+// is made from: the container of the secondary region once a load fell back
+// to it, the container of the adapter otherwise.
+func (a *Adapter) readContainer(ctx context.Context, name string) Container {
+	if op := operationFrom(ctx); op != nil && op.secondary {
+		if container, ok := a.secondary[name]; ok {
+			return container
+		}
+	}
+	return a.containers[name]
+}
+
+// regionalFailure reports whether a load failed because the region serving the
+// account is unavailable: the request failed without a response, timed out or
+// failed with a server error. Failures of the context are not regional.
+func regionalFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var resErr *azcore.ResponseError
+	if errors.As(err, &resErr) {
+		return resErr.StatusCode == http.StatusRequestTimeout || resErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,166 @@
+package cosmosadapter
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrInvalidSignature is matched with errors.Is by the errors of the rule
+// documents whose signature is missing or doesn't match, see Options.Signer.
+var ErrInvalidSignature = errors.New("cosmosadapter: invalid signature")
+
+// Signer signs the rule documents written by the adapter and verifies the
+// signatures of the documents loaded, see Options.Signer. It must be safe for
+// concurrent use. A Signer backed by a key management service, such as Azure
+// Key Vault, implements it to keep the key out of the application.
+type Signer interface {
+	// Sign returns the signature of the payload.
+	Sign(payload []byte) (string, error)
+	// Verify returns an error if signature is not a signature of the payload.
+	Verify(payload []byte, signature string) error
+}
+
+// SignaturePolicy is what loads do with a rule whose signature is missing or
+// doesn't match, see Options.SignaturePolicy.
+type SignaturePolicy int
+
+const (
+	// SignatureReject handles the document as a malformed document: the load
+	// fails with a *MalformedDocumentsError listing it, unless
+	// Options.SkipMalformedDocuments or Options.OnSkippedDocument skip it.
+	SignatureReject SignaturePolicy = iota
+	// SignatureSkip skips the rule with a warning.
+	SignatureSkip
+	// SignatureWarn loads the rule with a warning, to roll out signing while
+	// the stored rules are not signed yet.
+	SignatureWarn
+)
+
+// NewHMACSigner returns a Signer signing with the HMAC-SHA256 of the key,
+// shared by the instances writing and loading the rules. It panics if the key
+// is empty.
+func NewHMACSigner(key []byte) Signer {
+	if len(key) == 0 {
+		panic("cosmosadapter: NewHMACSigner requires a key")
+	}
+	return hmacSigner{key: slices.Clone(key)}
+}
+
+type hmacSigner struct {
+	key []byte
+}
+
+func (s hmacSigner) Sign(payload []byte) (string, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s hmacSigner) Verify(payload []byte, signature string) error {
+	expected, _ := s.Sign(payload)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// NewEd25519Signer returns a Signer signing with an Ed25519 private key, and
+// verifying with its public key. Instances that only load the rules are given
+// the public key alone, and fail to write rules.
+func NewEd25519Signer(privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) Signer {
+	if publicKey == nil && privateKey != nil {
+		publicKey = privateKey.Public().(ed25519.PublicKey)
+	}
+	return ed25519Signer{privateKey: privateKey, publicKey: publicKey}
+}
+
+type ed25519Signer struct {
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+func (s ed25519Signer) Sign(payload []byte) (string, error) {
+	if s.privateKey == nil {
+		return "", errors.New("signing requires an Ed25519 private key")
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, payload)), nil
+}
+
+func (s ed25519Signer) Verify(payload []byte, signature string) error {
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(s.publicKey, payload, decoded) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// signedPayload returns what the signature of a rule document covers: its ID,
+// policy type, namespace, values, generation, expiry and tombstone mark. The
+// fields derived from them, such as the partition key and the ttl, and the
+// system fields are not signed.
+func signedPayload(line CasbinRule) ([]byte, error) {
+	return json.Marshal([]any{line.ID, line.PType, line.Namespace, policyTokens(line), line.Generation, line.ExpiresAt, line.Deleted})
+}
+
+// encodeRule returns the document storing the rule, signed with
+// Options.Signer.
+func (a *Adapter) encodeRule(line CasbinRule) ([]byte, error) {
+	if a.signer != nil && line.PType != policyVersionID {
+		var err error
+		if line.Signature, err = a.sign(line); err != nil {
+			return nil, err
+		}
+	}
+	return a.mapper.ToDocument(line)
+}
+
+// sign returns the signature of the rule with Options.Signer.
+func (a *Adapter) sign(line CasbinRule) (string, error) {
+	payload, err := signedPayload(line)
+	if err != nil {
+		return "", err
+	}
+	signature, err := a.signer.Sign(payload)
+	if err != nil {
+		return "", fmt.Errorf("signing the rule %s: %w", line.ID, err)
+	}
+	return signature, nil
+}
+
+// checkSignature verifies the signature of a rule loaded, with Options.Signer,
+// and reports whether the load skips the rule or fails with the returned error,
+// as Options.SignaturePolicy requires.
+func (a *Adapter) checkSignature(line CasbinRule) (skip bool, err error) {
+	if a.signer == nil || line.PType == policyVersionID {
+		return false, nil
+	}
+	payload, err := signedPayload(line)
+	if err != nil {
+		return false, err
+	}
+	if line.Signature == "" {
+		err = fmt.Errorf("%w: the rule is not signed", ErrInvalidSignature)
+	} else if verifyErr := a.signer.Verify(payload, line.Signature); verifyErr != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidSignature, verifyErr)
+	} else {
+		return false, nil
+	}
+	switch a.signaturePolicy {
+	case SignatureSkip:
+		a.logger.Warn("skipped a rule with an invalid signature", "id", line.ID, "error", err)
+		return true, nil
+	case SignatureWarn:
+		a.logger.Warn("loaded a rule with an invalid signature", "id", line.ID, "error", err)
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,256 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// snapshotChunkSize is the number of rules stored per snapshot document, which
+// keeps documents well below the 2MB item size limit.
+const snapshotChunkSize = 1000
+
+// PolicySnapshot describes a snapshot of the stored policy.
+type PolicySnapshot struct {
+	// Version is the policy version the snapshot was taken at.
+	Version int64 `json:"version"`
+	// Time is the time of the snapshot in nanoseconds since the epoch.
+	Time int64 `json:"time"`
+	// Rules is the number of rules in the snapshot.
+	Rules int `json:"ruleCount"`
+}
+
+// snapshotChunk is a document of a snapshot. A snapshot is split into chunks
+// sharing a logical partition.
+type snapshotChunk struct {
+	ID        string `json:"id"`
+	Snapshot  string `json:"snapshot"`
+	Namespace string `json:"namespace,omitempty"`
+	PolicySnapshot
+	Chunk  int          `json:"chunk"`
+	Chunks int          `json:"chunks"`
+	Lines  []DumpRecord `json:"rules"`
+}
+
+// createSnapshotContainerIfNotExist creates the snapshot container, partitioned
+// by snapshot so every snapshot is a single partition.
+func (a *Adapter) createSnapshotContainerIfNotExist(ctx context.Context, name string) error {
+	if _, err := a.db.CreateContainer(ctx, partitionedContainer(name, snapshotPartitionKeyPath), nil); err == nil {
+		a.logger.Info("created cosmos snapshot container", "database", a.databaseName, "container", name)
+	} else if !isStatus(err, http.StatusConflict) {
+		return fmt.Errorf("creating cosmos snapshot container: %w", err)
+	}
+	return nil
+}
+
+// snapshotKey returns the partition key of the snapshot of the version.
+func (a *Adapter) snapshotKey(version int64) string {
+	key := strconv.FormatInt(version, 10)
+	if a.namespace != "" {
+		key = a.namespace + ":" + key
+	}
+	return key
+}
+
+// SnapshotPolicy stores a copy of every stored rule in the snapshot container,
+// labelled with the current policy version, so it can be restored with
+// RestorePolicyVersion. Options.SnapshotContainerName must be set.
+func (a *Adapter) SnapshotPolicy(ctx context.Context) (_ PolicySnapshot, err error) {
+	ctx, op := a.startOperation(ctx, "SnapshotPolicy")
+	defer func() { err = a.endOperation(op, err) }()
+	if a.snapshotClient == nil {
+		return PolicySnapshot{}, fmt.Errorf("snapshots require Options.SnapshotContainerName")
+	}
+	return a.snapshot(ctx)
+}
+
+func (a *Adapter) snapshot(ctx context.Context) (PolicySnapshot, error) {
+	version, _, err := readVersion(ctx, a.containerClient, a.versionID())
+	if err != nil {
+		return PolicySnapshot{}, err
+	}
+	var records []DumpRecord
+	err = a.scan(ctx, nil, 0, func(line CasbinRule) error {
+		records = append(records, DumpRecord{PType: line.PType, Rule: policyTokens(line)})
+		return nil
+	})
+	if err != nil {
+		return PolicySnapshot{}, err
+	}
+
+	snapshot := PolicySnapshot{Version: version, Time: a.now().UnixNano(), Rules: len(records)}
+	key := a.snapshotKey(version)
+	chunks := max((len(records)+snapshotChunkSize-1)/snapshotChunkSize, 1)
+	// chunk 0 is written last, so a snapshot is only listed once complete
+	for i := chunks - 1; i >= 0; i-- {
+		chunk := snapshotChunk{
+			ID:             fmt.Sprintf("%s.%d", key, i),
+			Snapshot:       key,
+			Namespace:      a.namespace,
+			PolicySnapshot: snapshot,
+			Chunk:          i,
+			Chunks:         chunks,
+			Lines:          records[i*snapshotChunkSize : min((i+1)*snapshotChunkSize, len(records))],
+		}
+		marshalled, err := json.Marshal(chunk)
+		if err != nil {
+			return PolicySnapshot{}, err
+		}
+		// taking a snapshot of the same version again replaces it
+		res, err := a.snapshotClient.UpsertItem(ctx, azcosmos.NewPartitionKeyString(key), marshalled, nil)
+		if err != nil {
+			return PolicySnapshot{}, err
+		}
+		operationFrom(ctx).record(res.Response, 1)
+	}
+	return snapshot, nil
+}
+
+// ListPolicySnapshots returns the snapshots of the policy, oldest first.
+func (a *Adapter) ListPolicySnapshots(ctx context.Context) (_ []PolicySnapshot, err error) {
+	ctx, op := a.startOperation(ctx, "ListPolicySnapshots")
+	defer func() { err = a.endOperation(op, err) }()
+	if a.snapshotClient == nil {
+		return nil, fmt.Errorf("snapshots require Options.SnapshotContainerName")
+	}
+
+	query, parameters := a.inNamespace("SELECT c.version, c.time, c.ruleCount, c.namespace FROM c WHERE c.chunk = 0", nil)
+	var snapshots []PolicySnapshot
+	queryPager := a.snapshotClient.NewQueryItemsPager(query, azcosmos.NewPartitionKey(), &azcosmos.QueryOptions{QueryParameters: parameters})
+	for queryPager.More() {
+		res, err := nextPage(ctx, queryPager)
+		if err != nil {
+			return nil, err
+		}
+		op.query(query, "", parameters, "", res)
+		for _, item := range res.Items {
+			var chunk snapshotChunk
+			if err := json.Unmarshal(item, &chunk); err != nil {
+				return nil, err
+			}
+			if chunk.Namespace != a.namespace {
+				continue
+			}
+			snapshots = append(snapshots, chunk.PolicySnapshot)
+		}
+	}
+	// ORDER BY is not supported by cross partition queries through the gateway
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Version < snapshots[j].Version
+	})
+	return snapshots, nil
+}
+
+// RestorePolicyVersion replaces the policy of the model with the snapshot of
+// the version, and saves it with SavePolicy, which bumps the policy version.
+// Rules of policy types the model does not define are not restored. When used
+// with an enforcer, call e.BuildRoleLinks() afterwards.
+func (a *Adapter) RestorePolicyVersion(model model.Model, version int64) (err error) {
+	return a.restorePolicyVersion(context.Background(), model, version)
+}
+
+// RestorePolicyVersionContext is RestorePolicyVersion with a context.
+func (a *Adapter) RestorePolicyVersionContext(ctx context.Context, model model.Model, version int64) (err error) {
+	return a.restorePolicyVersion(ctx, model, version)
+}
+
+func (a *Adapter) restorePolicyVersion(ctx context.Context, model model.Model, version int64) (err error) {
+	ctx, op := a.startOperation(ctx, "RestorePolicyVersion")
+	defer func() { err = a.endOperation(op, err) }()
+	if a.snapshotClient == nil {
+		return fmt.Errorf("snapshots require Options.SnapshotContainerName")
+	}
+
+	records, err := a.readSnapshot(ctx, version)
+	if err != nil {
+		return err
+	}
+	model.ClearPolicy()
+	for _, record := range records {
+		if err := a.loadPolicyLine(ctx, CasbinRule{PType: record.PType, Rule: record.Rule}, model); err != nil {
+			return err
+		}
+	}
+	a.filtered.Store(false)
+	return a.SavePolicy(model)
+}
+
+// readSnapshot returns the rules of the snapshot of the version.
+func (a *Adapter) readSnapshot(ctx context.Context, version int64) ([]DumpRecord, error) {
+	key := a.snapshotKey(version)
+	var chunks []snapshotChunk
+	queryPager := a.snapshotClient.NewQueryItemsPager("SELECT * FROM c", azcosmos.NewPartitionKeyString(key), nil)
+	for queryPager.More() {
+		res, err := nextPage(ctx, queryPager)
+		if err != nil {
+			return nil, err
+		}
+		operationFrom(ctx).query("SELECT * FROM c", "", nil, "", res)
+		for _, item := range res.Items {
+			var chunk snapshotChunk
+			if err := json.Unmarshal(item, &chunk); err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no snapshot of policy version %d", version)
+	}
+	if len(chunks) != chunks[0].Chunks {
+		return nil, fmt.Errorf("snapshot of policy version %d is incomplete: %d of %d chunks", version, len(chunks), chunks[0].Chunks)
+	}
+
+	var records []DumpRecord
+	for _, chunk := range chunks {
+		records = append(records, chunk.Lines...)
+	}
+	return records, nil
+}
+
+// DeletePolicySnapshot removes the snapshot of the version. Removing a
+// snapshot that does not exist is not an error.
+func (a *Adapter) DeletePolicySnapshot(ctx context.Context, version int64) (err error) {
+	ctx, op := a.startOperation(ctx, "DeletePolicySnapshot")
+	defer func() { err = a.endOperation(op, err) }()
+	if a.snapshotClient == nil {
+		return fmt.Errorf("snapshots require Options.SnapshotContainerName")
+	}
+
+	key := a.snapshotKey(version)
+	pk := azcosmos.NewPartitionKeyString(key)
+	var ids []string
+	queryPager := a.snapshotClient.NewQueryItemsPager("SELECT c.id FROM c", pk, nil)
+	for queryPager.More() {
+		res, err := nextPage(ctx, queryPager)
+		if err != nil {
+			return err
+		}
+		op.query("SELECT c.id FROM c", "", nil, "", res)
+		for _, item := range res.Items {
+			var chunk snapshotChunk
+			if err := json.Unmarshal(item, &chunk); err != nil {
+				return err
+			}
+			ids = append(ids, chunk.ID)
+		}
+	}
+	// chunk 0 is removed first, so an incomplete snapshot is no longer listed
+	sort.Strings(ids)
+	for _, id := range ids {
+		res, err := a.snapshotClient.DeleteItem(ctx, pk, id, nil)
+		if err != nil && !isStatus(err, http.StatusNotFound) {
+			return err
+		}
+		if err == nil {
+			operationFrom(ctx).record(res.Response, 1)
+		}
+	}
+	return nil
+}
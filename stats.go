@@ -0,0 +1,97 @@
+package cosmosadapter
+
+import (
+	"sync"
+	"time"
+)
+
+// OperationStats are the cumulative statistics of one kind of adapter
+// operation, see GetOperationStats.
+type OperationStats struct {
+	// Count is the number of operations, and Errors the number of those that
+	// failed.
+	Count  int64
+	Errors int64
+	// Items are the documents read or written, and Pages the query pages
+	// fetched.
+	Items int64
+	Pages int64
+	// Duration is the time spent in the operations.
+	Duration time.Duration
+	// RequestCharge is the request units consumed by the operations, and
+	// LastRequestCharge by the last one.
+	RequestCharge     float64
+	LastRequestCharge float64
+}
+
+// statsRecorder accumulates OperationStats by operation name, and the
+// request charges reported by GetRequestCharges. The charges can be reset
+// independently, the OperationStats only ever grow.
+type statsRecorder struct {
+	mu      sync.Mutex
+	ops     map[string]*OperationStats
+	charges map[string]float64
+}
+
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{ops: map[string]*OperationStats{}, charges: map[string]float64{}}
+}
+
+// record adds a finished operation to the statistics.
+func (r *statsRecorder) record(op *operation, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.ops[op.name]
+	if !ok {
+		s = &OperationStats{}
+		r.ops[op.name] = s
+	}
+	s.Count++
+	if err != nil {
+		s.Errors++
+	}
+	s.Items += int64(op.items)
+	s.Pages += int64(op.pages)
+	s.Duration += time.Since(op.start)
+	s.RequestCharge += op.requestCharge
+	s.LastRequestCharge = op.requestCharge
+	r.charges[op.name] += op.requestCharge
+}
+
+// GetOperationStats returns the statistics of the operations since the
+// adapter was created, by operation, for metrics systems such as Prometheus.
+// Unlike GetRequestCharges, they are never reset.
+func (a *Adapter) GetOperationStats() map[string]OperationStats {
+	a.stats.mu.Lock()
+	defer a.stats.mu.Unlock()
+
+	ops := make(map[string]OperationStats, len(a.stats.ops))
+	for name, s := range a.stats.ops {
+		ops[name] = *s
+	}
+	return ops
+}
+
+// GetRequestCharges returns the request units consumed since the adapter was
+// created or since the last ResetRequestCharges, by operation: "LoadPolicy",
+// "SavePolicy", "AddPolicy" and so on.
+func (a *Adapter) GetRequestCharges() map[string]float64 {
+	a.stats.mu.Lock()
+	defer a.stats.mu.Unlock()
+
+	charges := make(map[string]float64, len(a.stats.charges))
+	for name, charge := range a.stats.charges {
+		charges[name] = charge
+	}
+	return charges
+}
+
+// ResetRequestCharges resets the request charges returned by GetRequestCharges.
+// The metrics are not affected.
+func (a *Adapter) ResetRequestCharges() {
+	a.stats.mu.Lock()
+	defer a.stats.mu.Unlock()
+
+	a.stats.charges = map[string]float64{}
+}
@@ -0,0 +1,168 @@
+package cosmosadapter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// tenantCache holds the rules of recently loaded tenants.
+type tenantCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]tenantEntry
+}
+
+type tenantEntry struct {
+	lines    []CasbinRule
+	version  int64
+	loadedAt time.Time
+}
+
+func newTenantCache(ttl time.Duration) *tenantCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &tenantCache{ttl: ttl, entries: map[string]tenantEntry{}}
+}
+
+// get returns the cached rules of the domain if they were loaded at the given
+// policy version and are not older than the ttl.
+func (c *tenantCache) get(domain string, version int64, now time.Time) ([]CasbinRule, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[domain]
+	if !ok || entry.version != version || now.Sub(entry.loadedAt) > c.ttl {
+		delete(c.entries, domain)
+		return nil, false
+	}
+	return entry.lines, true
+}
+
+func (c *tenantCache) put(domain string, version int64, lines []CasbinRule, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[domain] = tenantEntry{lines: lines, version: version, loadedAt: now}
+}
+
+// lineDomain returns the domain of a rule of a model with domains: v1 of p
+// rules (sub, dom, obj, act) and v2 of g rules (_, _, dom).
+func lineDomain(line CasbinRule) string {
+	if line.PType == "" {
+		return ""
+	}
+	tokens := policyTokens(line)
+	switch {
+	case line.PType[:1] == "p" && len(tokens) > 1:
+		return tokens[1]
+	case line.PType[:1] == "g" && len(tokens) > 2:
+		return tokens[2]
+	}
+	return ""
+}
+
+// LoadPolicyForTenant replaces the policy of the model with the rules of the
+// domain: its p rules and the g rules granting roles within it, which is all
+// an RBAC with domains model needs to enforce requests of that tenant.
+// With PartitionByDomain this is a single partition query.
+//
+// When Options.TenantCacheTTL is set the rules are cached per domain, and
+// reused while the policy version is unchanged, at the cost of a point read.
+// When used with an enforcer, call e.BuildRoleLinks() afterwards.
+func (a *Adapter) LoadPolicyForTenant(model model.Model, domain string) (err error) {
+	return a.loadPolicyForTenant(context.Background(), model, domain)
+}
+
+// LoadPolicyForTenantContext is LoadPolicyForTenant with a context.
+func (a *Adapter) LoadPolicyForTenantContext(ctx context.Context, model model.Model, domain string) (err error) {
+	return a.loadPolicyForTenant(ctx, model, domain)
+}
+
+func (a *Adapter) loadPolicyForTenant(ctx context.Context, model model.Model, domain string) (err error) {
+	ctx, op := a.startOperation(ctx, "LoadPolicyForTenant")
+	defer func() { err = a.endOperation(op, err) }()
+	op.loading = true
+
+	version, _, err := readVersion(ctx, a.containerClient, a.versionID())
+	if err != nil {
+		return err
+	}
+
+	lines, ok := a.tenantCache.get(domain, version, a.now())
+	if !ok {
+		if lines, err = a.queryTenant(ctx, model, domain); err != nil {
+			return err
+		}
+		if len(op.malformed) == 0 {
+			// the load fails, and reads the documents again next time
+			a.tenantCache.put(domain, version, lines, a.now())
+		}
+	}
+
+	model.ClearPolicy()
+	for _, line := range lines {
+		if err := a.loadPolicyLine(ctx, line, model); err != nil {
+			return err
+		}
+	}
+	a.filtered.Store(true)
+	a.version.Store(version)
+	return nil
+}
+
+// queryTenant reads the rules of the domain.
+func (a *Adapter) queryTenant(ctx context.Context, model model.Model, domain string) ([]CasbinRule, error) {
+	var candidates []CasbinRule
+	if a.partitionStrategy == PartitionByDomain {
+		filter := DomainFilter(domain)
+		query, parameters := a.inNamespace(filter.Query, filter.Parameters)
+		lines, err := a.query(ctx, query, "", parameters)
+		if err != nil {
+			return nil, err
+		}
+		candidates = lines
+	} else {
+		for _, ptype := range policyTypes(model) {
+			query, parameters := "SELECT * FROM c", []azcosmos.QueryParameter(nil)
+			if !a.customMapping() {
+				query = tenantQuery(ptype)
+				parameters = []azcosmos.QueryParameter{{Name: "@domain", Value: domain}}
+			}
+			query, parameters = a.inNamespace(query, parameters)
+			lines, err := a.query(ctx, query, ptype, parameters)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, lines...)
+		}
+	}
+
+	// rules without a domain share the policy type partition, and custom
+	// mappings can't be filtered server side
+	var lines []CasbinRule
+	for _, line := range candidates {
+		if lineDomain(line) == domain {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// tenantQuery returns the query selecting the rules of a domain in the
+// partition of the policy type.
+func tenantQuery(ptype string) string {
+	if ptype[:1] == "g" {
+		return "SELECT * FROM c WHERE (c.v2 = @domain OR c.rule[2] = @domain)"
+	}
+	return "SELECT * FROM c WHERE (c.v1 = @domain OR c.rule[1] = @domain)"
+}
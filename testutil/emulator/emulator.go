@@ -0,0 +1,176 @@
+// Package emulator runs the Azure Cosmos DB emulator in a Linux container for
+// integration tests, with testcontainers-go, so they don't need a Cosmos
+// account:
+//
+//	func TestPolicy(t *testing.T) {
+//		a := cosmosadapter.NewAdapterFromConnectionString(emulator.ConnectionString(t), options)
+//		...
+//	}
+//
+// Docker must be available. The package is a module of its own, so the
+// adapter doesn't depend on testcontainers-go.
+package emulator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Image is the default image of the emulator, the Linux emulator that
+// serves HTTP and starts in seconds.
+const Image = "mcr.microsoft.com/cosmosdb/linux/azure-cosmos-emulator:vnext-preview"
+
+// Key is the well-known account key of the emulator.
+const Key = "C2y6yDjf5/R+ob0N8A7Cgv30VRDJIWEHLM+4QDU5DE2nQ9nDuVTqobD4b8mGGyPMbIZnqyMsEcaGQy67XIw/Jw=="
+
+// Options configures Start.
+type Options struct {
+	// Image is the image of the emulator, the Image constant by default.
+	Image string
+	// Port is the host port of the emulator, 8081 by default. The emulator
+	// advertises its endpoint to the clients with this port, so it is bound
+	// to the same port as in the container rather than a random one.
+	Port int
+	// StartupTimeout bounds the wait for the emulator to accept requests,
+	// 3 minutes by default, which includes pulling the image.
+	StartupTimeout time.Duration
+}
+
+// Emulator is a running emulator container.
+type Emulator struct {
+	container testcontainers.Container
+	// Endpoint is the account endpoint of the emulator, such as
+	// http://127.0.0.1:8081/.
+	Endpoint string
+}
+
+// Start starts an emulator container and waits until it accepts
+// requests. Terminate it when done; containers left behind by a crashed test
+// process are removed by the testcontainers reaper.
+func Start(ctx context.Context, options Options) (*Emulator, error) {
+	if options.Image == "" {
+		options.Image = Image
+	}
+	if options.Port == 0 {
+		options.Port = 8081
+	}
+	if options.StartupTimeout == 0 {
+		options.StartupTimeout = 3 * time.Minute
+	}
+	port := strconv.Itoa(options.Port)
+
+	c, err := testcontainers.Run(ctx, options.Image,
+		testcontainers.WithEnv(map[string]string{
+			"PORT":            port,
+			"PROTOCOL":        "http",
+			"ENABLE_EXPLORER": "false",
+		}),
+		testcontainers.WithExposedPorts(port+"/tcp"),
+		testcontainers.WithHostConfigModifier(func(hostConfig *container.HostConfig) {
+			hostConfig.PortBindings = network.PortMap{
+				network.MustParsePort(port + "/tcp"): {{HostPort: port}},
+			}
+		}),
+		// the gateway answers unauthenticated requests with 401 once ready
+		testcontainers.WithWaitStrategy(wait.ForHTTP("/").
+			WithPort(port+"/tcp").
+			WithStatusCodeMatcher(func(status int) bool { return status < http.StatusInternalServerError }).
+			WithStartupTimeout(options.StartupTimeout)),
+	)
+	if err != nil {
+		if c != nil {
+			_ = c.Terminate(context.Background())
+		}
+		return nil, fmt.Errorf("failed to start the Cosmos emulator: %w", err)
+	}
+	host, err := c.Host(ctx)
+	if err != nil {
+		_ = c.Terminate(context.Background())
+		return nil, err
+	}
+	if host == "localhost" {
+		// avoid resolving to ::1 when the port is only bound on IPv4
+		host = "127.0.0.1"
+	}
+	return &Emulator{container: c, Endpoint: fmt.Sprintf("http://%s:%s/", host, port)}, nil
+}
+
+// ConnectionString returns the connection string of the emulator, for
+// cosmosadapter.NewAdapterFromConnectionString.
+func (e *Emulator) ConnectionString() string {
+	return fmt.Sprintf("AccountEndpoint=%s;AccountKey=%s;", e.Endpoint, Key)
+}
+
+// Terminate stops and removes the emulator container.
+func (e *Emulator) Terminate(ctx context.Context) error {
+	return e.container.Terminate(ctx)
+}
+
+var shared struct {
+	once             sync.Once
+	connectionString string
+	err              error
+}
+
+// SharedConnectionString returns the connection string of the Cosmos account
+// of the tests: the TEST_COSMOS_URL environment variable when set, otherwise
+// an emulator started on the first call and shared by the tests of the
+// process.
+func SharedConnectionString() (string, error) {
+	shared.once.Do(func() {
+		if connectionString := os.Getenv("TEST_COSMOS_URL"); connectionString != "" {
+			shared.connectionString = connectionString
+			return
+		}
+		e, err := Start(context.Background(), Options{})
+		if err != nil {
+			shared.err = err
+			return
+		}
+		shared.connectionString = e.ConnectionString()
+	})
+	return shared.connectionString, shared.err
+}
+
+// ConnectionString is SharedConnectionString for a test, which is skipped
+// when there is neither TEST_COSMOS_URL nor Docker, and fails when the
+// emulator doesn't start.
+func ConnectionString(tb testing.TB) string {
+	tb.Helper()
+	if os.Getenv("TEST_COSMOS_URL") == "" {
+		skipWithoutDocker(tb)
+	}
+	connectionString, err := SharedConnectionString()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return connectionString
+}
+
+// skipWithoutDocker skips the test when Docker is not available.
+func skipWithoutDocker(tb testing.TB) {
+	tb.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			tb.Skipf("Docker is not available: %v", r)
+		}
+	}()
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err != nil {
+		tb.Skipf("Docker is not available: %v", err)
+	}
+	if err := provider.Health(context.Background()); err != nil {
+		tb.Skipf("Docker is not available: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package emulator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmulator(t *testing.T) {
+	client, err := azcosmos.NewClientFromConnectionString(ConnectionString(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_, err = client.CreateDatabase(ctx, azcosmos.DatabaseProperties{ID: "testutil"}, nil)
+	assert.NoError(t, err)
+	db, err := client.NewDatabase("testutil")
+	assert.NoError(t, err)
+	_, err = db.Delete(ctx, nil)
+	assert.NoError(t, err)
+}
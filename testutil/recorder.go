@@ -0,0 +1,266 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RecordMode selects what a Recorder does with the requests.
+type RecordMode int
+
+const (
+	// ModeReplay answers the requests with the recorded responses, without
+	// network access.
+	ModeReplay RecordMode = iota
+	// ModeRecord sends the requests to Cosmos and records them with their
+	// responses.
+	ModeRecord
+)
+
+// ErrNotRecorded is matched with errors.Is by the error a replaying Recorder
+// returns for a request it has no recorded response for.
+var ErrNotRecorded = errors.New("request not recorded")
+
+// replayedHeaders are the request headers, besides the method, the path, the
+// query and the body, that tell requests apart: the partition, the page and
+// the preconditions.
+var replayedHeaders = []string{
+	"A-Im",
+	"If-Match",
+	"If-None-Match",
+	"X-Ms-Continuation",
+	"X-Ms-Documentdb-Partitionkey",
+	"X-Ms-Documentdb-Partitionkeyrangeid",
+	"X-Ms-Documentdb-Query-Enablecrosspartition",
+	"X-Ms-Max-Item-Count",
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request without its host and credentials.
+type RecordedRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response.
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Recorder is an HTTP transport for the Cosmos client, set as
+// Options.Transport, that records the requests of a test and their responses
+// to a file, and replays them in later runs, so tests exercise the paging,
+// throttling and continuation tokens of Cosmos without network access.
+//
+// Requests are matched by method, path, query, body and the headers selecting
+// the partition and the page, ignoring the host, the dates and the
+// signatures. Identical requests are answered in the recorded order, so a
+// throttled request and its retry get their own responses. The documents must
+// be the same from one run to the other: use Options.Now, Options.NewID and
+// an IDFunc that doesn't depend on the environment.
+type Recorder struct {
+	mode      RecordMode
+	path      string
+	transport *http.Client
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewRecorder returns a recorder of the file. In ModeReplay the file is read,
+// in ModeRecord it is written by Save, with the requests sent by the default
+// HTTP transport.
+func NewRecorder(path string, mode RecordMode) (*Recorder, error) {
+	r := &Recorder{mode: mode, path: path, transport: &http.Client{}}
+	if mode == ModeRecord {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.interactions); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r.used = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+// Do sends or replays the request.
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	recorded, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.mode == ModeReplay {
+		return r.replay(req, recorded)
+	}
+
+	res, err := r.transport.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{
+		Request:  recorded,
+		Response: RecordedResponse{StatusCode: res.StatusCode, Headers: res.Header.Clone(), Body: string(body)},
+	})
+	r.mu.Unlock()
+	return res, nil
+}
+
+// replay returns the response of the first unused interaction matching the
+// request.
+func (r *Recorder) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.interactions {
+		if r.used[i] || !interaction.Request.matches(recorded) {
+			continue
+		}
+		r.used[i] = true
+		res := interaction.Response
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode)),
+			StatusCode:    res.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        res.Headers.Clone(),
+			Body:          io.NopCloser(strings.NewReader(res.Body)),
+			ContentLength: int64(len(res.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, recorded.Method, recorded.Path)
+}
+
+// Unused returns the number of recorded interactions that were not replayed,
+// which tells a test that stopped sending requests it used to.
+func (r *Recorder) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, used := range r.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+// Save writes the recorded interactions to the file, creating its directory.
+// It does nothing in ModeReplay.
+func (r *Recorder) Save() error {
+	if r.mode == ModeReplay {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o644)
+}
+
+// recordRequest returns the request to record, restoring its body.
+func recordRequest(req *http.Request) (RecordedRequest, error) {
+	recorded := RecordedRequest{Method: req.Method, Path: req.URL.Path, Query: req.URL.RawQuery}
+	for _, name := range replayedHeaders {
+		if value := req.Header.Get(name); value != "" {
+			if recorded.Headers == nil {
+				recorded.Headers = map[string]string{}
+			}
+			recorded.Headers[name] = value
+		}
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return recorded, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		recorded.Body = string(body)
+	}
+	return recorded, nil
+}
+
+func (q RecordedRequest) matches(other RecordedRequest) bool {
+	if q.Method != other.Method || q.Path != other.Path || q.Query != other.Query || q.Body != other.Body || len(q.Headers) != len(other.Headers) {
+		return false
+	}
+	for name, value := range q.Headers {
+		if other.Headers[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// replayEndpoint is the account endpoint of replayed tests, which is never
+// contacted.
+const replayEndpoint = "https://replay.documents.azure.com:443/"
+
+// Recording returns the connection string and the recorder of a test using the
+// recording at path, typically under testdata. With the COSMOS_RECORD
+// environment variable set, the requests are sent to the account of
+// ConnectionString and recorded, and the recording is saved when the test
+// ends. Otherwise they are replayed, and the test fails if the recording is
+// missing:
+//
+//	connectionString, recorder := testutil.Recording(t, "testdata/load_policy.json")
+//	options.Transport = recorder
+//	a := cosmosadapter.NewAdapterFromConnectionString(connectionString, options)
+func Recording(tb testing.TB, path string) (string, *Recorder) {
+	tb.Helper()
+	if os.Getenv("COSMOS_RECORD") == "" {
+		recorder, err := NewRecorder(path, ModeReplay)
+		if err != nil {
+			tb.Fatalf("replaying %s, set COSMOS_RECORD to record it: %v", path, err)
+		}
+		return fmt.Sprintf("AccountEndpoint=%s;AccountKey=%s;", replayEndpoint, EmulatorKey), recorder
+	}
+
+	connectionString := ConnectionString(tb)
+	recorder, err := NewRecorder(path, ModeRecord)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := recorder.Save(); err != nil {
+			tb.Error(err)
+		}
+	})
+	return connectionString, recorder
+}
@@ -0,0 +1,26 @@
+// Package testutil helps integration tests reach a Cosmos account: it records
+// and replays their requests, and gives them the connection string of the
+// account to test against. Starting the Cosmos DB emulator in a container is
+// left to the testutil/emulator module, so the adapter doesn't depend on
+// testcontainers-go.
+package testutil
+
+import (
+	"os"
+	"testing"
+)
+
+// EmulatorKey is the well-known account key of the Cosmos DB emulator.
+const EmulatorKey = "C2y6yDjf5/R+ob0N8A7Cgv30VRDJIWEHLM+4QDU5DE2nQ9nDuVTqobD4b8mGGyPMbIZnqyMsEcaGQy67XIw/Jw=="
+
+// ConnectionString returns the connection string of the Cosmos account of the
+// integration tests, the TEST_COSMOS_URL environment variable, and skips the
+// test when it is not set.
+func ConnectionString(tb testing.TB) string {
+	tb.Helper()
+	connectionString := os.Getenv("TEST_COSMOS_URL")
+	if connectionString == "" {
+		tb.Skip("TEST_COSMOS_URL is not set")
+	}
+	return connectionString
+}
@@ -0,0 +1,82 @@
+package testutil
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("x-ms-retry-after-ms", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("x-ms-continuation", r.Header.Get("x-ms-continuation")+"+")
+		fmt.Fprintf(w, `{"call":%d,"query":%s}`, calls, body)
+	}))
+	path := filepath.Join(t.TempDir(), "recording.json")
+
+	send := func(transport interface {
+		Do(*http.Request) (*http.Response, error)
+	}, continuation string) (int, string, string, error) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/dbs/casbin/colls/casbin_rule/docs", strings.NewReader(`"SELECT * FROM c"`))
+		req.Header.Set("Authorization", "secret")
+		req.Header.Set("x-ms-date", time.Now().String())
+		if continuation != "" {
+			req.Header.Set("x-ms-continuation", continuation)
+		}
+		res, err := transport.Do(req)
+		if err != nil {
+			return 0, "", "", err
+		}
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, res.Header.Get("x-ms-continuation"), string(body), nil
+	}
+	sendAll := func(transport interface {
+		Do(*http.Request) (*http.Response, error)
+	}) []string {
+		var results []string
+		for _, continuation := range []string{"", "", "+"} {
+			status, next, body, err := send(transport, continuation)
+			assert.NoError(t, err)
+			results = append(results, fmt.Sprintf("%d %s %s", status, next, body))
+		}
+		return results
+	}
+
+	recorder, err := NewRecorder(path, ModeRecord)
+	assert.NoError(t, err)
+	recorded := sendAll(recorder)
+	assert.Equal(t, []string{
+		"429  ",
+		`200 + {"call":2,"query":"SELECT * FROM c"}`,
+		`200 ++ {"call":3,"query":"SELECT * FROM c"}`,
+	}, recorded)
+	assert.NoError(t, recorder.Save())
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	server.Close()
+
+	recorder, err = NewRecorder(path, ModeReplay)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, recorder.Unused())
+	// the throttled request and its retry are replayed in order
+	assert.Equal(t, recorded, sendAll(recorder))
+	assert.Equal(t, 0, recorder.Unused())
+	_, _, _, err = send(recorder, "")
+	assert.ErrorIs(t, err, ErrNotRecorded)
+}
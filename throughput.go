@@ -0,0 +1,184 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// throughputResource is a rules container with a throughput of its own, or the
+// database whose throughput the other rules containers share.
+type throughputResource struct {
+	name       string
+	properties *azcosmos.ThroughputProperties
+	replace    func(ctx context.Context, properties azcosmos.ThroughputProperties) (azcosmos.ThroughputResponse, error)
+}
+
+// throughputResources reads the throughput of the rules containers, and of the
+// database when some of them have none of their own.
+func (a *Adapter) throughputResources(ctx context.Context) ([]throughputResource, error) {
+	if a.db == nil {
+		return nil, errors.New("scaling the throughput requires an adapter with an azcosmos client")
+	}
+	if a.dataPlaneOnly {
+		return nil, errors.New("scaling the throughput requires control-plane requests, which Options.DataPlaneOnly forbids")
+	}
+	var resources []throughputResource
+	shared := false
+	for _, name := range a.ruleContainerNames() {
+		client := cosmosContainer(a.containers[name])
+		if client == nil {
+			return nil, errors.New("scaling the throughput requires an adapter with an azcosmos client")
+		}
+		res, err := client.ReadThroughput(ctx, nil)
+		if isStatus(err, http.StatusNotFound) {
+			shared = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		operationFrom(ctx).record(res.Response, 0)
+		resources = append(resources, throughputResource{
+			name:       "container " + name,
+			properties: res.ThroughputProperties,
+			replace: func(ctx context.Context, properties azcosmos.ThroughputProperties) (azcosmos.ThroughputResponse, error) {
+				return client.ReplaceThroughput(ctx, properties, nil)
+			},
+		})
+	}
+	if shared {
+		res, err := a.db.ReadThroughput(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("reading the throughput of the database: %w", err)
+		}
+		operationFrom(ctx).record(res.Response, 0)
+		resources = append(resources, throughputResource{
+			name:       "database " + a.databaseName,
+			properties: res.ThroughputProperties,
+			replace: func(ctx context.Context, properties azcosmos.ThroughputProperties) (azcosmos.ThroughputResponse, error) {
+				return a.db.ReplaceThroughput(ctx, properties, nil)
+			},
+		})
+	}
+	return resources, nil
+}
+
+// throughputOf returns the maximum throughput of autoscale throughput, or the
+// provisioned throughput of manual throughput.
+func throughputOf(properties *azcosmos.ThroughputProperties) int32 {
+	if max, ok := properties.AutoscaleMaxThroughput(); ok {
+		return max
+	}
+	throughput, _ := properties.ManualThroughput()
+	return throughput
+}
+
+// withThroughput returns throughput properties of the same kind as properties,
+// autoscale or manual, with the given throughput.
+func withThroughput(properties *azcosmos.ThroughputProperties, throughput int32) azcosmos.ThroughputProperties {
+	if _, ok := properties.AutoscaleMaxThroughput(); ok {
+		return azcosmos.NewAutoscaleThroughputProperties(throughput)
+	}
+	return azcosmos.NewManualThroughputProperties(throughput)
+}
+
+// scale changes the throughput of the resource from one value to another.
+func (a *Adapter) scale(ctx context.Context, resource throughputResource, from, to int32) error {
+	res, err := resource.replace(ctx, withThroughput(resource.properties, to))
+	if err != nil {
+		return fmt.Errorf("scaling the throughput of the %s: %w", resource.name, err)
+	}
+	operationFrom(ctx).record(res.Response, 0)
+	a.logger.Info("scaled the cosmos throughput", "resource", resource.name, "from", from, "to", to, "pending", res.IsReplacePending)
+	return nil
+}
+
+// ScaleThroughput sets the throughput of the rules containers to target
+// request units per second: the maximum throughput of the containers with
+// autoscale throughput, the provisioned throughput of the others. The rules
+// containers without a throughput of their own share the throughput of the
+// database, which is scaled instead. Cosmos may take a while to apply an
+// increase beyond what the partitions of a container can serve, which is
+// logged as pending.
+func (a *Adapter) ScaleThroughput(ctx context.Context, target int32) (err error) {
+	ctx, op := a.startOperation(ctx, "ScaleThroughput")
+	defer func() { err = a.endOperation(op, err) }()
+	resources, err := a.throughputResources(ctx)
+	if err != nil {
+		return err
+	}
+	for _, resource := range resources {
+		current := throughputOf(resource.properties)
+		if current == target {
+			continue
+		}
+		if err := a.scale(ctx, resource, current, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BurstThroughput raises the throughput of the rules containers below target
+// to target, as ScaleThroughput does, for a bulk operation such as a migration,
+// and returns the function restoring their previous throughput once it is
+// done:
+//
+//	restore, err := a.BurstThroughput(ctx, 20000)
+//	if err != nil {
+//		return err
+//	}
+//	defer restore(context.WithoutCancel(ctx))
+//	_, err = a.MigrateFromSQL(ctx, db, "")
+//
+// If raising the throughput of a container fails, the containers raised
+// already are restored. The throughput is only restored by calling restore, a
+// crashed process leaves it raised. See ImportOptions.BurstThroughput for the
+// imports and copies.
+func (a *Adapter) BurstThroughput(ctx context.Context, target int32) (restore func(ctx context.Context) error, err error) {
+	ctx, op := a.startOperation(ctx, "BurstThroughput")
+	defer func() { err = a.endOperation(op, err) }()
+	resources, err := a.throughputResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var raised []throughputResource
+	restore = func(ctx context.Context) (err error) {
+		ctx, op := a.startOperation(ctx, "RestoreThroughput")
+		defer func() { err = a.endOperation(op, err) }()
+		var errs []error
+		for _, resource := range raised {
+			errs = append(errs, a.scale(ctx, resource, target, throughputOf(resource.properties)))
+		}
+		return errors.Join(errs...)
+	}
+	for _, resource := range resources {
+		current := throughputOf(resource.properties)
+		if current >= target {
+			continue
+		}
+		if err := a.scale(ctx, resource, current, target); err != nil {
+			return nil, errors.Join(err, restore(context.WithoutCancel(ctx)))
+		}
+		raised = append(raised, resource)
+	}
+	return restore, nil
+}
+
+// burst raises the throughput for a bulk operation as ImportOptions.BurstThroughput
+// requests, and returns the function restoring it.
+func (a *Adapter) burst(ctx context.Context, options ImportOptions) (restore func() error, err error) {
+	if options.BurstThroughput <= 0 {
+		return func() error { return nil }, nil
+	}
+	restoreThroughput, err := a.BurstThroughput(ctx, options.BurstThroughput)
+	if err != nil {
+		return nil, err
+	}
+	// the throughput is restored even if the operation was canceled
+	return func() error { return restoreThroughput(context.WithoutCancel(ctx)) }, nil
+}
@@ -0,0 +1,272 @@
+package cosmosadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// UpdatePolicy replaces a rule of the storage with another. When both rules
+// are stored under the same document ID and partition key, such as with an
+// Options.IDFunc leaving out the values the update changes, the stored
+// document is patched in place: only its values, signature, updatedAt and
+// schemaVersion are written, and its other fields, such as metadata written by other systems,
+// are kept. Otherwise, as with the default IDs, which derive from every value,
+// the rule is removed and the new one added. Containers of Options.NewContainer
+// are only patched if they implement Patcher.
+func (a *Adapter) UpdatePolicy(sec string, ptype string, oldRule, newRule []string) (err error) {
+	return a.updatePolicy(context.Background(), sec, ptype, oldRule, newRule)
+}
+
+func (a *Adapter) updatePolicy(ctx context.Context, sec string, ptype string, oldRule, newRule []string) (err error) {
+	ctx, op := a.startOperation(ctx, "UpdatePolicy")
+	defer func() { err = a.endOperation(op, err) }()
+
+	m := &Mutation{Operation: op.name, Sec: sec, PType: ptype, Rules: [][]string{oldRule}, NewRules: [][]string{newRule}}
+	if err := a.beforeMutation(ctx, m); err != nil {
+		return err
+	}
+	defer func() { a.afterMutation(ctx, m, err) }()
+	if len(m.Rules) != len(m.NewRules) {
+		return errors.New("the rules to update and the updated rules differ in number")
+	}
+	if len(m.Rules) == 0 {
+		return nil
+	}
+
+	lines, err := a.updateRule(ctx, ptype, m.Rules[0], m.NewRules[0])
+	if err != nil {
+		return err
+	}
+	return a.policyChanged(ctx, a.ruleChange(lines...))
+}
+
+// UpdatePolicies replaces rules of the storage with others, as UpdatePolicy
+// does, oldRules[i] with newRules[i]. The rules are updated one by one: if some
+// fail, the others are updated and the policy version is bumped, and the
+// returned error joins a *RuleError for every failed rule, see FailedRules.
+func (a *Adapter) UpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) (err error) {
+	return a.updatePolicies(context.Background(), sec, ptype, oldRules, newRules)
+}
+
+func (a *Adapter) updatePolicies(ctx context.Context, sec string, ptype string, oldRules, newRules [][]string) (err error) {
+	ctx, op := a.startOperation(ctx, "UpdatePolicies")
+	defer func() { err = a.endOperation(op, err) }()
+
+	m := &Mutation{Operation: op.name, Sec: sec, PType: ptype, Rules: oldRules, NewRules: newRules}
+	if err := a.beforeMutation(ctx, m); err != nil {
+		return err
+	}
+	defer func() { a.afterMutation(ctx, m, err) }()
+	if len(m.Rules) != len(m.NewRules) {
+		return errors.New("the rules to update and the updated rules differ in number")
+	}
+
+	var updated []CasbinRule
+	var errs []error
+	for i, rule := range m.Rules {
+		lines, err := a.updateRule(ctx, ptype, rule, m.NewRules[i])
+		if err != nil {
+			errs = append(errs, ruleError(ctx, ptype, rule, err))
+			continue
+		}
+		updated = append(updated, lines...)
+	}
+	if len(updated) > 0 {
+		if err := a.policyChanged(ctx, a.ruleChange(updated...)); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// UpdateFilteredPolicies replaces the rules matching the filter, as
+// RemoveFilteredPolicy matches them, with newRules, and returns the replaced
+// rules. The replaced rules are removed and the new ones added.
+func (a *Adapter) UpdateFilteredPolicies(sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	return a.updateFilteredPolicies(context.Background(), sec, ptype, newRules, fieldIndex, fieldValues...)
+}
+
+func (a *Adapter) updateFilteredPolicies(ctx context.Context, sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) (replaced [][]string, err error) {
+	ctx, op := a.startOperation(ctx, "UpdateFilteredPolicies")
+	defer func() { err = a.endOperation(op, err) }()
+
+	m := &Mutation{Operation: op.name, Sec: sec, PType: ptype, NewRules: newRules, FieldIndex: fieldIndex, FieldValues: fieldValues}
+	if err := a.beforeMutation(ctx, m); err != nil {
+		return nil, err
+	}
+	defer func() { a.afterMutation(ctx, m, err) }()
+
+	lines := make([]CasbinRule, len(m.NewRules))
+	for i, rule := range m.NewRules {
+		rule = a.normalize(ptype, rule)
+		if err := a.validateRule(ptype, rule); err != nil {
+			return nil, err
+		}
+		lines[i] = a.newPolicyLine(ptype, rule)
+	}
+	policies, _, err := a.filteredRules(ctx, ptype, fieldIndex, a.normalizeFrom(ptype, fieldIndex, m.FieldValues))
+	if err != nil {
+		return nil, err
+	}
+	replacedQuota, err := a.checkReplaceQuota(ctx, policies, lines)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.removeAll(ctx, policies); err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if err := a.appendEvents(ctx, a.newEvent(ctx, EventAdd, ptype, policyTokens(line))); err != nil {
+			return nil, err
+		}
+		if err := a.save(ctx, line); err != nil {
+			return nil, err
+		}
+	}
+	replacedQuota()
+
+	for _, policy := range policies {
+		replaced = append(replaced, policyTokens(policy))
+	}
+	return replaced, a.policyChanged(ctx, a.ruleChange(append(policies, lines...)...))
+}
+
+// updateRule replaces a rule updated by UpdatePolicy or UpdatePolicies, and
+// returns the documents of the replaced and the new rule. The policy version is
+// not bumped.
+func (a *Adapter) updateRule(ctx context.Context, ptype string, oldRule, newRule []string) ([]CasbinRule, error) {
+	if err := a.checkRule(oldRule); err != nil {
+		return nil, err
+	}
+	oldRule, newRule = a.normalize(ptype, oldRule), a.normalize(ptype, newRule)
+	if err := a.validateRule(ptype, newRule); err != nil {
+		return nil, err
+	}
+	stale, updated := a.newPolicyLine(ptype, oldRule), a.newPolicyLine(ptype, newRule)
+	replaced, err := a.checkReplaceQuota(ctx, []CasbinRule{stale}, []CasbinRule{updated})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.appendEvents(ctx, a.newEvent(ctx, EventRemove, ptype, oldRule), a.newEvent(ctx, EventAdd, ptype, newRule)); err != nil {
+		return nil, err
+	}
+
+	if patcher, ok := a.patcherFor(stale, updated); ok {
+		err = a.patchRule(ctx, patcher, stale, updated)
+	} else {
+		err = a.replaceRule(ctx, stale, updated)
+	}
+	if err != nil {
+		return nil, err
+	}
+	replaced()
+	return []CasbinRule{stale, updated}, nil
+}
+
+// patcherFor returns the container to patch the stored document of stale
+// into updated with, if it can be patched: the document keeps its ID and
+// partition key, and has the default field names. Cosmos can't change the ID
+// of a document, so with the default IDs, which derive from every value, no
+// update is patched.
+func (a *Adapter) patcherFor(stale, updated CasbinRule) (Patcher, bool) {
+	if stale.ID != updated.ID || stale.PartitionKey != updated.PartitionKey {
+		return nil, false
+	}
+	if _, ok := a.mapper.(jsonMapper); !ok {
+		return nil, false
+	}
+	return patcherOf(a.containerFor(updated.PType))
+}
+
+// patchRule patches the values of the stored document of the rule stale into
+// those of updated, leaving its other fields as they are.
+func (a *Adapter) patchRule(ctx context.Context, patcher Patcher, stale, updated CasbinRule) error {
+	var ops azcosmos.PatchOperations
+	staleValues, updatedValues := stale.values(), updated.values()
+	for i, value := range updatedValues {
+		path := fmt.Sprintf("/v%d", i)
+		switch {
+		case *value != "" && *value != *staleValues[i]:
+			ops.AppendSet(path, *value)
+		case *value == "" && *staleValues[i] != "":
+			ops.AppendRemove(path)
+		}
+	}
+	if updated.Rule != nil {
+		ops.AppendSet("/rule", updated.Rule)
+	} else if stale.Rule != nil {
+		ops.AppendRemove("/rule")
+	}
+	ops.AppendSet("/updatedAt", a.now().Unix())
+	ops.AppendSet("/schemaVersion", currentSchemaVersion)
+	if a.tombstones {
+		// a tombstone is not brought back
+		ops.SetCondition("FROM c WHERE NOT IS_DEFINED(c.deleted)")
+	}
+
+	var options *azcosmos.ItemOptions
+	if a.signer != nil {
+		// the signature covers fields of the stored document the update keeps,
+		// such as the expiry of a temporary rule
+		signature, etag, err := a.updatedSignature(ctx, stale, updated)
+		if err != nil {
+			return err
+		}
+		ops.AppendSet("/signature", signature)
+		options = &azcosmos.ItemOptions{IfMatchEtag: &etag}
+	}
+
+	res, err := patcher.PatchItem(ctx, a.partitionKey(stale), stale.ID, ops, options)
+	if isStatus(err, http.StatusPreconditionFailed) && a.signer == nil {
+		return fmt.Errorf("the rule to update is removed: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+	operationFrom(ctx).record(res.Response, 1)
+	return nil
+}
+
+// updatedSignature returns the signature of the stored document of the rule
+// stale once patched with the values of updated, and the ETag of the document
+// it was computed from.
+func (a *Adapter) updatedSignature(ctx context.Context, stale, updated CasbinRule) (string, azcore.ETag, error) {
+	res, err := a.containerFor(stale.PType).ReadItem(ctx, a.partitionKey(stale), stale.ID, nil)
+	if err != nil {
+		return "", "", err
+	}
+	operationFrom(ctx).record(res.Response, 0)
+	stored, err := a.mapper.FromDocument(res.Value)
+	if err != nil {
+		return "", "", err
+	}
+	if stored.Deleted {
+		return "", "", fmt.Errorf("the rule to update is removed: %w", &azcore.ResponseError{StatusCode: http.StatusNotFound})
+	}
+	for i, value := range stored.values() {
+		*value = *updated.values()[i]
+	}
+	stored.Rule = updated.Rule
+	signature, err := a.sign(stored)
+	return signature, res.ETag, err
+}
+
+// replaceRule removes the stored rule stale and writes updated, for the
+// updates that can't be patched in place.
+func (a *Adapter) replaceRule(ctx context.Context, stale, updated CasbinRule) error {
+	copies, err := a.storedCopies(ctx, stale)
+	if err != nil {
+		return err
+	}
+	for _, stored := range copies {
+		if err := a.remove(ctx, stored); err != nil {
+			return err
+		}
+	}
+	return a.save(ctx, updated)
+}
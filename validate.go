@@ -0,0 +1,69 @@
+package cosmosadapter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrInvalidRule is matched with errors.Is by the errors of the operations
+// writing a rule rejected by Options.Validator.
+var ErrInvalidRule = errors.New("cosmosadapter: invalid rule")
+
+// Validator returns an error if the rule of the policy type must not be
+// written, see Options.Validator. It must be safe for concurrent use.
+type Validator func(ptype string, rule []string) error
+
+// ValidateAll returns a Validator running the validators in order, failing
+// with the error of the first one rejecting the rule.
+func ValidateAll(validators ...Validator) Validator {
+	return func(ptype string, rule []string) error {
+		for _, validator := range validators {
+			if err := validator(ptype, rule); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// NoEmptyValues is a Validator rejecting the rules with an empty value, such
+// as a policy without subject, which Casbin would match against empty
+// request values.
+func NoEmptyValues(ptype string, rule []string) error {
+	for i, value := range rule {
+		if value == "" {
+			return fmt.Errorf("value %d is empty", i)
+		}
+	}
+	return nil
+}
+
+// MaxValueLength returns a Validator rejecting the rules with a value longer
+// than n bytes.
+func MaxValueLength(n int) Validator {
+	return func(ptype string, rule []string) error {
+		for i, value := range rule {
+			if len(value) > n {
+				return fmt.Errorf("value %d is %d bytes long, at most %d are allowed", i, len(value), n)
+			}
+		}
+		return nil
+	}
+}
+
+// MatchValue returns a Validator rejecting the rules of the policy type whose
+// value at index doesn't match the pattern, to enforce naming conventions such
+// as a "user:" prefix for subjects. Rules of other policy types, or too short
+// to have the value, are accepted.
+func MatchValue(ptype string, index int, pattern *regexp.Regexp) Validator {
+	return func(rulePType string, rule []string) error {
+		if rulePType != ptype || index >= len(rule) {
+			return nil
+		}
+		if !pattern.MatchString(rule[index]) {
+			return fmt.Errorf("value %d %q doesn't match %s", index, rule[index], pattern)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// GetAllSubjects returns the subjects of the p rules, their v0, sorted, for
+// example to populate the dropdowns of an administration UI without loading
+// the policy into an enforcer.
+func (a *Adapter) GetAllSubjects(ctx context.Context) (subjects []string, err error) {
+	ctx, op := a.startOperation(ctx, "GetAllSubjects")
+	defer func() { err = a.endOperation(op, err) }()
+	return a.distinctValues(ctx, "p", 0)
+}
+
+// GetAllObjects returns the objects of the p rules, sorted: their v1, or v2
+// with Options.Domains.
+func (a *Adapter) GetAllObjects(ctx context.Context) (objects []string, err error) {
+	ctx, op := a.startOperation(ctx, "GetAllObjects")
+	defer func() { err = a.endOperation(op, err) }()
+	return a.distinctValues(ctx, "p", a.domainOffset(1))
+}
+
+// GetAllActions returns the actions of the p rules, sorted: their v2, or v3
+// with Options.Domains.
+func (a *Adapter) GetAllActions(ctx context.Context) (actions []string, err error) {
+	ctx, op := a.startOperation(ctx, "GetAllActions")
+	defer func() { err = a.endOperation(op, err) }()
+	return a.distinctValues(ctx, "p", a.domainOffset(2))
+}
+
+// GetAllDomains returns the domains of the p and g rules, sorted: the v1 of p
+// rules and the v2 of g rules. It requires Options.Domains.
+func (a *Adapter) GetAllDomains(ctx context.Context) (domains []string, err error) {
+	ctx, op := a.startOperation(ctx, "GetAllDomains")
+	defer func() { err = a.endOperation(op, err) }()
+	if !a.domains {
+		return nil, errors.New("domains require Options.Domains")
+	}
+	p, err := a.distinctValues(ctx, "p", 1)
+	if err != nil {
+		return nil, err
+	}
+	g, err := a.distinctValues(ctx, "g", 2)
+	if err != nil {
+		return nil, err
+	}
+	domains = append(p, g...)
+	slices.Sort(domains)
+	return slices.Compact(domains), nil
+}
+
+// domainOffset returns the index of a value of p rules, shifted past the
+// domain with Options.Domains.
+func (a *Adapter) domainOffset(index int) int {
+	if a.domains {
+		return index + 1
+	}
+	return index
+}
+
+// distinctValues returns the distinct non-empty values at index of the rules
+// of the policy type, sorted. The values are selected with DISTINCT VALUE
+// queries, which return scalars, so the removed, expired and other namespace
+// or generation rules are left out by the queries.
+func (a *Adapter) distinctValues(ctx context.Context, ptype string, index int) ([]string, error) {
+	var values []string
+	if a.customMapping() {
+		// the stored field names are unknown, read the rules
+		lines, err := a.query(ctx, "SELECT * FROM c", ptype, nil)
+		if err != nil {
+			return nil, err
+		}
+		now := a.now()
+		for _, line := range lines {
+			if line.PType == policyVersionID || line.Deleted || line.expired(now) {
+				continue
+			}
+			if tokens := policyTokens(line); index < len(tokens) && tokens[index] != "" {
+				values = append(values, tokens[index])
+			}
+		}
+	} else {
+		fields := []string{fmt.Sprintf("c.v%d", index)}
+		if a.arraySchema {
+			// the documents written before the option was enabled as well
+			fields = append(fields, fmt.Sprintf("c.rule[%d]", index))
+		}
+		for _, field := range fields {
+			found, err := a.queryValues(ctx, ptype, field)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, found...)
+		}
+	}
+	slices.Sort(values)
+	return slices.Compact(values), nil
+}
+
+// queryValues returns the distinct values of the field of the current rules
+// of the policy type.
+func (a *Adapter) queryValues(ctx context.Context, ptype string, field string) ([]string, error) {
+	query := "SELECT DISTINCT VALUE " + field + " FROM c WHERE (NOT IS_DEFINED(c.deleted) OR c.deleted = false)" +
+		" AND (NOT IS_DEFINED(c.expiresAt) OR c.expiresAt > @now)"
+	parameters := []azcosmos.QueryParameter{{Name: "@now", Value: a.now().Unix()}}
+	if a.generational {
+		if generation := a.generation.Load(); generation != 0 {
+			query += " AND c.generation = @generation"
+			parameters = append(parameters, azcosmos.QueryParameter{Name: "@generation", Value: generation})
+		} else {
+			query += " AND NOT IS_DEFINED(c.generation)"
+		}
+	}
+	query, parameters = a.inNamespace(query, parameters)
+	query, parameters, pk := a.inPolicyType(query, parameters, ptype)
+	if a.partitionStrategy == PartitionByDomain {
+		// the gateway doesn't serve DISTINCT across partitions, the values are
+		// deduplicated by the caller
+		query = "SELECT VALUE" + query[len("SELECT DISTINCT VALUE"):]
+	}
+
+	var values []string
+	queryPager := a.containerFor(ptype).NewQueryItemsPager(query, pk, &azcosmos.QueryOptions{QueryParameters: parameters})
+	continuation := ""
+	for queryPager.More() {
+		res, err := nextPage(ctx, queryPager)
+		if err != nil {
+			return nil, err
+		}
+		operationFrom(ctx).query(query, ptype, parameters, continuation, res)
+		if res.ContinuationToken != nil {
+			continuation = *res.ContinuationToken
+		}
+		for _, item := range res.Items {
+			var value string
+			// values of other types, written by hand, are not rule values
+			if json.Unmarshal(item, &value) == nil && value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values, nil
+}
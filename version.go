@@ -0,0 +1,308 @@
+package cosmosadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// policyVersionID is the id and partition key of the document holding the
+// policy version counter.
+const policyVersionID = "policy_version"
+
+// policyVersion is the document holding the policy version counter. It also
+// records what the last change touched.
+type policyVersion struct {
+	ID    string `json:"id"`
+	PType string `json:"pType"`
+	// PartitionKey is the partition key with PartitionByDomain.
+	PartitionKey string `json:"partitionKey"`
+	Version      int64  `json:"version"`
+	// Generation is the current generation of the rules with
+	// Options.GenerationalSave.
+	Generation int64 `json:"generation,omitempty"`
+	// StoredPTypes are the policy types that may have stored rules: those
+	// written by the last SavePolicy and those changed since, so PolicyTypes
+	// doesn't scan the container. It is nil when a change of unknown policy
+	// types was made since, or before any SavePolicy.
+	StoredPTypes *[]string `json:"storedPTypes,omitempty"`
+	PolicyChange
+}
+
+// storedPTypesAfter returns the StoredPTypes of the version document after
+// the change.
+func (v policyVersion) storedPTypesAfter(change PolicyChange) *[]string {
+	if v.StoredPTypes == nil || len(change.PTypes) == 0 {
+		return nil
+	}
+	ptypes := slices.Clone(*v.StoredPTypes)
+	for _, ptype := range change.PTypes {
+		if !slices.Contains(ptypes, ptype) {
+			ptypes = append(ptypes, ptype)
+		}
+	}
+	slices.Sort(ptypes)
+	return &ptypes
+}
+
+// PolicyChange describes a change of the policy. It is passed, encoded as JSON,
+// to the update callbacks of the watchers in this package, so subscribers that
+// only load part of the policy can skip changes that don't concern them.
+// Empty PTypes means the change is unknown and may affect any rule.
+type PolicyChange struct {
+	// Version is the policy version after the change, if known.
+	Version int64 `json:"version,omitempty"`
+	// PTypes are the policy types of the changed rules.
+	PTypes []string `json:"pTypes,omitempty"`
+	// Domains are the domains of the changed rules. It is only filled in when
+	// Options.Domains is set.
+	Domains []string `json:"domains,omitempty"`
+}
+
+// ParsePolicyChange decodes the message passed to a watcher update callback.
+func ParsePolicyChange(msg string) (PolicyChange, error) {
+	var change PolicyChange
+	err := json.Unmarshal([]byte(msg), &change)
+	return change, err
+}
+
+// String encodes the change as the JSON message passed to update callbacks.
+func (c PolicyChange) String() string {
+	marshalled, _ := json.Marshal(c)
+	return string(marshalled)
+}
+
+// Affects reports whether the change may concern rules of the given policy type
+// and domain. An empty ptype or domain matches any.
+func (c PolicyChange) Affects(ptype string, domain string) bool {
+	if len(c.PTypes) == 0 {
+		return true
+	}
+	if ptype != "" && !contains(c.PTypes, ptype) {
+		return false
+	}
+	if domain != "" && len(c.Domains) > 0 && !contains(c.Domains, domain) {
+		return false
+	}
+	return true
+}
+
+// add records a changed rule.
+func (c *PolicyChange) add(ptype string, domain string) {
+	if !contains(c.PTypes, ptype) {
+		c.PTypes = append(c.PTypes, ptype)
+	}
+	if domain != "" && !contains(c.Domains, domain) {
+		c.Domains = append(c.Domains, domain)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// readVersion returns the current policy version and the ETag of its document.
+// A missing document is reported as version 0 with an empty ETag.
+func readVersion(ctx context.Context, container Container, id string) (int64, azcore.ETag, error) {
+	doc, etag, err := readVersionDocument(ctx, container, id)
+	return doc.Version, etag, err
+}
+
+func readVersionDocument(ctx context.Context, container Container, id string) (policyVersion, azcore.ETag, error) {
+	doc, etag, _, err := readVersionResponse(ctx, container, id)
+	return doc, etag, err
+}
+
+// readVersionResponse reads the policy version document like
+// readVersionDocument, and also returns the HTTP response, if any, including
+// the response of a missing document.
+func readVersionResponse(ctx context.Context, container Container, id string) (policyVersion, azcore.ETag, *http.Response, error) {
+	var doc policyVersion
+	res, err := container.ReadItem(ctx, azcosmos.NewPartitionKeyString(policyVersionID), id, nil)
+	if err != nil {
+		var responseErr *azcore.ResponseError
+		if isStatus(err, http.StatusNotFound) && errors.As(err, &responseErr) {
+			return doc, "", responseErr.RawResponse, nil
+		}
+		return doc, "", nil, err
+	}
+	operationFrom(ctx).record(res.Response, 0)
+	if err := json.Unmarshal(res.Value, &doc); err != nil {
+		return doc, "", nil, err
+	}
+	return doc, res.ETag, res.RawResponse, nil
+}
+
+// watermarkSkew is subtracted from the time a load starts to get its
+// watermark, to cover the clock differences between the Cosmos replicas, or
+// with the local clock when the response carries no time.
+const watermarkSkew = time.Minute
+
+// readLoadVersion reads the policy version document at the start of a load,
+// and returns the watermark of the load for LoadPolicyDelta: the time of the
+// Cosmos server when the load started, from the Date header of the response,
+// less watermarkSkew. The documents written while the rules are read have a
+// later _ts, so the next LoadPolicyDelta reads them.
+func (a *Adapter) readLoadVersion(ctx context.Context, container Container) (policyVersion, int64, error) {
+	doc, _, res, err := readVersionResponse(ctx, container, a.versionID())
+	if err != nil {
+		return doc, 0, err
+	}
+	start := a.now()
+	if res != nil {
+		if date, err := http.ParseTime(res.Header.Get("Date")); err == nil {
+			start = date
+		}
+	}
+	return doc, start.Add(-watermarkSkew).Unix(), nil
+}
+
+// bumpVersion increments the policy version, records the change and returns the
+// new version. The write is guarded by the document ETag and retried when
+// another instance bumped the version concurrently.
+func bumpVersion(ctx context.Context, container Container, id string, pkField string, change PolicyChange) (int64, error) {
+	for {
+		current, etag, err := readVersionDocument(ctx, container, id)
+		if err != nil {
+			return 0, err
+		}
+		doc := policyVersion{ID: id, PType: policyVersionID, PartitionKey: policyVersionID, Version: current.Version + 1, Generation: current.Generation, StoredPTypes: current.storedPTypesAfter(change), PolicyChange: change}
+		doc.PolicyChange.Version = 0
+		marshalled, err := marshalVersion(doc, pkField)
+		if err != nil {
+			return 0, err
+		}
+
+		pk := azcosmos.NewPartitionKeyString(policyVersionID)
+		var res azcosmos.ItemResponse
+		if etag == "" {
+			res, err = container.CreateItem(ctx, pk, marshalled, nil)
+		} else {
+			res, err = container.ReplaceItem(ctx, pk, id, marshalled, &azcosmos.ItemOptions{IfMatchEtag: &etag})
+		}
+		if err != nil {
+			if isStatus(err, http.StatusPreconditionFailed) || isStatus(err, http.StatusConflict) {
+				operationFrom(ctx).retry("policy version changed concurrently")
+				continue
+			}
+			return 0, err
+		}
+		operationFrom(ctx).record(res.Response, 0)
+		return doc.Version, nil
+	}
+}
+
+// writeVersion overwrites the policy version, the generation of the rules and
+// their policy types.
+func writeVersion(ctx context.Context, container Container, id string, pkField string, version int64, generation int64, ptypes []string) error {
+	ptypes = append([]string{}, ptypes...)
+	doc := policyVersion{ID: id, PType: policyVersionID, PartitionKey: policyVersionID, Version: version, Generation: generation, StoredPTypes: &ptypes}
+	marshalled, err := marshalVersion(doc, pkField)
+	if err != nil {
+		return err
+	}
+	res, err := container.UpsertItem(ctx, azcosmos.NewPartitionKeyString(policyVersionID), marshalled, nil)
+	if err != nil {
+		return err
+	}
+	operationFrom(ctx).record(res.Response, 0)
+	return nil
+}
+
+// marshalVersion encodes the version document, with the partition key in
+// pkField as well when the container is partitioned on another field than
+// pType or partitionKey.
+func marshalVersion(doc policyVersion, pkField string) ([]byte, error) {
+	marshalled, err := json.Marshal(doc)
+	if err != nil || pkField == "" || pkField == "pType" || pkField == "partitionKey" {
+		return marshalled, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(marshalled, &fields); err != nil {
+		return nil, err
+	}
+	fields[pkField] = policyVersionID
+	return json.Marshal(fields)
+}
+
+// versionPKField returns the field holding the partition key of the version
+// document, see marshalVersion.
+func (a *Adapter) versionPKField() string {
+	if a.compat != nil && a.partitionStrategy == PartitionByPType {
+		return a.compat.partitionKeyField()
+	}
+	return ""
+}
+
+// GetPolicyVersion returns the current policy version. The version is bumped by
+// every mutation made through any adapter using the same container.
+func (a *Adapter) GetPolicyVersion() (int64, error) {
+	return a.getPolicyVersion(context.Background())
+}
+
+func (a *Adapter) getPolicyVersion(ctx context.Context) (version int64, err error) {
+	ctx, op := a.startOperation(ctx, "GetPolicyVersion")
+	defer func() { err = a.endOperation(op, err) }()
+	version, _, err = readVersion(ctx, a.containerClient, a.versionID())
+	return version, err
+}
+
+// NeedsReload reports whether the policy changed since it was last loaded by
+// this adapter. It costs a single point read, which is much cheaper than
+// calling LoadPolicy just in case.
+func (a *Adapter) NeedsReload() (bool, error) {
+	return a.needsReload(context.Background())
+}
+
+func (a *Adapter) needsReload(ctx context.Context) (bool, error) {
+	version, _, err := readVersion(ctx, a.containerClient, a.versionID())
+	if err != nil {
+		return false, err
+	}
+	return version != a.version.Load(), nil
+}
+
+// policyChanged bumps the policy version after a mutation made through this
+// adapter. The enforcer applies its own mutations to its model, so the loaded
+// version follows along unless another instance changed the policy as well.
+func (a *Adapter) policyChanged(ctx context.Context, change PolicyChange) error {
+	version, err := bumpVersion(ctx, a.containerClient, a.versionID(), a.versionPKField(), change)
+	if err != nil {
+		return err
+	}
+	// unless another instance changed the policy meanwhile, or the bump was
+	// skipped with DryRun
+	if !a.dryRun {
+		a.version.CompareAndSwap(version-1, version)
+	}
+	return nil
+}
+
+// ruleDomain returns the domain of the rule when Options.Domains is set: v1 of
+// p rules (sub, dom, obj, act) and v2 of g rules (_, _, dom).
+func (a *Adapter) ruleDomain(line CasbinRule) string {
+	if !a.domains {
+		return ""
+	}
+	return lineDomain(line)
+}
+
+// ruleChange returns the change made by writing or removing the rules.
+func (a *Adapter) ruleChange(lines ...CasbinRule) PolicyChange {
+	var change PolicyChange
+	for _, line := range lines {
+		change.add(line.PType, a.ruleDomain(line))
+	}
+	return change
+}
@@ -0,0 +1,134 @@
+package cosmosadapter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// DefaultPollingInterval is the interval used by NewPollingWatcher when none is given.
+const DefaultPollingInterval = 10 * time.Second
+
+// PollingWatcher is a persist.Watcher that polls the policy version document
+// stored next to the rules. Every mutation made through an adapter bumps the
+// version, and every instance polling the same container calls its update
+// callback once it sees the version change. It does not need the change feed.
+// The callback receives the change encoded as JSON, see ParsePolicyChange.
+type PollingWatcher struct {
+	containerClient Container
+	versionID       string
+	interval        time.Duration
+
+	mu       sync.Mutex
+	callback func(string)
+	version  int64
+	known    bool
+
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	untrack func()
+}
+
+var _ persist.Watcher = (*PollingWatcher)(nil)
+
+// NewPollingWatcher creates a watcher polling the container used by the adapter
+// every interval. If interval is zero or less DefaultPollingInterval is used.
+// Polling starts immediately and stops when Close, or Close of the adapter, is
+// called.
+func NewPollingWatcher(a *Adapter, interval time.Duration) *PollingWatcher {
+	if interval <= 0 {
+		interval = DefaultPollingInterval
+	}
+	w := &PollingWatcher{
+		containerClient: a.containerClient,
+		versionID:       a.versionID(),
+		interval:        interval,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	w.untrack = a.lifecycle.track(w.Close)
+	go w.run()
+	return w
+}
+
+// SetUpdateCallback sets the callback called when another instance changed the
+// policy. The callback receives the change encoded as JSON, see
+// ParsePolicyChange: its new policy version, and the policy types and domains
+// of the changed rules when a single change happened since the last poll.
+func (w *PollingWatcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callback = callback
+	return nil
+}
+
+// Update marks the current policy version as seen, so the change this instance
+// just made through the adapter, which already bumped the version, does not
+// trigger its own callback.
+func (w *PollingWatcher) Update() error {
+	version, _, err := readVersion(withFaultScope(context.Background(), "PollingWatcher"), w.containerClient, w.versionID)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.known || version > w.version {
+		w.version = version
+		w.known = true
+	}
+	return nil
+}
+
+// Close stops polling. The callback will not be called any more.
+func (w *PollingWatcher) Close() {
+	w.once.Do(func() {
+		close(w.stop)
+		w.untrack()
+	})
+	<-w.done
+}
+
+func (w *PollingWatcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	w.poll()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+func (w *PollingWatcher) poll() {
+	doc, _, err := readVersionDocument(withFaultScope(context.Background(), "PollingWatcher"), w.containerClient, w.versionID)
+	if err != nil {
+		// try again on the next tick
+		return
+	}
+
+	w.mu.Lock()
+	changed := w.known && doc.Version != w.version
+	// the document only describes the last change, so if several happened
+	// since the last poll, report an unknown change
+	change := PolicyChange{Version: doc.Version}
+	if doc.Version == w.version+1 {
+		change = doc.PolicyChange
+		change.Version = doc.Version
+	}
+	w.version = doc.Version
+	w.known = true
+	callback := w.callback
+	w.mu.Unlock()
+
+	if changed && callback != nil {
+		callback(change.String())
+	}
+}